@@ -0,0 +1,108 @@
+package pcapreader
+
+import (
+	"context"
+	"io"
+)
+
+// BatchTraffic is Traffic which can hand out many packets
+// per call. This saves calling Next for every single packet.
+type BatchTraffic interface {
+	Traffic
+
+	// Reads as many packets as fit into infos and packets. The
+	// data of the packets is copied one after the other into buf
+	// and packets[i] is the part of buf that holds packet i.
+	// A packet that does not fit into what is left of buf is
+	// returned by the next call. If not even one packet fits,
+	// io.ErrShortBuffer is returned. Like with io.Reader, the
+	// n packets should be processed before looking at the error.
+	// The data in buf belongs to the caller, so unlike with
+	// Next, it is not overwritten by later calls.
+	ReadBatch(infos []PacketInfo, packets []Packet, buf []byte) (n int, err error)
+}
+
+// Returns t as BatchTraffic. If t does not read
+// batches by itself, they are filled by calling Next.
+func Batched(t Traffic) BatchTraffic {
+	if bt, ok := t.(BatchTraffic); ok {
+		return bt
+	}
+	return &batchedTraffic{Traffic: t}
+}
+
+// a packet that has been read but did not fit into a batch.
+// Its data is still valid as the reader was not used since.
+type pendingPacket struct {
+	ok   bool
+	info *PacketInfo
+	data Packet
+}
+
+func (p *pendingPacket) keep(info *PacketInfo, data Packet) {
+	p.ok = true
+	p.info = info
+	p.data = data
+}
+
+func (p *pendingPacket) take() (*PacketInfo, Packet, error) {
+	p.ok = false
+	return p.info, p.data, nil
+}
+
+// fills the batch by calling next which must return
+// the pending packet first if there is one
+func fillBatch(next func() (*PacketInfo, Packet, error), pending *pendingPacket, infos []PacketInfo, packets []Packet, buf []byte) (int, error) {
+	n := 0
+	used := 0
+	for n < len(infos) && n < len(packets) {
+		info, data, err := next()
+		if err != nil {
+			return n, err
+		}
+
+		if used+len(data) > len(buf) {
+			pending.keep(info, data)
+			if n == 0 {
+				return 0, io.ErrShortBuffer
+			}
+			break
+		}
+
+		end := used + len(data)
+		packets[n] = buf[used:end:end]
+		copy(packets[n], data)
+		infos[n] = *info
+
+		used = end
+		n++
+	}
+
+	return n, nil
+}
+
+// batches for traffic that does not read them by itself
+type batchedTraffic struct {
+	Traffic
+	pending pendingPacket
+}
+
+func (t *batchedTraffic) Next() (*PacketInfo, Packet, error) {
+	if t.pending.ok {
+		return t.pending.take()
+	}
+	return t.Traffic.Next()
+}
+
+func (t *batchedTraffic) ReadBatch(infos []PacketInfo, packets []Packet, buf []byte) (int, error) {
+	return fillBatch(t.Next, &t.pending, infos, packets, buf)
+}
+
+func (t *batchedTraffic) setContext(ctx context.Context) {
+	setContext(t.Traffic, ctx)
+}
+
+func (t *batchedTraffic) Stop() {
+	t.pending = pendingPacket{}
+	t.Traffic.Stop()
+}
@@ -0,0 +1,110 @@
+package pcapreader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func TestReadBatch(t *testing.T) {
+	pcap := genPcap(genPcapOpts{order: binary.LittleEndian, snaplen: 0xFFFF, packets: 14})
+	var g genNg
+	g.shb(binary.LittleEndian, 1, 0)
+	g.idb(1, 0)
+	g.packets(0, 1e6, 0, 14)
+	pcapng := g.b.Bytes()
+
+	for name, open := range map[string]func() (Traffic, error){
+		"pcap": func() (Traffic, error) {
+			return OpenReader(bytes.NewReader(pcap))
+		},
+		"pcapng": func() (Traffic, error) {
+			return readPcapNg(&memorySource{data: pcapng}, ReaderOptions{})
+		},
+		// fills the batches by calling Next
+		"other": func() (Traffic, error) {
+			t, err := OpenReader(bytes.NewReader(pcap))
+			if err != nil {
+				return nil, err
+			}
+			return wrappedTraffic{t}, nil
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			traffic, err := open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			bt := Batched(traffic)
+			defer bt.Stop()
+
+			infos := make([]PacketInfo, 8)
+			packets := make([]Packet, 8)
+			// packets are taken out of the batches as they
+			// have to stay valid while reading the next ones
+			var got []Packet
+			batch := func(size int, wantN int, wantErr error) {
+				t.Helper()
+				n, err := bt.ReadBatch(infos, packets, make([]byte, size))
+				if n != wantN || err != wantErr {
+					t.Fatalf("%d packets and %v instead of %d and %v", n, err, wantN, wantErr)
+				}
+				for i := 0; i < n; i++ {
+					if infos[i].Size != uint32(len(packets[i])) {
+						t.Errorf("packet %d is %d bytes but has size %d", len(got), len(packets[i]), infos[i].Size)
+					}
+					got = append(got, packets[i])
+				}
+			}
+
+			// the 4th packet has 1514 bytes and does not fit
+			batch(100, 3, nil)
+			batch(100, 0, io.ErrShortBuffer)
+			// the packet that did not fit comes next
+			batch(1514, 1, nil)
+			// as many as there are room for in infos and packets
+			batch(4096, 8, nil)
+			// the end is reported with the last packets
+			batch(4096, 2, io.EOF)
+
+			if len(got) != 14 {
+				t.Fatalf("%d packets", len(got))
+			}
+			for i, packet := range got {
+				if !bytes.Equal(packet, genData(i, genSizes[i%len(genSizes)])) {
+					t.Errorf("packet %d differs", i)
+				}
+			}
+		})
+	}
+}
+
+// Next and ReadBatch can be used one after the other
+func TestReadBatchNext(t *testing.T) {
+	traffic, err := OpenReader(bytes.NewReader(genPcap(genPcapOpts{order: binary.LittleEndian, snaplen: 0xFFFF, packets: 8})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt := Batched(traffic)
+	defer bt.Stop()
+
+	if n, err := bt.ReadBatch(make([]PacketInfo, 4), make([]Packet, 4), make([]byte, 10)); n != 0 || err != io.ErrShortBuffer {
+		t.Fatalf("%d packets and %v", n, err)
+	}
+	if _, packet, err := bt.Next(); err != nil || !bytes.Equal(packet, genData(0, genSizes[0])) {
+		t.Errorf("the packet that did not fit is not next: %v", err)
+	}
+
+	infos := make([]PacketInfo, 8)
+	packets := make([]Packet, 8)
+	n, err := bt.ReadBatch(infos, packets, make([]byte, 4096))
+	if n != 7 || err != io.EOF {
+		t.Fatalf("%d packets and %v", n, err)
+	}
+	for i, packet := range packets[:n] {
+		if !bytes.Equal(packet, genData(i+1, genSizes[i+1])) {
+			t.Errorf("packet %d differs", i+1)
+		}
+	}
+}
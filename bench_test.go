@@ -0,0 +1,179 @@
+package pcapreader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+// how many packets the generated captures have. Enough
+// for the reader to be reopened only rarely while benchmarking.
+const benchPackets = 10000
+
+// packet sizes cycle through these, roughly what
+// a mix of acks, small requests and full frames looks like
+var benchSizes = []int{60, 66, 74, 128, 590, 1514}
+
+func benchPcap() []byte {
+	var b bytes.Buffer
+	le := binary.LittleEndian
+
+	binary.Write(&b, le, uint32(magicMicroseconds))
+	binary.Write(&b, le, []uint16{2, 4})
+	binary.Write(&b, le, []uint32{0, 0, 65535, 1})
+
+	data := make([]byte, 1514)
+	for i := 0; i < benchPackets; i++ {
+		size := benchSizes[i%len(benchSizes)]
+		binary.Write(&b, le, []uint32{uint32(1600000000 + i/1000), uint32(i%1000) * 1000, uint32(size), uint32(size)})
+		b.Write(data[:size])
+	}
+	return b.Bytes()
+}
+
+func benchPcapNgBlock(b *bytes.Buffer, blockType uint32, body []byte) {
+	le := binary.LittleEndian
+	blockLen := uint32(12 + len(body))
+	binary.Write(b, le, blockType)
+	binary.Write(b, le, blockLen)
+	b.Write(body)
+	binary.Write(b, le, blockLen)
+}
+
+func benchPcapNg() []byte {
+	var b, body bytes.Buffer
+	le := binary.LittleEndian
+
+	binary.Write(&body, le, ngByteOrderMagic)
+	binary.Write(&body, le, []uint16{1, 0})
+	binary.Write(&body, le, int64(-1))
+	benchPcapNgBlock(&b, ngSHB, body.Bytes())
+
+	body.Reset()
+	binary.Write(&body, le, []uint16{1, 0})
+	binary.Write(&body, le, []uint32{65535, 0})
+	benchPcapNgBlock(&b, ngIDB, body.Bytes())
+
+	data := make([]byte, 1516)
+	for i := 0; i < benchPackets; i++ {
+		size := benchSizes[i%len(benchSizes)]
+		ts := uint64(1600000000+i/1000)*1000000 + uint64(i%1000)*1000
+
+		body.Reset()
+		binary.Write(&body, le, []uint32{0, uint32(ts >> 32), uint32(ts), uint32(size), uint32(size)})
+		// data is padded to 32 bits
+		body.Write(data[:(size+3)&^3])
+		benchPcapNgBlock(&b, ngEPB, body.Bytes())
+	}
+	return b.Bytes()
+}
+
+func benchmarkTraffic(b *testing.B, open func() (Traffic, error)) {
+	t, err := open()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+
+	for i := 0; i < b.N; i++ {
+		_, _, err := t.Next()
+		if err == io.EOF {
+			b.StopTimer()
+			t, err = open()
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.StartTimer()
+			continue
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "packets/s")
+}
+
+func benchmarkStream(b *testing.B, data []byte, read func(source, ReaderOptions) (Traffic, error)) {
+	benchmarkTraffic(b, func() (Traffic, error) {
+		return read(newReaderSource(io.NopCloser(bytes.NewReader(data))), ReaderOptions{})
+	})
+}
+
+func benchmarkMemory(b *testing.B, data []byte, read func(source, ReaderOptions) (Traffic, error)) {
+	benchmarkTraffic(b, func() (Traffic, error) {
+		return read(&memorySource{data: data}, ReaderOptions{})
+	})
+}
+
+func BenchmarkPcapStream(b *testing.B) {
+	benchmarkStream(b, benchPcap(), readPcap)
+}
+
+func BenchmarkPcapMemory(b *testing.B) {
+	benchmarkMemory(b, benchPcap(), readPcap)
+}
+
+func BenchmarkPcapNgStream(b *testing.B) {
+	benchmarkStream(b, benchPcapNg(), readPcapNg)
+}
+
+func BenchmarkPcapNgMemory(b *testing.B) {
+	benchmarkMemory(b, benchPcapNg(), readPcapNg)
+}
+
+// like benchmarkStream but the packets are read through
+// ReadBatch in batches of 64. An op is still one packet.
+func benchmarkBatch(b *testing.B, data []byte, read func(source, ReaderOptions) (Traffic, error)) {
+	open := func() (BatchTraffic, error) {
+		t, err := read(newReaderSource(io.NopCloser(bytes.NewReader(data))), ReaderOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return Batched(t), nil
+	}
+	t, err := open()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	infos := make([]PacketInfo, 64)
+	packets := make([]Packet, 64)
+	buf := make([]byte, 64*1514)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+
+	for i := 0; i < b.N; {
+		n, err := t.ReadBatch(infos, packets, buf)
+		i += n
+		if err == io.EOF {
+			b.StopTimer()
+			t, err = open()
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.StartTimer()
+			continue
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "packets/s")
+}
+
+func BenchmarkPcapBatch(b *testing.B) {
+	benchmarkBatch(b, benchPcap(), readPcap)
+}
+
+func BenchmarkPcapNgBatch(b *testing.B) {
+	benchmarkBatch(b, benchPcapNg(), readPcapNg)
+}
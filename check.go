@@ -0,0 +1,731 @@
+package pcapreader
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// CheckOptions change what Check does besides looking for problems
+type CheckOptions struct {
+	// When set, a fixed copy of the capture is written to it. Records
+	// and blocks that are cut off or cannot be made sense of are
+	// dropped, lengths that are wrong are corrected. Seeking is needed
+	// to fix up headers once it is known what has been written after them.
+	Repair io.WriteSeeker
+}
+
+// Checks the structure of the capture in r and calls report for
+// every problem found instead of stopping at the first one.
+// When the capture cannot be made sense of at some point, i.e.
+// because a block total length is off, the capture is searched for
+// the next record or block that looks right, like when recovering.
+// The returned error is not about the capture but about reading
+// it or writing the repaired copy.
+func Check(r io.Reader, opts CheckOptions, report func(*FormatError)) error {
+	// r belongs to the caller so it is not closed
+	src := newReaderSource(io.NopCloser(r))
+
+	var out *repairWriter
+	if opts.Repair != nil {
+		out = newRepairWriter(opts.Repair)
+	}
+
+	magic, err := src.peek(4)
+	switch {
+	case err == io.EOF && len(magic) == 0:
+		report(fileHeaderErr(ErrEmptyPcap, "no file header"))
+		return nil
+	case err == io.EOF:
+		report(fileHeaderErr(ErrMalformedPcap, "file header cut off"))
+		return nil
+	case err != nil:
+		return err
+	}
+
+	if binary.BigEndian.Uint32(magic) == ngSHB {
+		err = checkPcapNg(src, out, report)
+	} else {
+		err = checkPcap(src, out, report)
+	}
+	if err != nil {
+		return err
+	}
+	return out.flush()
+}
+
+// writes the repaired capture and keeps track of how much
+// has been written. A nil writer writes nothing.
+type repairWriter struct {
+	w io.WriteSeeker
+	// records and blocks are written in several small
+	// pieces, which would each be a syscall for a file
+	buf *bufio.Writer
+	off int64
+	err error
+}
+
+func newRepairWriter(w io.WriteSeeker) *repairWriter {
+	return &repairWriter{w: w, buf: bufio.NewWriter(w)}
+}
+
+func (w *repairWriter) write(b []byte) {
+	if w == nil || w.err != nil {
+		return
+	}
+	n, err := w.buf.Write(b)
+	w.off += int64(n)
+	w.err = err
+}
+
+// overwrites what has been written at offset with b
+func (w *repairWriter) patch(offset int64, b []byte) {
+	if w == nil || w.err != nil {
+		return
+	}
+	// what is buffered has to be where it belongs first
+	if err := w.buf.Flush(); err != nil {
+		w.err = err
+		return
+	}
+	if _, err := w.w.Seek(offset, io.SeekStart); err != nil {
+		w.err = err
+		return
+	}
+	if _, err := w.w.Write(b); err != nil {
+		w.err = err
+		return
+	}
+	_, w.err = w.w.Seek(w.off, io.SeekStart)
+}
+
+func (w *repairWriter) offset() int64 {
+	if w == nil {
+		return 0
+	}
+	return w.off
+}
+
+// writes what is buffered and returns the first error
+func (w *repairWriter) flush() error {
+	if w == nil {
+		return nil
+	}
+	if w.err == nil {
+		w.err = w.buf.Flush()
+	}
+	return w.err
+}
+
+// reports the parts of the capture that are skipped while searching
+// for the next thing that looks right, as they are dropped from the copy
+func skippedReporter(report func(*FormatError)) ReaderOptions {
+	return ReaderOptions{
+		OnRecover: func(s Skipped) {
+			fe := *s.Reason.(*FormatError)
+			fe.Reason = fmt.Sprintf("%s, skipped %d bytes", fe.Reason, s.Length)
+			report(&fe)
+		},
+	}
+}
+
+/* pcap */
+
+func checkPcap(src source, out *repairWriter, report func(*FormatError)) error {
+	header, err := src.next(24)
+	switch err {
+	case nil:
+	case io.ErrUnexpectedEOF:
+		report(fileHeaderErr(ErrMalformedPcap, "file header cut off"))
+		return nil
+	default:
+		return err
+	}
+
+	byteOrder, nanoSecsFactor, err := checkMagic(header)
+	if err != nil {
+		// without the byte order nothing else can be read
+		report(err.(*FormatError))
+		return nil
+	}
+
+	p := &pcap{
+		src:            src,
+		byteOrder:      byteOrder,
+		nanoSecsFactor: nanoSecsFactor,
+		snaplen:        byteOrder.Uint32(header[16:20]),
+		packetHeader:   make([]byte, 16),
+		opts:           ReaderOptions{}.withDefaults(),
+	}
+	fixed := append([]byte(nil), header...)
+
+	major := byteOrder.Uint16(header[4:6])
+	minor := byteOrder.Uint16(header[6:8])
+	if major != 2 || minor != 4 {
+		report(fileHeaderErr(ErrPcapVersionNotSupported, fmt.Sprintf("version %d.%d", major, minor)))
+		byteOrder.PutUint16(fixed[4:6], 2)
+		byteOrder.PutUint16(fixed[6:8], 4)
+	}
+	if p.snaplen == 0 {
+		report(fileHeaderErr(ErrMalformedPcap, "snaplen is 0"))
+	}
+	out.write(fixed)
+
+	opts := skippedReporter(report)
+	maxSaved := uint32(0)
+	record := make([]byte, 0, 16)
+
+	for {
+		p.recordOffset = src.offset()
+
+		b, err := src.peek(recoverWindow)
+		atEnd := err == io.EOF
+		if err != nil && !atEnd {
+			return err
+		}
+		if len(b) == 0 {
+			break
+		}
+		if len(b) < 16 {
+			report(p.formatErr(ErrMalformedPcap, "record header cut off"))
+			src.skip(int64(len(b)))
+			break
+		}
+
+		// a record is believed when the one after it looks right as well.
+		// What it says about itself is checked after that so that
+		// it can be fixed instead of being thrown away.
+		if p.checkRecordEnd(b, atEnd) == recoverBroken {
+			reason := p.formatErr(ErrMalformedPcap, "record header does not look right")
+			err := resync(src, &opts, reason, p.checkRecords)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		copy(p.packetHeader, b)
+		secs := p.timeStampSecs()
+		subSecs := p.timeStampMSecs()
+		savedSize := p.packetSavedSize()
+		actualSize := p.packetActualSize()
+		record = append(record[:0], p.packetHeader...)
+
+		if int32(secs) < 0 {
+			report(p.formatErr(ErrMalformedPcap, "negative timestamp %d", int32(secs)))
+		}
+		if uint64(subSecs)*uint64(p.nanoSecsFactor) >= 1e9 {
+			report(p.formatErr(ErrMalformedPcap, "sub second part %d is a second or more", subSecs))
+		}
+		if savedSize > actualSize {
+			report(p.formatErr(ErrMalformedPcap, "saved size %d exceeds actual size %d", savedSize, actualSize))
+			byteOrder.PutUint32(record[12:16], savedSize)
+		}
+		if savedSize > p.snaplen {
+			report(p.formatErr(ErrMalformedPcap, "saved size %d exceeds snaplen %d", savedSize, p.snaplen))
+		}
+
+		if err := src.skip(16); err != nil {
+			return err
+		}
+		data, err := src.next(int(savedSize))
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			report(p.formatErr(ErrMalformedPcap, "packet data cut off"))
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		out.write(record)
+		out.write(data)
+		maxSaved = max(maxSaved, savedSize)
+		p.lastSecs = secs
+		p.haveLastSecs = true
+		p.packets++
+	}
+
+	// the readers do not accept packets larger than the snaplen
+	if maxSaved > p.snaplen {
+		snaplen := make([]byte, 4)
+		byteOrder.PutUint32(snaplen, maxSaved)
+		out.patch(16, snaplen)
+	}
+
+	return nil
+}
+
+// tells if the record at the start of b ends where it says it does,
+// which is when a record that looks right or the end comes after it.
+// Records that are larger than what can be looked at are believed.
+func (p *pcap) checkRecordEnd(b []byte, atEnd bool) recoverVerdict {
+	savedSize := p.byteOrder.Uint32(b[8:12])
+	if savedSize > recoverMaxBlock {
+		return recoverBroken
+	}
+
+	end := 16 + int(savedSize)
+	switch {
+	case end+16 <= len(b):
+		next := b[end : end+16]
+		if p.byteOrder.Uint32(next[8:12]) > recoverMaxBlock {
+			return recoverBroken
+		}
+		if p.haveLastSecs {
+			secs, last := int64(p.byteOrder.Uint32(next[0:4])), int64(p.lastSecs)
+			if secs < last-recoverBackward || secs > last+recoverForward {
+				return recoverBroken
+			}
+		}
+		return recoverFine
+	case atEnd:
+		// either this or the next record is cut off
+		return recoverFine
+	default:
+		return recoverNeedsMore
+	}
+}
+
+/* pcapng */
+
+// what is known about an interface while checking
+type ngCheckInterface struct {
+	snaplen      uint32
+	maxCaptured  uint32
+	unitsPerSec  uint64
+	tsOffset     int64
+	snaplenPatch int64 // where the snaplen has been written to
+}
+
+// the smallest size of each block, which is
+// the part of it that always has to be there
+var ngMinBlockLength = map[uint32]uint32{
+	ngSHB: ngMinSHBLen,
+	ngIDB: ngMinIDBLen,
+	ngSPB: ngMinSPBLen,
+	ngEPB: ngMinEPBLen,
+	ngISB: ngMinISBLen,
+}
+
+type ngChecker struct {
+	p      *pcapng
+	out    *repairWriter
+	report func(*FormatError)
+
+	// the block that is being checked, it is copied
+	// so that it can be fixed before it is written
+	buf []byte
+
+	inSection    bool
+	shbOffset    int64
+	shbBlock     int64
+	shbPackets   uint64
+	sectionOut   int64 // where the section after the SHB starts in the copy
+	sectionPatch int64 // where the section length has been written to
+	interfaces   []ngCheckInterface
+}
+
+func checkPcapNg(src source, out *repairWriter, report func(*FormatError)) error {
+	c := &ngChecker{
+		p:      &pcapng{src: src, block: -1, opts: ReaderOptions{}.withDefaults()},
+		out:    out,
+		report: report,
+	}
+	p := c.p
+	opts := skippedReporter(report)
+
+	for {
+		p.blockOffset = src.offset()
+		p.blockType = 0
+		p.block++
+
+		b, err := src.peek(12)
+		atEnd := err == io.EOF
+		if err != nil && !atEnd {
+			return err
+		}
+		if len(b) == 0 {
+			break
+		}
+		if len(b) < 12 {
+			c.reportf("block cut off")
+			src.skip(int64(len(b)))
+			break
+		}
+
+		// until there is a section only a SHB is looked for
+		check := p.checkBlocks
+		if !c.inSection {
+			check = p.checkSHBs
+		}
+
+		length, broken, err := c.checkFraming(b)
+		if err != nil {
+			return err
+		}
+		if broken != nil {
+			err := resync(src, &opts, broken, check)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		block, err := src.next(int(length))
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			c.reportf("block cut off")
+			break
+		}
+		if err != nil {
+			return err
+		}
+		c.buf = append(c.buf[:0], block...)
+
+		if c.checkBlock() {
+			c.out.write(c.buf)
+		}
+	}
+
+	c.endSection(src.offset())
+	return nil
+}
+
+func (c *ngChecker) reportf(format string, args ...any) {
+	c.report(c.p.formatErr(ErrMalformedPcap, format, args...))
+}
+
+// only a SHB starts a section
+func (p *pcapng) checkSHBs(b []byte, atEnd bool) recoverVerdict {
+	if len(b) < 4 {
+		if atEnd {
+			return recoverBroken
+		}
+		return recoverNeedsMore
+	}
+	if binary.BigEndian.Uint32(b[0:4]) != ngSHB {
+		return recoverBroken
+	}
+	return p.checkBlocks(b, atEnd)
+}
+
+// checks that the block at the start of b can be read as a whole and
+// returns its length. A total length at the end of the block that
+// does not match the one at the start is fixed when a block that
+// looks right comes after it or when the block is too large to look
+// past it. Other than that, a block whose framing
+// is broken cannot be trusted and has to be skipped, why is returned.
+func (c *ngChecker) checkFraming(b []byte) (uint32, *FormatError, error) {
+	p := c.p
+	broken := func(format string, args ...any) *FormatError {
+		return p.formatErr(ErrMalformedPcap, format, args...)
+	}
+
+	blockType := binary.BigEndian.Uint32(b[0:4])
+	if blockType == ngSHB {
+		switch {
+		case binary.BigEndian.Uint32(b[8:12]) == ngByteOrderMagic:
+			p.byteOrder = binary.BigEndian
+		case binary.LittleEndian.Uint32(b[8:12]) == ngByteOrderMagic:
+			p.byteOrder = binary.LittleEndian
+		default:
+			p.blockType = ngSHB
+			return 0, broken("unknown byte order magic 0x%08X", binary.BigEndian.Uint32(b[8:12])), nil
+		}
+	} else if !c.inSection {
+		return 0, broken("the block is not in a section"), nil
+	}
+
+	p.blockType = p.byteOrder.Uint32(b[0:4])
+	length := p.byteOrder.Uint32(b[4:8])
+	if length < ngMinBlockLen || length%4 != 0 || length > p.opts.MaxBlockSize {
+		return 0, broken("block total length %d is not valid", length), nil
+	}
+
+	b, err := p.src.peek(int(length) + 12)
+	atEnd := err == io.EOF
+	if err != nil && !atEnd {
+		return 0, nil, err
+	}
+	if len(b) < int(length) {
+		// either it is cut off, which reading it reports, or it is
+		// larger than can be looked at ahead. Then the length at the
+		// end is only checked by checkBlock once it has been read.
+		return length, nil, nil
+	}
+
+	trailing := p.byteOrder.Uint32(b[length-4 : length])
+	if trailing == length {
+		return length, nil, nil
+	}
+
+	rest := b[length:]
+	if (atEnd && len(rest) == 0) || p.checkBlocks(rest, atEnd) != recoverBroken {
+		// checkBlock reports and fixes it
+		return length, nil, nil
+	}
+	return 0, broken("block total length %d at the end does not match %d", trailing, length), nil
+}
+
+// checks what is inside the block in c.buf and fixes it where
+// it can be. Returns if the block should be kept.
+func (c *ngChecker) checkBlock() bool {
+	p := c.p
+	b := c.buf
+	bo := p.byteOrder
+
+	// the framing has been checked before so
+	// fixing the total length at the end is safe
+	if trailing := bo.Uint32(b[len(b)-4:]); trailing != uint32(len(b)) {
+		c.reportf("block total length %d at the end does not match %d", trailing, len(b))
+		bo.PutUint32(b[len(b)-4:], uint32(len(b)))
+	}
+
+	if minLen, ok := ngMinBlockLength[p.blockType]; ok && uint32(len(b)) < minLen {
+		c.reportf("block total length %d is less than the %d bytes a %s needs", len(b), minLen, ngBlockTypeName(p.blockType))
+		return false
+	}
+
+	switch p.blockType {
+	case ngSHB:
+		return c.checkSHB()
+	case ngIDB:
+		return c.checkIDB()
+	case ngSPB:
+		return c.checkSPB()
+	case ngEPB:
+		return c.checkEPB()
+	case ngISB:
+		ifId := bo.Uint32(b[8:12])
+		if int(ifId) >= len(c.interfaces) {
+			c.reportf("interface %d does not exist, there are %d", ifId, len(c.interfaces))
+			return false
+		}
+		c.checkOptions(20, nil)
+	}
+	return true
+}
+
+func (c *ngChecker) checkSHB() bool {
+	p := c.p
+	b := c.buf
+
+	c.endSection(p.blockOffset)
+	c.inSection = true
+	c.interfaces = c.interfaces[:0]
+	c.shbOffset = p.blockOffset
+	c.shbBlock = p.block
+	c.shbPackets = p.packets
+	p.sectionLen = p.byteOrder.Uint64(b[16:24])
+	p.sectionStart = p.src.offset()
+
+	major := p.byteOrder.Uint16(b[12:14])
+	minor := p.byteOrder.Uint16(b[14:16])
+	if major != 1 || (minor != 0 && minor != 2) {
+		c.report(p.formatErr(ErrPcapVersionNotSupported, "version %d.%d", major, minor))
+	}
+
+	c.checkOptions(24, nil)
+
+	c.sectionPatch = c.out.offset() + 16
+	c.sectionOut = c.out.offset() + int64(len(c.buf))
+	return true
+}
+
+// the section ends with the next SHB or the end
+// of the capture, so now its length can be checked
+func (c *ngChecker) endSection(end int64) {
+	if !c.inSection {
+		return
+	}
+	p := c.p
+	bo := p.byteOrder
+
+	if length := uint64(end - p.sectionStart); p.sectionLen != ngUnknownSectionLen && p.sectionLen != length {
+		c.report(&FormatError{
+			Err:       ErrMalformedPcap,
+			Offset:    c.shbOffset,
+			Packet:    c.shbPackets,
+			Block:     c.shbBlock,
+			BlockType: ngSHB,
+			Reason:    fmt.Sprintf("section length %d does not match %d", p.sectionLen, length),
+		})
+	}
+
+	// what has been dropped changes the length
+	// of the section in the copy as well
+	if p.sectionLen != ngUnknownSectionLen {
+		b := make([]byte, 8)
+		bo.PutUint64(b, uint64(c.out.offset()-c.sectionOut))
+		c.out.patch(c.sectionPatch, b)
+	}
+
+	// the readers do not accept packets larger than the snaplen
+	for _, iface := range c.interfaces {
+		if iface.snaplen != 0 && iface.maxCaptured > iface.snaplen {
+			b := make([]byte, 4)
+			bo.PutUint32(b, iface.maxCaptured)
+			c.out.patch(iface.snaplenPatch, b)
+		}
+	}
+}
+
+func (c *ngChecker) checkIDB() bool {
+	p := c.p
+	b := c.buf
+	bo := p.byteOrder
+
+	iface := ngCheckInterface{
+		snaplen:      bo.Uint32(b[12:16]),
+		unitsPerSec:  1e6,
+		snaplenPatch: c.out.offset() + 12,
+	}
+
+	c.checkOptions(16, func(code uint16, value []byte) {
+		switch code {
+		case 9: // if_tsresol
+			if len(value) != 1 {
+				c.reportf("if_tsresol has length %d instead of 1", len(value))
+				return
+			}
+			exp := value[0] & 0x7F
+			if value[0]>>7 == 1 {
+				if exp > 63 {
+					c.reportf("if_tsresol 2^-%d is too fine", exp)
+					return
+				}
+				iface.unitsPerSec = 1 << exp
+			} else {
+				if exp > 19 {
+					c.reportf("if_tsresol 10^-%d is too fine", exp)
+					return
+				}
+				iface.unitsPerSec = 1
+				for i := uint8(0); i < exp; i++ {
+					iface.unitsPerSec *= 10
+				}
+			}
+		case 14: // if_tsoffset
+			if len(value) != 8 {
+				c.reportf("if_tsoffset has length %d instead of 8", len(value))
+				return
+			}
+			iface.tsOffset = int64(bo.Uint64(value))
+		}
+	})
+
+	c.interfaces = append(c.interfaces, iface)
+	return true
+}
+
+func (c *ngChecker) checkSPB() bool {
+	if len(c.interfaces) == 0 {
+		c.reportf("SPB without an interface")
+		return false
+	}
+
+	// the reader cuts the data off at the snaplen,
+	// so unlike an EPB there is nothing to check
+
+	c.p.packets++
+	return true
+}
+
+func (c *ngChecker) checkEPB() bool {
+	p := c.p
+	b := c.buf
+	bo := p.byteOrder
+
+	ifId := bo.Uint32(b[8:12])
+	ts := uint64(bo.Uint32(b[12:16]))<<32 | uint64(bo.Uint32(b[16:20]))
+	captured := bo.Uint32(b[20:24])
+	origLen := bo.Uint32(b[24:28])
+
+	if captured > uint32(len(b)-32) {
+		c.reportf("EPB captured length %d exceeds block length %d", captured, len(b))
+		return false
+	}
+	if int(ifId) >= len(c.interfaces) {
+		c.reportf("interface %d does not exist, there are %d", ifId, len(c.interfaces))
+		return false
+	}
+	iface := &c.interfaces[ifId]
+
+	if captured > origLen {
+		c.reportf("captured length %d exceeds original length %d", captured, origLen)
+		bo.PutUint32(b[24:28], captured)
+	}
+	c.checkSnaplen(iface, captured)
+
+	if secs := ts / iface.unitsPerSec; iface.tsOffset < 0 && secs < uint64(-iface.tsOffset) {
+		c.reportf("negative timestamp %d", int64(secs)+iface.tsOffset)
+	}
+
+	c.checkOptions(28+int(captured+3)&^3, nil)
+
+	p.packets++
+	return true
+}
+
+func (c *ngChecker) checkSnaplen(iface *ngCheckInterface, captured uint32) {
+	if iface.snaplen != 0 && captured > iface.snaplen {
+		c.reportf("captured length %d exceeds snaplen %d", captured, iface.snaplen)
+		iface.maxCaptured = max(iface.maxCaptured, captured)
+	}
+}
+
+// checks the options that start at offset in the block and hands
+// each one to fn. Options after the first one that is broken are
+// cut off, which shortens the block.
+func (c *ngChecker) checkOptions(offset int, fn func(code uint16, value []byte)) {
+	bo := c.p.byteOrder
+	options := c.buf[offset : len(c.buf)-4]
+
+	good := 0
+	for good < len(options) {
+		if len(options)-good < 4 {
+			c.reportf("option header cut off")
+			break
+		}
+		code := bo.Uint16(options[good : good+2])
+		valueLen := int(bo.Uint16(options[good+2 : good+4]))
+		padded := (valueLen + 3) &^ 3
+		if padded > len(options)-good-4 {
+			c.reportf("option %d with length %d does not fit into the block", code, valueLen)
+			break
+		}
+
+		if code == 0 { // opt_endofopt
+			if valueLen != 0 {
+				c.reportf("opt_endofopt has length %d", valueLen)
+				break
+			}
+			good += 4
+			if good < len(options) {
+				c.reportf("%d bytes after opt_endofopt", len(options)-good)
+			}
+			break
+		}
+
+		if fn != nil {
+			fn(code, options[good+4:good+4+valueLen])
+		}
+		good += 4 + padded
+	}
+
+	if good == len(options) {
+		return
+	}
+
+	// everything after the last good option is dropped
+	length := offset + good + 4
+	c.buf = c.buf[:length]
+	bo.PutUint32(c.buf[4:8], uint32(length))
+	bo.PutUint32(c.buf[length-4:], uint32(length))
+}
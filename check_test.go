@@ -0,0 +1,121 @@
+package pcapreader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckRepair(t *testing.T) {
+	pcap := genPcap(genPcapOpts{order: binary.LittleEndian, snaplen: 0xFFFF, packets: 50})
+	var g genNg
+	g.shb(binary.BigEndian, 1, 0)
+	g.idb(1, 0)
+	g.packets(0, 1e6, 0, 20)
+	middle := g.b.Len()
+	g.packets(0, 1e6, 20, 30)
+	pcapng := g.b.Bytes()
+
+	for _, c := range []struct {
+		name string
+		data []byte
+		// where garbage is put in between two records
+		garbageAt int
+		// the packets that are lost to the garbage
+		lost int
+	}{
+		// the record before the garbage is not followed by
+		// one that looks right, so it is skipped as well
+		{"pcap", pcap, 24 + 16 + genSizes[0], 1},
+		{"pcapng", pcapng, middle, 0},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			// the last packet is cut off as well
+			var corrupt []byte
+			corrupt = append(corrupt, c.data[:c.garbageAt]...)
+			corrupt = append(corrupt, "some garbage"...)
+			corrupt = append(corrupt, c.data[c.garbageAt:len(c.data)-10]...)
+
+			out, err := os.Create(filepath.Join(t.TempDir(), "fixed"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer out.Close()
+			var problems []*FormatError
+			err = Check(bytes.NewReader(corrupt), CheckOptions{Repair: out}, func(fe *FormatError) {
+				problems = append(problems, fe)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(problems) != 2 {
+				t.Errorf("problems %v", problems)
+			}
+
+			if _, err := out.Seek(0, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			traffic, err := OpenReader(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer traffic.Stop()
+			for i := c.lost; ; i++ {
+				info, packet, err := traffic.Next()
+				if err == io.EOF {
+					if i != 49 {
+						t.Errorf("%d packets in the fixed copy", i-c.lost)
+					}
+					break
+				}
+				if err != nil {
+					t.Fatalf("packet %d: %v", i, err)
+				}
+				size := genSizes[i%len(genSizes)]
+				if !bytes.Equal(packet, genData(i, size)) || info.Size != uint32(size) {
+					t.Errorf("packet %d differs", i)
+				}
+			}
+		})
+	}
+}
+
+// the end of a block larger than what is looked at ahead
+// is checked once the whole block has been read
+func TestCheckLargeBlock(t *testing.T) {
+	var g genNg
+	g.shb(binary.LittleEndian, 1, 0)
+	g.idb(1, 0)
+	start := g.b.Len()
+	large := genData(0, readerSourceBufferSize+1000)
+	g.epb(0, 0, large, uint32(len(large)))
+	end := g.b.Len()
+	g.packets(0, 1e6, 1, 1)
+	valid := g.b.Bytes()
+	data := corrupt(valid, end-4, 12)
+
+	name := filepath.Join(t.TempDir(), "fixed")
+	out, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	var problems []*FormatError
+	err = Check(bytes.NewReader(data), CheckOptions{Repair: out}, func(fe *FormatError) {
+		problems = append(problems, fe)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("block total length 12 at the end does not match %d", end-start)
+	if len(problems) != 1 || problems[0].Offset != int64(start) || problems[0].Reason != want {
+		t.Fatalf("problems %v", problems)
+	}
+	if fixed, err := os.ReadFile(name); err != nil || !bytes.Equal(fixed, valid) {
+		t.Errorf("the block has not been fixed: %v", err)
+	}
+}
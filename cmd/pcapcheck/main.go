@@ -0,0 +1,89 @@
+// Checks the structure of pcap and pcapng captures and prints
+// every problem found. With -repair a fixed copy is written.
+//
+//	pcapcheck [-repair fixed.pcapng] capture.pcapng
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Sojamann/pcapreader"
+)
+
+func main() {
+	repair := flag.String("repair", "", "write a fixed copy of the capture to this file")
+	quiet := flag.Bool("q", false, "only print how many problems have been found")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-repair file] [-q] file\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	name := flag.Arg(0)
+
+	in, err := os.Open(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open the capture. Reason: %v\n", err)
+		os.Exit(2)
+	}
+	defer in.Close()
+
+	var opts pcapreader.CheckOptions
+	var out *os.File
+	if *repair != "" {
+		if same(name, *repair) {
+			fmt.Fprintln(os.Stderr, "The fixed copy cannot be written over the capture")
+			os.Exit(2)
+		}
+		out, err = os.Create(*repair)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not create the fixed copy. Reason: %v\n", err)
+			os.Exit(2)
+		}
+		opts.Repair = out
+	}
+
+	problems := 0
+	err = pcapreader.Check(in, opts, func(fe *pcapreader.FormatError) {
+		problems++
+		if !*quiet {
+			fmt.Println(fe)
+		}
+	})
+	// os.Exit does not run deferred calls, and the
+	// copy is only complete once it has been closed
+	if out != nil {
+		if closeErr := out.Close(); closeErr != nil && err == nil {
+			fmt.Fprintf(os.Stderr, "Could not write the fixed copy. Reason: %v\n", closeErr)
+			os.Exit(2)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not check the capture. Reason: %v\n", err)
+		os.Exit(2)
+	}
+
+	fmt.Fprintf(os.Stderr, "%d problems found in %s\n", problems, name)
+	if problems > 0 {
+		os.Exit(1)
+	}
+}
+
+// reports if a and b are the same file
+func same(a, b string) bool {
+	sa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	sb, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(sa, sb)
+}
@@ -0,0 +1,155 @@
+// Edits pcap and pcapng captures like editcap. The packets are
+// selected, deduplicated, cut off and moved in time in that order.
+//
+//	pcapedit [-first n] [-last n] [-start time] [-end time] [-dedup n]
+//		[-snaplen n] [-shift duration] [-format pcap|pcapng] in out
+//
+// Either file can be - for stdin and stdout.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/Sojamann/pcapreader"
+)
+
+func main() {
+	first := flag.Uint64("first", 1, "the first packet to keep, counted from 1")
+	last := flag.Uint64("last", 0, "the last packet to keep, 0 for all up to the end")
+	start := flag.String("start", "", "keep packets captured at or after this RFC 3339 time")
+	end := flag.String("end", "", "keep packets captured before this RFC 3339 time")
+	dedup := flag.Int("dedup", 0, "drop packets that are the same as one of the this many before them")
+	snaplen := flag.Uint("snaplen", 0, "cut packets off after this many bytes")
+	shift := flag.Duration("shift", 0, "move all packets by this much in time, i.e. -1h30m")
+	format := flag.String("format", "", "pcap or pcapng, defaults to the extension of out")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] in out\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	inName, outName := flag.Arg(0), flag.Arg(1)
+
+	startTime, err := parseTime(*start)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid start. Reason: %v\n", err)
+		os.Exit(2)
+	}
+	endTime, err := parseTime(*end)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid end. Reason: %v\n", err)
+		os.Exit(2)
+	}
+	if *first == 0 {
+		fmt.Fprintln(os.Stderr, "Packets are counted from 1")
+		os.Exit(2)
+	}
+	if *format == "" {
+		*format = "pcapng"
+		if filepath.Ext(outName) == ".pcap" {
+			*format = "pcap"
+		}
+	}
+	if *format != "pcap" && *format != "pcapng" {
+		fmt.Fprintf(os.Stderr, "Unknown format %s\n", *format)
+		os.Exit(2)
+	}
+	if inName != "-" && same(inName, outName) {
+		fmt.Fprintln(os.Stderr, "The output cannot be written over the input")
+		os.Exit(2)
+	}
+
+	traffic, err := open(inName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open the capture. Reason: %v\n", err)
+		os.Exit(1)
+	}
+	defer traffic.Stop()
+
+	to := uint64(math.MaxUint64)
+	if *last != 0 {
+		to = *last
+	}
+	traffic = pcapreader.Slice(traffic, *first-1, to)
+	traffic = pcapreader.TimeWindow(traffic, startTime, endTime)
+	if *dedup > 0 {
+		traffic = pcapreader.Dedup(traffic, *dedup)
+	}
+	if *snaplen > 0 {
+		traffic = pcapreader.Snap(traffic, uint32(min(*snaplen, math.MaxUint32)))
+	}
+	if *shift != 0 {
+		traffic = pcapreader.ShiftTime(traffic, *shift)
+	}
+
+	var out io.Writer = os.Stdout
+	if outName != "-" {
+		f, err := os.Create(outName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not create the output. Reason: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	var w pcapreader.Writer = pcapreader.NewPcapNgWriter(out)
+	if *format == "pcap" {
+		w = pcapreader.NewPcapWriter(out)
+	}
+
+	// stop reading when interrupted
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	n, err := pcapreader.CopyPackets(ctx, w, traffic)
+	fmt.Fprintf(os.Stderr, "%d packets written\n", n)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not edit all of the capture. Reason: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// a zero time for an empty string
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// opens the capture at name or reads it from stdin if name is -
+func open(name string) (pcapreader.Traffic, error) {
+	if name == "-" {
+		return pcapreader.OpenReader(os.Stdin)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return pcapreader.OpenReader(f)
+}
+
+// reports if a and b are the same file
+func same(a, b string) bool {
+	sa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	sb, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(sa, sb)
+}
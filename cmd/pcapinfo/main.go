@@ -0,0 +1,188 @@
+// Prints what capinfos does for pcap and pcapng captures,
+// as text or as JSON, including a summary per interface.
+//
+//	pcapinfo [-json] capture.pcapng...
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Sojamann/pcapreader"
+)
+
+// a summary of a file as it is printed as JSON. The
+// rates are computed here as they are not fields.
+type fileSummary struct {
+	File string `json:"file"`
+	jsonCounts
+	Ordered    bool                    `json:"ordered"`
+	Sizes      []pcapreader.SizeBucket `json:"sizes"`
+	Interfaces []interfaceSummary      `json:"interfaces"`
+	Error      string                  `json:"error,omitempty"`
+}
+
+type interfaceSummary struct {
+	pcapreader.Interface
+	jsonCounts
+}
+
+type jsonCounts struct {
+	pcapreader.Counts
+	DurationSeconds float64 `json:"duration_seconds"`
+	DataRate        float64 `json:"data_bytes_per_second"`
+	PacketRate      float64 `json:"packets_per_second"`
+	AverageSize     float64 `json:"average_size"`
+}
+
+func counts(c pcapreader.Counts) jsonCounts {
+	return jsonCounts{
+		Counts:          c,
+		DurationSeconds: c.Duration().Seconds(),
+		DataRate:        c.DataRate(),
+		PacketRate:      c.PacketRate(),
+		AverageSize:     c.AverageSize(),
+	}
+}
+
+func main() {
+	asJSON := flag.Bool("json", false, "print the summaries as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-json] file...\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// stop reading when interrupted
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	failed := false
+	var summaries []fileSummary
+	for _, name := range flag.Args() {
+		s, err := summarize(ctx, name)
+		if err != nil {
+			failed = true
+			fmt.Fprintf(os.Stderr, "Could not read all of %s. Reason: %v\n", name, err)
+		}
+		if s == nil {
+			continue
+		}
+
+		if *asJSON {
+			summaries = append(summaries, toJSON(name, s, err))
+			continue
+		}
+		printText(os.Stdout, name, s)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(summaries); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write the summaries. Reason: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// the summary is nil if the capture could not be opened
+func summarize(ctx context.Context, name string) (*pcapreader.Summary, error) {
+	traffic, err := pcapreader.OpenFile(name)
+	if err == pcapreader.ErrUnkownExtension {
+		var f *os.File
+		if f, err = os.Open(name); err == nil {
+			traffic, err = pcapreader.OpenReader(f)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer traffic.Stop()
+
+	return pcapreader.Summarize(ctx, traffic)
+}
+
+func toJSON(name string, s *pcapreader.Summary, err error) fileSummary {
+	fs := fileSummary{
+		File:       name,
+		jsonCounts: counts(s.Counts),
+		Ordered:    s.Ordered,
+		Sizes:      s.Sizes,
+	}
+	for _, iface := range s.Interfaces {
+		fs.Interfaces = append(fs.Interfaces, interfaceSummary{Interface: iface.Interface, jsonCounts: counts(iface.Counts)})
+	}
+	if err != nil {
+		fs.Error = err.Error()
+	}
+	return fs
+}
+
+func printText(out io.Writer, name string, s *pcapreader.Summary) {
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "File name:\t%s\n", name)
+	printCounts(w, "", s.Counts)
+	fmt.Fprintf(w, "Strict time order:\t%v\n", s.Ordered)
+
+	fmt.Fprintf(w, "Packet sizes:\tpackets\n")
+	for _, b := range s.Sizes {
+		if b.Max == 0 {
+			fmt.Fprintf(w, "  %d and more:\t%d\n", b.Min, b.Packets)
+			continue
+		}
+		fmt.Fprintf(w, "  %d-%d:\t%d\n", b.Min, b.Max, b.Packets)
+	}
+
+	for i, iface := range s.Interfaces {
+		fmt.Fprintf(w, "Interface #%d:\tsection %d, id %d\n", i, iface.Section, iface.ID)
+		fmt.Fprintf(w, "  Link layer type:\t%s\n", formatLinkLayerType(iface.LinkLayerType))
+		fmt.Fprintf(w, "  Snaplen:\t%d\n", iface.Snaplen)
+		printCounts(w, "  ", iface.Counts)
+	}
+	fmt.Fprintln(w)
+	w.Flush()
+}
+
+func printCounts(w io.Writer, indent string, c pcapreader.Counts) {
+	fmt.Fprintf(w, "%sPackets:\t%d\n", indent, c.Packets)
+	fmt.Fprintf(w, "%sData size:\t%d bytes\n", indent, c.DataBytes)
+	fmt.Fprintf(w, "%sCaptured size:\t%d bytes\n", indent, c.CapturedBytes)
+	fmt.Fprintf(w, "%sFirst packet time:\t%s\n", indent, formatTime(c.First))
+	fmt.Fprintf(w, "%sLast packet time:\t%s\n", indent, formatTime(c.Last))
+	fmt.Fprintf(w, "%sDuration:\t%s\n", indent, c.Duration())
+	fmt.Fprintf(w, "%sData byte rate:\t%.2f bytes/s\n", indent, c.DataRate())
+	fmt.Fprintf(w, "%sData bit rate:\t%.2f bits/s\n", indent, c.DataRate()*8)
+	fmt.Fprintf(w, "%sAverage packet size:\t%.2f bytes\n", indent, c.AverageSize())
+	fmt.Fprintf(w, "%sAverage packet rate:\t%.2f packets/s\n", indent, c.PacketRate())
+}
+
+// i.e. "Ethernet (EN10MB, 1)"
+func formatLinkLayerType(llt pcapreader.LinkLayerType) string {
+	if !llt.Known() {
+		return fmt.Sprintf("unknown (%d)", llt)
+	}
+	return fmt.Sprintf("%s (%s, %d)", llt.Description(), llt, uint32(llt))
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "n/a"
+	}
+	return t.UTC().Format("2006-01-02 15:04:05.000000000")
+}
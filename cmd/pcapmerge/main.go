@@ -0,0 +1,127 @@
+// Merges pcap and pcapng captures into one ordered by time, like
+// mergecap. With -a the captures are appended one after the other.
+// Every capture gets interfaces of its own in a pcapng.
+//
+//	pcapmerge [-a] [-format pcap|pcapng] -w merged.pcapng in.pcap...
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/Sojamann/pcapreader"
+)
+
+func main() {
+	appendOnly := flag.Bool("a", false, "append the captures instead of merging them by time")
+	outName := flag.String("w", "", "the file to write to, - for stdout")
+	format := flag.String("format", "", "pcap or pcapng, defaults to the extension of the output")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-a] [-format pcap|pcapng] -w out file...\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 || *outName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *format == "" {
+		*format = "pcapng"
+		if filepath.Ext(*outName) == ".pcap" {
+			*format = "pcap"
+		}
+	}
+	if *format != "pcap" && *format != "pcapng" {
+		fmt.Fprintf(os.Stderr, "Unknown format %s\n", *format)
+		os.Exit(2)
+	}
+
+	var inputs []pcapreader.Traffic
+	stopAll := func() {
+		for _, t := range inputs {
+			t.Stop()
+		}
+	}
+	for _, name := range flag.Args() {
+		if same(name, *outName) {
+			stopAll()
+			fmt.Fprintf(os.Stderr, "The output cannot be written over %s\n", name)
+			os.Exit(2)
+		}
+		t, err := open(name)
+		if err != nil {
+			stopAll()
+			fmt.Fprintf(os.Stderr, "Could not open %s. Reason: %v\n", name, err)
+			os.Exit(1)
+		}
+		inputs = append(inputs, t)
+	}
+
+	var traffic pcapreader.Traffic
+	if *appendOnly {
+		traffic = pcapreader.Append(inputs...)
+	} else {
+		traffic = pcapreader.Merge(inputs...)
+	}
+	defer traffic.Stop()
+
+	var out io.Writer = os.Stdout
+	if *outName != "-" {
+		f, err := os.Create(*outName)
+		if err != nil {
+			traffic.Stop()
+			fmt.Fprintf(os.Stderr, "Could not create the output. Reason: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	var w pcapreader.Writer = pcapreader.NewPcapNgWriter(out)
+	if *format == "pcap" {
+		w = pcapreader.NewPcapWriter(out)
+	}
+
+	// stop reading when interrupted
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	n, err := pcapreader.CopyPackets(ctx, w, traffic)
+	fmt.Fprintf(os.Stderr, "%d packets written\n", n)
+	if err == pcapreader.ErrLinkLayerTypeMismatch {
+		fmt.Fprintln(os.Stderr, "The captures have different link layer types, which only a pcapng can hold")
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not merge all of the captures. Reason: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// opens a capture no matter its extension, with all of its
+// interfaces so that merged captures can be merged again
+func open(name string) (pcapreader.Traffic, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return pcapreader.OpenReaderWithOptions(f, pcapreader.ReaderOptions{AllInterfaces: true})
+}
+
+// reports if a and b are the same file
+func same(a, b string) bool {
+	sa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	sb, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(sa, sb)
+}
@@ -0,0 +1,102 @@
+// Splits a pcap or pcapng capture into several files like editcap -c
+// and -i, by size or into a file for every flow.
+//
+//	pcapsplit [-packets n] [-interval duration] [-size bytes] [-flow]
+//		[-max-open n] [-format pcap|pcapng] [-o template] in
+//
+// The input can be - for stdin. In the template {n} is replaced with the
+// number of the file, {time} with the time of its first packet and {flow}
+// with the flow of its packets.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sojamann/pcapreader"
+	"github.com/Sojamann/pcapreader/decode"
+)
+
+func main() {
+	packets := flag.Uint64("packets", 0, "start a new file after this many packets")
+	interval := flag.Duration("interval", 0, "start a new file for every interval of the wall clock, i.e. 1h")
+	size := flag.Int64("size", 0, "start a new file once a file has this many bytes")
+	byFlow := flag.Bool("flow", false, "write every flow into files of its own")
+	maxOpen := flag.Int("max-open", 256, "how many files are kept open at most when splitting by flow")
+	format := flag.String("format", "", "pcap or pcapng, defaults to the extension of the template")
+	template := flag.String("o", "", "the names of the files, defaults to split_{n}.pcapng")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] in\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *packets == 0 && *interval <= 0 && *size <= 0 && !*byFlow {
+		fmt.Fprintln(os.Stderr, "One of -packets, -interval, -size and -flow is needed")
+		os.Exit(2)
+	}
+	if *format == "" {
+		*format = "pcapng"
+		if filepath.Ext(*template) == ".pcap" {
+			*format = "pcap"
+		}
+	}
+	if *format != "pcap" && *format != "pcapng" {
+		fmt.Fprintf(os.Stderr, "Unknown format %s\n", *format)
+		os.Exit(2)
+	}
+	if *template != "" && !strings.Contains(*template, "{n}") &&
+		!strings.Contains(*template, "{time}") && !strings.Contains(*template, "{flow}") {
+		fmt.Fprintln(os.Stderr, "The template needs {n}, {time} or {flow}, otherwise all files have the same name")
+		os.Exit(2)
+	}
+
+	traffic, err := open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open the capture. Reason: %v\n", err)
+		os.Exit(1)
+	}
+	defer traffic.Stop()
+
+	// stop reading when interrupted
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	opts := pcapreader.SplitOptions{
+		Packets:      *packets,
+		Interval:     *interval,
+		Size:         *size,
+		MaxOpenFiles: *maxOpen,
+		Template:     *template,
+		Pcap:         *format == "pcap",
+	}
+	if *byFlow {
+		opts.FlowOf = decode.SplitFlow
+	}
+	names, err := pcapreader.Split(ctx, traffic, opts)
+	fmt.Fprintf(os.Stderr, "%d files written\n", len(names))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not split all of the capture. Reason: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// opens the capture at name or reads it from stdin if name is -
+func open(name string) (pcapreader.Traffic, error) {
+	if name == "-" {
+		return pcapreader.OpenReader(os.Stdin)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return pcapreader.OpenReader(f)
+}
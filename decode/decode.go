@@ -0,0 +1,285 @@
+// Package decode splits packets into their layers, i.e. Ethernet,
+// IPv4 and TCP. The layers are views into the packet, nothing is
+// copied, so they are only valid as long as the packet is.
+//
+//	packets := pcapreader.Packets(ctx, traffic)
+//	for info, packet := range packets.All() {
+//		p := decode.Decode(pcapreader.InterfaceOf(traffic).LinkLayerType, packet, info.Size)
+//		if tcp, ok := decode.First[decode.TCP](p); ok {
+//			...
+//		}
+//	}
+package decode
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/Sojamann/pcapreader"
+)
+
+var (
+	// a header is cut off because less of the packet has been captured
+	// than it had on the wire, which is normal when a snaplen is used
+	ErrTruncated = errors.New("packet has been cut off while capturing")
+	// a header is cut off although the whole packet has been captured,
+	// or its fields make no sense
+	ErrMalformed = errors.New("packet is malformed")
+)
+
+// LayerType tells what a layer is
+type LayerType uint8
+
+const (
+	LayerPayload LayerType = iota
+	LayerEthernet
+	LayerLLC
+	LayerDot1Q
+	LayerARP
+	LayerIPv4
+	LayerIPv6
+	LayerIPv6Extension
+	LayerIPv6Fragment
+	LayerTCP
+	LayerUDP
+	LayerICMPv4
+	LayerICMPv6
+	LayerLinuxSLL
+	LayerLinuxSLL2
+	LayerLoopback
+	LayerPPP
+	LayerPPPoE
+	LayerCiscoHDLC
+	LayerRadiotap
+	LayerPPI
+	LayerDot11
+	LayerDot11Management
+	LayerGRE
+	LayerERSPAN
+	LayerVXLAN
+	LayerGeneve
+	LayerGTPU
+	LayerMPLS
+)
+
+var layerTypeNames = [...]string{
+	LayerPayload:         "Payload",
+	LayerEthernet:        "Ethernet",
+	LayerLLC:             "LLC",
+	LayerDot1Q:           "Dot1Q",
+	LayerARP:             "ARP",
+	LayerIPv4:            "IPv4",
+	LayerIPv6:            "IPv6",
+	LayerIPv6Extension:   "IPv6Extension",
+	LayerIPv6Fragment:    "IPv6Fragment",
+	LayerTCP:             "TCP",
+	LayerUDP:             "UDP",
+	LayerICMPv4:          "ICMPv4",
+	LayerICMPv6:          "ICMPv6",
+	LayerLinuxSLL:        "LinuxSLL",
+	LayerLinuxSLL2:       "LinuxSLL2",
+	LayerLoopback:        "Loopback",
+	LayerPPP:             "PPP",
+	LayerPPPoE:           "PPPoE",
+	LayerCiscoHDLC:       "CiscoHDLC",
+	LayerRadiotap:        "Radiotap",
+	LayerPPI:             "PPI",
+	LayerDot11:           "Dot11",
+	LayerDot11Management: "Dot11Management",
+	LayerGRE:             "GRE",
+	LayerERSPAN:          "ERSPAN",
+	LayerVXLAN:           "VXLAN",
+	LayerGeneve:          "Geneve",
+	LayerGTPU:            "GTPU",
+	LayerMPLS:            "MPLS",
+}
+
+func (t LayerType) String() string {
+	if int(t) < len(layerTypeNames) && layerTypeNames[t] != "" {
+		return layerTypeNames[t]
+	}
+	return "LayerType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Layer is one header of a packet, or the payload at its end
+type Layer interface {
+	LayerType() LayerType
+	// Returns the header
+	Contents() []byte
+	// Returns what follows the header, without the
+	// padding of the layers below. May be cut off.
+	Payload() []byte
+}
+
+// the views all start out as this
+type layer struct {
+	contents []byte
+	payload  []byte
+}
+
+func (l layer) Contents() []byte { return l.contents }
+func (l layer) Payload() []byte  { return l.payload }
+
+// Payload is what is left when no more layers can be decoded, either
+// because its protocol is not known or it is a fragment. Its data are
+// its Contents, it has no payload of its own.
+type Payload struct{ layer }
+
+func (Payload) LayerType() LayerType { return LayerPayload }
+
+// Packet holds the layers of a packet from the outermost to the innermost
+type Packet struct {
+	Layers []Layer
+	// Whether less of the packet has been captured than it had on the wire
+	Truncated bool
+	// The first problem found, ErrTruncated or ErrMalformed. Decoding
+	// stops at a header that is cut off or makes no sense, the layers
+	// up to there are still available. A length that points past the
+	// end of what has been captured only cuts the payload short.
+	Err error
+}
+
+// Returns the first layer of type t or nil
+func (p *Packet) Layer(t LayerType) Layer {
+	for _, l := range p.Layers {
+		if l.LayerType() == t {
+			return l
+		}
+	}
+	return nil
+}
+
+// Returns the outermost layer of type L, i.e. First[decode.TCP](p)
+func First[L Layer](p *Packet) (L, bool) {
+	for _, l := range p.Layers {
+		if l, ok := l.(L); ok {
+			return l, true
+		}
+	}
+	var zero L
+	return zero, false
+}
+
+// Returns the innermost layer of type L, which differs
+// from the outermost one when packets are tunneled
+func Last[L Layer](p *Packet) (L, bool) {
+	for i := len(p.Layers) - 1; i >= 0; i-- {
+		if l, ok := p.Layers[i].(L); ok {
+			return l, true
+		}
+	}
+	var zero L
+	return zero, false
+}
+
+// Decodes a packet of the link layer type llt. size is its
+// size on the wire, as in PacketInfo, which tells whether it
+// has been cut off while capturing. Packets of link layer types
+// that are not supported are a single Payload.
+func Decode(llt pcapreader.LinkLayerType, data []byte, size uint32) *Packet {
+	p := &Packet{}
+	DecodeInto(p, llt, data, size)
+	return p
+}
+
+// Like Decode but reuses p, so that the layers
+// slice does not have to be allocated again
+func DecodeInto(p *Packet, llt pcapreader.LinkLayerType, data []byte, size uint32) {
+	clear(p.Layers)
+	p.Layers = p.Layers[:0]
+	p.Truncated = uint32(len(data)) < size
+	p.Err = nil
+
+	d := decoder{p: p}
+	d.link(llt, data)
+}
+
+// decodes the layers one after the other,
+// every one calling the one that follows it
+type decoder struct {
+	p *Packet
+}
+
+func (d *decoder) add(l Layer) {
+	d.p.Layers = append(d.p.Layers, l)
+}
+
+// records err if it is the first problem
+func (d *decoder) fail(err error) {
+	if d.p.Err == nil {
+		d.p.Err = err
+	}
+}
+
+// records that a header needs more than there is of data
+func (d *decoder) cut() {
+	if d.p.Truncated {
+		d.fail(ErrTruncated)
+	} else {
+		d.fail(ErrMalformed)
+	}
+}
+
+// splits off a header of length n, or records
+// that it is cut off when data is shorter
+func (d *decoder) header(data []byte, n int) (layer, bool) {
+	if n > len(data) {
+		d.cut()
+		return layer{}, false
+	}
+	return layer{contents: data[:n:n], payload: data[n:]}, true
+}
+
+// cuts the payload of l down to length n, which removes the padding
+// of the layers below, or records that it is cut off when it is shorter
+func (d *decoder) limit(l *layer, n int) {
+	if n < len(l.payload) {
+		l.payload = l.payload[:n]
+	} else if n > len(l.payload) && !d.p.Truncated {
+		d.fail(ErrMalformed)
+	}
+}
+
+func (d *decoder) payload(data []byte) {
+	if len(data) > 0 {
+		d.add(Payload{layer{contents: data}})
+	}
+}
+
+// decodes a packet of the link layer type llt
+func (d *decoder) link(llt pcapreader.LinkLayerType, data []byte) {
+	switch llt {
+	case pcapreader.LinkTypeEthernet:
+		d.ethernet(data)
+	case pcapreader.LinkTypeLinuxSLL:
+		d.linuxSLL(data)
+	case pcapreader.LinkTypeLinuxSLL2:
+		d.linuxSLL2(data)
+	case pcapreader.LinkTypeRaw:
+		d.raw(data)
+	case pcapreader.LinkTypeIPv4:
+		d.ipv4(data)
+	case pcapreader.LinkTypeIPv6:
+		d.ipv6(data)
+	case pcapreader.LinkTypeNull:
+		d.loopback(data, false)
+	case pcapreader.LinkTypeLoop:
+		d.loopback(data, true)
+	case pcapreader.LinkTypePPP, pcapreader.LinkTypePPPHDLC:
+		d.ppp(data, false)
+	case pcapreader.LinkTypePPPWithDir:
+		d.ppp(data, true)
+	case pcapreader.LinkTypePPPEther:
+		d.pppoe(data)
+	case pcapreader.LinkTypeCHDLC:
+		d.ciscoHDLC(data)
+	case pcapreader.LinkTypeIEEE802_11:
+		d.dot11(data, false, false)
+	case pcapreader.LinkTypeIEEE802_11Radiotap:
+		d.radiotap(data)
+	case pcapreader.LinkTypePPI:
+		d.ppi(data)
+	default:
+		d.payload(data)
+	}
+}
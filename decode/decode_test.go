@@ -0,0 +1,461 @@
+package decode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/Sojamann/pcapreader"
+)
+
+// builds packets from the inside out
+type testPacket []byte
+
+func (p testPacket) ethernet(t EtherType) testPacket {
+	h := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 0, 0}
+	binary.BigEndian.PutUint16(h[12:14], uint16(t))
+	return append(h, p...)
+}
+
+func (p testPacket) dot1q(vlan uint16, t EtherType) testPacket {
+	h := make([]byte, 4)
+	binary.BigEndian.PutUint16(h[0:2], 5<<13|vlan)
+	binary.BigEndian.PutUint16(h[2:4], uint16(t))
+	return append(h, p...)
+}
+
+func (p testPacket) llc(t EtherType) testPacket {
+	h := []byte{0xAA, 0xAA, 0x03, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(h[6:8], uint16(t))
+	return append(h, p...)
+}
+
+func (p testPacket) ipv4(proto IPProtocol, options ...byte) testPacket {
+	h := make([]byte, 20, 20+len(options))
+	h = append(h, options...)
+	h[0] = 0x40 | byte(len(h)/4)
+	binary.BigEndian.PutUint16(h[2:4], uint16(len(h)+len(p)))
+	h[8] = 64
+	h[9] = byte(proto)
+	copy(h[12:16], []byte{10, 0, 0, 1})
+	copy(h[16:20], []byte{10, 0, 0, 2})
+	binary.BigEndian.PutUint16(h[10:12], checksum(h, 0))
+	return append(h, p...)
+}
+
+func (p testPacket) ipv6(next IPProtocol) testPacket {
+	h := make([]byte, 40)
+	h[0] = 0x60
+	binary.BigEndian.PutUint16(h[4:6], uint16(len(p)))
+	h[6] = byte(next)
+	h[7] = 64
+	h[8], h[23] = 0x20, 1
+	h[24], h[39] = 0x20, 2
+	return append(h, p...)
+}
+
+func (p testPacket) tcp(flags TCPFlags, options ...byte) testPacket {
+	h := make([]byte, 20, 20+len(options))
+	h = append(h, options...)
+	binary.BigEndian.PutUint16(h[0:2], 4711)
+	binary.BigEndian.PutUint16(h[2:4], 80)
+	binary.BigEndian.PutUint32(h[4:8], 1000)
+	binary.BigEndian.PutUint16(h[12:14], uint16(len(h)/4)<<12|uint16(flags))
+	return append(h, p...)
+}
+
+func (p testPacket) udp() testPacket {
+	h := make([]byte, 8)
+	binary.BigEndian.PutUint16(h[0:2], 53)
+	binary.BigEndian.PutUint16(h[2:4], 5353)
+	binary.BigEndian.PutUint16(h[4:6], uint16(8+len(p)))
+	return append(h, p...)
+}
+
+// the layer types of p and its error
+func layerTypes(p *Packet) string {
+	var types []string
+	for _, l := range p.Layers {
+		types = append(types, l.LayerType().String())
+	}
+	if p.Err != nil {
+		types = append(types, "error: "+p.Err.Error())
+	}
+	return strings.Join(types, " ")
+}
+
+func TestDecodeLayers(t *testing.T) {
+	payload := testPacket("hello")
+	// router alert and mss, window scale, SACK permitted and timestamps
+	ipOptions := []byte{0x94, 4, 0, 0}
+	tcpOptions := []byte{2, 4, 0x05, 0xB4, 1, 3, 3, 7, 4, 2, 1, 1, 8, 10, 0, 0, 0, 1, 0, 0, 0, 2}
+	hopByHop := []byte{byte(IPProtocolFragment), 0, 1, 4, 0, 0, 0, 0}
+	fragment := []byte{byte(IPProtocolUDP), 0, 0, 0, 0, 0, 0, 42}
+	arp := make(testPacket, 28)
+	arp[4], arp[5] = 6, 4
+
+	cases := []struct {
+		name string
+		data testPacket
+		want string
+	}{
+		{"tcp", payload.tcp(TCPSyn, tcpOptions...).ipv4(IPProtocolTCP, ipOptions...).ethernet(EtherTypeIPv4), "Ethernet IPv4 TCP Payload"},
+		{"qinq", payload.udp().ipv4(IPProtocolUDP).dot1q(10, EtherTypeIPv4).dot1q(20, EtherTypeDot1Q).ethernet(EtherTypeQinQ), "Ethernet Dot1Q Dot1Q IPv4 UDP Payload"},
+		{"ipv6", testPacket(append(hopByHop, append(fragment, payload.udp()...)...)).ipv6(IPProtocolHopByHop).ethernet(EtherTypeIPv6), "Ethernet IPv6 IPv6Extension IPv6Fragment UDP Payload"},
+		{"arp", arp.ethernet(EtherTypeARP), "Ethernet ARP"},
+		{"unknown", payload.ethernet(0x1234), "Ethernet Payload"},
+		{"snap", payload.udp().ipv4(IPProtocolUDP).llc(EtherTypeIPv4).ethernet(8 + 20 + 8 + 5), "Ethernet LLC IPv4 UDP Payload"},
+		{"icmp cut off", payload.ipv4(IPProtocolICMPv4).ethernet(EtherTypeIPv4), "Ethernet IPv4 error: packet is malformed"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := Decode(pcapreader.LinkTypeEthernet, c.data, uint32(len(c.data)))
+			if got := layerTypes(p); got != c.want {
+				t.Errorf("decoded %s instead of %s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestDecodeFields(t *testing.T) {
+	tcpOptions := []byte{2, 4, 0x05, 0xB4, 1, 3, 3, 7, 4, 2, 8, 10, 0, 0, 0, 1, 0, 0, 0, 2}
+	data := testPacket("hello").tcp(TCPSyn|TCPAck, tcpOptions...).ipv4(IPProtocolTCP, 0x94, 4, 0, 0).
+		dot1q(42, EtherTypeIPv4).ethernet(EtherTypeDot1Q)
+	// Ethernet padding is not part of the payload
+	data = append(data, 0, 0, 0, 0)
+	p := Decode(pcapreader.LinkTypeEthernet, data, uint32(len(data)))
+	if p.Err != nil || p.Truncated {
+		t.Fatal(p.Err)
+	}
+
+	vlan, _ := First[Dot1Q](p)
+	if vlan.VLANID() != 42 || vlan.Priority() != 5 || vlan.EtherType() != EtherTypeIPv4 {
+		t.Errorf("VLAN %d, priority %d, %s", vlan.VLANID(), vlan.Priority(), vlan.EtherType())
+	}
+
+	ip, _ := First[IPv4](p)
+	var options []string
+	for o := range ip.Options() {
+		options = append(options, fmt.Sprint(o.Type, o.Data))
+	}
+	if ip.Src() != netip.MustParseAddr("10.0.0.1") || !ip.ChecksumValid() || ip.TTL() != 64 || len(options) != 1 || options[0] != "148 [0 0]" {
+		t.Errorf("IPv4 from %s with options %v", ip.Src(), options)
+	}
+
+	tcp, _ := First[TCP](p)
+	mss, _ := tcp.MSS()
+	scale, _ := tcp.WindowScale()
+	tsval, tsecr, _ := tcp.Timestamps()
+	if tcp.SrcPort() != 4711 || tcp.Flags().String() != "SYN|ACK" || mss != 1460 || scale != 7 ||
+		!tcp.SACKPermitted() || tsval != 1 || tsecr != 2 {
+		t.Errorf("TCP from %d with %s, mss %d, scale %d, timestamps %d %d", tcp.SrcPort(), tcp.Flags(), mss, scale, tsval, tsecr)
+	}
+	if !bytes.Equal(tcp.Payload(), []byte("hello")) {
+		t.Errorf("payload %q", tcp.Payload())
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	data := testPacket(make([]byte, 1000)).udp().ipv6(IPProtocolUDP).ethernet(EtherTypeIPv6)
+
+	// cut off in the payload
+	p := Decode(pcapreader.LinkTypeEthernet, data[:96], uint32(len(data)))
+	if layerTypes(p) != "Ethernet IPv6 UDP Payload" || !p.Truncated {
+		t.Errorf("decoded %s", layerTypes(p))
+	}
+	if udp, _ := First[UDP](p); udp.Length() != 1008 || len(udp.Payload()) != 96-14-40-8 {
+		t.Errorf("UDP of length %d with %d bytes", udp.Length(), len(udp.Payload()))
+	}
+
+	// cut off in the UDP header
+	p = Decode(pcapreader.LinkTypeEthernet, data[:58], uint32(len(data)))
+	if layerTypes(p) != "Ethernet IPv6 error: "+ErrTruncated.Error() || !errors.Is(p.Err, ErrTruncated) {
+		t.Errorf("decoded %s", layerTypes(p))
+	}
+
+	// the same without the snaplen is malformed
+	p = Decode(pcapreader.LinkTypeEthernet, data[:58], 58)
+	if !errors.Is(p.Err, ErrMalformed) {
+		t.Errorf("decoded %s", layerTypes(p))
+	}
+}
+
+func TestDecodeInto(t *testing.T) {
+	data := testPacket("hello").udp().ipv4(IPProtocolUDP).ethernet(EtherTypeIPv4)
+	var p Packet
+	allocs := testing.AllocsPerRun(100, func() {
+		DecodeInto(&p, pcapreader.LinkTypeEthernet, data, uint32(len(data)))
+	})
+	if layerTypes(&p) != "Ethernet IPv4 UDP Payload" {
+		t.Errorf("decoded %s", layerTypes(&p))
+	}
+	// the layers are boxed into interfaces, but the slice is reused
+	if allocs > 4 {
+		t.Errorf("%v allocations per packet", allocs)
+	}
+}
+
+func TestDecodeLinkTypes(t *testing.T) {
+	ip := testPacket("hello").udp().ipv4(IPProtocolUDP)
+	ip6 := testPacket("hello").udp().ipv6(IPProtocolUDP)
+
+	sll := testPacket{0, 4, 0, 1, 0, 6, 1, 2, 3, 4, 5, 6, 0, 0, 0x08, 0x00}
+	sll2 := testPacket{0x86, 0xDD, 0, 0, 0, 0, 0, 7, 0, 1, 0, 6, 1, 2, 3, 4, 5, 6, 0, 0}
+	cases := []struct {
+		name string
+		llt  pcapreader.LinkLayerType
+		data testPacket
+		want string
+	}{
+		{"sll", pcapreader.LinkTypeLinuxSLL, append(sll, ip...), "LinuxSLL IPv4 UDP Payload"},
+		{"sll2", pcapreader.LinkTypeLinuxSLL2, append(sll2, ip6...), "LinuxSLL2 IPv6 UDP Payload"},
+		{"raw4", pcapreader.LinkTypeRaw, ip, "IPv4 UDP Payload"},
+		{"raw6", pcapreader.LinkTypeRaw, ip6, "IPv6 UDP Payload"},
+		{"ipv6", pcapreader.LinkTypeIPv6, ip6, "IPv6 UDP Payload"},
+		{"null little endian", pcapreader.LinkTypeNull, append(testPacket{30, 0, 0, 0}, ip6...), "Loopback IPv6 UDP Payload"},
+		{"null big endian", pcapreader.LinkTypeNull, append(testPacket{0, 0, 0, 2}, ip...), "Loopback IPv4 UDP Payload"},
+		{"loop", pcapreader.LinkTypeLoop, append(testPacket{0, 0, 0, 24}, ip6...), "Loopback IPv6 UDP Payload"},
+		{"ppp", pcapreader.LinkTypePPP, append(testPacket{0xFF, 0x03, 0x00, 0x21}, ip...), "PPP IPv4 UDP Payload"},
+		{"ppp compressed", pcapreader.LinkTypePPP, append(testPacket{0x57}, ip6...), "PPP IPv6 UDP Payload"},
+		{"ppp with dir", pcapreader.LinkTypePPPWithDir, append(testPacket{1, 0x00, 0x21}, ip...), "PPP IPv4 UDP Payload"},
+		{"pppoe", pcapreader.LinkTypeEthernet, append(testPacket{0x11, 0, 0, 1, 0, byte(len(ip) + 2), 0, 0x21}, ip...).ethernet(EtherTypePPPoE), "Ethernet PPPoE PPP IPv4 UDP Payload"},
+		{"cisco hdlc", pcapreader.LinkTypeCHDLC, append(testPacket{0x0F, 0, 0x08, 0x00}, ip...), "CiscoHDLC IPv4 UDP Payload"},
+		{"unknown", 4242, ip, "Payload"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := Decode(c.llt, c.data, uint32(len(c.data)))
+			if got := layerTypes(p); got != c.want {
+				t.Errorf("decoded %s instead of %s", got, c.want)
+			}
+		})
+	}
+
+	p := Decode(pcapreader.LinkTypeLinuxSLL, append(sll, ip...), uint32(len(sll)+len(ip)))
+	if s, _ := First[LinuxSLL](p); !s.PacketType().Outgoing() || s.Addr().String() != "01:02:03:04:05:06" {
+		t.Errorf("SLL %s from %s", s.PacketType(), s.Addr())
+	}
+	p = Decode(pcapreader.LinkTypeLinuxSLL2, append(sll2, ip6...), uint32(len(sll2)+len(ip6)))
+	if s, _ := First[LinuxSLL2](p); s.InterfaceIndex() != 7 || s.PacketType() != SLLHost {
+		t.Errorf("SLL2 %s on %d", s.PacketType(), s.InterfaceIndex())
+	}
+	p = Decode(pcapreader.LinkTypePPPWithDir, append(testPacket{1, 0x00, 0x21}, ip...), 100)
+	if ppp, _ := First[PPP](p); ppp.Protocol() != PPPProtocolIPv4 {
+		t.Errorf("PPP protocol %#x", ppp.Protocol())
+	} else if out, known := ppp.Direction(); !out || !known {
+		t.Errorf("PPP direction %v %v", out, known)
+	}
+}
+
+// a radiotap header with flags, rate, channel and the signal
+func (p testPacket) radiotap(flags byte) testPacket {
+	h := testPacket{0, 0, 16, 0, 0x2E, 0, 0, 0, flags, 0x6C, 0x85, 0x09, 0xA0, 0x00, 0xD5, 0}
+	return append(h, p...)
+}
+
+// an 802.11 header of type and subtype from the station 2 to the AP 1
+func (p testPacket) dot11(t Dot11Type, subtype uint8, flags Dot11Flags) testPacket {
+	h := testPacket{byte(subtype<<4 | uint8(t)<<2), byte(flags), 0, 0}
+	h = append(h, 1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 0x30, 0x01)
+	if t == Dot11Data && subtype&0x8 != 0 {
+		h = append(h, 0, 0)
+	}
+	return append(h, p...)
+}
+
+func (p testPacket) fcs() testPacket {
+	return binary.LittleEndian.AppendUint32(p, crc32.ChecksumIEEE(p))
+}
+
+func TestDecodeWireless(t *testing.T) {
+	ip := testPacket("hello").udp().ipv4(IPProtocolUDP)
+	rsn := []byte{48, 20, 1, 0, 0, 0x0F, 0xAC, 4, 1, 0, 0, 0x0F, 0xAC, 4, 1, 0, 0, 0x0F, 0xAC, 2, 0x0C, 0}
+	beacon := append(testPacket{1, 0, 0, 0, 0, 0, 0, 0, 100, 0, 0x11, 0x04,
+		0, 4, 't', 'e', 's', 't', 1, 2, 0x82, 0x0C, 3, 1, 6, 50, 1, 0x6C}, rsn...)
+	ppi := testPacket{0, 0, 32, 0, 105, 0, 0, 0, 2, 0, 20, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 2, 0, 0x85, 0x09, 0, 0, 0, 0, 0xC0, 0xA0}
+	cases := []struct {
+		name string
+		llt  pcapreader.LinkLayerType
+		data testPacket
+		want string
+	}{
+		{"beacon", pcapreader.LinkTypeIEEE802_11, beacon.dot11(Dot11Management, Dot11Beacon, 0), "Dot11 Dot11Management"},
+		{"data", pcapreader.LinkTypeIEEE802_11, ip.llc(EtherTypeIPv4).dot11(Dot11Data, 0, Dot11ToDS), "Dot11 LLC IPv4 UDP Payload"},
+		{"qos data", pcapreader.LinkTypeIEEE802_11, ip.llc(EtherTypeIPv4).dot11(Dot11Data, 8, Dot11ToDS), "Dot11 LLC IPv4 UDP Payload"},
+		{"protected", pcapreader.LinkTypeIEEE802_11, testPacket("secret").dot11(Dot11Data, 0, Dot11Protected), "Dot11 Payload"},
+		{"ack", pcapreader.LinkTypeIEEE802_11, testPacket{0xD4, 0, 0, 0, 1, 1, 1, 1, 1, 1}, "Dot11"},
+		{"radiotap", pcapreader.LinkTypeIEEE802_11Radiotap, beacon.dot11(Dot11Management, Dot11Beacon, 0).fcs().radiotap(RadiotapFlagFCS), "Radiotap Dot11 Dot11Management"},
+		{"ppi", pcapreader.LinkTypePPI, append(ppi, ip.llc(EtherTypeIPv4).dot11(Dot11Data, 0, Dot11ToDS).fcs()...), "PPI Dot11 LLC IPv4 UDP Payload"},
+		{"ppi ethernet", pcapreader.LinkTypePPI, append(testPacket{0, 0, 8, 0, 1, 0, 0, 0}, ip.ethernet(EtherTypeIPv4)...), "PPI Ethernet IPv4 UDP Payload"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := Decode(c.llt, c.data, uint32(len(c.data)))
+			if got := layerTypes(p); got != c.want {
+				t.Errorf("decoded %s instead of %s", got, c.want)
+			}
+			if p.Err != nil {
+				t.Error(p.Err)
+			}
+		})
+	}
+
+	data := beacon.dot11(Dot11Management, Dot11Beacon, 0).fcs().radiotap(RadiotapFlagFCS)
+	p := Decode(pcapreader.LinkTypeIEEE802_11Radiotap, data, uint32(len(data)))
+	r, _ := First[Radiotap](p)
+	if freq, _, ok := r.Channel(); !ok || ChannelNumber(freq) != 6 {
+		t.Errorf("channel %d", freq)
+	}
+	if signal, ok := r.AntennaSignal(); !ok || signal != -43 {
+		t.Errorf("signal %d", signal)
+	}
+	if rate, ok := r.Rate(); !ok || rate != 108 {
+		t.Errorf("rate %d", rate)
+	}
+	f, _ := First[Dot11](p)
+	if !f.FCSValid() {
+		t.Error("FCS is not valid")
+	}
+	if f.Src().String() != "02:02:02:02:02:02" || f.BSSID().String() != "01:01:01:01:01:01" {
+		t.Errorf("beacon from %s in %s", f.Src(), f.BSSID())
+	}
+	if seq, frag, _ := f.Sequence(); seq != 19 || frag != 0 {
+		t.Errorf("sequence %d fragment %d", seq, frag)
+	}
+	m, _ := First[Dot11ManagementBody](p)
+	if ssid, _ := m.SSID(); string(ssid) != "test" {
+		t.Errorf("SSID %q", ssid)
+	}
+	if ch, _ := m.DSChannel(); ch != 6 {
+		t.Errorf("DS channel %d", ch)
+	}
+	if interval, _ := m.BeaconInterval(); interval != 100 {
+		t.Errorf("beacon interval %d", interval)
+	}
+	var rates []string
+	for r := range m.Rates() {
+		rates = append(rates, r.String())
+	}
+	if got := strings.Join(rates, " "); got != "1(B) 6 54" {
+		t.Errorf("rates %s", got)
+	}
+	s, ok := m.RSN()
+	if !ok || s.GroupCipher.CipherName() != "CCMP-128" || len(s.Pairwise) != 1 || len(s.AKM) != 1 || s.AKM[0].AKMName() != "PSK" || s.Capabilities != 0x0C {
+		t.Errorf("RSN %+v", s)
+	}
+
+	// a broken FCS is still decoded
+	data[len(data)-1] ^= 0xFF
+	p = Decode(pcapreader.LinkTypeIEEE802_11Radiotap, data, uint32(len(data)))
+	if f, _ := First[Dot11](p); f.FCSValid() || p.Err != nil {
+		t.Errorf("broken FCS is valid: %v", p.Err)
+	}
+
+	data = ip.llc(EtherTypeIPv4).dot11(Dot11Data, 0, Dot11ToDS).fcs()
+	p = Decode(pcapreader.LinkTypePPI, append(ppi, data...), uint32(len(ppi)+len(data)))
+	if c, ok := First[PPI](p); !ok {
+		t.Error("no PPI")
+	} else if common, _ := c.Common(); common.Frequency != 2437 || common.Signal != -64 {
+		t.Errorf("PPI common %+v", common)
+	}
+	if f, _ := First[Dot11](p); !f.FCSValid() || f.Dst().String() != "01:01:01:01:01:01" {
+		t.Errorf("data frame to %s", f.Dst())
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add([]byte(testPacket("hello").tcp(TCPSyn, 2, 4, 0, 0).ipv4(IPProtocolTCP, 1, 1, 1, 0).ethernet(EtherTypeIPv4)))
+	f.Add([]byte(testPacket("hello").udp().ipv6(IPProtocolUDP).dot1q(1, EtherTypeIPv6).ethernet(EtherTypeDot1Q)))
+	f.Add([]byte(testPacket("hello").udp().ipv4(IPProtocolUDP).ethernet(EtherTypeIPv4).vxlan(1).udpTo(UDPPortVXLAN).ipv4(IPProtocolUDP).ethernet(EtherTypeIPv4)))
+	f.Add([]byte(testPacket("hello").udp().ipv4(IPProtocolUDP).gre(EtherTypeIPv4, GREKey|GRESequence, 1, 2).ipv4(IPProtocolGRE).ethernet(EtherTypeIPv4)))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := NewDefragmenter(DefragOptions{})
+		ft := NewFlowTable(FlowOptions{})
+		a := NewAssembler(AssemblerOptions{OnData: func(s *Stream, dir Direction, data []byte) { _ = data[len(data)-1] }})
+		for _, llt := range []pcapreader.LinkLayerType{pcapreader.LinkTypeEthernet, pcapreader.LinkTypeLinuxSLL,
+			pcapreader.LinkTypeLinuxSLL2, pcapreader.LinkTypeNull, pcapreader.LinkTypePPPWithDir,
+			pcapreader.LinkTypeIEEE802_11Radiotap, pcapreader.LinkTypePPI} {
+			p := Decode(llt, data, uint32(len(data))+1)
+			fuzzLayers(p)
+			p.Inner()
+			d.Add(&pcapreader.PacketInfo{}, data, p)
+			a.Add(&pcapreader.PacketInfo{}, p)
+			ft.Add(&pcapreader.PacketInfo{}, pcapreader.Interface{}, p)
+		}
+	})
+}
+
+// reads all fields of all layers
+func fuzzLayers(p *Packet) {
+	for _, l := range p.Layers {
+		switch l := l.(type) {
+		case IPv4:
+			for range l.Options() {
+			}
+		case IPv6Extension:
+			for range l.Options() {
+			}
+		case TCP:
+			l.MSS()
+			l.Timestamps()
+			for range l.SACK() {
+			}
+		case ARP:
+			l.TargetIP()
+		case LLC:
+			l.Control()
+			l.ProtocolID()
+		case LinuxSLL:
+			l.Addr()
+		case LinuxSLL2:
+			l.Addr()
+		case PPP:
+			l.Protocol()
+			l.Direction()
+		case Radiotap:
+			l.TSFT()
+			l.Channel()
+			l.MCS()
+			l.VHT()
+			l.HE()
+		case PPI:
+			l.Common()
+		case Dot11:
+			l.Addr4()
+			l.BSSID()
+			l.Sequence()
+			l.QoS()
+			l.FCSValid()
+		case GRE:
+			l.Checksum()
+			l.Key()
+			l.Sequence()
+			l.Ack()
+		case ERSPAN:
+			l.Index()
+			l.SGT()
+			l.Egress()
+		case VXLAN:
+			l.GroupPolicyID()
+		case Geneve:
+			for range l.Options() {
+			}
+		case GTPU:
+			l.Sequence()
+			l.NPDU()
+			l.QFI()
+		case Dot11ManagementBody:
+			l.Capabilities()
+			l.StatusCode()
+			for range l.Rates() {
+			}
+			l.RSN()
+		}
+	}
+}
@@ -0,0 +1,468 @@
+package decode
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"time"
+
+	"github.com/Sojamann/pcapreader"
+)
+
+var (
+	// the last fragment did not arrive within DefragOptions.Timeout
+	ErrFragmentTimeout = errors.New("fragments have not all arrived in time")
+	// the datagram has been dropped to stay within the memory bounds
+	ErrFragmentMemory = errors.New("fragments take up too much memory")
+	// the fragments go beyond the largest packet IP allows
+	ErrFragmentTooLarge = errors.New("fragments are larger than an IP packet can be")
+	// the fragments tell different sizes of the datagram
+	ErrFragmentMismatch = errors.New("fragments do not fit together")
+	// the datagram was still missing fragments when Flush was called
+	ErrFragmentMissing = errors.New("fragments are missing")
+	// a fragment has been cut off while capturing, so
+	// the datagram cannot be put together completely
+	ErrFragmentTruncated = errors.New("fragment has been cut off while capturing")
+)
+
+// OverlapPolicy tells which data are kept when fragments overlap.
+// Operating systems differ in this, which attacks use to make an
+// IDS see something else than the host, so the policy should be
+// the one of the host the datagrams are sent to.
+type OverlapPolicy uint8
+
+const (
+	// the data that arrived first are kept, like Windows
+	OverlapFirst OverlapPolicy = iota
+	// the data that arrived last are kept, like some printers and routers
+	OverlapLast
+	// the data that arrived first are kept, unless the
+	// fragment that arrived later starts before the other one
+	OverlapBSD
+	// like OverlapBSD, but the later fragment wins as well
+	// when both start at the same offset
+	OverlapLinux
+)
+
+// the defaults of DefragOptions, which are those of Linux
+const (
+	defaultFragmentTimeout = 30 * time.Second
+	defaultMaxFragmentMem  = 4 << 20
+	defaultMaxDatagrams    = 1024
+)
+
+// DefragOptions change how a Defragmenter reassembles datagrams
+type DefragOptions struct {
+	// How long after its first fragment a datagram has to be complete,
+	// measured in capture time. Defaults to 30 seconds.
+	Timeout time.Duration
+	Policy  OverlapPolicy
+
+	// Limits for the fragments that are held until their datagram
+	// is complete. When adding a fragment goes beyond one, the
+	// datagrams that have waited longest are dropped.
+
+	// The most bytes of fragments. Defaults to 4MiB.
+	MaxBytes int
+	// The most datagrams. Defaults to 1024.
+	MaxDatagrams int
+
+	// Called for every datagram that is given up on, with the reason
+	OnIncomplete func(Incomplete)
+}
+
+func (o DefragOptions) withDefaults() DefragOptions {
+	if o.Timeout == 0 {
+		o.Timeout = defaultFragmentTimeout
+	}
+	if o.MaxBytes == 0 {
+		o.MaxBytes = defaultMaxFragmentMem
+	}
+	if o.MaxDatagrams == 0 {
+		o.MaxDatagrams = defaultMaxDatagrams
+	}
+	return o
+}
+
+// Incomplete is a datagram that could not be reassembled
+type Incomplete struct {
+	Src, Dst netip.Addr
+	// the protocol of IPv4 datagrams, 0 for IPv6
+	Protocol IPProtocol
+	ID       uint32
+	// when the first and the last fragment have been captured
+	First, Last time.Time
+	Fragments   int
+	// how many bytes of the datagram have arrived, and its
+	// size, which is 0 when the last fragment is missing
+	Received, Size int
+	// ErrFragmentTimeout or one of the other ErrFragment errors
+	Reason error
+}
+
+// the fragments of a datagram are told apart by
+type fragmentKey struct {
+	src, dst netip.Addr
+	protocol IPProtocol
+	id       uint32
+}
+
+type fragment struct {
+	offset int
+	data   []byte
+}
+
+// what comes in front of the data of a fragment, up to the end
+// of the IPv4 header or the IPv6 fragment header
+type fragmentHead struct {
+	data []byte
+	// where the IP header starts
+	ipStart int
+	// where the next header field that points to the IPv6 fragment
+	// header is and what it has to become without it
+	nextHeader   int
+	nextProtocol IPProtocol
+	ipv6         bool
+}
+
+// the half open range of a datagram that has arrived
+type fragmentSpan struct{ start, end int }
+
+type datagram struct {
+	key   fragmentKey
+	entry *list.Element
+
+	first, last time.Time
+	// in the order they arrived
+	fragments []fragment
+	// sorted and merged
+	covered []fragmentSpan
+	// the size of the datagram, -1 until the last fragment arrived
+	size int
+	// what is held, the overlaps and the head counted as well
+	bytes int
+	// of the fragment at offset 0, data is nil until it arrived
+	head fragmentHead
+}
+
+// Defragmenter reassembles fragmented IPv4 and IPv6 datagrams. It
+// holds the fragments until their datagram is complete and then
+// returns the datagram as a packet with the headers in front of it
+// of the fragment at offset 0, i.e. its Ethernet header. Lengths in
+// those headers, i.e. of an outer IP header of a tunnel, are not
+// changed, so it is meant for the outermost IP header.
+type Defragmenter struct {
+	opts      DefragOptions
+	datagrams map[fragmentKey]*datagram
+	// datagrams by the time their first fragment arrived, oldest first
+	order *list.List
+	bytes int
+}
+
+func NewDefragmenter(opts DefragOptions) *Defragmenter {
+	return &Defragmenter{
+		opts:      opts.withDefaults(),
+		datagrams: make(map[fragmentKey]*datagram),
+		order:     list.New(),
+	}
+}
+
+// Returns the offset of layer i in the packet it has been decoded from.
+// The layers are views that go on up to the end of it except for
+// padding, so the capacity of the payload before it tells.
+func layerOffset(packet []byte, p *Packet, i int) int {
+	if i == 0 {
+		return 0
+	}
+	return cap(packet) - cap(p.Layers[i-1].Payload())
+}
+
+// finds the outermost IP header of p that belongs to a fragment
+func fragmentOf(packet []byte, p *Packet) (key fragmentKey, head fragmentHead, f fragment, more bool, ok bool) {
+	for i, l := range p.Layers {
+		switch l := l.(type) {
+		case IPv4:
+			if !l.IsFragment() {
+				continue
+			}
+			key = fragmentKey{src: l.Src(), dst: l.Dst(), protocol: l.Protocol(), id: uint32(l.ID())}
+			start := layerOffset(packet, p, i)
+			head = fragmentHead{data: packet[:start+l.HeaderLength()], ipStart: start}
+			f = fragment{offset: int(l.FragmentOffset()), data: l.Payload()}
+			return key, head, f, l.Flags()&IPv4MoreFragments != 0, true
+		case IPv6Fragment:
+			if l.FragmentOffset() == 0 && !l.MoreFragments() {
+				continue
+			}
+			// the header before it has to be the IPv6 header or
+			// an extension header that comes after the IPv6 header
+			ip := -1
+			for j := i - 1; j >= 0 && ip < 0; j-- {
+				switch p.Layers[j].(type) {
+				case IPv6:
+					ip = j
+				case IPv6Extension:
+				default:
+					return key, head, f, false, false
+				}
+			}
+			if ip < 0 {
+				return key, head, f, false, false
+			}
+			nextHeader := layerOffset(packet, p, i-1)
+			if i-1 == ip {
+				nextHeader += 6
+			}
+			v6 := p.Layers[ip].(IPv6)
+			key = fragmentKey{src: v6.Src(), dst: v6.Dst(), id: l.ID()}
+			head = fragmentHead{
+				data:         packet[:layerOffset(packet, p, i)+8],
+				ipStart:      layerOffset(packet, p, ip),
+				nextHeader:   nextHeader,
+				nextProtocol: l.NextHeader(),
+				ipv6:         true,
+			}
+			f = fragment{offset: int(l.FragmentOffset()), data: l.Payload()}
+			return key, head, f, l.MoreFragments(), true
+		}
+	}
+	return key, head, f, false, false
+}
+
+// Adds a packet, p is what it has been decoded into. Packets that
+// are no fragments are returned as they are. Fragments are held
+// until their datagram is complete, then the datagram is returned
+// and info.Size set to its length, else Add returns false. The
+// datagram carries the capture time of the fragment that completed
+// it, which is the one of info. A fragment that has been cut off
+// while capturing drops its datagram, which is reported with
+// ErrFragmentTruncated.
+func (d *Defragmenter) Add(info *pcapreader.PacketInfo, packet pcapreader.Packet, p *Packet) (pcapreader.Packet, bool) {
+	if !info.CaptureTime.IsZero() {
+		d.expire(info.CaptureTime)
+	}
+	key, head, f, more, ok := fragmentOf(packet, p)
+	if !ok {
+		return packet, true
+	}
+
+	dg := d.datagrams[key]
+	if dg == nil {
+		dg = &datagram{key: key, first: info.CaptureTime, size: -1}
+		dg.entry = d.order.PushBack(dg)
+		d.datagrams[key] = dg
+	}
+	dg.last = info.CaptureTime
+
+	// neither its length nor its data can be trusted
+	if p.Truncated {
+		dg.fragments = append(dg.fragments, f)
+		d.drop(dg, ErrFragmentTruncated)
+		return nil, false
+	}
+
+	end := f.offset + len(f.data)
+	// the length of the IP packet, or of the IPv6 payload
+	length := len(head.data) - head.ipStart + end
+	if head.ipv6 {
+		length -= 40 + 8
+	}
+	switch {
+	case !more && dg.size >= 0 && end != dg.size,
+		dg.size >= 0 && end > dg.size,
+		!more && len(dg.covered) > 0 && dg.covered[len(dg.covered)-1].end > end:
+		dg.fragments = append(dg.fragments, f)
+		d.drop(dg, ErrFragmentMismatch)
+		return nil, false
+	case length > 0xFFFF:
+		dg.fragments = append(dg.fragments, f)
+		d.drop(dg, ErrFragmentTooLarge)
+		return nil, false
+	}
+
+	if !more {
+		dg.size = end
+	}
+	if f.offset == 0 && dg.head.data == nil {
+		dg.head = head
+		dg.head.data = append([]byte(nil), head.data...)
+		dg.bytes += len(head.data)
+		d.bytes += len(head.data)
+	}
+	dg.fragments = append(dg.fragments, fragment{offset: f.offset, data: append([]byte(nil), f.data...)})
+	dg.bytes += len(f.data)
+	d.bytes += len(f.data)
+	dg.cover(f.offset, end)
+
+	if dg.size >= 0 && dg.head.data != nil && len(dg.covered) == 1 && dg.covered[0] == (fragmentSpan{0, dg.size}) {
+		data := d.assemble(dg)
+		d.remove(dg)
+		info.Size = uint32(len(data))
+		return data, true
+	}
+
+	// the datagrams that have waited longest go first,
+	// which can be this one if it is too large by itself
+	for d.bytes > d.opts.MaxBytes || len(d.datagrams) > d.opts.MaxDatagrams {
+		oldest := d.order.Front().Value.(*datagram)
+		d.drop(oldest, ErrFragmentMemory)
+		if oldest == dg {
+			break
+		}
+	}
+	return nil, false
+}
+
+// marks start to end as arrived
+func (dg *datagram) cover(start, end int) {
+	if start == end {
+		return
+	}
+	var merged []fragmentSpan
+	inserted := false
+	for _, s := range dg.covered {
+		switch {
+		case s.end < start:
+			merged = append(merged, s)
+		case s.start > end:
+			if !inserted {
+				merged = append(merged, fragmentSpan{start, end})
+				inserted = true
+			}
+			merged = append(merged, s)
+		default:
+			start, end = min(start, s.start), max(end, s.end)
+		}
+	}
+	if !inserted {
+		merged = append(merged, fragmentSpan{start, end})
+	}
+	dg.covered = merged
+}
+
+// reports whether the data of a fragment starting at offset replace
+// those of one that arrived before it and starts at original
+func (p OverlapPolicy) replaces(offset, original int) bool {
+	switch p {
+	case OverlapLast:
+		return true
+	case OverlapBSD:
+		return offset < original
+	case OverlapLinux:
+		return offset <= original
+	}
+	return false
+}
+
+// puts the datagram together with the headers of the first fragment
+func (d *Defragmenter) assemble(dg *datagram) []byte {
+	data := make([]byte, len(dg.head.data)+dg.size)
+	copy(data, dg.head.data)
+	payload := data[len(dg.head.data):]
+
+	if dg.bytes-len(dg.head.data) == dg.size {
+		// no overlaps
+		for _, f := range dg.fragments {
+			copy(payload[f.offset:], f.data)
+		}
+	} else {
+		// the offset of the fragment every byte came from, -1 for none
+		from := make([]int32, dg.size)
+		for i := range from {
+			from[i] = -1
+		}
+		for _, f := range dg.fragments {
+			for i, b := range f.data {
+				at := f.offset + i
+				if from[at] < 0 || d.opts.Policy.replaces(f.offset, int(from[at])) {
+					payload[at] = b
+					from[at] = int32(f.offset)
+				}
+			}
+		}
+	}
+
+	h := dg.head
+	ip := data[h.ipStart:]
+	if h.ipv6 {
+		// without the fragment header
+		data[h.nextHeader] = byte(h.nextProtocol)
+		copy(data[len(h.data)-8:], payload)
+		data = data[:len(data)-8]
+		binary.BigEndian.PutUint16(ip[4:6], uint16(len(data)-h.ipStart-40))
+		return data
+	}
+	binary.BigEndian.PutUint16(ip[2:4], uint16(len(data)-h.ipStart))
+	// keeps don't fragment but not more fragments and the offset
+	ip[6] &= 0xC0
+	ip[7] = 0
+	ip[10], ip[11] = 0, 0
+	binary.BigEndian.PutUint16(ip[10:12], checksum(ip[:int(ip[0]&0x0F)*4], 0))
+	return data
+}
+
+// forgets about dg
+func (d *Defragmenter) remove(dg *datagram) {
+	d.order.Remove(dg.entry)
+	delete(d.datagrams, dg.key)
+	d.bytes -= dg.bytes
+}
+
+// forgets about dg and reports it as incomplete
+func (d *Defragmenter) drop(dg *datagram, reason error) {
+	d.remove(dg)
+	if d.opts.OnIncomplete == nil {
+		return
+	}
+	received := 0
+	for _, s := range dg.covered {
+		received += s.end - s.start
+	}
+	d.opts.OnIncomplete(Incomplete{
+		Src:       dg.key.src,
+		Dst:       dg.key.dst,
+		Protocol:  dg.key.protocol,
+		ID:        dg.key.id,
+		First:     dg.first,
+		Last:      dg.last,
+		Fragments: len(dg.fragments),
+		Received:  received,
+		Size:      max(dg.size, 0),
+		Reason:    reason,
+	})
+}
+
+// drops the datagrams that have not been completed in time
+func (d *Defragmenter) expire(now time.Time) {
+	for d.order.Len() > 0 {
+		dg := d.order.Front().Value.(*datagram)
+		if now.Sub(dg.first) <= d.opts.Timeout {
+			return
+		}
+		d.drop(dg, ErrFragmentTimeout)
+	}
+}
+
+// Drops all datagrams that are not complete and reports them with
+// ErrFragmentMissing, i.e. once the end of the capture has been reached
+func (d *Defragmenter) Flush() {
+	for d.order.Len() > 0 {
+		d.drop(d.order.Front().Value.(*datagram), ErrFragmentMissing)
+	}
+}
+
+// Returns the number of datagrams waiting for fragments
+func (d *Defragmenter) Pending() int { return len(d.datagrams) }
+
+// Returns t with the fragments of IP datagrams replaced by the
+// datagram, which comes in place of the fragment that completed it.
+// Call Flush once all of it has been read to learn about the
+// datagrams that are left incomplete.
+func (d *Defragmenter) Defragment(t pcapreader.Traffic) pcapreader.Traffic {
+	var p Packet
+	return pcapreader.Map(t, func(info *pcapreader.PacketInfo, packet pcapreader.Packet, iface *pcapreader.Interface) (pcapreader.Packet, bool) {
+		DecodeInto(&p, iface.LinkLayerType, packet, info.Size)
+		return d.Add(info, packet, &p)
+	})
+}
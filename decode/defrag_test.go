@@ -0,0 +1,244 @@
+package decode
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Sojamann/pcapreader"
+)
+
+// an IPv4 fragment in an Ethernet frame of the datagram with the id
+func ipv4Fragment(id uint16, offset int, more bool, data []byte) testPacket {
+	p := testPacket(data).ipv4(IPProtocolUDP)
+	binary.BigEndian.PutUint16(p[4:6], id)
+	flags := uint16(offset / 8)
+	if more {
+		flags |= 0x2000
+	}
+	binary.BigEndian.PutUint16(p[6:8], flags)
+	p[10], p[11] = 0, 0
+	binary.BigEndian.PutUint16(p[10:12], checksum(p[:20], 0))
+	return p.ethernet(EtherTypeIPv4)
+}
+
+// an IPv6 fragment with a destination options header in front of the fragment header
+func ipv6Fragment(id uint32, offset int, more bool, data []byte) testPacket {
+	h := testPacket{byte(IPProtocolFragment), 0, 1, 4, 0, 0, 0, 0}
+	frag := binary.BigEndian.AppendUint16(testPacket{byte(IPProtocolUDP), 0}, uint16(offset))
+	if more {
+		frag[3] |= 1
+	}
+	frag = binary.BigEndian.AppendUint32(frag, id)
+	return append(append(h, frag...), data...).ipv6(IPProtocolDestOptions).ethernet(EtherTypeIPv6)
+}
+
+// adds packets, which are a second apart, and returns the datagrams
+func defrag(d *Defragmenter, packets ...testPacket) (datagrams []testPacket, infos []pcapreader.PacketInfo) {
+	for i, packet := range packets {
+		info := &pcapreader.PacketInfo{CaptureTime: genTime(i), Size: uint32(len(packet))}
+		p := Decode(pcapreader.LinkTypeEthernet, packet, info.Size)
+		if data, ok := d.Add(info, pcapreader.Packet(packet), p); ok {
+			datagrams = append(datagrams, testPacket(data))
+			infos = append(infos, *info)
+		}
+	}
+	return datagrams, infos
+}
+
+func genTime(i int) time.Time {
+	return time.Date(2024, 2, 29, 13, 37, 0, 0, time.UTC).Add(time.Duration(i) * time.Second)
+}
+
+func TestDefragment(t *testing.T) {
+	payload := testPacket(bytes.Repeat([]byte("0123456789abcdef"), 20)).udp()
+	whole := payload.ipv4(IPProtocolUDP).ethernet(EtherTypeIPv4)
+
+	var incomplete []Incomplete
+	d := NewDefragmenter(DefragOptions{OnIncomplete: func(i Incomplete) { incomplete = append(incomplete, i) }})
+	got, infos := defrag(d,
+		ipv4Fragment(1, 160, true, payload[160:320]),
+		whole,
+		ipv4Fragment(1, 320, false, payload[320:]),
+		ipv4Fragment(1, 0, true, payload[:160]),
+	)
+	if len(got) != 2 || !bytes.Equal(got[0], whole) {
+		t.Fatalf("%d packets", len(got))
+	}
+	p := Decode(pcapreader.LinkTypeEthernet, got[1], uint32(len(got[1])))
+	if layerTypes(p) != "Ethernet IPv4 UDP Payload" {
+		t.Errorf("reassembled into %s", layerTypes(p))
+	}
+	if ip, _ := First[IPv4](p); !ip.ChecksumValid() || ip.IsFragment() || ip.ID() != 1 {
+		t.Errorf("IPv4 header %x", ip.Contents())
+	}
+	if !bytes.Equal(p.Layers[2].Contents(), payload[:8]) || !bytes.Equal(p.Layers[3].Contents(), payload[8:]) {
+		t.Error("payload differs")
+	}
+	if !infos[1].CaptureTime.Equal(genTime(3)) || infos[1].Size != uint32(len(got[1])) {
+		t.Errorf("reassembled at %v with size %d", infos[1].CaptureTime, infos[1].Size)
+	}
+
+	// IPv6 with an extension header that stays
+	got, _ = defrag(d,
+		ipv6Fragment(7, 200, false, payload[200:]),
+		ipv6Fragment(7, 0, true, payload[:200]),
+	)
+	if len(got) != 1 {
+		t.Fatalf("%d IPv6 packets", len(got))
+	}
+	p = Decode(pcapreader.LinkTypeEthernet, got[0], uint32(len(got[0])))
+	if layerTypes(p) != "Ethernet IPv6 IPv6Extension UDP Payload" {
+		t.Errorf("reassembled into %s", layerTypes(p))
+	}
+	if u, _ := First[UDP](p); !bytes.Equal(u.Payload(), payload[8:]) {
+		t.Error("IPv6 payload differs")
+	}
+
+	// the first fragment never comes
+	defrag(d, ipv4Fragment(2, 160, false, payload[160:]))
+	d.Flush()
+	if len(incomplete) != 1 || incomplete[0].Reason != ErrFragmentMissing || incomplete[0].Received != len(payload)-160 || incomplete[0].ID != 2 {
+		t.Errorf("incomplete %+v", incomplete)
+	}
+	if d.Pending() != 0 {
+		t.Errorf("%d pending", d.Pending())
+	}
+}
+
+func TestDefragmentOverlap(t *testing.T) {
+	// the second fragment starts before the first
+	// one and the third at the same offset as it
+	fragments := []testPacket{
+		ipv4Fragment(1, 8, true, []byte("AAAAAAAAAAAAAAAA")),
+		ipv4Fragment(1, 0, true, []byte("BBBBBBBBBBBBBBBB")),
+		ipv4Fragment(1, 8, true, []byte("CCCCCCCCCCCCCCCC")),
+		ipv4Fragment(1, 24, false, []byte("DDDDDDDD")),
+	}
+	for _, c := range []struct {
+		policy OverlapPolicy
+		want   string
+	}{
+		{OverlapFirst, "BBBBBBBBAAAAAAAAAAAAAAAADDDDDDDD"},
+		{OverlapLast, "BBBBBBBBCCCCCCCCCCCCCCCCDDDDDDDD"},
+		{OverlapBSD, "BBBBBBBBBBBBBBBBAAAAAAAADDDDDDDD"},
+		{OverlapLinux, "BBBBBBBBBBBBBBBBCCCCCCCCDDDDDDDD"},
+	} {
+		got, _ := defrag(NewDefragmenter(DefragOptions{Policy: c.policy}), fragments...)
+		if len(got) != 1 {
+			t.Fatalf("%d packets with policy %d", len(got), c.policy)
+		}
+		if data := string(got[0][14+20:]); data != c.want {
+			t.Errorf("policy %d gives %s instead of %s", c.policy, data, c.want)
+		}
+	}
+}
+
+func TestDefragmentLimits(t *testing.T) {
+	data := bytes.Repeat([]byte{1}, 1024)
+	var reasons []error
+	d := NewDefragmenter(DefragOptions{
+		Timeout:      10 * time.Second,
+		MaxBytes:     3000,
+		OnIncomplete: func(i Incomplete) { reasons = append(reasons, i.Reason) },
+	})
+	defrag(d,
+		ipv4Fragment(1, 0, true, data),
+		ipv4Fragment(2, 0, true, data),
+		// does not fit with the other two
+		ipv4Fragment(3, 0, true, data),
+	)
+	if len(reasons) != 1 || reasons[0] != ErrFragmentMemory || d.Pending() != 2 {
+		t.Errorf("%v with %d pending", reasons, d.Pending())
+	}
+
+	// all of them are more than 10 seconds old
+	packet := ipv4Fragment(4, 1024, true, data)
+	info := &pcapreader.PacketInfo{CaptureTime: genTime(20), Size: uint32(len(packet))}
+	d.Add(info, pcapreader.Packet(packet), Decode(pcapreader.LinkTypeEthernet, packet, info.Size))
+	if len(reasons) != 3 || reasons[2] != ErrFragmentTimeout || d.Pending() != 1 {
+		t.Errorf("%v with %d pending", reasons, d.Pending())
+	}
+
+	reasons = nil
+	defrag(d,
+		// the last one ends before the first one
+		ipv4Fragment(5, 0, true, data),
+		ipv4Fragment(5, 8, false, data[:8]),
+		ipv4Fragment(6, 65528, false, data),
+	)
+	if len(reasons) != 2 || !errors.Is(reasons[0], ErrFragmentMismatch) || !errors.Is(reasons[1], ErrFragmentTooLarge) {
+		t.Errorf("%v", reasons)
+	}
+}
+
+func TestDefragmentTruncated(t *testing.T) {
+	payload := testPacket(bytes.Repeat([]byte("x"), 24)).udp()
+	var incomplete []Incomplete
+	d := NewDefragmenter(DefragOptions{OnIncomplete: func(i Incomplete) { incomplete = append(incomplete, i) }})
+
+	first := ipv4Fragment(1, 0, true, payload[:16])
+	// the last fragment is missing 8 bytes in the capture
+	last := ipv4Fragment(1, 16, false, payload[16:])
+	for i, c := range []struct {
+		packet testPacket
+		size   int
+	}{{first, len(first)}, {last[:len(last)-8], len(last)}} {
+		info := &pcapreader.PacketInfo{CaptureTime: genTime(i), Size: uint32(c.size)}
+		p := Decode(pcapreader.LinkTypeEthernet, c.packet, info.Size)
+		if data, ok := d.Add(info, pcapreader.Packet(c.packet), p); ok {
+			t.Fatalf("reassembled %d bytes from a cut off fragment", len(data))
+		}
+	}
+	if len(incomplete) != 1 || incomplete[0].Reason != ErrFragmentTruncated || incomplete[0].Fragments != 2 || incomplete[0].Size != 0 {
+		t.Errorf("incomplete %+v", incomplete)
+	}
+	if d.Pending() != 0 {
+		t.Errorf("%d pending", d.Pending())
+	}
+
+	// a cut off first fragment does not leave the others waiting
+	incomplete = nil
+	info := &pcapreader.PacketInfo{CaptureTime: genTime(2), Size: uint32(len(first))}
+	d.Add(info, pcapreader.Packet(first[:len(first)-4]), Decode(pcapreader.LinkTypeEthernet, first[:len(first)-4], info.Size))
+	if data, ok := d.Add(info, pcapreader.Packet(last), Decode(pcapreader.LinkTypeEthernet, last, uint32(len(last)))); ok {
+		t.Errorf("reassembled %d bytes without the first fragment", len(data))
+	}
+	if len(incomplete) != 1 || incomplete[0].Reason != ErrFragmentTruncated {
+		t.Errorf("incomplete %+v", incomplete)
+	}
+}
+
+func TestDefragmentTraffic(t *testing.T) {
+	payload := testPacket(bytes.Repeat([]byte("x"), 100)).udp()
+	packets := []testPacket{
+		ipv4Fragment(1, 0, true, payload[:56]),
+		testPacket("hello").udp().ipv4(IPProtocolUDP).ethernet(EtherTypeIPv4),
+		ipv4Fragment(1, 56, false, payload[56:]),
+	}
+	var b bytes.Buffer
+	w := pcapreader.NewPcapWriter(&b)
+	for i, packet := range packets {
+		info := &pcapreader.PacketInfo{CaptureTime: genTime(i), Size: uint32(len(packet))}
+		if err := w.WritePacket(pcapreader.Interface{LinkLayerType: pcapreader.LinkTypeEthernet}, info, pcapreader.Packet(packet)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Flush()
+	traffic, err := pcapreader.OpenReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDefragmenter(DefragOptions{})
+	var sizes []uint32
+	for info := range pcapreader.Packets(context.Background(), d.Defragment(traffic)).All() {
+		sizes = append(sizes, info.Size)
+	}
+	if len(sizes) != 2 || sizes[0] != uint32(len(packets[1])) || sizes[1] != uint32(14+20+len(payload)) {
+		t.Errorf("sizes %v", sizes)
+	}
+}
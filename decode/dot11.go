@@ -0,0 +1,564 @@
+package decode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"iter"
+	"net"
+)
+
+// Dot11Type is the type of an 802.11 frame
+type Dot11Type uint8
+
+const (
+	Dot11Management Dot11Type = iota
+	Dot11Control
+	Dot11Data
+	Dot11Extension
+)
+
+var dot11TypeNames = [...]string{"Management", "Control", "Data", "Extension"}
+
+func (t Dot11Type) String() string { return dot11TypeNames[t&3] }
+
+// subtypes of management frames
+const (
+	Dot11AssocRequest    uint8 = 0
+	Dot11AssocResponse   uint8 = 1
+	Dot11ReassocRequest  uint8 = 2
+	Dot11ReassocResponse uint8 = 3
+	Dot11ProbeRequest    uint8 = 4
+	Dot11ProbeResponse   uint8 = 5
+	Dot11Beacon          uint8 = 8
+	Dot11ATIM            uint8 = 9
+	Dot11Disassoc        uint8 = 10
+	Dot11Auth            uint8 = 11
+	Dot11Deauth          uint8 = 12
+	Dot11Action          uint8 = 13
+	Dot11ActionNoAck     uint8 = 14
+)
+
+// subtypes of control frames
+const (
+	Dot11BlockAckRequest uint8 = 8
+	Dot11BlockAck        uint8 = 9
+	Dot11PSPoll          uint8 = 10
+	Dot11RTS             uint8 = 11
+	Dot11CTS             uint8 = 12
+	Dot11Ack             uint8 = 13
+)
+
+// Dot11Flags are the flags of the frame control field
+type Dot11Flags uint8
+
+const (
+	Dot11ToDS Dot11Flags = 1 << iota
+	Dot11FromDS
+	Dot11MoreFragments
+	Dot11Retry
+	Dot11PowerManagement
+	Dot11MoreData
+	Dot11Protected
+	// for QoS data and management frames that there is an HT control field
+	Dot11Order
+)
+
+// Dot11 is the MAC header of an 802.11 frame
+type Dot11 struct {
+	layer
+	// the frame without and with the FCS,
+	// fcs is nil when there is none
+	frame []byte
+	fcs   []byte
+}
+
+func (Dot11) LayerType() LayerType { return LayerDot11 }
+
+func (f Dot11) Type() Dot11Type   { return Dot11Type(f.contents[0] >> 2 & 0x3) }
+func (f Dot11) Subtype() uint8    { return f.contents[0] >> 4 }
+func (f Dot11) Flags() Dot11Flags { return Dot11Flags(f.contents[1]) }
+
+// Returns the duration in microseconds, or the association
+// id for PS-Poll frames
+func (f Dot11) Duration() uint16 { return binary.LittleEndian.Uint16(f.contents[2:4]) }
+
+// Returns the receiver
+func (f Dot11) Addr1() net.HardwareAddr { return net.HardwareAddr(f.contents[4:10]) }
+
+// Returns the transmitter, nil for ACK and CTS
+func (f Dot11) Addr2() net.HardwareAddr { return f.addr(10) }
+
+// Returns the third address, nil for control frames
+func (f Dot11) Addr3() net.HardwareAddr {
+	if f.Type() == Dot11Control {
+		return nil
+	}
+	return f.addr(16)
+}
+
+// Returns the fourth address, which is only there
+// when the frame goes from one AP to another
+func (f Dot11) Addr4() net.HardwareAddr {
+	if f.Type() != Dot11Data || f.Flags()&(Dot11ToDS|Dot11FromDS) != Dot11ToDS|Dot11FromDS {
+		return nil
+	}
+	return f.addr(24)
+}
+
+func (f Dot11) addr(off int) net.HardwareAddr {
+	if len(f.contents) < off+6 {
+		return nil
+	}
+	return net.HardwareAddr(f.contents[off : off+6])
+}
+
+// Returns the address of the BSS of management and data frames,
+// nil when the frame goes from one AP to another
+func (f Dot11) BSSID() net.HardwareAddr {
+	if f.Type() != Dot11Management && f.Type() != Dot11Data {
+		return nil
+	}
+	switch f.Flags() & (Dot11ToDS | Dot11FromDS) {
+	case 0:
+		return f.Addr3()
+	case Dot11ToDS:
+		return f.Addr1()
+	case Dot11FromDS:
+		return f.Addr2()
+	}
+	return nil
+}
+
+// Returns the sender of management and data frames
+func (f Dot11) Src() net.HardwareAddr {
+	switch f.Flags() & (Dot11ToDS | Dot11FromDS) {
+	case Dot11FromDS:
+		return f.Addr3()
+	case Dot11ToDS | Dot11FromDS:
+		return f.Addr4()
+	}
+	return f.Addr2()
+}
+
+// Returns the final receiver of management and data frames
+func (f Dot11) Dst() net.HardwareAddr {
+	if f.Flags()&Dot11ToDS != 0 {
+		return f.Addr3()
+	}
+	return f.Addr1()
+}
+
+// Returns the sequence number and the fragment number
+// of management and data frames
+func (f Dot11) Sequence() (seq uint16, frag uint8, ok bool) {
+	if f.Type() != Dot11Management && f.Type() != Dot11Data {
+		return 0, 0, false
+	}
+	sc := binary.LittleEndian.Uint16(f.contents[22:24])
+	return sc >> 4, uint8(sc & 0xF), true
+}
+
+// reports whether this is a QoS data frame
+func (f Dot11) isQoS() bool { return f.Type() == Dot11Data && f.Subtype()&0x8 != 0 }
+
+// Returns the QoS control field of QoS data frames
+func (f Dot11) QoS() (uint16, bool) {
+	if !f.isQoS() {
+		return 0, false
+	}
+	off := 24
+	if f.Addr4() != nil {
+		off += 6
+	}
+	return binary.LittleEndian.Uint16(f.contents[off : off+2]), true
+}
+
+// Returns the FCS at the end of the frame, which is only
+// there when the radiotap or PPI header says so
+func (f Dot11) FCS() (uint32, bool) {
+	if f.fcs == nil {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(f.fcs), true
+}
+
+// Reports whether the FCS is right, false when there is none
+func (f Dot11) FCSValid() bool {
+	fcs, ok := f.FCS()
+	return ok && crc32.ChecksumIEEE(f.frame) == fcs
+}
+
+// the length of the MAC header of data
+func dot11HeaderLength(data []byte) int {
+	t := Dot11Type(data[0] >> 2 & 0x3)
+	subtype := data[0] >> 4
+	flags := Dot11Flags(data[1])
+	switch t {
+	case Dot11Control:
+		if subtype == Dot11CTS || subtype == Dot11Ack {
+			return 10
+		}
+		return 16
+	case Dot11Management:
+		if flags&Dot11Order != 0 {
+			return 28
+		}
+		return 24
+	case Dot11Data:
+		n := 24
+		if flags&(Dot11ToDS|Dot11FromDS) == Dot11ToDS|Dot11FromDS {
+			n += 6
+		}
+		if subtype&0x8 != 0 {
+			n += 2
+			if flags&Dot11Order != 0 {
+				n += 4
+			}
+		}
+		return n
+	}
+	return 10
+}
+
+// decodes an 802.11 frame, which ends with the FCS if fcs is set.
+// With pad the payload starts at the next multiple of 4 bytes.
+func (d *decoder) dot11(data []byte, fcs bool, pad bool) {
+	if len(data) < 10 {
+		d.cut()
+		return
+	}
+	f := Dot11{}
+	// when cut off the FCS has not been captured
+	if fcs && !d.p.Truncated {
+		if len(data) < 14 {
+			d.fail(ErrMalformed)
+			return
+		}
+		f.fcs = data[len(data)-4:]
+		data = data[:len(data)-4]
+	}
+	f.frame = data
+	l, ok := d.header(data, dot11HeaderLength(data))
+	if !ok {
+		return
+	}
+	f.layer = l
+	if pad {
+		f.payload = data[min((len(l.contents)+3)&^3, len(data)):]
+	}
+	d.add(f)
+
+	switch {
+	case f.Flags()&Dot11Protected != 0:
+		d.payload(f.payload)
+	case f.Type() == Dot11Management:
+		d.dot11Management(f.Subtype(), f.payload)
+	case f.Type() == Dot11Data && f.Subtype()&0x4 == 0:
+		// no A-MSDUs, which have several frames
+		if qos, ok := f.QoS(); ok && qos&0x80 != 0 {
+			d.payload(f.payload)
+		} else if len(f.payload) > 0 {
+			d.llc(f.payload)
+		}
+	default:
+		d.payload(f.payload)
+	}
+}
+
+// Dot11Element is an information element of a management frame
+type Dot11Element struct {
+	ID   uint8
+	Data []byte
+}
+
+// Returns the id of elements with ID 255, which is the first byte of their data
+func (e Dot11Element) ExtID() (uint8, bool) {
+	if e.ID != Dot11ElementExtension || len(e.Data) == 0 {
+		return 0, false
+	}
+	return e.Data[0], true
+}
+
+// ids of information elements
+const (
+	Dot11ElementSSID      uint8 = 0
+	Dot11ElementRates     uint8 = 1
+	Dot11ElementDSSet     uint8 = 3
+	Dot11ElementTIM       uint8 = 5
+	Dot11ElementCountry   uint8 = 7
+	Dot11ElementHTCaps    uint8 = 45
+	Dot11ElementRSN       uint8 = 48
+	Dot11ElementExtRates  uint8 = 50
+	Dot11ElementHTInfo    uint8 = 61
+	Dot11ElementVHTCaps   uint8 = 191
+	Dot11ElementVendor    uint8 = 221
+	Dot11ElementExtension uint8 = 255
+)
+
+// Dot11ManagementBody is the body of a management frame: the fixed
+// fields, which differ from subtype to subtype, and the information elements
+type Dot11ManagementBody struct {
+	layer
+	Subtype uint8
+}
+
+func (Dot11ManagementBody) LayerType() LayerType { return LayerDot11Management }
+
+// the length of the fixed fields, -1 for
+// subtypes that have no information elements
+func (m Dot11ManagementBody) fixedLength() int {
+	switch m.Subtype {
+	case Dot11ProbeRequest, Dot11ATIM:
+		return 0
+	case Dot11Beacon, Dot11ProbeResponse:
+		return 12
+	case Dot11AssocRequest:
+		return 4
+	case Dot11AssocResponse, Dot11ReassocResponse, Dot11Auth:
+		return 6
+	case Dot11ReassocRequest:
+		return 10
+	case Dot11Disassoc, Dot11Deauth:
+		return 2
+	}
+	return -1
+}
+
+// returns the 16 bit fixed field at off for the subtypes
+func (m Dot11ManagementBody) fixed(off int, subtypes ...uint8) (uint16, bool) {
+	for _, s := range subtypes {
+		if s == m.Subtype && off+2 <= len(m.contents) {
+			return binary.LittleEndian.Uint16(m.contents[off : off+2]), true
+		}
+	}
+	return 0, false
+}
+
+// Returns the TSF timer of beacons and probe responses in microseconds
+func (m Dot11ManagementBody) Timestamp() (uint64, bool) {
+	if (m.Subtype != Dot11Beacon && m.Subtype != Dot11ProbeResponse) || len(m.contents) < 8 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(m.contents[0:8]), true
+}
+
+// Returns the beacon interval in time units of 1024 microseconds
+func (m Dot11ManagementBody) BeaconInterval() (uint16, bool) {
+	return m.fixed(8, Dot11Beacon, Dot11ProbeResponse)
+}
+
+func (m Dot11ManagementBody) Capabilities() (uint16, bool) {
+	if v, ok := m.fixed(10, Dot11Beacon, Dot11ProbeResponse); ok {
+		return v, true
+	}
+	return m.fixed(0, Dot11AssocRequest, Dot11AssocResponse, Dot11ReassocRequest, Dot11ReassocResponse)
+}
+
+func (m Dot11ManagementBody) StatusCode() (uint16, bool) {
+	if v, ok := m.fixed(2, Dot11AssocResponse, Dot11ReassocResponse); ok {
+		return v, true
+	}
+	return m.fixed(4, Dot11Auth)
+}
+
+func (m Dot11ManagementBody) ReasonCode() (uint16, bool) {
+	return m.fixed(0, Dot11Disassoc, Dot11Deauth)
+}
+
+// Returns the information elements
+func (m Dot11ManagementBody) Elements() iter.Seq[Dot11Element] {
+	return func(yield func(Dot11Element) bool) {
+		n := m.fixedLength()
+		if n < 0 || n > len(m.contents) {
+			return
+		}
+		for data := m.contents[n:]; len(data) >= 2; {
+			size := int(data[1])
+			if 2+size > len(data) {
+				return
+			}
+			if !yield(Dot11Element{ID: data[0], Data: data[2 : 2+size]}) {
+				return
+			}
+			data = data[2+size:]
+		}
+	}
+}
+
+// Returns the data of the first element with id
+func (m Dot11ManagementBody) Element(id uint8) ([]byte, bool) {
+	for e := range m.Elements() {
+		if e.ID == id {
+			return e.Data, true
+		}
+	}
+	return nil, false
+}
+
+// Returns the SSID, which is empty for hidden networks
+// and in probe requests for any network
+func (m Dot11ManagementBody) SSID() ([]byte, bool) { return m.Element(Dot11ElementSSID) }
+
+// Returns the channel of the DS parameter set
+func (m Dot11ManagementBody) DSChannel() (uint8, bool) {
+	data, ok := m.Element(Dot11ElementDSSet)
+	if !ok || len(data) != 1 {
+		return 0, false
+	}
+	return data[0], true
+}
+
+// Dot11Rate is a rate of the supported rates elements
+type Dot11Rate uint8
+
+// Returns the rate in Mbps
+func (r Dot11Rate) Mbps() float64 { return float64(r&0x7F) / 2 }
+
+// Reports whether every station of the BSS must support the rate
+func (r Dot11Rate) Basic() bool { return r&0x80 != 0 }
+
+func (r Dot11Rate) String() string {
+	if r.Basic() {
+		return fmt.Sprintf("%g(B)", r.Mbps())
+	}
+	return fmt.Sprintf("%g", r.Mbps())
+}
+
+// Returns the supported and extended supported rates
+func (m Dot11ManagementBody) Rates() iter.Seq[Dot11Rate] {
+	return func(yield func(Dot11Rate) bool) {
+		for e := range m.Elements() {
+			if e.ID != Dot11ElementRates && e.ID != Dot11ElementExtRates {
+				continue
+			}
+			for _, r := range e.Data {
+				if !yield(Dot11Rate(r)) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Dot11Suite is a cipher or AKM suite of an RSN element,
+// an OUI followed by the type of the suite
+type Dot11Suite uint32
+
+// the OUI of the suites defined by 802.11
+const dot11SuiteOUI = 0x000FAC
+
+var dot11CipherNames = map[uint8]string{
+	1: "WEP-40", 2: "TKIP", 4: "CCMP-128", 5: "WEP-104", 6: "BIP-CMAC-128",
+	8: "GCMP-128", 9: "GCMP-256", 10: "CCMP-256", 11: "BIP-GMAC-128",
+	12: "BIP-GMAC-256", 13: "BIP-CMAC-256",
+}
+
+var dot11AKMNames = map[uint8]string{
+	1: "802.1X", 2: "PSK", 3: "FT-802.1X", 4: "FT-PSK", 5: "802.1X-SHA256",
+	6: "PSK-SHA256", 8: "SAE", 9: "FT-SAE", 12: "802.1X-SUITE-B-192",
+	18: "OWE", 24: "SAE-EXT-KEY",
+}
+
+func (s Dot11Suite) OUI() uint32 { return uint32(s) >> 8 }
+func (s Dot11Suite) Type() uint8 { return uint8(s) }
+
+func (s Dot11Suite) name(names map[uint8]string) string {
+	if s.OUI() == dot11SuiteOUI {
+		if name, ok := names[s.Type()]; ok {
+			return name
+		}
+	}
+	return fmt.Sprintf("%06X:%d", s.OUI(), s.Type())
+}
+
+// Returns the name of a cipher suite, i.e. CCMP-128
+func (s Dot11Suite) CipherName() string { return s.name(dot11CipherNames) }
+
+// Returns the name of an AKM suite, i.e. PSK
+func (s Dot11Suite) AKMName() string { return s.name(dot11AKMNames) }
+
+// RSN is the RSN element of a management frame, which tells how a network is secured
+type RSN struct {
+	Version      uint16
+	GroupCipher  Dot11Suite
+	Pairwise     []Dot11Suite
+	AKM          []Dot11Suite
+	Capabilities uint16
+	// the cipher to protect group management frames, 0 if there is none
+	GroupManagementCipher Dot11Suite
+}
+
+// Returns the RSN element. Fields which are left
+// out at its end get their default values.
+func (m Dot11ManagementBody) RSN() (RSN, bool) {
+	data, ok := m.Element(Dot11ElementRSN)
+	if !ok || len(data) < 2 {
+		return RSN{}, false
+	}
+	le := binary.LittleEndian
+	suite := func(b []byte) Dot11Suite { return Dot11Suite(binary.BigEndian.Uint32(b)) }
+	suites := func() ([]Dot11Suite, bool) {
+		if len(data) < 2 {
+			return nil, false
+		}
+		n := int(le.Uint16(data))
+		data = data[2:]
+		if len(data) < 4*n {
+			return nil, false
+		}
+		s := make([]Dot11Suite, n)
+		for i := range s {
+			s[i] = suite(data[4*i:])
+		}
+		data = data[4*n:]
+		return s, true
+	}
+
+	rsn := RSN{
+		Version:     le.Uint16(data),
+		GroupCipher: dot11SuiteOUI<<8 | 4,
+		Pairwise:    []Dot11Suite{dot11SuiteOUI<<8 | 4},
+		AKM:         []Dot11Suite{dot11SuiteOUI<<8 | 1},
+	}
+	data = data[2:]
+	if len(data) < 4 {
+		return rsn, len(data) == 0
+	}
+	rsn.GroupCipher = suite(data)
+	data = data[4:]
+	if len(data) == 0 {
+		return rsn, true
+	}
+	if rsn.Pairwise, ok = suites(); !ok {
+		return RSN{}, false
+	}
+	if len(data) == 0 {
+		return rsn, true
+	}
+	if rsn.AKM, ok = suites(); !ok {
+		return RSN{}, false
+	}
+	if len(data) < 2 {
+		return rsn, true
+	}
+	rsn.Capabilities = le.Uint16(data)
+	data = data[2:]
+	// PMKIDs are 16 bytes long
+	if len(data) >= 2 {
+		n := int(le.Uint16(data))
+		data = data[min(2+16*n, len(data)):]
+	}
+	if len(data) >= 4 {
+		rsn.GroupManagementCipher = suite(data)
+	}
+	return rsn, true
+}
+
+func (d *decoder) dot11Management(subtype uint8, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	m := Dot11ManagementBody{layer: layer{contents: data}, Subtype: subtype}
+	d.add(m)
+}
@@ -0,0 +1,278 @@
+package decode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// EtherType tells what follows an Ethernet header or VLAN tag
+type EtherType uint16
+
+const (
+	EtherTypeIPv4        EtherType = 0x0800
+	EtherTypeARP         EtherType = 0x0806
+	EtherTypeDot1Q       EtherType = 0x8100
+	EtherTypeIPv6        EtherType = 0x86DD
+	EtherTypeMPLS        EtherType = 0x8847
+	EtherTypeMPLSMulti   EtherType = 0x8848
+	EtherTypePPPoEDisc   EtherType = 0x8863
+	EtherTypePPPoE       EtherType = 0x8864
+	EtherTypeQinQ        EtherType = 0x88A8
+	EtherTypeLLDP        EtherType = 0x88CC
+	EtherTypeTransparent EtherType = 0x6558
+	EtherTypeERSPAN      EtherType = 0x88BE
+	EtherTypeERSPAN3     EtherType = 0x22EB
+	// PPP in the enhanced GRE of PPTP
+	EtherTypePPP EtherType = 0x880B
+	// the tag of QinQ before 802.1ad
+	EtherTypeQinQOld EtherType = 0x9100
+)
+
+var etherTypeNames = map[EtherType]string{
+	EtherTypeIPv4:        "IPv4",
+	EtherTypeARP:         "ARP",
+	EtherTypeDot1Q:       "Dot1Q",
+	EtherTypeIPv6:        "IPv6",
+	EtherTypeMPLS:        "MPLS",
+	EtherTypeMPLSMulti:   "MPLSMulticast",
+	EtherTypePPPoEDisc:   "PPPoEDiscovery",
+	EtherTypePPPoE:       "PPPoESession",
+	EtherTypeQinQ:        "QinQ",
+	EtherTypeLLDP:        "LLDP",
+	EtherTypeTransparent: "TransparentEthernetBridging",
+	EtherTypeERSPAN:      "ERSPAN",
+	EtherTypeERSPAN3:     "ERSPAN3",
+	EtherTypePPP:         "PPP",
+	EtherTypeQinQOld:     "QinQOld",
+}
+
+func (t EtherType) String() string {
+	if name, ok := etherTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("EtherType(%#04x)", uint16(t))
+}
+
+// the largest type field that is a length, for 802.3 frames
+const maxEthernetLength = 1500
+
+// Ethernet is an Ethernet II or 802.3 header. An 802.3 header
+// has a length instead of an EtherType and is followed by LLC.
+type Ethernet struct{ layer }
+
+func (Ethernet) LayerType() LayerType { return LayerEthernet }
+
+func (e Ethernet) Dst() net.HardwareAddr { return net.HardwareAddr(e.contents[0:6]) }
+func (e Ethernet) Src() net.HardwareAddr { return net.HardwareAddr(e.contents[6:12]) }
+
+// Reports whether this is an 802.3 frame, which has a length instead of an EtherType
+func (e Ethernet) IsIEEE8023() bool {
+	return binary.BigEndian.Uint16(e.contents[12:14]) <= maxEthernetLength
+}
+
+// Returns the EtherType or 0 for 802.3 frames
+func (e Ethernet) EtherType() EtherType {
+	if e.IsIEEE8023() {
+		return 0
+	}
+	return EtherType(binary.BigEndian.Uint16(e.contents[12:14]))
+}
+
+// Returns the length of the payload of an 802.3 frame or 0 for Ethernet II
+func (e Ethernet) Length() uint16 {
+	if !e.IsIEEE8023() {
+		return 0
+	}
+	return binary.BigEndian.Uint16(e.contents[12:14])
+}
+
+func (d *decoder) ethernet(data []byte) {
+	l, ok := d.header(data, 14)
+	if !ok {
+		return
+	}
+	e := Ethernet{l}
+	if e.IsIEEE8023() {
+		d.limit(&e.layer, int(e.Length()))
+		d.add(e)
+		d.llc(e.payload)
+		return
+	}
+	d.add(e)
+	d.etherType(e.EtherType(), e.payload)
+}
+
+// decodes what follows an EtherType
+func (d *decoder) etherType(t EtherType, data []byte) {
+	switch t {
+	case EtherTypeIPv4:
+		d.ipv4(data)
+	case EtherTypeIPv6:
+		d.ipv6(data)
+	case EtherTypeARP:
+		d.arp(data)
+	case EtherTypeDot1Q, EtherTypeQinQ, EtherTypeQinQOld:
+		d.dot1q(t, data)
+	case EtherTypePPPoE, EtherTypePPPoEDisc:
+		d.pppoe(data)
+	case EtherTypeMPLS, EtherTypeMPLSMulti:
+		d.mpls(data)
+	case EtherTypeTransparent:
+		d.ethernet(data)
+	case EtherTypePPP:
+		d.ppp(data, false)
+	default:
+		d.payload(data)
+	}
+}
+
+// LLC is the 802.2 header of 802.3 frames, with
+// a SNAP header when DSAP and SSAP are 0xAA
+type LLC struct{ layer }
+
+func (LLC) LayerType() LayerType { return LayerLLC }
+
+func (l LLC) DSAP() uint8 { return l.contents[0] }
+func (l LLC) SSAP() uint8 { return l.contents[1] }
+
+// Returns the control field, which is one byte long for
+// unnumbered frames and two bytes for the others
+func (l LLC) Control() uint16 {
+	if l.contents[2]&0x03 == 0x03 {
+		return uint16(l.contents[2])
+	}
+	return binary.BigEndian.Uint16(l.contents[2:4])
+}
+
+// Reports whether there is a SNAP header
+func (l LLC) IsSNAP() bool {
+	return l.DSAP() == 0xAA && l.SSAP() == 0xAA && len(l.contents) == 8
+}
+
+// Returns the OUI of the SNAP header, 0 when there is none
+func (l LLC) OUI() uint32 {
+	if !l.IsSNAP() {
+		return 0
+	}
+	return uint32(l.contents[3])<<16 | uint32(l.contents[4])<<8 | uint32(l.contents[5])
+}
+
+// Returns the protocol of the SNAP header, which is an
+// EtherType when the OUI is 0, or 0 when there is none
+func (l LLC) ProtocolID() uint16 {
+	if !l.IsSNAP() {
+		return 0
+	}
+	return binary.BigEndian.Uint16(l.contents[6:8])
+}
+
+func (d *decoder) llc(data []byte) {
+	if len(data) < 3 {
+		d.cut()
+		return
+	}
+	n := 3
+	switch {
+	case data[0] == 0xAA && data[1] == 0xAA && data[2] == 0x03:
+		n = 8
+	case data[2]&0x03 != 0x03:
+		n = 4
+	}
+	l, ok := d.header(data, n)
+	if !ok {
+		return
+	}
+	llc := LLC{l}
+	d.add(llc)
+	if llc.IsSNAP() && llc.OUI() == 0 {
+		d.etherType(EtherType(llc.ProtocolID()), llc.payload)
+		return
+	}
+	d.payload(llc.payload)
+}
+
+// Dot1Q is an 802.1Q VLAN tag. With QinQ there is one for every tag.
+type Dot1Q struct {
+	layer
+	// the EtherType that announced the tag,
+	// EtherTypeDot1Q or one of the QinQ ones
+	TPID EtherType
+}
+
+func (Dot1Q) LayerType() LayerType { return LayerDot1Q }
+
+func (v Dot1Q) Priority() uint8 { return v.contents[0] >> 5 }
+
+// Reports whether the frame may be dropped first on congestion
+func (v Dot1Q) DropEligible() bool { return v.contents[0]&0x10 != 0 }
+
+func (v Dot1Q) VLANID() uint16 { return binary.BigEndian.Uint16(v.contents[0:2]) & 0x0FFF }
+
+func (v Dot1Q) EtherType() EtherType { return EtherType(binary.BigEndian.Uint16(v.contents[2:4])) }
+
+func (d *decoder) dot1q(tpid EtherType, data []byte) {
+	l, ok := d.header(data, 4)
+	if !ok {
+		return
+	}
+	v := Dot1Q{layer: l, TPID: tpid}
+	d.add(v)
+	d.etherType(v.EtherType(), v.payload)
+}
+
+// ARP is an ARP request or reply of any hardware and protocol type
+type ARP struct{ layer }
+
+func (ARP) LayerType() LayerType { return LayerARP }
+
+const (
+	ARPRequest uint16 = 1
+	ARPReply   uint16 = 2
+)
+
+func (a ARP) HardwareType() uint16    { return binary.BigEndian.Uint16(a.contents[0:2]) }
+func (a ARP) ProtocolType() EtherType { return EtherType(binary.BigEndian.Uint16(a.contents[2:4])) }
+func (a ARP) Operation() uint16       { return binary.BigEndian.Uint16(a.contents[6:8]) }
+
+func (a ARP) hwLen() int    { return int(a.contents[4]) }
+func (a ARP) protoLen() int { return int(a.contents[5]) }
+
+func (a ARP) SenderHardwareAddr() net.HardwareAddr {
+	return net.HardwareAddr(a.contents[8 : 8+a.hwLen()])
+}
+
+func (a ARP) SenderProtocolAddr() []byte {
+	start := 8 + a.hwLen()
+	return a.contents[start : start+a.protoLen()]
+}
+
+func (a ARP) TargetHardwareAddr() net.HardwareAddr {
+	start := 8 + a.hwLen() + a.protoLen()
+	return net.HardwareAddr(a.contents[start : start+a.hwLen()])
+}
+
+func (a ARP) TargetProtocolAddr() []byte {
+	start := 8 + 2*a.hwLen() + a.protoLen()
+	return a.contents[start : start+a.protoLen()]
+}
+
+// Returns the protocol addresses as IP addresses,
+// which only works when they are 4 or 16 bytes long
+func (a ARP) SenderIP() (netip.Addr, bool) { return netip.AddrFromSlice(a.SenderProtocolAddr()) }
+func (a ARP) TargetIP() (netip.Addr, bool) { return netip.AddrFromSlice(a.TargetProtocolAddr()) }
+
+func (d *decoder) arp(data []byte) {
+	if len(data) < 8 {
+		d.cut()
+		return
+	}
+	l, ok := d.header(data, 8+2*int(data[4])+2*int(data[5]))
+	if !ok {
+		return
+	}
+	// the rest is the padding of the Ethernet frame
+	l.payload = nil
+	d.add(ARP{l})
+}
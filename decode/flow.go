@@ -0,0 +1,533 @@
+package decode
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Sojamann/pcapreader"
+)
+
+// FlowEnd tells why a flow has been exported
+type FlowEnd uint8
+
+const (
+	// nothing has been sent for FlowOptions.IdleTimeout
+	FlowEndIdle FlowEnd = iota
+	// the flow has gone on for FlowOptions.ActiveTimeout,
+	// the packets after that are counted in a new one
+	FlowEndActive
+	// the TCP connection has been closed with FINs from both
+	// sides or a RST and FlowOptions.ClosedTimeout has passed
+	FlowEndClosed
+	// dropped to stay within FlowOptions.MaxFlows
+	FlowEndEvicted
+	// still going when Flush was called
+	FlowEndFlush
+)
+
+var flowEndNames = [...]string{
+	FlowEndIdle:    "idle",
+	FlowEndActive:  "active",
+	FlowEndClosed:  "closed",
+	FlowEndEvicted: "evicted",
+	FlowEndFlush:   "flushed",
+}
+
+func (e FlowEnd) String() string {
+	if int(e) < len(flowEndNames) {
+		return flowEndNames[e]
+	}
+	return "unknown"
+}
+
+// the defaults of FlowOptions, which are those of NetFlow
+const (
+	defaultFlowIdleTimeout   = 15 * time.Second
+	defaultFlowActiveTimeout = 30 * time.Minute
+	defaultFlowClosedTimeout = 5 * time.Second
+	defaultMaxFlows          = 65536
+)
+
+// FlowOptions change when a FlowTable exports its flows.
+// The timeouts are measured in capture time.
+type FlowOptions struct {
+	// How long a flow may go without a packet. Defaults to 15 seconds.
+	IdleTimeout time.Duration
+	// How long a flow may go on before it is exported and continued
+	// in a new one, so long conversations are reported while they
+	// are still going. Defaults to 30 minutes.
+	ActiveTimeout time.Duration
+	// How long a TCP flow may go without a packet after it has been
+	// closed, which leaves time for the last ACKs. Defaults to 5 seconds.
+	ClosedTimeout time.Duration
+	// The most flows that are tracked at once, beyond which the ones
+	// that have been idle longest are exported. Defaults to 65536.
+	MaxFlows int
+
+	// Called for every flow once it has ended
+	OnFlow func(Flow)
+}
+
+func (o FlowOptions) withDefaults() FlowOptions {
+	if o.IdleTimeout == 0 {
+		o.IdleTimeout = defaultFlowIdleTimeout
+	}
+	if o.ActiveTimeout == 0 {
+		o.ActiveTimeout = defaultFlowActiveTimeout
+	}
+	if o.ClosedTimeout == 0 {
+		o.ClosedTimeout = defaultFlowClosedTimeout
+	}
+	if o.MaxFlows == 0 {
+		o.MaxFlows = defaultMaxFlows
+	}
+	return o
+}
+
+// FlowCounts is what is counted for one direction of a flow
+type FlowCounts struct {
+	Packets uint64 `json:"packets"`
+	// the sum of the sizes of the packets on the wire
+	Bytes uint64 `json:"bytes"`
+	// all flags of the TCP headers
+	TCPFlags TCPFlags `json:"tcp_flags"`
+}
+
+// Flow is a conversation between two hosts over one protocol, like
+// a NetFlow record but with both directions in one. A flow is told
+// apart by the innermost IP header of its packets, the ports of TCP
+// and UDP, the innermost VLAN tag and the interface.
+type Flow struct {
+	Interface pcapreader.Interface `json:"interface"`
+	// the id of the innermost VLAN tag, 0 without one
+	VLAN     uint16     `json:"vlan"`
+	Protocol IPProtocol `json:"protocol"`
+	// The side that sent the first packet and the other one.
+	// The ports are 0 for protocols other than TCP and UDP.
+	Src netip.AddrPort `json:"src"`
+	Dst netip.AddrPort `json:"dst"`
+
+	// the time of the first and the latest packet
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+	// from Src to Dst and back
+	Forward FlowCounts `json:"forward"`
+	Reverse FlowCounts `json:"reverse"`
+
+	End FlowEnd `json:"end"`
+}
+
+// Returns the time from the first to the latest packet
+func (f *Flow) Duration() time.Duration { return f.Last.Sub(f.First) }
+
+// both directions of a flow are told apart by, with a before b
+type flowKey struct {
+	iface    pcapreader.Interface
+	vlan     uint16
+	protocol IPProtocol
+	a, b     netip.AddrPort
+}
+
+type trackedFlow struct {
+	Flow
+	key   flowKey
+	entry *list.Element
+	// whether a FIN has been seen from Src and from Dst
+	finForward, finReverse bool
+	closed                 bool
+}
+
+// FlowTable sums up packets into flows and hands them over through
+// FlowOptions.OnFlow once they have ended. Fragments after the first
+// one have no ports, so fragmented datagrams should be reassembled
+// before, i.e. with a Defragmenter.
+type FlowTable struct {
+	opts  FlowOptions
+	flows map[flowKey]*trackedFlow
+	// the flows by the time of their latest packet, oldest first,
+	// those of closed TCP connections apart as they time out sooner
+	open, closed *list.List
+}
+
+func NewFlowTable(opts FlowOptions) *FlowTable {
+	return &FlowTable{
+		opts:   opts.withDefaults(),
+		flows:  make(map[flowKey]*trackedFlow),
+		open:   list.New(),
+		closed: list.New(),
+	}
+}
+
+// finds the innermost IP header of p, the protocol after
+// its extension headers and the VLAN tag in front of it
+func flowOf(p *Packet) (vlan uint16, protocol IPProtocol, src, dst netip.AddrPort, flags TCPFlags, ok bool) {
+	ip := -1
+	for i := len(p.Layers) - 1; i >= 0 && ip < 0; i-- {
+		switch p.Layers[i].(type) {
+		case IPv4, IPv6:
+			ip = i
+		}
+	}
+	if ip < 0 {
+		return 0, 0, src, dst, 0, false
+	}
+	for i := ip - 1; i >= 0; i-- {
+		if v, isVLAN := p.Layers[i].(Dot1Q); isVLAN {
+			vlan = v.VLANID()
+			break
+		}
+	}
+
+	var srcAddr, dstAddr netip.Addr
+	switch l := p.Layers[ip].(type) {
+	case IPv4:
+		srcAddr, dstAddr, protocol = l.Src(), l.Dst(), l.Protocol()
+	case IPv6:
+		srcAddr, dstAddr, protocol = l.Src(), l.Dst(), l.NextHeader()
+	}
+	var srcPort, dstPort uint16
+	for _, l := range p.Layers[ip+1:] {
+		switch l := l.(type) {
+		case IPv6Extension:
+			protocol = l.NextHeader()
+			continue
+		case IPv6Fragment:
+			protocol = l.NextHeader()
+			continue
+		case TCP:
+			srcPort, dstPort, flags = l.SrcPort(), l.DstPort(), l.Flags()
+		case UDP:
+			srcPort, dstPort = l.SrcPort(), l.DstPort()
+		}
+		break
+	}
+	return vlan, protocol, netip.AddrPortFrom(srcAddr, srcPort), netip.AddrPortFrom(dstAddr, dstPort), flags, true
+}
+
+// Adds a packet captured on iface, p is what it has been decoded into.
+// Packets without IP are ignored. OnFlow is called from within Add.
+func (ft *FlowTable) Add(info *pcapreader.PacketInfo, iface pcapreader.Interface, p *Packet) {
+	now := info.CaptureTime
+	if !now.IsZero() {
+		ft.expire(now)
+	}
+	vlan, protocol, src, dst, flags, ok := flowOf(p)
+	if !ok {
+		return
+	}
+	key := flowKey{iface: iface, vlan: vlan, protocol: protocol, a: src, b: dst}
+	if src.Compare(dst) > 0 {
+		key.a, key.b = dst, src
+	}
+
+	f := ft.flows[key]
+	if f != nil && !now.IsZero() && !f.First.IsZero() && now.Sub(f.First) >= ft.opts.ActiveTimeout {
+		// goes on in a new flow the same way around
+		src, dst := f.Src, f.Dst
+		ft.end(f, FlowEndActive)
+		f = ft.track(key, src, dst, now)
+	}
+	if f == nil {
+		for len(ft.flows) >= ft.opts.MaxFlows {
+			oldest := ft.closed.Front()
+			if oldest == nil {
+				oldest = ft.open.Front()
+			}
+			ft.end(oldest.Value.(*trackedFlow), FlowEndEvicted)
+		}
+		f = ft.track(key, src, dst, now)
+	}
+	if f.First.IsZero() {
+		f.First = now
+	}
+	if !now.IsZero() {
+		f.Last = now
+	}
+
+	counts, fin := &f.Forward, &f.finForward
+	if src != f.Src {
+		counts, fin = &f.Reverse, &f.finReverse
+	}
+	counts.Packets++
+	counts.Bytes += uint64(info.Size)
+	counts.TCPFlags |= flags
+	if flags&TCPFin != 0 {
+		*fin = true
+	}
+
+	if !f.closed && (flags&TCPRst != 0 || f.finForward && f.finReverse) {
+		f.closed = true
+		ft.open.Remove(f.entry)
+		f.entry = ft.closed.PushBack(f)
+	} else if f.closed {
+		ft.closed.MoveToBack(f.entry)
+	} else {
+		ft.open.MoveToBack(f.entry)
+	}
+}
+
+// starts a flow from src to dst
+func (ft *FlowTable) track(key flowKey, src, dst netip.AddrPort, now time.Time) *trackedFlow {
+	f := &trackedFlow{
+		Flow: Flow{
+			Interface: key.iface,
+			VLAN:      key.vlan,
+			Protocol:  key.protocol,
+			Src:       src,
+			Dst:       dst,
+			First:     now,
+		},
+		key: key,
+	}
+	f.entry = ft.open.PushBack(f)
+	ft.flows[key] = f
+	return f
+}
+
+// forgets about f and hands it over
+func (ft *FlowTable) end(f *trackedFlow, reason FlowEnd) {
+	if f.closed {
+		ft.closed.Remove(f.entry)
+	} else {
+		ft.open.Remove(f.entry)
+	}
+	delete(ft.flows, f.key)
+	if ft.opts.OnFlow != nil {
+		f.End = reason
+		ft.opts.OnFlow(f.Flow)
+	}
+}
+
+// ends the flows that have been idle for too long
+func (ft *FlowTable) expire(now time.Time) {
+	for _, c := range []struct {
+		flows   *list.List
+		timeout time.Duration
+		reason  FlowEnd
+	}{
+		{ft.open, ft.opts.IdleTimeout, FlowEndIdle},
+		{ft.closed, ft.opts.ClosedTimeout, FlowEndClosed},
+	} {
+		for c.flows.Len() > 0 {
+			f := c.flows.Front().Value.(*trackedFlow)
+			if f.Last.IsZero() || now.Sub(f.Last) <= c.timeout {
+				break
+			}
+			ft.end(f, c.reason)
+		}
+	}
+}
+
+// Ends all flows, i.e. once the end of the capture has been reached.
+// Those of closed TCP connections end with FlowEndClosed, the others
+// with FlowEndFlush.
+func (ft *FlowTable) Flush() {
+	for ft.closed.Len() > 0 {
+		ft.end(ft.closed.Front().Value.(*trackedFlow), FlowEndClosed)
+	}
+	for ft.open.Len() > 0 {
+		ft.end(ft.open.Front().Value.(*trackedFlow), FlowEndFlush)
+	}
+}
+
+// Returns the number of flows that have not ended yet
+func (ft *FlowTable) Flows() int { return len(ft.flows) }
+
+// Adds all packets of t until it ends or ctx is done. At the end of t
+// the flows that are still going are flushed, which is not done when
+// an error is returned. Reaching the end of t is not an error.
+func (ft *FlowTable) Track(ctx context.Context, t pcapreader.Traffic) error {
+	var p Packet
+	packets := pcapreader.Packets(ctx, t)
+	for info, packet := range packets.All() {
+		iface := pcapreader.InterfaceOf(t)
+		DecodeInto(&p, iface.LinkLayerType, packet, info.Size)
+		ft.Add(info, iface, &p)
+	}
+	if err := packets.Err(); err != nil {
+		return err
+	}
+	ft.Flush()
+	return nil
+}
+
+// Returns the name of the flow of a packet for SplitOptions.FlowOf of
+// pcapreader, the same for both directions. Every TCP and UDP connection
+// has a flow of its own, i.e. "tcp_10.0.0.1_4711_10.0.0.2_80". Other
+// packets go by their pair of IP addresses and protocol, i.e.
+// "ip1_10.0.0.1_10.0.0.2", which is also where fragments and cut off
+// headers end up. Packets without IP are "other". Like for FlowTable
+// the innermost IP header counts.
+func SplitFlow(iface pcapreader.Interface, info *pcapreader.PacketInfo, packet pcapreader.Packet) string {
+	st := splitFlowStates.Get().(*splitFlowState)
+	defer splitFlowStates.Put(st)
+
+	DecodeInto(&st.p, iface.LinkLayerType, packet, info.Size)
+	_, protocol, src, dst, _, ok := flowOf(&st.p)
+	if !ok {
+		return "other"
+	}
+	if src.Compare(dst) > 0 {
+		src, dst = dst, src
+	}
+
+	var name string
+	switch protocol {
+	case IPProtocolTCP:
+		name = "tcp"
+	case IPProtocolUDP:
+		name = "udp"
+	}
+
+	b := st.name[:0]
+	// the ports are only known when the header has been decoded
+	if name == "" || src.Port() == 0 && dst.Port() == 0 {
+		b = append(b, "ip"...)
+		b = strconv.AppendUint(b, uint64(protocol), 10)
+		b = append(b, '_')
+		b = src.Addr().AppendTo(b)
+		b = append(b, '_')
+		b = dst.Addr().AppendTo(b)
+	} else {
+		b = append(b, name...)
+		b = append(b, '_')
+		b = src.Addr().AppendTo(b)
+		b = append(b, '_')
+		b = strconv.AppendUint(b, uint64(src.Port()), 10)
+		b = append(b, '_')
+		b = dst.Addr().AppendTo(b)
+		b = append(b, '_')
+		b = strconv.AppendUint(b, uint64(dst.Port()), 10)
+	}
+	st.name = b
+
+	// IPv6 addresses cannot be part of file names on every system
+	for i, c := range b {
+		if c == ':' {
+			b[i] = '-'
+		}
+	}
+	return string(b)
+}
+
+// what SplitFlow reuses from one packet to the next
+type splitFlowState struct {
+	p    Packet
+	name []byte
+}
+
+var splitFlowStates = sync.Pool{
+	New: func() any { return new(splitFlowState) },
+}
+
+// FlowWriter writes flows, i.e. from FlowOptions.OnFlow. The
+// first error is returned by Flush as well, so it may be
+// checked once all flows have been written.
+type FlowWriter interface {
+	WriteFlow(f Flow) error
+	Flush() error
+}
+
+// FlowCSVWriter writes flows as CSV with a header line
+type FlowCSVWriter struct {
+	w *csv.Writer
+	// set once the header has been written
+	started bool
+}
+
+var flowCSVHeader = []string{
+	"interface_source", "interface_section", "interface_id", "vlan", "protocol",
+	"src_addr", "src_port", "dst_addr", "dst_port", "first", "last", "duration_seconds",
+	"forward_packets", "forward_bytes", "forward_tcp_flags",
+	"reverse_packets", "reverse_bytes", "reverse_tcp_flags", "end",
+}
+
+func NewFlowCSVWriter(w io.Writer) *FlowCSVWriter {
+	return &FlowCSVWriter{w: csv.NewWriter(w)}
+}
+
+// Writes f, and the header before the first flow.
+// Times are written as RFC 3339 and empty if unknown.
+func (w *FlowCSVWriter) WriteFlow(f Flow) error {
+	if !w.started {
+		w.started = true
+		if err := w.w.Write(flowCSVHeader); err != nil {
+			return err
+		}
+	}
+	u := func(n uint64) string { return strconv.FormatUint(n, 10) }
+	t := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339Nano)
+	}
+	return w.w.Write([]string{
+		strconv.Itoa(f.Interface.Source),
+		strconv.Itoa(f.Interface.Section),
+		u(uint64(f.Interface.ID)),
+		u(uint64(f.VLAN)),
+		u(uint64(f.Protocol)),
+		f.Src.Addr().String(),
+		u(uint64(f.Src.Port())),
+		f.Dst.Addr().String(),
+		u(uint64(f.Dst.Port())),
+		t(f.First),
+		t(f.Last),
+		strconv.FormatFloat(f.Duration().Seconds(), 'f', -1, 64),
+		u(f.Forward.Packets),
+		u(f.Forward.Bytes),
+		u(uint64(f.Forward.TCPFlags)),
+		u(f.Reverse.Packets),
+		u(f.Reverse.Bytes),
+		u(uint64(f.Reverse.TCPFlags)),
+		f.End.String(),
+	})
+}
+
+// Writes the header if no flow has been written, so
+// that the output is a valid CSV file in any case
+func (w *FlowCSVWriter) Flush() error {
+	if !w.started {
+		w.started = true
+		w.w.Write(flowCSVHeader)
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// FlowJSONWriter writes flows as JSON, one object per line
+type FlowJSONWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+	err error
+}
+
+func NewFlowJSONWriter(w io.Writer) *FlowJSONWriter {
+	bw := bufio.NewWriter(w)
+	return &FlowJSONWriter{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func (w *FlowJSONWriter) WriteFlow(f Flow) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.enc.Encode(f)
+	return w.err
+}
+
+func (w *FlowJSONWriter) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.w.Flush()
+	return w.err
+}
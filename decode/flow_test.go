@@ -0,0 +1,187 @@
+package decode
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Sojamann/pcapreader"
+)
+
+// adds the packets at the seconds they are keyed by
+func track(ft *FlowTable, packets map[int]testPacket) {
+	for i := range 60 {
+		if packet, ok := packets[i]; ok {
+			info := &pcapreader.PacketInfo{CaptureTime: genTime(i), Size: uint32(len(packet))}
+			ft.Add(info, pcapreader.Interface{LinkLayerType: pcapreader.LinkTypeEthernet}, Decode(pcapreader.LinkTypeEthernet, packet, info.Size))
+		}
+	}
+}
+
+func TestFlowTable(t *testing.T) {
+	var flows []Flow
+	ft := NewFlowTable(FlowOptions{OnFlow: func(f Flow) { flows = append(flows, f) }})
+	dns := testPacket("x").udp().ipv4(IPProtocolUDP).dot1q(5, EtherTypeIPv4).ethernet(EtherTypeDot1Q)
+	packets := map[int]testPacket{
+		0: tcpSegment(false, 1000, TCPSyn, ""),
+		1: tcpSegment(true, 5000, TCPSyn|TCPAck, ""),
+		2: tcpSegment(false, 1001, TCPAck|TCPPsh, "hello"),
+		3: tcpSegment(false, 1006, TCPFin|TCPAck, ""),
+		4: tcpSegment(true, 5001, TCPFin|TCPAck, ""),
+		// the last ACK is still counted
+		5:  tcpSegment(false, 1007, TCPAck, ""),
+		6:  dns,
+		30: dns,
+	}
+	track(ft, packets)
+	if len(flows) != 2 || ft.Flows() != 1 {
+		t.Fatalf("%d flows ended, %d going", len(flows), ft.Flows())
+	}
+	ft.Flush()
+
+	udp, conn, last := flows[0], flows[1], flows[2]
+	if udp.End != FlowEndIdle || udp.VLAN != 5 || udp.Protocol != IPProtocolUDP || udp.Src.Port() != 53 || udp.Forward.Packets != 1 {
+		t.Errorf("UDP flow %+v", udp)
+	}
+	if last.End != FlowEndFlush || !last.First.Equal(genTime(30)) {
+		t.Errorf("last flow %+v", last)
+	}
+
+	if conn.End != FlowEndClosed || conn.Src.Port() != 4711 || conn.Dst.Port() != 80 || conn.VLAN != 0 {
+		t.Errorf("TCP flow %s -> %s ended %s", conn.Src, conn.Dst, conn.End)
+	}
+	if conn.Duration() != 5*time.Second {
+		t.Errorf("TCP flow took %v", conn.Duration())
+	}
+	forward := FlowCounts{Packets: 4, TCPFlags: TCPSyn | TCPAck | TCPPsh | TCPFin}
+	for _, i := range []int{0, 2, 3, 5} {
+		forward.Bytes += uint64(len(packets[i]))
+	}
+	if conn.Forward != forward {
+		t.Errorf("forward %+v instead of %+v", conn.Forward, forward)
+	}
+	if conn.Reverse.Packets != 2 || conn.Reverse.TCPFlags != TCPSyn|TCPAck|TCPFin {
+		t.Errorf("reverse %+v", conn.Reverse)
+	}
+}
+
+func TestFlowTableTimeouts(t *testing.T) {
+	var flows []Flow
+	ft := NewFlowTable(FlowOptions{ActiveTimeout: 10 * time.Second, OnFlow: func(f Flow) { flows = append(flows, f) }})
+	udp := testPacket("x").udp().ipv4(IPProtocolUDP).ethernet(EtherTypeIPv4)
+	track(ft, map[int]testPacket{0: udp, 5: udp, 10: udp, 12: udp})
+	ft.Flush()
+	if len(flows) != 2 || flows[0].End != FlowEndActive || flows[0].Forward.Packets != 2 || flows[1].Forward.Packets != 2 {
+		t.Errorf("flows %+v", flows)
+	}
+
+	flows = nil
+	ft = NewFlowTable(FlowOptions{MaxFlows: 1, OnFlow: func(f Flow) { flows = append(flows, f) }})
+	track(ft, map[int]testPacket{0: udp, 1: tcpSegment(false, 1, TCPSyn, "")})
+	if len(flows) != 1 || flows[0].End != FlowEndEvicted || flows[0].Protocol != IPProtocolUDP {
+		t.Errorf("flows %+v", flows)
+	}
+}
+
+func TestFlowWriters(t *testing.T) {
+	var flows []Flow
+	ft := NewFlowTable(FlowOptions{OnFlow: func(f Flow) { flows = append(flows, f) }})
+	track(ft, map[int]testPacket{
+		0: tcpSegment(false, 1000, TCPSyn, ""),
+		2: tcpSegment(true, 5000, TCPSyn|TCPAck, ""),
+	})
+	ft.Flush()
+
+	var b bytes.Buffer
+	w := NewFlowCSVWriter(&b)
+	if err := w.WriteFlow(flows[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || len(records[1]) != len(flowCSVHeader) {
+		t.Fatalf("%d records", len(records))
+	}
+	row := make(map[string]string)
+	for i, name := range records[0] {
+		row[name] = records[1][i]
+	}
+	if row["src_addr"] != "10.0.0.1" || row["dst_port"] != "80" || row["protocol"] != "6" ||
+		row["duration_seconds"] != "2" || row["reverse_tcp_flags"] != "18" || row["end"] != "flushed" {
+		t.Errorf("CSV %v", row)
+	}
+
+	b.Reset()
+	jw := NewFlowJSONWriter(&b)
+	jw.WriteFlow(flows[0])
+	jw.WriteFlow(flows[0])
+	if err := jw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(&b)
+	for range 2 {
+		var f Flow
+		if err := dec.Decode(&f); err != nil {
+			t.Fatal(err)
+		}
+		if f.Src != flows[0].Src || f.Dst != flows[0].Dst || !f.Last.Equal(flows[0].Last) || f.Reverse != flows[0].Reverse {
+			t.Errorf("JSON %+v instead of %+v", f, flows[0])
+		}
+	}
+}
+
+func TestSplitFlow(t *testing.T) {
+	udp := testPacket("x").udp()
+	// a destination options header in front of the TCP header
+	ipv6TCP := append(testPacket{byte(IPProtocolTCP), 0, 1, 4, 0, 0, 0, 0}, testPacket("").tcp(TCPSyn)...)
+	cutOff := tcpSegment(false, 1, TCPAck, "hello")
+	for _, c := range []struct {
+		name   string
+		llt    pcapreader.LinkLayerType
+		packet testPacket
+		size   int
+		want   string
+	}{
+		{"client", pcapreader.LinkTypeEthernet, tcpSegment(false, 1, TCPSyn, ""), 0, "tcp_10.0.0.1_4711_10.0.0.2_80"},
+		{"server", pcapreader.LinkTypeEthernet, tcpSegment(true, 1, TCPSyn|TCPAck, ""), 0, "tcp_10.0.0.1_4711_10.0.0.2_80"},
+		{"VLAN", pcapreader.LinkTypeEthernet, udp.ipv4(IPProtocolUDP).dot1q(5, EtherTypeIPv4).ethernet(EtherTypeDot1Q), 0, "udp_10.0.0.1_53_10.0.0.2_5353"},
+		{"QinQ", pcapreader.LinkTypeEthernet, udp.ipv4(IPProtocolUDP).dot1q(5, EtherTypeIPv4).dot1q(7, EtherTypeDot1Q).ethernet(EtherTypeDot1Q), 0, "udp_10.0.0.1_53_10.0.0.2_5353"},
+		{"raw IP", pcapreader.LinkTypeRaw, udp.ipv4(IPProtocolUDP), 0, "udp_10.0.0.1_53_10.0.0.2_5353"},
+		{"IPv6", pcapreader.LinkTypeEthernet, ipv6TCP.ipv6(IPProtocolDestOptions).ethernet(EtherTypeIPv6), 0, "tcp_2000--1_4711_2000--2_80"},
+		{"ICMP", pcapreader.LinkTypeEthernet, testPacket("x").ipv4(IPProtocolICMPv4).ethernet(EtherTypeIPv4), 0, "ip1_10.0.0.1_10.0.0.2"},
+		{"TCP header cut off", pcapreader.LinkTypeEthernet, cutOff[:14+20+10], len(cutOff), "ip6_10.0.0.1_10.0.0.2"},
+		{"first fragment", pcapreader.LinkTypeEthernet, ipv4Fragment(1, 0, true, udp), 0, "ip17_10.0.0.1_10.0.0.2"},
+		{"later fragment", pcapreader.LinkTypeEthernet, ipv4Fragment(1, 8, false, udp[8:]), 0, "ip17_10.0.0.1_10.0.0.2"},
+		{"IPv6 fragment", pcapreader.LinkTypeEthernet, ipv6Fragment(1, 0, true, udp), 0, "ip17_2000--1_2000--2"},
+		{"ARP", pcapreader.LinkTypeEthernet, testPacket(make([]byte, 28)).ethernet(EtherTypeARP), 0, "other"},
+		{"frame cut off", pcapreader.LinkTypeEthernet, testPacket{0, 1, 2}, 60, "other"},
+	} {
+		size := c.size
+		if size == 0 {
+			size = len(c.packet)
+		}
+		info := &pcapreader.PacketInfo{Size: uint32(size)}
+		if got := SplitFlow(pcapreader.Interface{LinkLayerType: c.llt}, info, pcapreader.Packet(c.packet)); got != c.want {
+			t.Errorf("%s: %s instead of %s", c.name, got, c.want)
+		}
+	}
+
+	// the decoded packet is reused, only the name is new
+	data := pcapreader.Packet(tcpSegment(false, 1, TCPSyn, ""))
+	info := &pcapreader.PacketInfo{Size: uint32(len(data))}
+	iface := pcapreader.Interface{LinkLayerType: pcapreader.LinkTypeEthernet}
+	var p Packet
+	decodeAllocs := testing.AllocsPerRun(100, func() {
+		DecodeInto(&p, iface.LinkLayerType, data, info.Size)
+	})
+	if allocs := testing.AllocsPerRun(100, func() { SplitFlow(iface, info, data) }); allocs > decodeAllocs+1 {
+		t.Errorf("%v allocations per packet, decoding takes %v", allocs, decodeAllocs)
+	}
+}
@@ -0,0 +1,347 @@
+package decode
+
+import (
+	"encoding/binary"
+	"iter"
+	"net/netip"
+	"strconv"
+)
+
+// IPProtocol tells what follows an IP header, also known as next header
+type IPProtocol uint8
+
+const (
+	IPProtocolHopByHop    IPProtocol = 0
+	IPProtocolICMPv4      IPProtocol = 1
+	IPProtocolIGMP        IPProtocol = 2
+	IPProtocolIPv4        IPProtocol = 4
+	IPProtocolTCP         IPProtocol = 6
+	IPProtocolUDP         IPProtocol = 17
+	IPProtocolIPv6        IPProtocol = 41
+	IPProtocolRouting     IPProtocol = 43
+	IPProtocolFragment    IPProtocol = 44
+	IPProtocolGRE         IPProtocol = 47
+	IPProtocolESP         IPProtocol = 50
+	IPProtocolAH          IPProtocol = 51
+	IPProtocolICMPv6      IPProtocol = 58
+	IPProtocolNoNext      IPProtocol = 59
+	IPProtocolDestOptions IPProtocol = 60
+	IPProtocolSCTP        IPProtocol = 132
+	IPProtocolMPLS        IPProtocol = 137
+)
+
+var ipProtocolNames = map[IPProtocol]string{
+	IPProtocolHopByHop:    "HopByHop",
+	IPProtocolICMPv4:      "ICMPv4",
+	IPProtocolIGMP:        "IGMP",
+	IPProtocolIPv4:        "IPv4",
+	IPProtocolTCP:         "TCP",
+	IPProtocolUDP:         "UDP",
+	IPProtocolIPv6:        "IPv6",
+	IPProtocolRouting:     "Routing",
+	IPProtocolFragment:    "Fragment",
+	IPProtocolGRE:         "GRE",
+	IPProtocolESP:         "ESP",
+	IPProtocolAH:          "AH",
+	IPProtocolICMPv6:      "ICMPv6",
+	IPProtocolNoNext:      "NoNext",
+	IPProtocolDestOptions: "DestOptions",
+	IPProtocolSCTP:        "SCTP",
+	IPProtocolMPLS:        "MPLS",
+}
+
+func (p IPProtocol) String() string {
+	if name, ok := ipProtocolNames[p]; ok {
+		return name
+	}
+	return "IPProtocol(" + strconv.Itoa(int(p)) + ")"
+}
+
+// decodes what follows an IP header or IPv6 extension header
+func (d *decoder) ipProtocol(p IPProtocol, data []byte) {
+	switch p {
+	case IPProtocolTCP:
+		d.tcp(data)
+	case IPProtocolUDP:
+		d.udp(data)
+	case IPProtocolICMPv4:
+		d.icmpv4(data)
+	case IPProtocolICMPv6:
+		d.icmpv6(data)
+	case IPProtocolHopByHop, IPProtocolRouting, IPProtocolDestOptions, IPProtocolAH:
+		d.ipv6Extension(p, data)
+	case IPProtocolFragment:
+		d.ipv6Fragment(data)
+	case IPProtocolIPv4:
+		d.ipv4(data)
+	case IPProtocolIPv6:
+		d.ipv6(data)
+	case IPProtocolGRE:
+		d.gre(data)
+	case IPProtocolMPLS:
+		d.mpls(data)
+	default:
+		d.payload(data)
+	}
+}
+
+// IPv4 is an IPv4 header with its options
+type IPv4 struct{ layer }
+
+func (IPv4) LayerType() LayerType { return LayerIPv4 }
+
+// the flags of the IPv4 header
+const (
+	IPv4DontFragment  uint8 = 0x2
+	IPv4MoreFragments uint8 = 0x1
+)
+
+// Returns the length of the header in bytes
+func (ip IPv4) HeaderLength() int   { return int(ip.contents[0]&0x0F) * 4 }
+func (ip IPv4) TOS() uint8          { return ip.contents[1] }
+func (ip IPv4) TotalLength() uint16 { return binary.BigEndian.Uint16(ip.contents[2:4]) }
+func (ip IPv4) ID() uint16          { return binary.BigEndian.Uint16(ip.contents[4:6]) }
+func (ip IPv4) Flags() uint8        { return ip.contents[6] >> 5 }
+
+// Returns the offset of a fragment in bytes
+func (ip IPv4) FragmentOffset() uint16 {
+	return (binary.BigEndian.Uint16(ip.contents[6:8]) & 0x1FFF) * 8
+}
+
+// Reports whether this is a fragment of a larger packet
+func (ip IPv4) IsFragment() bool {
+	return ip.Flags()&IPv4MoreFragments != 0 || ip.FragmentOffset() != 0
+}
+
+func (ip IPv4) TTL() uint8           { return ip.contents[8] }
+func (ip IPv4) Protocol() IPProtocol { return IPProtocol(ip.contents[9]) }
+func (ip IPv4) Checksum() uint16     { return binary.BigEndian.Uint16(ip.contents[10:12]) }
+func (ip IPv4) Src() netip.Addr      { return netip.AddrFrom4([4]byte(ip.contents[12:16])) }
+func (ip IPv4) Dst() netip.Addr      { return netip.AddrFrom4([4]byte(ip.contents[16:20])) }
+
+// Reports whether the checksum of the header is right
+func (ip IPv4) ChecksumValid() bool { return checksum(ip.contents, 0) == 0 }
+
+// Returns the options, the end of options is left out
+func (ip IPv4) Options() iter.Seq[IPv4Option] {
+	return func(yield func(IPv4Option) bool) {
+		for t, data := range options(ip.contents[20:], false) {
+			if !yield(IPv4Option{Type: t, Data: data}) {
+				return
+			}
+		}
+	}
+}
+
+// IPv4Option is an option of an IPv4 header
+type IPv4Option struct {
+	Type uint8
+	// the data after the type and length, empty for
+	// the options that are just one byte long
+	Data []byte
+}
+
+// IPv4 option types
+const (
+	IPv4OptionEnd         uint8 = 0
+	IPv4OptionNOP         uint8 = 1
+	IPv4OptionRecordRoute uint8 = 7
+	IPv4OptionTimestamp   uint8 = 68
+	IPv4OptionLooseRoute  uint8 = 131
+	IPv4OptionStrictRoute uint8 = 137
+	IPv4OptionRouterAlert uint8 = 148
+)
+
+func (d *decoder) ipv4(data []byte) {
+	if len(data) < 20 {
+		d.cut()
+		return
+	}
+	if data[0]>>4 != 4 || data[0]&0x0F < 5 {
+		d.fail(ErrMalformed)
+		return
+	}
+	l, ok := d.header(data, int(data[0]&0x0F)*4)
+	if !ok {
+		return
+	}
+	ip := IPv4{l}
+	// captured on the sending host with segmentation
+	// offloading the length is 0, then all data count
+	if total := int(ip.TotalLength()); total != 0 {
+		if total < ip.HeaderLength() {
+			d.fail(ErrMalformed)
+			return
+		}
+		d.limit(&ip.layer, total-ip.HeaderLength())
+	}
+	d.add(ip)
+	if ip.IsFragment() {
+		d.payload(ip.payload)
+		return
+	}
+	d.ipProtocol(ip.Protocol(), ip.payload)
+}
+
+// IPv6 is the fixed IPv6 header, the extension
+// headers are layers of their own
+type IPv6 struct{ layer }
+
+func (IPv6) LayerType() LayerType { return LayerIPv6 }
+
+func (ip IPv6) TrafficClass() uint8 {
+	return uint8(binary.BigEndian.Uint16(ip.contents[0:2]) >> 4)
+}
+
+func (ip IPv6) FlowLabel() uint32 {
+	return binary.BigEndian.Uint32(ip.contents[0:4]) & 0x000FFFFF
+}
+
+func (ip IPv6) PayloadLength() uint16  { return binary.BigEndian.Uint16(ip.contents[4:6]) }
+func (ip IPv6) NextHeader() IPProtocol { return IPProtocol(ip.contents[6]) }
+func (ip IPv6) HopLimit() uint8        { return ip.contents[7] }
+func (ip IPv6) Src() netip.Addr        { return netip.AddrFrom16([16]byte(ip.contents[8:24])) }
+func (ip IPv6) Dst() netip.Addr        { return netip.AddrFrom16([16]byte(ip.contents[24:40])) }
+
+func (d *decoder) ipv6(data []byte) {
+	if len(data) > 0 && data[0]>>4 != 6 {
+		d.fail(ErrMalformed)
+		return
+	}
+	l, ok := d.header(data, 40)
+	if !ok {
+		return
+	}
+	ip := IPv6{l}
+	// jumbograms have a length of 0 and the real one in a hop-by-hop
+	// option, which is not looked at, and neither is offloading
+	if ip.PayloadLength() != 0 {
+		d.limit(&ip.layer, int(ip.PayloadLength()))
+	}
+	d.add(ip)
+	d.ipProtocol(ip.NextHeader(), ip.payload)
+}
+
+// IPv6Extension is an IPv6 hop-by-hop options, routing or destination
+// options header, or an authentication header, which is used with IPv4 as well
+type IPv6Extension struct {
+	layer
+	// which header this is
+	Protocol IPProtocol
+}
+
+func (IPv6Extension) LayerType() LayerType { return LayerIPv6Extension }
+
+func (e IPv6Extension) NextHeader() IPProtocol { return IPProtocol(e.contents[0]) }
+
+// Returns the data after the next header and length fields
+func (e IPv6Extension) Data() []byte { return e.contents[2:] }
+
+// Returns the options of hop-by-hop and destination options headers
+func (e IPv6Extension) Options() iter.Seq2[uint8, []byte] {
+	if e.Protocol != IPProtocolHopByHop && e.Protocol != IPProtocolDestOptions {
+		return func(func(uint8, []byte) bool) {}
+	}
+	return options(e.Data(), true)
+}
+
+func (d *decoder) ipv6Extension(p IPProtocol, data []byte) {
+	if len(data) < 2 {
+		d.cut()
+		return
+	}
+	n := (int(data[1]) + 1) * 8
+	if p == IPProtocolAH {
+		n = (int(data[1]) + 2) * 4
+	}
+	l, ok := d.header(data, n)
+	if !ok {
+		return
+	}
+	e := IPv6Extension{layer: l, Protocol: p}
+	d.add(e)
+	d.ipProtocol(e.NextHeader(), e.payload)
+}
+
+// IPv6Fragment is the IPv6 fragment header
+type IPv6Fragment struct{ layer }
+
+func (IPv6Fragment) LayerType() LayerType { return LayerIPv6Fragment }
+
+func (f IPv6Fragment) NextHeader() IPProtocol { return IPProtocol(f.contents[0]) }
+
+// Returns the offset of the fragment in bytes
+func (f IPv6Fragment) FragmentOffset() uint16 {
+	return binary.BigEndian.Uint16(f.contents[2:4]) &^ 0x7
+}
+
+func (f IPv6Fragment) MoreFragments() bool { return f.contents[3]&0x1 != 0 }
+func (f IPv6Fragment) ID() uint32          { return binary.BigEndian.Uint32(f.contents[4:8]) }
+
+func (d *decoder) ipv6Fragment(data []byte) {
+	l, ok := d.header(data, 8)
+	if !ok {
+		return
+	}
+	f := IPv6Fragment{l}
+	d.add(f)
+	// a fragment that is the whole packet, which
+	// is allowed, can be decoded as it is
+	if f.FragmentOffset() != 0 || f.MoreFragments() {
+		d.payload(f.payload)
+		return
+	}
+	d.ipProtocol(f.NextHeader(), f.payload)
+}
+
+// Returns the options of IPv4 and TCP headers, or of IPv6 hop-by-hop
+// and destination options headers when ipv6 is set, as type and data.
+// An option with a length that makes no sense ends them.
+func options(data []byte, ipv6 bool) iter.Seq2[uint8, []byte] {
+	return func(yield func(uint8, []byte) bool) {
+		for len(data) > 0 {
+			t := data[0]
+			switch {
+			case !ipv6 && t == 0: // end of options
+				return
+			case !ipv6 && t == 1, ipv6 && t == 0: // nop and pad1 have no length
+				if !yield(t, nil) {
+					return
+				}
+				data = data[1:]
+				continue
+			}
+			if len(data) < 2 {
+				return
+			}
+			n := int(data[1])
+			if !ipv6 {
+				// the length of IPv4 and TCP options includes type and length
+				n -= 2
+			}
+			if n < 0 || 2+n > len(data) {
+				return
+			}
+			if !yield(t, data[2:2+n]) {
+				return
+			}
+			data = data[2+n:]
+		}
+	}
+}
+
+// the internet checksum of data, starting from sum,
+// which is 0 when data contain a valid checksum
+func checksum(data []byte, sum uint32) uint16 {
+	for len(data) >= 2 {
+		sum += uint32(binary.BigEndian.Uint16(data))
+		data = data[2:]
+	}
+	if len(data) == 1 {
+		sum += uint32(data[0]) << 8
+	}
+	for sum > 0xFFFF {
+		sum = sum&0xFFFF + sum>>16
+	}
+	return ^uint16(sum)
+}
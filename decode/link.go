@@ -0,0 +1,325 @@
+package decode
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+)
+
+// SLLPacketType tells where a packet captured with Linux
+// cooked capture was going to, i.e. with tcpdump -i any
+type SLLPacketType uint16
+
+const (
+	SLLHost SLLPacketType = iota
+	SLLBroadcast
+	SLLMulticast
+	// sent to someone else, seen in promiscuous mode
+	SLLOtherHost
+	// sent by this host
+	SLLOutgoing
+)
+
+var sllPacketTypeNames = [...]string{"host", "broadcast", "multicast", "otherhost", "outgoing"}
+
+func (t SLLPacketType) String() string {
+	if int(t) < len(sllPacketTypeNames) {
+		return sllPacketTypeNames[t]
+	}
+	return "SLLPacketType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Reports whether the packet has been sent by the capturing host
+func (t SLLPacketType) Outgoing() bool { return t == SLLOutgoing }
+
+// ARPHRD_ values of Linux, which tell what the interface of a
+// Linux cooked capture is and what its protocol field means
+const (
+	ARPHRDEthernet  uint16 = 1
+	ARPHRDLoopback  uint16 = 772
+	ARPHRDIPGRE     uint16 = 778
+	ARPHRDIEEE80211 uint16 = 801
+	ARPHRDNetlink   uint16 = 824
+)
+
+// the protocols of Linux cooked captures that are no EtherTypes
+const (
+	sllProtocolNovell8023 = 0x0001
+	sllProtocolLLC        = 0x0004
+)
+
+// LinuxSLL is the header of Linux cooked captures
+// (LINKTYPE_LINUX_SLL), as tcpdump -i any writes them
+type LinuxSLL struct{ layer }
+
+func (LinuxSLL) LayerType() LayerType { return LayerLinuxSLL }
+
+func (s LinuxSLL) PacketType() SLLPacketType {
+	return SLLPacketType(binary.BigEndian.Uint16(s.contents[0:2]))
+}
+
+func (s LinuxSLL) ARPHRDType() uint16 { return binary.BigEndian.Uint16(s.contents[2:4]) }
+
+// Returns the link layer address of the sender, i.e. a MAC address
+func (s LinuxSLL) Addr() net.HardwareAddr {
+	n := min(int(binary.BigEndian.Uint16(s.contents[4:6])), 8)
+	return net.HardwareAddr(s.contents[6 : 6+n])
+}
+
+// Returns the protocol, which is an EtherType for most interfaces
+func (s LinuxSLL) Protocol() EtherType { return EtherType(binary.BigEndian.Uint16(s.contents[14:16])) }
+
+func (d *decoder) linuxSLL(data []byte) {
+	l, ok := d.header(data, 16)
+	if !ok {
+		return
+	}
+	s := LinuxSLL{l}
+	d.add(s)
+	d.sllProtocol(s.ARPHRDType(), s.Protocol(), s.payload)
+}
+
+// LinuxSLL2 is the header of Linux cooked captures version 2
+// (LINKTYPE_LINUX_SLL2), which also tells the interface
+type LinuxSLL2 struct{ layer }
+
+func (LinuxSLL2) LayerType() LayerType { return LayerLinuxSLL2 }
+
+func (s LinuxSLL2) Protocol() EtherType { return EtherType(binary.BigEndian.Uint16(s.contents[0:2])) }
+
+// Returns the index of the interface the packet has been captured on
+func (s LinuxSLL2) InterfaceIndex() uint32 { return binary.BigEndian.Uint32(s.contents[4:8]) }
+func (s LinuxSLL2) ARPHRDType() uint16     { return binary.BigEndian.Uint16(s.contents[8:10]) }
+func (s LinuxSLL2) PacketType() SLLPacketType {
+	return SLLPacketType(s.contents[10])
+}
+
+// Returns the link layer address of the sender, i.e. a MAC address
+func (s LinuxSLL2) Addr() net.HardwareAddr {
+	n := min(int(s.contents[11]), 8)
+	return net.HardwareAddr(s.contents[12 : 12+n])
+}
+
+func (d *decoder) linuxSLL2(data []byte) {
+	l, ok := d.header(data, 20)
+	if !ok {
+		return
+	}
+	s := LinuxSLL2{l}
+	d.add(s)
+	d.sllProtocol(s.ARPHRDType(), s.Protocol(), s.payload)
+}
+
+// decodes what follows a Linux cooked capture header
+func (d *decoder) sllProtocol(arphrd uint16, p EtherType, data []byte) {
+	switch {
+	case arphrd == ARPHRDNetlink:
+		// the protocol is the one of netlink
+		d.payload(data)
+	case p == sllProtocolLLC:
+		d.llc(data)
+	case p == sllProtocolNovell8023:
+		d.payload(data)
+	default:
+		d.etherType(p, data)
+	}
+}
+
+// decodes an IP packet without anything in front of it, telling
+// the versions apart by the first bytes (LINKTYPE_RAW)
+func (d *decoder) raw(data []byte) {
+	if len(data) == 0 {
+		d.cut()
+		return
+	}
+	switch data[0] >> 4 {
+	case 4:
+		d.ipv4(data)
+	case 6:
+		d.ipv6(data)
+	default:
+		d.fail(ErrMalformed)
+	}
+}
+
+// Address families of loopback headers. IPv4 is the same
+// everywhere, but IPv6 differs between the systems.
+const (
+	AFInet         uint32 = 2
+	AFInet6BSD     uint32 = 24 // NetBSD, OpenBSD, BSD/OS
+	AFInet6FreeBSD uint32 = 28 // FreeBSD, DragonFly BSD
+	AFInet6Darwin  uint32 = 30 // macOS, iOS
+	AFInet6Linux   uint32 = 10
+)
+
+// Loopback is the 4 byte header of BSD loopback captures, which
+// holds the address family of the packet. With LINKTYPE_NULL it
+// is in the byte order of the capturing host, with LINKTYPE_LOOP
+// it is in network byte order. Family is in the right order.
+type Loopback struct {
+	layer
+	Family uint32
+}
+
+func (Loopback) LayerType() LayerType { return LayerLoopback }
+
+// decodes a BSD loopback header, whose family is big endian
+// for LINKTYPE_LOOP and in the order of the capturing host else
+func (d *decoder) loopback(data []byte, bigEndian bool) {
+	l, ok := d.header(data, 4)
+	if !ok {
+		return
+	}
+	lo := Loopback{layer: l}
+	if bigEndian {
+		lo.Family = binary.BigEndian.Uint32(l.contents)
+	} else {
+		// families are small, so when they are
+		// in the high bytes the order is wrong
+		lo.Family = binary.LittleEndian.Uint32(l.contents)
+		if lo.Family&0xFFFF == 0 {
+			lo.Family = binary.BigEndian.Uint32(l.contents)
+		}
+	}
+	d.add(lo)
+	switch lo.Family {
+	case AFInet:
+		d.ipv4(lo.payload)
+	case AFInet6BSD, AFInet6FreeBSD, AFInet6Darwin, AFInet6Linux:
+		d.ipv6(lo.payload)
+	default:
+		d.payload(lo.payload)
+	}
+}
+
+// PPPProtocol tells what follows a PPP header
+type PPPProtocol uint16
+
+const (
+	PPPProtocolIPv4 PPPProtocol = 0x0021
+	PPPProtocolIPv6 PPPProtocol = 0x0057
+	PPPProtocolMPLS PPPProtocol = 0x0281
+	PPPProtocolIPCP PPPProtocol = 0x8021
+	PPPProtocolLCP  PPPProtocol = 0xC021
+	PPPProtocolPAP  PPPProtocol = 0xC023
+	PPPProtocolCHAP PPPProtocol = 0xC223
+)
+
+// PPP is a PPP header, with the address and control fields
+// of HDLC-like framing if they are there, and for
+// LINKTYPE_PPP_WITH_DIR the direction in front of it
+type PPP struct {
+	layer
+	// the length of the direction and address and control fields
+	framing uint8
+	withDir bool
+}
+
+func (PPP) LayerType() LayerType { return LayerPPP }
+
+// Returns whether the packet has been sent by the capturing
+// host, which is only known for LINKTYPE_PPP_WITH_DIR
+func (p PPP) Direction() (outgoing bool, known bool) {
+	if !p.withDir {
+		return false, false
+	}
+	return p.contents[0] != 0, true
+}
+
+// Returns the protocol, which can be compressed to one byte
+func (p PPP) Protocol() PPPProtocol {
+	proto := p.contents[p.framing:]
+	if len(proto) == 1 {
+		return PPPProtocol(proto[0])
+	}
+	return PPPProtocol(binary.BigEndian.Uint16(proto))
+}
+
+func (d *decoder) ppp(data []byte, withDir bool) {
+	n := 0
+	if withDir {
+		n = 1
+	}
+	// address and control of HDLC-like framing
+	if len(data) >= n+2 && data[n] == 0xFF && data[n+1] == 0x03 {
+		n += 2
+	}
+	framing := n
+	if len(data) <= n {
+		d.cut()
+		return
+	}
+	// compressed protocols are odd
+	if data[n]&1 == 1 {
+		n++
+	} else {
+		n += 2
+	}
+	l, ok := d.header(data, n)
+	if !ok {
+		return
+	}
+	p := PPP{layer: l, framing: uint8(framing), withDir: withDir}
+	d.add(p)
+	d.pppProtocol(p.Protocol(), p.payload)
+}
+
+// decodes what follows a PPP header
+func (d *decoder) pppProtocol(p PPPProtocol, data []byte) {
+	switch p {
+	case PPPProtocolIPv4:
+		d.ipv4(data)
+	case PPPProtocolIPv6:
+		d.ipv6(data)
+	default:
+		d.payload(data)
+	}
+}
+
+// PPPoE is the header of PPPoE discovery and session packets,
+// session packets are followed by PPP without framing
+type PPPoE struct{ layer }
+
+func (PPPoE) LayerType() LayerType { return LayerPPPoE }
+
+func (p PPPoE) Version() uint8    { return p.contents[0] >> 4 }
+func (p PPPoE) Type() uint8       { return p.contents[0] & 0x0F }
+func (p PPPoE) Code() uint8       { return p.contents[1] }
+func (p PPPoE) SessionID() uint16 { return binary.BigEndian.Uint16(p.contents[2:4]) }
+func (p PPPoE) Length() uint16    { return binary.BigEndian.Uint16(p.contents[4:6]) }
+
+// decodes PPPoE, which for LINKTYPE_PPP_ETHER comes without Ethernet
+func (d *decoder) pppoe(data []byte) {
+	l, ok := d.header(data, 6)
+	if !ok {
+		return
+	}
+	p := PPPoE{l}
+	d.limit(&p.layer, int(p.Length()))
+	d.add(p)
+	// session data have a code of 0
+	if p.Code() != 0 {
+		d.payload(p.payload)
+		return
+	}
+	d.ppp(p.payload, false)
+}
+
+// CiscoHDLC is the header of Cisco HDLC, which holds an EtherType
+type CiscoHDLC struct{ layer }
+
+func (CiscoHDLC) LayerType() LayerType { return LayerCiscoHDLC }
+
+// Returns 0x0F for unicast and 0x8F for broadcast packets
+func (c CiscoHDLC) Address() uint8      { return c.contents[0] }
+func (c CiscoHDLC) Protocol() EtherType { return EtherType(binary.BigEndian.Uint16(c.contents[2:4])) }
+
+func (d *decoder) ciscoHDLC(data []byte) {
+	l, ok := d.header(data, 4)
+	if !ok {
+		return
+	}
+	c := CiscoHDLC{l}
+	d.add(c)
+	d.etherType(c.Protocol(), c.payload)
+}
@@ -0,0 +1,543 @@
+package decode
+
+import (
+	"encoding/binary"
+	"iter"
+
+	"github.com/Sojamann/pcapreader"
+)
+
+// Radiotap fields, which are the bits of the present bitmap
+const (
+	RadiotapTSFT = iota
+	RadiotapFlags
+	RadiotapRate
+	RadiotapChannel
+	RadiotapFHSS
+	RadiotapAntennaSignal
+	RadiotapAntennaNoise
+	RadiotapLockQuality
+	RadiotapTxAttenuation
+	RadiotapDBTxAttenuation
+	RadiotapDBmTxPower
+	RadiotapAntenna
+	RadiotapDBAntennaSignal
+	RadiotapDBAntennaNoise
+	RadiotapRxFlags
+	RadiotapTxFlags
+	RadiotapRTSRetries
+	RadiotapDataRetries
+	RadiotapXChannel
+	RadiotapMCS
+	RadiotapAMPDUStatus
+	RadiotapVHT
+	RadiotapTimestamp
+	RadiotapHE
+	RadiotapHEMU
+	RadiotapHEMUOtherUser
+	RadiotapZeroLengthPSDU
+	RadiotapLSIG
+	RadiotapTLV
+)
+
+// the alignment and size of the fields, from https://www.radiotap.org/fields/defined
+var radiotapFields = [...]struct{ align, size uint8 }{
+	RadiotapTSFT:            {8, 8},
+	RadiotapFlags:           {1, 1},
+	RadiotapRate:            {1, 1},
+	RadiotapChannel:         {2, 4},
+	RadiotapFHSS:            {1, 2},
+	RadiotapAntennaSignal:   {1, 1},
+	RadiotapAntennaNoise:    {1, 1},
+	RadiotapLockQuality:     {2, 2},
+	RadiotapTxAttenuation:   {2, 2},
+	RadiotapDBTxAttenuation: {2, 2},
+	RadiotapDBmTxPower:      {1, 1},
+	RadiotapAntenna:         {1, 1},
+	RadiotapDBAntennaSignal: {1, 1},
+	RadiotapDBAntennaNoise:  {1, 1},
+	RadiotapRxFlags:         {2, 2},
+	RadiotapTxFlags:         {2, 2},
+	RadiotapRTSRetries:      {1, 1},
+	RadiotapDataRetries:     {1, 1},
+	RadiotapXChannel:        {4, 8},
+	RadiotapMCS:             {1, 3},
+	RadiotapAMPDUStatus:     {4, 8},
+	RadiotapVHT:             {2, 12},
+	RadiotapTimestamp:       {8, 12},
+	RadiotapHE:              {2, 12},
+	RadiotapHEMU:            {2, 12},
+	RadiotapHEMUOtherUser:   {2, 6},
+	RadiotapZeroLengthPSDU:  {1, 1},
+	RadiotapLSIG:            {2, 4},
+}
+
+// the bits of the present bitmap that are no fields
+const (
+	radiotapNamespace = 29
+	radiotapVendor    = 30
+	radiotapExt       = 31
+)
+
+// the flags of the flags field
+const (
+	RadiotapFlagShortPreamble uint8 = 0x02
+	RadiotapFlagWEP           uint8 = 0x04
+	RadiotapFlagFragmented    uint8 = 0x08
+	// the frame ends with the FCS
+	RadiotapFlagFCS uint8 = 0x10
+	// there is padding between the 802.11 header and its payload
+	RadiotapFlagDataPad uint8 = 0x20
+	RadiotapFlagBadFCS  uint8 = 0x40
+	RadiotapFlagShortGI uint8 = 0x80
+)
+
+// Radiotap is the radiotap header that monitor mode captures
+// have in front of the 802.11 frame (LINKTYPE_IEEE802_11_RADIOTAP).
+// Fields are only looked at in the first radiotap namespace, fields
+// after one that is not known cannot be found, as their place is not
+// known either.
+type Radiotap struct {
+	layer
+	// where the fields start in contents, 0 for those that are not there
+	offsets [RadiotapTLV]uint16
+}
+
+func (Radiotap) LayerType() LayerType { return LayerRadiotap }
+
+// Returns the raw data of a field, i.e. RadiotapChannel
+func (r Radiotap) Field(field int) ([]byte, bool) {
+	if field < 0 || field >= len(r.offsets) || r.offsets[field] == 0 {
+		return nil, false
+	}
+	off := int(r.offsets[field])
+	return r.contents[off : off+int(radiotapFields[field].size)], true
+}
+
+// Returns the value of the TSF timer in microseconds
+func (r Radiotap) TSFT() (uint64, bool) {
+	data, ok := r.Field(RadiotapTSFT)
+	if !ok {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(data), true
+}
+
+func (r Radiotap) Flags() (uint8, bool) {
+	data, ok := r.Field(RadiotapFlags)
+	if !ok {
+		return 0, false
+	}
+	return data[0], true
+}
+
+// Returns the legacy data rate in units of 500 kbps
+func (r Radiotap) Rate() (uint8, bool) {
+	data, ok := r.Field(RadiotapRate)
+	if !ok {
+		return 0, false
+	}
+	return data[0], true
+}
+
+// Returns the frequency in MHz and the channel flags
+func (r Radiotap) Channel() (freq uint16, flags uint16, ok bool) {
+	data, ok := r.Field(RadiotapChannel)
+	if !ok {
+		return 0, 0, false
+	}
+	return binary.LittleEndian.Uint16(data[0:2]), binary.LittleEndian.Uint16(data[2:4]), true
+}
+
+// Returns the signal at the antenna in dBm
+func (r Radiotap) AntennaSignal() (int8, bool) {
+	data, ok := r.Field(RadiotapAntennaSignal)
+	if !ok {
+		return 0, false
+	}
+	return int8(data[0]), true
+}
+
+// Returns the noise at the antenna in dBm
+func (r Radiotap) AntennaNoise() (int8, bool) {
+	data, ok := r.Field(RadiotapAntennaNoise)
+	if !ok {
+		return 0, false
+	}
+	return int8(data[0]), true
+}
+
+// Returns the index of the antenna the frame was received on
+func (r Radiotap) Antenna() (uint8, bool) {
+	data, ok := r.Field(RadiotapAntenna)
+	if !ok {
+		return 0, false
+	}
+	return data[0], true
+}
+
+// RadiotapMCSInfo is the 802.11n rate of a frame
+type RadiotapMCSInfo struct {
+	// which of the flags and the index are known
+	Known uint8
+	Flags uint8
+	Index uint8
+}
+
+// Returns the width of the channel in MHz, 0 if it is not known
+func (m RadiotapMCSInfo) Bandwidth() int {
+	if m.Known&0x01 == 0 {
+		return 0
+	}
+	if m.Flags&0x03 == 1 {
+		return 40
+	}
+	return 20
+}
+
+// Reports whether the short guard interval is used
+func (m RadiotapMCSInfo) ShortGI() bool { return m.Known&0x04 != 0 && m.Flags&0x04 != 0 }
+
+func (r Radiotap) MCS() (RadiotapMCSInfo, bool) {
+	data, ok := r.Field(RadiotapMCS)
+	if !ok {
+		return RadiotapMCSInfo{}, false
+	}
+	return RadiotapMCSInfo{Known: data[0], Flags: data[1], Index: data[2]}, true
+}
+
+// RadiotapVHTInfo is the 802.11ac rate of a frame
+type RadiotapVHTInfo struct {
+	// which of the other fields are known
+	Known     uint16
+	Flags     uint8
+	Bandwidth uint8
+	// MCS and number of spatial streams of up to four users
+	MCSNSS     [4]uint8
+	Coding     uint8
+	GroupID    uint8
+	PartialAID uint16
+}
+
+// Returns the width of the channel in MHz, 0 if it is not known
+func (v RadiotapVHTInfo) BandwidthMHz() int {
+	switch {
+	case v.Known&0x0040 == 0:
+		return 0
+	case v.Bandwidth == 0:
+		return 20
+	case v.Bandwidth <= 3:
+		return 40
+	case v.Bandwidth <= 10:
+		return 80
+	case v.Bandwidth <= 25:
+		return 160
+	}
+	return 0
+}
+
+// Returns the MCS of user 0 to 3
+func (v RadiotapVHTInfo) MCS(user int) uint8 { return v.MCSNSS[user] >> 4 }
+
+// Returns the number of spatial streams of user 0 to 3, 0 if the user is not there
+func (v RadiotapVHTInfo) NSS(user int) uint8 { return v.MCSNSS[user] & 0x0F }
+
+func (r Radiotap) VHT() (RadiotapVHTInfo, bool) {
+	data, ok := r.Field(RadiotapVHT)
+	if !ok {
+		return RadiotapVHTInfo{}, false
+	}
+	return RadiotapVHTInfo{
+		Known:      binary.LittleEndian.Uint16(data[0:2]),
+		Flags:      data[2],
+		Bandwidth:  data[3],
+		MCSNSS:     [4]uint8(data[4:8]),
+		Coding:     data[8],
+		GroupID:    data[9],
+		PartialAID: binary.LittleEndian.Uint16(data[10:12]),
+	}, true
+}
+
+// RadiotapHEInfo is the 802.11ax information of a frame, as six data
+// fields whose first tells which parts of the others are known
+type RadiotapHEInfo struct {
+	Data [6]uint16
+}
+
+// the PPDU formats of HE
+const (
+	HEFormatSU = iota
+	HEFormatExtSU
+	HEFormatMU
+	HEFormatTrigger
+)
+
+// Returns the PPDU format, one of HEFormatSU and the others
+func (h RadiotapHEInfo) Format() uint8 { return uint8(h.Data[0] & 0x3) }
+
+func (h RadiotapHEInfo) MCS() (uint8, bool) {
+	return uint8(h.Data[2] >> 8 & 0xF), h.Data[0]&0x0020 != 0
+}
+
+func (h RadiotapHEInfo) BSSColor() (uint8, bool) {
+	return uint8(h.Data[2] & 0x3F), h.Data[0]&0x0004 != 0
+}
+
+// Returns the width of the channel in MHz, for the values that are one
+func (h RadiotapHEInfo) Bandwidth() (int, bool) {
+	if h.Data[0]&0x4000 == 0 {
+		return 0, false
+	}
+	switch h.Data[4] & 0xF {
+	case 0:
+		return 20, true
+	case 1:
+		return 40, true
+	case 2:
+		return 80, true
+	case 3:
+		return 160, true
+	}
+	return 0, false
+}
+
+func (r Radiotap) HE() (RadiotapHEInfo, bool) {
+	data, ok := r.Field(RadiotapHE)
+	if !ok {
+		return RadiotapHEInfo{}, false
+	}
+	var h RadiotapHEInfo
+	for i := range h.Data {
+		h.Data[i] = binary.LittleEndian.Uint16(data[2*i:])
+	}
+	return h, true
+}
+
+// finds the fields, returns false if they do not fit into the header
+func (r *Radiotap) parse() bool {
+	n := len(r.contents)
+	le := binary.LittleEndian
+
+	// the present bitmaps
+	start := 4
+	end := start
+	for {
+		if end+4 > n {
+			return false
+		}
+		end += 4
+		if le.Uint32(r.contents[end-4:end])&(1<<radiotapExt) == 0 {
+			break
+		}
+	}
+
+	pos := end
+	// the index of the bitmap within its namespace
+	index := 0
+	vendor := false
+	first := true
+	for w := start; w < end; w += 4 {
+		present := le.Uint32(r.contents[w : w+4])
+		if !vendor {
+			for bit := 0; bit < radiotapNamespace; bit++ {
+				if present&(1<<bit) == 0 {
+					continue
+				}
+				field := index*32 + bit
+				if field >= len(radiotapFields) {
+					// neither this one nor those after it can be found
+					return true
+				}
+				f := radiotapFields[field]
+				pos = (pos + int(f.align) - 1) &^ (int(f.align) - 1)
+				if pos+int(f.size) > n {
+					return false
+				}
+				if first {
+					r.offsets[field] = uint16(pos)
+				}
+				pos += int(f.size)
+			}
+		}
+		index++
+
+		switch {
+		case present&(1<<radiotapNamespace) != 0:
+			vendor = false
+			first = false
+			index = 0
+		case present&(1<<radiotapVendor) != 0:
+			// OUI, sub namespace and the length of the data
+			pos = (pos + 1) &^ 1
+			if pos+6 > n {
+				return false
+			}
+			pos += 6 + int(le.Uint16(r.contents[pos+4:pos+6]))
+			if pos > n {
+				return false
+			}
+			vendor = true
+			first = false
+			index = 0
+		}
+	}
+	return true
+}
+
+func (d *decoder) radiotap(data []byte) {
+	if len(data) < 8 {
+		d.cut()
+		return
+	}
+	n := int(binary.LittleEndian.Uint16(data[2:4]))
+	if data[0] != 0 || n < 8 {
+		d.fail(ErrMalformed)
+		return
+	}
+	l, ok := d.header(data, n)
+	if !ok {
+		return
+	}
+	r := Radiotap{layer: l}
+	if !r.parse() {
+		d.fail(ErrMalformed)
+		return
+	}
+	d.add(r)
+	flags, _ := r.Flags()
+	d.dot11(r.payload, flags&RadiotapFlagFCS != 0, flags&RadiotapFlagDataPad != 0)
+}
+
+// Returns the number of the 802.11 channel of a frequency
+// in MHz of the 2.4, 5 and 6 GHz bands, 0 for others
+func ChannelNumber(freq uint16) int {
+	switch {
+	case freq == 2484:
+		return 14
+	case freq >= 2412 && freq < 2484:
+		return (int(freq) - 2407) / 5
+	case freq >= 5955 && freq <= 7115:
+		return (int(freq) - 5950) / 5
+	case freq >= 5000 && freq < 5955:
+		return (int(freq) - 5000) / 5
+	}
+	return 0
+}
+
+// PPI field types
+const (
+	PPIDot11Common  uint16 = 2
+	PPIDot11NMACExt uint16 = 3
+	PPIDot11NMACPHY uint16 = 4
+	PPISpectrumMap  uint16 = 5
+	PPIProcessInfo  uint16 = 6
+	PPICaptureInfo  uint16 = 7
+	PPIAggregation  uint16 = 8
+	PPIDot3         uint16 = 9
+	PPIGPS          uint16 = 30002
+	PPIVector       uint16 = 30003
+)
+
+// PPI is the Per-Packet Information header (LINKTYPE_PPI),
+// which is followed by a packet of its own link layer type
+type PPI struct{ layer }
+
+func (PPI) LayerType() LayerType { return LayerPPI }
+
+func (p PPI) Flags() uint8 { return p.contents[1] }
+
+// Returns the link layer type of what follows
+func (p PPI) LinkLayerType() pcapreader.LinkLayerType {
+	return pcapreader.LinkLayerType(binary.LittleEndian.Uint32(p.contents[4:8]))
+}
+
+// Returns the fields as type and data
+func (p PPI) Fields() iter.Seq2[uint16, []byte] {
+	return func(yield func(uint16, []byte) bool) {
+		data := p.contents[8:]
+		for len(data) >= 4 {
+			t := binary.LittleEndian.Uint16(data[0:2])
+			n := int(binary.LittleEndian.Uint16(data[2:4]))
+			if 4+n > len(data) {
+				return
+			}
+			if !yield(t, data[4:4+n]) {
+				return
+			}
+			data = data[4+n:]
+			// fields are aligned to 32 bits when the flag is set
+			if p.Flags()&0x01 != 0 {
+				data = data[min((4-n%4)%4, len(data)):]
+			}
+		}
+	}
+}
+
+// the flags of PPICommon
+const (
+	PPIFlagFCS      uint16 = 0x0001
+	PPIFlagTSFTms   uint16 = 0x0002
+	PPIFlagBadFCS   uint16 = 0x0004
+	PPIFlagPHYError uint16 = 0x0008
+)
+
+// PPICommon is the 802.11-Common field of PPI
+type PPICommon struct {
+	TSFT  uint64
+	Flags uint16
+	// in units of 500 kbps
+	Rate         uint16
+	Frequency    uint16
+	ChannelFlags uint16
+	FHSSHopset   uint8
+	FHSSPattern  uint8
+	// in dBm
+	Signal int8
+	Noise  int8
+}
+
+// Returns the 802.11-Common field
+func (p PPI) Common() (PPICommon, bool) {
+	for t, data := range p.Fields() {
+		if t != PPIDot11Common || len(data) < 20 {
+			continue
+		}
+		le := binary.LittleEndian
+		return PPICommon{
+			TSFT:         le.Uint64(data[0:8]),
+			Flags:        le.Uint16(data[8:10]),
+			Rate:         le.Uint16(data[10:12]),
+			Frequency:    le.Uint16(data[12:14]),
+			ChannelFlags: le.Uint16(data[14:16]),
+			FHSSHopset:   data[16],
+			FHSSPattern:  data[17],
+			Signal:       int8(data[18]),
+			Noise:        int8(data[19]),
+		}, true
+	}
+	return PPICommon{}, false
+}
+
+func (d *decoder) ppi(data []byte) {
+	if len(data) < 8 {
+		d.cut()
+		return
+	}
+	n := int(binary.LittleEndian.Uint16(data[2:4]))
+	if data[0] != 0 || n < 8 {
+		d.fail(ErrMalformed)
+		return
+	}
+	l, ok := d.header(data, n)
+	if !ok {
+		return
+	}
+	p := PPI{l}
+	d.add(p)
+	if llt := p.LinkLayerType(); llt == pcapreader.LinkTypeIEEE802_11 {
+		common, _ := p.Common()
+		d.dot11(p.payload, common.Flags&PPIFlagFCS != 0, false)
+	} else {
+		d.link(llt, p.payload)
+	}
+}
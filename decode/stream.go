@@ -0,0 +1,493 @@
+package decode
+
+import (
+	"container/list"
+	"context"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/Sojamann/pcapreader"
+)
+
+// Direction tells which side of a TCP connection has sent data
+type Direction uint8
+
+const (
+	ClientToServer Direction = iota
+	ServerToClient
+)
+
+func (d Direction) String() string {
+	if d == ServerToClient {
+		return "server to client"
+	}
+	return "client to server"
+}
+
+// Returns the other direction
+func (d Direction) Reverse() Direction { return d ^ 1 }
+
+// CloseReason tells why an Assembler is done with a stream
+type CloseReason uint8
+
+const (
+	// both sides have sent a FIN and all data before it has arrived
+	CloseFIN CloseReason = iota
+	// one side has sent a RST
+	CloseRST
+	// nothing has been sent for AssemblerOptions.Timeout
+	CloseTimeout
+	// dropped to stay within the memory bounds
+	CloseEvicted
+	// still open when Flush was called
+	CloseFlush
+)
+
+var closeReasonNames = [...]string{
+	CloseFIN:     "FIN",
+	CloseRST:     "RST",
+	CloseTimeout: "timeout",
+	CloseEvicted: "evicted",
+	CloseFlush:   "flushed",
+}
+
+func (r CloseReason) String() string {
+	if int(r) < len(closeReasonNames) {
+		return closeReasonNames[r]
+	}
+	return "unknown"
+}
+
+// the defaults of AssemblerOptions
+const (
+	defaultStreamTimeout  = 5 * time.Minute
+	defaultMaxStreamMem   = 64 << 20
+	defaultMaxStreamBytes = 1 << 20
+	defaultMaxStreams     = 65536
+)
+
+// AssemblerOptions change how an Assembler reassembles streams
+// and are where the data of the streams are handed over.
+type AssemblerOptions struct {
+	// How long a stream may go without a packet before it is closed,
+	// measured in capture time. Defaults to 5 minutes.
+	Timeout time.Duration
+
+	// Limits for the segments that are held because data in front of
+	// them is missing. Going beyond MaxStreamBytes gives up on the
+	// missing data of that direction, which is reported as a gap.
+	// Going beyond MaxBytes or MaxStreams closes the streams that
+	// have been idle longest.
+
+	// The most bytes held for all streams. Defaults to 64MiB.
+	MaxBytes int
+	// The most bytes held for one direction of a stream. Defaults to 1MiB.
+	MaxStreamBytes int
+	// The most streams. Defaults to 65536.
+	MaxStreams int
+
+	// Called for a new stream before any of its data
+	OnOpen func(s *Stream)
+	// Called with the data of a stream in order. The data are only
+	// valid during the call. s.Last is the capture time of the
+	// packet that made them available.
+	OnData func(s *Stream, dir Direction, data []byte)
+	// Called instead of OnData for bytes that are missing in the
+	// capture, so the next data do not follow the ones before.
+	OnGap func(s *Stream, dir Direction, missing int)
+	// Called once a stream is done, after all of its data
+	OnClose func(s *Stream, reason CloseReason)
+}
+
+func (o AssemblerOptions) withDefaults() AssemblerOptions {
+	if o.Timeout == 0 {
+		o.Timeout = defaultStreamTimeout
+	}
+	if o.MaxBytes == 0 {
+		o.MaxBytes = defaultMaxStreamMem
+	}
+	if o.MaxStreamBytes == 0 {
+		o.MaxStreamBytes = defaultMaxStreamBytes
+	}
+	if o.MaxStreams == 0 {
+		o.MaxStreams = defaultMaxStreams
+	}
+	return o
+}
+
+// StreamStats count what has been seen of one direction of a stream
+type StreamStats struct {
+	Packets uint64
+	// handed to OnData
+	Bytes uint64
+	// reported to OnGap
+	Missing uint64
+	// segments with data that had been seen before, in full or in part
+	Retransmissions uint64
+	// segments that arrived before data in front of them
+	OutOfOrder uint64
+}
+
+// Stream is a TCP connection that is being reassembled
+type Stream struct {
+	// The client is the side that sent the SYN. Without a handshake
+	// it is the one that sent the first packet.
+	Client, Server netip.AddrPort
+	// when the first and the latest packet have been captured
+	First, Last time.Time
+	// whether the handshake has not been captured, so
+	// data before the first segment may be missing
+	MidStream bool
+	// left to the callbacks, i.e. for the state of a parser
+	User any
+
+	key   streamKey
+	entry *list.Element
+	half  [2]halfStream
+}
+
+// Returns what has been seen of dir so far
+func (s *Stream) Stats(dir Direction) StreamStats { return s.half[dir].stats }
+
+// both directions of a connection are told apart by, with a before b
+type streamKey struct{ a, b netip.AddrPort }
+
+func newStreamKey(src, dst netip.AddrPort) streamKey {
+	if src.Compare(dst) > 0 {
+		src, dst = dst, src
+	}
+	return streamKey{src, dst}
+}
+
+// part of a direction of a stream
+type segment struct {
+	seq  uint32
+	data []byte
+	// the length on the wire, more than len(data) when the
+	// segment has been cut off while capturing
+	size int
+}
+
+// one direction of a stream
+type halfStream struct {
+	// whether next is known
+	synced bool
+	// the sequence number of the next byte to hand over
+	next uint32
+	// segments after next, sorted by their distance to it
+	pending  []segment
+	buffered int
+	// a FIN has been seen which ends at finSeq
+	fin    bool
+	finSeq uint32
+	// all data up to the FIN has been handed over
+	closed bool
+	stats  StreamStats
+}
+
+// Returns the distance of a sequence number after next,
+// which is negative for those before it. Sequence numbers
+// wrap around, so they compare as in RFC 1982.
+func (h *halfStream) after(seq uint32) int32 { return int32(seq - h.next) }
+
+// Assembler puts the segments of TCP connections back in order and
+// hands over the data of both directions through the callbacks of
+// its options. Retransmitted and overlapping data are handed over
+// once, as they arrived first. Fragmented IP datagrams have to be
+// reassembled before, i.e. with a Defragmenter.
+type Assembler struct {
+	opts    AssemblerOptions
+	streams map[streamKey]*Stream
+	// streams by the time of their latest packet, oldest first
+	order *list.List
+	bytes int
+}
+
+func NewAssembler(opts AssemblerOptions) *Assembler {
+	return &Assembler{
+		opts:    opts.withDefaults(),
+		streams: make(map[streamKey]*Stream),
+		order:   list.New(),
+	}
+}
+
+// finds the innermost TCP header of p and the IP header in front of it,
+// along with the length of the TCP payload on the wire
+func tcpOf(p *Packet) (tcp TCP, src, dst netip.AddrPort, size int, ok bool) {
+	i := len(p.Layers) - 1
+	for ; i >= 0; i-- {
+		if tcp, ok = p.Layers[i].(TCP); ok {
+			break
+		}
+	}
+	if !ok {
+		return tcp, src, dst, 0, false
+	}
+	size = len(tcp.Payload())
+	for i--; i >= 0; i-- {
+		var length int
+		switch ip := p.Layers[i].(type) {
+		case IPv4:
+			src, dst = netip.AddrPortFrom(ip.Src(), tcp.SrcPort()), netip.AddrPortFrom(ip.Dst(), tcp.DstPort())
+			length = int(ip.TotalLength()) - ip.HeaderLength()
+		case IPv6:
+			src, dst = netip.AddrPortFrom(ip.Src(), tcp.SrcPort()), netip.AddrPortFrom(ip.Dst(), tcp.DstPort())
+			length = int(ip.PayloadLength())
+		case IPv6Extension, IPv6Fragment:
+			continue
+		default:
+			return tcp, src, dst, 0, false
+		}
+		if p.Truncated {
+			// what the IP header says is left after the TCP header
+			// and the IPv6 extension headers before it
+			n := length - (cap(p.Layers[i].Payload()) - cap(tcp.Payload()))
+			if n > size {
+				size = n
+			}
+		}
+		return tcp, src, dst, size, true
+	}
+	return tcp, src, dst, 0, false
+}
+
+// Adds a packet, p is what it has been decoded into. Packets that do
+// not carry TCP are ignored. The callbacks are called from within Add.
+func (a *Assembler) Add(info *pcapreader.PacketInfo, p *Packet) {
+	now := info.CaptureTime
+	if !now.IsZero() {
+		a.expire(now)
+	}
+	tcp, src, dst, size, ok := tcpOf(p)
+	if !ok {
+		return
+	}
+	flags := tcp.Flags()
+
+	key := newStreamKey(src, dst)
+	s := a.streams[key]
+	if s == nil {
+		// a stream starts with a SYN or with data, so the last
+		// ACKs of a stream that has been closed are no new one
+		if flags&TCPRst != 0 || (flags&TCPSyn == 0 && size == 0) {
+			return
+		}
+		for len(a.streams) >= a.opts.MaxStreams {
+			a.close(a.order.Front().Value.(*Stream), CloseEvicted)
+		}
+		s = &Stream{Client: src, Server: dst, First: now, MidStream: flags&TCPSyn == 0, key: key}
+		if flags&(TCPSyn|TCPAck) == TCPSyn|TCPAck {
+			s.Client, s.Server = dst, src
+		}
+		s.entry = a.order.PushBack(s)
+		a.streams[key] = s
+		if a.opts.OnOpen != nil {
+			a.opts.OnOpen(s)
+		}
+	} else {
+		a.order.MoveToBack(s.entry)
+	}
+	s.Last = now
+
+	dir := ClientToServer
+	if src != s.Client {
+		dir = ServerToClient
+	}
+	h := &s.half[dir]
+	h.stats.Packets++
+	if flags&TCPRst != 0 {
+		a.close(s, CloseRST)
+		return
+	}
+
+	seq := tcp.Seq()
+	if flags&TCPSyn != 0 {
+		// a retransmitted SYN does not start over
+		if !h.synced {
+			h.synced = true
+			h.next = seq + 1
+		}
+		seq++
+	}
+	if !h.synced {
+		h.synced = true
+		h.next = seq
+	}
+	if flags&TCPFin != 0 && !h.fin {
+		h.fin = true
+		h.finSeq = seq + uint32(size)
+	}
+	a.segment(s, dir, segment{seq: seq, data: tcp.Payload(), size: size})
+
+	if s.half[ClientToServer].closed && s.half[ServerToClient].closed {
+		a.close(s, CloseFIN)
+		return
+	}
+	for h.buffered > a.opts.MaxStreamBytes {
+		a.skip(s, dir)
+	}
+	// the streams that have been idle longest go first,
+	// which can be this one if it is too large by itself
+	for a.bytes > a.opts.MaxBytes {
+		oldest := a.order.Front().Value.(*Stream)
+		a.close(oldest, CloseEvicted)
+		if oldest == s {
+			break
+		}
+	}
+}
+
+// hands over seg if it is next and holds it back otherwise
+func (a *Assembler) segment(s *Stream, dir Direction, seg segment) {
+	h := &s.half[dir]
+	if seg.size == 0 {
+		a.finish(h)
+		return
+	}
+	if h.closed {
+		h.stats.Retransmissions++
+		return
+	}
+	if h.after(seg.seq) <= 0 {
+		a.deliver(s, dir, seg)
+		a.drain(s, dir)
+		return
+	}
+
+	h.stats.OutOfOrder++
+	seg.data = append([]byte(nil), seg.data...)
+	// after those with the same distance, so the first to arrive wins
+	i, _ := slices.BinarySearchFunc(h.pending, int(h.after(seg.seq))+1, func(p segment, d int) int {
+		return int(h.after(p.seq)) - d
+	})
+	h.pending = slices.Insert(h.pending, i, seg)
+	h.buffered += len(seg.data)
+	a.bytes += len(seg.data)
+}
+
+// hands over what seg has beyond next, seg must not start after it
+func (a *Assembler) deliver(s *Stream, dir Direction, seg segment) {
+	h := &s.half[dir]
+	skip := int(-h.after(seg.seq))
+	if skip >= seg.size {
+		if seg.size > 0 {
+			h.stats.Retransmissions++
+		}
+		return
+	}
+	if skip > 0 {
+		h.stats.Retransmissions++
+	}
+	h.next = seg.seq + uint32(seg.size)
+	if skip < len(seg.data) {
+		data := seg.data[skip:]
+		h.stats.Bytes += uint64(len(data))
+		if a.opts.OnData != nil {
+			a.opts.OnData(s, dir, data)
+		}
+		skip = len(seg.data)
+	}
+	// cut off while capturing
+	if missing := seg.size - skip; missing > 0 {
+		a.gap(s, dir, missing)
+	}
+}
+
+func (a *Assembler) gap(s *Stream, dir Direction, missing int) {
+	s.half[dir].stats.Missing += uint64(missing)
+	if a.opts.OnGap != nil {
+		a.opts.OnGap(s, dir, missing)
+	}
+}
+
+// hands over the held segments that have become next
+func (a *Assembler) drain(s *Stream, dir Direction) {
+	h := &s.half[dir]
+	for len(h.pending) > 0 && h.after(h.pending[0].seq) <= 0 {
+		seg := h.pending[0]
+		h.pending = h.pending[1:]
+		h.buffered -= len(seg.data)
+		a.bytes -= len(seg.data)
+		a.deliver(s, dir, seg)
+	}
+	if len(h.pending) == 0 {
+		h.pending = nil
+	}
+	a.finish(h)
+}
+
+// gives up on the data in front of the first held segment
+func (a *Assembler) skip(s *Stream, dir Direction) {
+	h := &s.half[dir]
+	if len(h.pending) == 0 {
+		return
+	}
+	seq := h.pending[0].seq
+	a.gap(s, dir, int(h.after(seq)))
+	h.next = seq
+	a.drain(s, dir)
+}
+
+// marks h closed once all data up to its FIN has been handed over
+func (a *Assembler) finish(h *halfStream) {
+	if h.fin && !h.closed && h.next == h.finSeq {
+		h.closed = true
+		// the FIN takes up a sequence number as well
+		h.next++
+	}
+}
+
+// hands over what is held for s, with gaps where data is
+// missing, and forgets about it
+func (a *Assembler) close(s *Stream, reason CloseReason) {
+	for dir := range s.half {
+		for len(s.half[dir].pending) > 0 {
+			a.skip(s, Direction(dir))
+		}
+	}
+	a.order.Remove(s.entry)
+	delete(a.streams, s.key)
+	if a.opts.OnClose != nil {
+		a.opts.OnClose(s, reason)
+	}
+}
+
+// closes the streams that have been idle for too long
+func (a *Assembler) expire(now time.Time) {
+	for a.order.Len() > 0 {
+		s := a.order.Front().Value.(*Stream)
+		if now.Sub(s.Last) <= a.opts.Timeout {
+			return
+		}
+		a.close(s, CloseTimeout)
+	}
+}
+
+// Closes all streams with CloseFlush, i.e. once
+// the end of the capture has been reached
+func (a *Assembler) Flush() {
+	for a.order.Len() > 0 {
+		a.close(a.order.Front().Value.(*Stream), CloseFlush)
+	}
+}
+
+// Returns the number of open streams
+func (a *Assembler) Streams() int { return len(a.streams) }
+
+// Adds all packets of t until it ends or ctx is done. At the end of t
+// the streams that are still open are flushed, which is not done when
+// an error is returned. Reaching the end of t is not an error.
+func (a *Assembler) Assemble(ctx context.Context, t pcapreader.Traffic) error {
+	var p Packet
+	packets := pcapreader.Packets(ctx, t)
+	for info, packet := range packets.All() {
+		DecodeInto(&p, pcapreader.InterfaceOf(t).LinkLayerType, packet, info.Size)
+		a.Add(info, &p)
+	}
+	if err := packets.Err(); err != nil {
+		return err
+	}
+	a.Flush()
+	return nil
+}
@@ -0,0 +1,155 @@
+package decode
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Sojamann/pcapreader"
+)
+
+// a TCP segment in an Ethernet frame, from the server when server is set
+func tcpSegment(server bool, seq uint32, flags TCPFlags, data string) testPacket {
+	p := testPacket(data).tcp(flags)
+	binary.BigEndian.PutUint32(p[4:8], seq)
+	if server {
+		copy(p[0:4], []byte{p[2], p[3], p[0], p[1]})
+	}
+	p = p.ipv4(IPProtocolTCP)
+	if server {
+		// the checksum stays the same
+		copy(p[12:20], append(p[16:20:20], p[12:16]...))
+	}
+	return p.ethernet(EtherTypeIPv4)
+}
+
+// records what the callbacks of the options are called with
+type streamEvents struct{ events []string }
+
+func (e *streamEvents) options(opts AssemblerOptions) AssemblerOptions {
+	opts.OnData = func(s *Stream, dir Direction, data []byte) {
+		e.events = append(e.events, fmt.Sprintf("%d %s", dir, data))
+	}
+	opts.OnGap = func(s *Stream, dir Direction, missing int) {
+		e.events = append(e.events, fmt.Sprintf("%d gap %d", dir, missing))
+	}
+	opts.OnClose = func(s *Stream, reason CloseReason) {
+		e.events = append(e.events, reason.String())
+	}
+	return opts
+}
+
+func (e *streamEvents) String() string { return strings.Join(e.events, ", ") }
+
+// adds packets, which are a second apart
+func assemble(a *Assembler, packets ...testPacket) {
+	for i, packet := range packets {
+		info := &pcapreader.PacketInfo{CaptureTime: genTime(i), Size: uint32(len(packet))}
+		a.Add(info, Decode(pcapreader.LinkTypeEthernet, packet, info.Size))
+	}
+}
+
+func TestAssembler(t *testing.T) {
+	packets := []testPacket{
+		tcpSegment(false, 1000, TCPSyn, ""),
+		tcpSegment(true, 5000, TCPSyn|TCPAck, ""),
+		tcpSegment(false, 1007, TCPAck, "world"),
+		tcpSegment(false, 1001, TCPAck, "hello "),
+		tcpSegment(false, 1001, TCPAck, "hello "),
+		tcpSegment(false, 1004, TCPAck, "lo wor"),
+		tcpSegment(true, 5001, TCPAck, "hi"),
+		tcpSegment(false, 1012, TCPFin|TCPAck, ""),
+		tcpSegment(true, 5003, TCPFin|TCPAck, ""),
+		// the last ACK comes after the stream has been closed
+		tcpSegment(false, 1013, TCPAck, ""),
+	}
+	var b bytes.Buffer
+	w := pcapreader.NewPcapWriter(&b)
+	for i, packet := range packets {
+		info := &pcapreader.PacketInfo{CaptureTime: genTime(i), Size: uint32(len(packet))}
+		if err := w.WritePacket(pcapreader.Interface{LinkLayerType: pcapreader.LinkTypeEthernet}, info, pcapreader.Packet(packet)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Flush()
+	traffic, err := pcapreader.OpenReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var e streamEvents
+	var stream *Stream
+	opts := e.options(AssemblerOptions{OnOpen: func(s *Stream) { stream = s }})
+	a := NewAssembler(opts)
+	if err := a.Assemble(context.Background(), traffic); err != nil {
+		t.Fatal(err)
+	}
+	if want := "0 hello , 0 world, 1 hi, FIN"; e.String() != want {
+		t.Errorf("got %s instead of %s", &e, want)
+	}
+	if stream.Client.Port() != 4711 || stream.Server.Port() != 80 || stream.MidStream {
+		t.Errorf("stream from %s to %s", stream.Client, stream.Server)
+	}
+	if stats := stream.Stats(ClientToServer); stats.Retransmissions != 2 || stats.OutOfOrder != 1 || stats.Bytes != 11 {
+		t.Errorf("client stats %+v", stats)
+	}
+	if !stream.First.Equal(genTime(0)) || !stream.Last.Equal(genTime(8)) || a.Streams() != 0 {
+		t.Errorf("stream from %v to %v, %d left", stream.First, stream.Last, a.Streams())
+	}
+}
+
+func TestAssemblerWraparound(t *testing.T) {
+	var e streamEvents
+	var stream *Stream
+	a := NewAssembler(e.options(AssemblerOptions{OnOpen: func(s *Stream) { stream = s }}))
+	assemble(a,
+		tcpSegment(true, 0xFFFFFFFE, TCPAck, "ab"),
+		tcpSegment(true, 2, TCPAck, "ef"),
+		tcpSegment(true, 0, TCPAck, "cd"),
+		tcpSegment(false, 7, TCPRst, ""),
+	)
+	if want := "0 ab, 0 cd, 0 ef, RST"; e.String() != want {
+		t.Errorf("got %s instead of %s", &e, want)
+	}
+	// without a handshake the server is the client
+	if !stream.MidStream || stream.Client.Port() != 80 {
+		t.Errorf("mid stream %v from %s", stream.MidStream, stream.Client)
+	}
+}
+
+func TestAssemblerGaps(t *testing.T) {
+	var e streamEvents
+	a := NewAssembler(e.options(AssemblerOptions{MaxStreamBytes: 4}))
+	assemble(a,
+		tcpSegment(false, 100, TCPAck, "ab"),
+		tcpSegment(false, 110, TCPAck, "cd"),
+		// goes beyond MaxStreamBytes
+		tcpSegment(false, 120, TCPAck, "efg"),
+	)
+	a.Flush()
+	if want := "0 ab, 0 gap 8, 0 cd, 0 gap 8, 0 efg, flushed"; e.String() != want {
+		t.Errorf("got %s instead of %s", &e, want)
+	}
+
+	// cut off while capturing
+	e.events = nil
+	packet := tcpSegment(false, 100, TCPAck, "hello")
+	a.Add(&pcapreader.PacketInfo{}, Decode(pcapreader.LinkTypeEthernet, packet[:len(packet)-2], uint32(len(packet))))
+	a.Add(&pcapreader.PacketInfo{}, Decode(pcapreader.LinkTypeEthernet, tcpSegment(false, 105, TCPAck, "!"), uint32(len(packet))))
+	if want := "0 hel, 0 gap 2, 0 !"; e.String() != want {
+		t.Errorf("got %s instead of %s", &e, want)
+	}
+
+	// the stream that has been idle longest is evicted
+	e.events = nil
+	other := tcpSegment(false, 100, TCPAck, "cd")
+	binary.BigEndian.PutUint16(other[14+20:], 4712)
+	a = NewAssembler(e.options(AssemblerOptions{MaxStreams: 1}))
+	assemble(a, tcpSegment(false, 100, TCPAck, "ab"), other)
+	if want := "0 ab, evicted, 0 cd"; e.String() != want || a.Streams() != 1 {
+		t.Errorf("got %s instead of %s", &e, want)
+	}
+}
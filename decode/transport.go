@@ -0,0 +1,276 @@
+package decode
+
+import (
+	"encoding/binary"
+	"iter"
+	"strings"
+)
+
+// TCPFlags are the flags of a TCP header
+type TCPFlags uint16
+
+const (
+	TCPFin TCPFlags = 1 << iota
+	TCPSyn
+	TCPRst
+	TCPPsh
+	TCPAck
+	TCPUrg
+	TCPEce
+	TCPCwr
+	TCPNs
+)
+
+var tcpFlagNames = [...]string{"FIN", "SYN", "RST", "PSH", "ACK", "URG", "ECE", "CWR", "NS"}
+
+// Returns the flags that are set, i.e. "SYN|ACK"
+func (f TCPFlags) String() string {
+	var names []string
+	for i, name := range tcpFlagNames {
+		if f&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// TCP option kinds
+const (
+	TCPOptionEnd           uint8 = 0
+	TCPOptionNOP           uint8 = 1
+	TCPOptionMSS           uint8 = 2
+	TCPOptionWindowScale   uint8 = 3
+	TCPOptionSACKPermitted uint8 = 4
+	TCPOptionSACK          uint8 = 5
+	TCPOptionTimestamps    uint8 = 8
+)
+
+// TCPOption is an option of a TCP header
+type TCPOption struct {
+	Kind uint8
+	// the data after the kind and length, empty for
+	// the options that are just one byte long
+	Data []byte
+}
+
+// TCP is a TCP header with its options
+type TCP struct{ layer }
+
+func (TCP) LayerType() LayerType { return LayerTCP }
+
+func (t TCP) SrcPort() uint16 { return binary.BigEndian.Uint16(t.contents[0:2]) }
+func (t TCP) DstPort() uint16 { return binary.BigEndian.Uint16(t.contents[2:4]) }
+func (t TCP) Seq() uint32     { return binary.BigEndian.Uint32(t.contents[4:8]) }
+func (t TCP) Ack() uint32     { return binary.BigEndian.Uint32(t.contents[8:12]) }
+
+// Returns the length of the header in bytes
+func (t TCP) HeaderLength() int { return int(t.contents[12]>>4) * 4 }
+
+func (t TCP) Flags() TCPFlags {
+	return TCPFlags(binary.BigEndian.Uint16(t.contents[12:14]) & 0x01FF)
+}
+
+func (t TCP) Window() uint16   { return binary.BigEndian.Uint16(t.contents[14:16]) }
+func (t TCP) Checksum() uint16 { return binary.BigEndian.Uint16(t.contents[16:18]) }
+func (t TCP) Urgent() uint16   { return binary.BigEndian.Uint16(t.contents[18:20]) }
+
+// Returns the options, the end of options is left out
+func (t TCP) Options() iter.Seq[TCPOption] {
+	return func(yield func(TCPOption) bool) {
+		for kind, data := range options(t.contents[20:], false) {
+			if !yield(TCPOption{Kind: kind, Data: data}) {
+				return
+			}
+		}
+	}
+}
+
+// returns the data of the first option of kind
+// if it is there and has length n
+func (t TCP) option(kind uint8, n int) ([]byte, bool) {
+	for o := range t.Options() {
+		if o.Kind == kind {
+			return o.Data, len(o.Data) == n
+		}
+	}
+	return nil, false
+}
+
+// Returns the maximum segment size option
+func (t TCP) MSS() (uint16, bool) {
+	data, ok := t.option(TCPOptionMSS, 2)
+	if !ok {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(data), true
+}
+
+// Returns the shift count of the window scale option
+func (t TCP) WindowScale() (uint8, bool) {
+	data, ok := t.option(TCPOptionWindowScale, 1)
+	if !ok {
+		return 0, false
+	}
+	return data[0], true
+}
+
+// Reports whether there is a SACK permitted option
+func (t TCP) SACKPermitted() bool {
+	_, ok := t.option(TCPOptionSACKPermitted, 0)
+	return ok
+}
+
+// Returns the blocks of the SACK option as left and right edges
+func (t TCP) SACK() iter.Seq2[uint32, uint32] {
+	return func(yield func(uint32, uint32) bool) {
+		for o := range t.Options() {
+			if o.Kind != TCPOptionSACK {
+				continue
+			}
+			for data := o.Data; len(data) >= 8; data = data[8:] {
+				if !yield(binary.BigEndian.Uint32(data[0:4]), binary.BigEndian.Uint32(data[4:8])) {
+					return
+				}
+			}
+			return
+		}
+	}
+}
+
+// Returns the value and echo reply of the timestamps option
+func (t TCP) Timestamps() (uint32, uint32, bool) {
+	data, ok := t.option(TCPOptionTimestamps, 8)
+	if !ok {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint32(data[0:4]), binary.BigEndian.Uint32(data[4:8]), true
+}
+
+func (d *decoder) tcp(data []byte) {
+	if len(data) < 20 {
+		d.cut()
+		return
+	}
+	if data[12]>>4 < 5 {
+		d.fail(ErrMalformed)
+		return
+	}
+	l, ok := d.header(data, int(data[12]>>4)*4)
+	if !ok {
+		return
+	}
+	t := TCP{l}
+	d.add(t)
+	d.payload(t.payload)
+}
+
+// UDP is a UDP header
+type UDP struct{ layer }
+
+func (UDP) LayerType() LayerType { return LayerUDP }
+
+func (u UDP) SrcPort() uint16 { return binary.BigEndian.Uint16(u.contents[0:2]) }
+func (u UDP) DstPort() uint16 { return binary.BigEndian.Uint16(u.contents[2:4]) }
+
+// Returns the length of header and payload
+func (u UDP) Length() uint16   { return binary.BigEndian.Uint16(u.contents[4:6]) }
+func (u UDP) Checksum() uint16 { return binary.BigEndian.Uint16(u.contents[6:8]) }
+
+func (d *decoder) udp(data []byte) {
+	l, ok := d.header(data, 8)
+	if !ok {
+		return
+	}
+	u := UDP{l}
+	// like IPv4 the length is 0 with offloading
+	if u.Length() != 0 {
+		if u.Length() < 8 {
+			d.fail(ErrMalformed)
+			return
+		}
+		d.limit(&u.layer, int(u.Length())-8)
+	}
+	d.add(u)
+	d.udpPort(u.SrcPort(), u.DstPort(), u.payload)
+}
+
+// ICMPv4 is an ICMP header. The payload starts after the
+// first four bytes of the rest of the header, which are the
+// id and sequence number of echos and differ for the others.
+type ICMPv4 struct{ layer }
+
+func (ICMPv4) LayerType() LayerType { return LayerICMPv4 }
+
+// ICMPv4 types
+const (
+	ICMPv4EchoReply        uint8 = 0
+	ICMPv4Unreachable      uint8 = 3
+	ICMPv4Redirect         uint8 = 5
+	ICMPv4EchoRequest      uint8 = 8
+	ICMPv4TimeExceeded     uint8 = 11
+	ICMPv4ParameterProblem uint8 = 12
+)
+
+func (i ICMPv4) Type() uint8      { return i.contents[0] }
+func (i ICMPv4) Code() uint8      { return i.contents[1] }
+func (i ICMPv4) Checksum() uint16 { return binary.BigEndian.Uint16(i.contents[2:4]) }
+
+// Returns the four bytes after the checksum
+func (i ICMPv4) Rest() []byte { return i.contents[4:8] }
+
+// Returns the id and sequence number of echos
+func (i ICMPv4) ID() uint16  { return binary.BigEndian.Uint16(i.contents[4:6]) }
+func (i ICMPv4) Seq() uint16 { return binary.BigEndian.Uint16(i.contents[6:8]) }
+
+func (d *decoder) icmpv4(data []byte) {
+	l, ok := d.header(data, 8)
+	if !ok {
+		return
+	}
+	i := ICMPv4{l}
+	d.add(i)
+	d.payload(i.payload)
+}
+
+// ICMPv6 is an ICMPv6 header. Like for ICMPv4, the payload starts
+// after the first four bytes of the message body, which hold the id
+// and sequence number of echos.
+type ICMPv6 struct{ layer }
+
+func (ICMPv6) LayerType() LayerType { return LayerICMPv6 }
+
+// ICMPv6 types
+const (
+	ICMPv6Unreachable           uint8 = 1
+	ICMPv6PacketTooBig          uint8 = 2
+	ICMPv6TimeExceeded          uint8 = 3
+	ICMPv6ParameterProblem      uint8 = 4
+	ICMPv6EchoRequest           uint8 = 128
+	ICMPv6EchoReply             uint8 = 129
+	ICMPv6RouterSolicitation    uint8 = 133
+	ICMPv6RouterAdvertisement   uint8 = 134
+	ICMPv6NeighborSolicitation  uint8 = 135
+	ICMPv6NeighborAdvertisement uint8 = 136
+	ICMPv6Redirect              uint8 = 137
+)
+
+func (i ICMPv6) Type() uint8      { return i.contents[0] }
+func (i ICMPv6) Code() uint8      { return i.contents[1] }
+func (i ICMPv6) Checksum() uint16 { return binary.BigEndian.Uint16(i.contents[2:4]) }
+
+// Returns the four bytes after the checksum
+func (i ICMPv6) Rest() []byte { return i.contents[4:8] }
+
+// Returns the id and sequence number of echos
+func (i ICMPv6) ID() uint16  { return binary.BigEndian.Uint16(i.contents[4:6]) }
+func (i ICMPv6) Seq() uint16 { return binary.BigEndian.Uint16(i.contents[6:8]) }
+
+func (d *decoder) icmpv6(data []byte) {
+	l, ok := d.header(data, 8)
+	if !ok {
+		return
+	}
+	i := ICMPv6{l}
+	d.add(i)
+	d.payload(i.payload)
+}
@@ -0,0 +1,619 @@
+package decode
+
+import (
+	"encoding/binary"
+	"iter"
+
+	"github.com/Sojamann/pcapreader"
+)
+
+// the flags of the GRE header
+const (
+	GREChecksum uint16 = 0x8000
+	GRERouting  uint16 = 0x4000
+	GREKey      uint16 = 0x2000
+	GRESequence uint16 = 0x1000
+	// the acknowledgment number of the enhanced GRE of PPTP
+	GREAck uint16 = 0x0080
+)
+
+// GRE is a GRE header, version 0 as of RFC 2784 and 2890
+// or version 1, the enhanced GRE of PPTP
+type GRE struct{ layer }
+
+func (GRE) LayerType() LayerType { return LayerGRE }
+
+func (g GRE) Flags() uint16  { return binary.BigEndian.Uint16(g.contents[0:2]) &^ 0x7 }
+func (g GRE) Version() uint8 { return g.contents[1] & 0x7 }
+
+// Returns the EtherType of the payload
+func (g GRE) Protocol() EtherType { return EtherType(binary.BigEndian.Uint16(g.contents[2:4])) }
+
+// returns the 32 bit field of flag, which are in the order
+// checksum, key, sequence number and acknowledgment number
+func (g GRE) field(flag uint16) (uint32, bool) {
+	flags := g.Flags()
+	if flags&flag == 0 {
+		return 0, false
+	}
+	off := 4
+	for _, f := range []uint16{GREChecksum, GREKey, GRESequence} {
+		if f == flag {
+			break
+		}
+		// with routing there is a checksum field, even if it is not used
+		if flags&f != 0 || (f == GREChecksum && flags&GRERouting != 0) {
+			off += 4
+		}
+	}
+	return binary.BigEndian.Uint32(g.contents[off : off+4]), true
+}
+
+func (g GRE) Checksum() (uint16, bool) {
+	v, ok := g.field(GREChecksum)
+	return uint16(v >> 16), ok
+}
+
+// Returns the key, which for PPTP holds
+// the length of the payload and the call id
+func (g GRE) Key() (uint32, bool)      { return g.field(GREKey) }
+func (g GRE) Sequence() (uint32, bool) { return g.field(GRESequence) }
+
+func (g GRE) Ack() (uint32, bool) {
+	if g.Version() != 1 {
+		return 0, false
+	}
+	return g.field(GREAck)
+}
+
+func (d *decoder) gre(data []byte) {
+	if len(data) < 4 {
+		d.cut()
+		return
+	}
+	flags := binary.BigEndian.Uint16(data[0:2])
+	n := 4
+	if flags&(GREChecksum|GRERouting) != 0 {
+		n += 4
+	}
+	if flags&GREKey != 0 {
+		n += 4
+	}
+	if flags&GRESequence != 0 {
+		n += 4
+	}
+	if flags&GREAck != 0 && flags&0x7 == 1 {
+		n += 4
+	}
+	// the source route entries of RFC 1701, up to one with a length of 0
+	if flags&GRERouting != 0 {
+		for {
+			if len(data) < n+4 {
+				d.cut()
+				return
+			}
+			size := int(data[n+3])
+			n += 4 + size
+			if size == 0 {
+				break
+			}
+		}
+	}
+	l, ok := d.header(data, n)
+	if !ok {
+		return
+	}
+	g := GRE{l}
+	d.add(g)
+
+	switch p := g.Protocol(); {
+	case p == EtherTypeERSPAN && flags&GRESequence == 0:
+		// type I has no header of its own
+		d.ethernet(g.payload)
+	case p == EtherTypeERSPAN || p == EtherTypeERSPAN3:
+		d.erspan(g.payload)
+	default:
+		d.etherType(p, g.payload)
+	}
+}
+
+// ERSPAN is the header of mirrored packets of ERSPAN type II, which
+// is version 1, or type III, which is version 2. Type I has none.
+type ERSPAN struct{ layer }
+
+func (ERSPAN) LayerType() LayerType { return LayerERSPAN }
+
+func (e ERSPAN) Version() uint8 { return e.contents[0] >> 4 }
+
+// Returns the VLAN the packet was mirrored from
+func (e ERSPAN) VLAN() uint16 { return binary.BigEndian.Uint16(e.contents[0:2]) & 0x0FFF }
+
+// Returns the class of service of the packet
+func (e ERSPAN) COS() uint8        { return e.contents[2] >> 5 }
+func (e ERSPAN) SessionID() uint16 { return binary.BigEndian.Uint16(e.contents[2:4]) & 0x03FF }
+
+// Reports whether the mirrored packet has been cut off
+func (e ERSPAN) Truncated() bool { return e.contents[2]&0x04 != 0 }
+
+// Returns the index of the port the packet was mirrored from, type II only
+func (e ERSPAN) Index() (uint32, bool) {
+	if e.Version() != 1 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(e.contents[4:8]) & 0xFFFFF, true
+}
+
+// the frame types of type III
+const (
+	ERSPANFrameEthernet uint8 = 0
+	ERSPANFrameIP       uint8 = 2
+)
+
+// Returns the low 32 bits of the time the packet was mirrored
+// at, in units of the granularity, type III only
+func (e ERSPAN) Timestamp() (uint32, bool) {
+	if e.Version() != 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(e.contents[4:8]), true
+}
+
+// the last 16 bits of type III
+func (e ERSPAN) v3() (uint16, bool) {
+	if e.Version() != 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(e.contents[10:12]), true
+}
+
+// Returns the security group tag, type III only
+func (e ERSPAN) SGT() (uint16, bool) {
+	if e.Version() != 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(e.contents[8:10]), true
+}
+
+// Returns which of the ERSPANFrame types the packet is, type III only
+func (e ERSPAN) FrameType() (uint8, bool) {
+	v, ok := e.v3()
+	return uint8(v >> 10 & 0x1F), ok
+}
+
+func (e ERSPAN) HardwareID() (uint8, bool) {
+	v, ok := e.v3()
+	return uint8(v >> 4 & 0x3F), ok
+}
+
+// Reports whether the packet was mirrored when leaving
+// instead of entering the port, type III only
+func (e ERSPAN) Egress() (bool, bool) {
+	v, ok := e.v3()
+	return v&0x08 != 0, ok
+}
+
+func (d *decoder) erspan(data []byte) {
+	if len(data) < 8 {
+		d.cut()
+		return
+	}
+	n := 0
+	switch data[0] >> 4 {
+	case 1:
+		n = 8
+	case 2:
+		if len(data) < 12 {
+			d.cut()
+			return
+		}
+		n = 12
+		// followed by a platform specific subheader
+		if data[11]&0x01 != 0 {
+			n += 8
+		}
+	default:
+		d.fail(ErrMalformed)
+		return
+	}
+	l, ok := d.header(data, n)
+	if !ok {
+		return
+	}
+	e := ERSPAN{l}
+	d.add(e)
+	if t, _ := e.FrameType(); t == ERSPANFrameIP {
+		d.raw(e.payload)
+		return
+	}
+	d.ethernet(e.payload)
+}
+
+// UDP ports of the tunnels that are decoded
+const (
+	UDPPortVXLAN = 4789
+	// the port of VXLAN the Linux kernel used before there was one
+	UDPPortVXLANLinux = 8472
+	UDPPortGeneve     = 6081
+	UDPPortGTPU       = 2152
+)
+
+// decodes what follows a UDP header. Tunnels are told apart by
+// their ports, if their header does not look right it is a payload.
+func (d *decoder) udpPort(src, dst uint16, data []byte) {
+	switch {
+	case dst == UDPPortVXLAN || dst == UDPPortVXLANLinux:
+		d.vxlan(data)
+	case dst == UDPPortGeneve:
+		d.geneve(data)
+	case dst == UDPPortGTPU || src == UDPPortGTPU:
+		d.gtpu(data)
+	default:
+		d.payload(data)
+	}
+}
+
+// reports whether data, which has been sent to the port of a
+// tunnel, has its header of at least n bytes and it looks right,
+// which check tells. It is a payload if not.
+func (d *decoder) looksLike(data []byte, n int, check bool) bool {
+	switch {
+	case len(data) < n && d.p.Truncated:
+		d.cut()
+		return false
+	case len(data) < n || !check:
+		d.payload(data)
+		return false
+	}
+	return true
+}
+
+// VXLAN is a VXLAN header, which is followed by an Ethernet frame
+type VXLAN struct{ layer }
+
+func (VXLAN) LayerType() LayerType { return LayerVXLAN }
+
+func (v VXLAN) Flags() uint8 { return v.contents[0] }
+
+// Returns the virtual network identifier
+func (v VXLAN) VNI() uint32 { return binary.BigEndian.Uint32(v.contents[4:8]) >> 8 }
+
+// Returns the group of the group based policy extension
+func (v VXLAN) GroupPolicyID() (uint16, bool) {
+	if v.Flags()&0x80 == 0 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(v.contents[2:4]), true
+}
+
+func (d *decoder) vxlan(data []byte) {
+	// without the flag the VNI is not valid
+	if !d.looksLike(data, 8, len(data) > 0 && data[0]&0x08 != 0) {
+		return
+	}
+	l, ok := d.header(data, 8)
+	if !ok {
+		return
+	}
+	v := VXLAN{l}
+	d.add(v)
+	d.ethernet(v.payload)
+}
+
+// Geneve is a Geneve header with its options
+type Geneve struct{ layer }
+
+func (Geneve) LayerType() LayerType { return LayerGeneve }
+
+func (g Geneve) Version() uint8 { return g.contents[0] >> 6 }
+
+// Reports whether this is a control packet
+func (g Geneve) OAM() bool { return g.contents[1]&0x80 != 0 }
+
+// Reports whether there are critical options
+func (g Geneve) Critical() bool { return g.contents[1]&0x40 != 0 }
+
+// Returns the EtherType of the payload, which is
+// EtherTypeTransparent for Ethernet frames
+func (g Geneve) Protocol() EtherType { return EtherType(binary.BigEndian.Uint16(g.contents[2:4])) }
+
+// Returns the virtual network identifier
+func (g Geneve) VNI() uint32 { return binary.BigEndian.Uint32(g.contents[4:8]) >> 8 }
+
+// GeneveOption is an option of a Geneve header
+type GeneveOption struct {
+	Class uint16
+	Type  uint8
+	Data  []byte
+}
+
+// Reports whether the packet has to be dropped
+// by those that do not know the option
+func (o GeneveOption) Critical() bool { return o.Type&0x80 != 0 }
+
+// Returns the options
+func (g Geneve) Options() iter.Seq[GeneveOption] {
+	return func(yield func(GeneveOption) bool) {
+		for data := g.contents[8:]; len(data) >= 4; {
+			n := 4 + int(data[3]&0x1F)*4
+			if n > len(data) {
+				return
+			}
+			o := GeneveOption{Class: binary.BigEndian.Uint16(data[0:2]), Type: data[2], Data: data[4:n]}
+			if !yield(o) {
+				return
+			}
+			data = data[n:]
+		}
+	}
+}
+
+func (d *decoder) geneve(data []byte) {
+	if !d.looksLike(data, 8, len(data) > 0 && data[0]>>6 == 0) {
+		return
+	}
+	l, ok := d.header(data, 8+int(data[0]&0x3F)*4)
+	if !ok {
+		return
+	}
+	g := Geneve{l}
+	d.add(g)
+	d.etherType(g.Protocol(), g.payload)
+}
+
+// GTP-U message types
+const (
+	GTPUEchoRequest     uint8 = 1
+	GTPUEchoResponse    uint8 = 2
+	GTPUErrorIndication uint8 = 26
+	GTPUEndMarker       uint8 = 254
+	GTPUMessageGPDU     uint8 = 255
+)
+
+// the flags of the GTP-U header
+const (
+	gtpuFlagProtocolType = 0x10
+	// any of them means that there are sequence number,
+	// N-PDU number and next extension header type
+	gtpuFlagsOptional = 0x07
+	gtpuFlagSequence  = 0x02
+	gtpuFlagNPDU      = 0x01
+)
+
+// GTPU is a GTP-U header of GTP version 1 with its extension
+// headers. G-PDUs are followed by the IP packet of a user.
+type GTPU struct{ layer }
+
+func (GTPU) LayerType() LayerType { return LayerGTPU }
+
+func (g GTPU) Version() uint8     { return g.contents[0] >> 5 }
+func (g GTPU) MessageType() uint8 { return g.contents[1] }
+
+// Returns the length of what follows the first 8 bytes
+func (g GTPU) Length() uint16 { return binary.BigEndian.Uint16(g.contents[2:4]) }
+
+// Returns the tunnel endpoint identifier
+func (g GTPU) TEID() uint32 { return binary.BigEndian.Uint32(g.contents[4:8]) }
+
+func (g GTPU) Sequence() (uint16, bool) {
+	if g.contents[0]&gtpuFlagSequence == 0 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(g.contents[8:10]), true
+}
+
+func (g GTPU) NPDU() (uint8, bool) {
+	if g.contents[0]&gtpuFlagNPDU == 0 {
+		return 0, false
+	}
+	return g.contents[10], true
+}
+
+// Returns the type and the data of the extension headers
+func (g GTPU) Extensions() iter.Seq2[uint8, []byte] {
+	return func(yield func(uint8, []byte) bool) {
+		if g.contents[0]&gtpuFlagsOptional == 0 {
+			return
+		}
+		// the type of the next header is the last byte of the one before
+		next := g.contents[11]
+		for data := g.contents[12:]; next != 0 && len(data) > 0; {
+			n := int(data[0]) * 4
+			if n == 0 || n > len(data) {
+				return
+			}
+			if !yield(next, data[1:n-1]) {
+				return
+			}
+			next = data[n-1]
+			data = data[n:]
+		}
+	}
+}
+
+// the extension header of the PDU session of 5G
+const GTPUExtensionPDUSession uint8 = 0x85
+
+// Returns the QoS flow identifier of 5G
+func (g GTPU) QFI() (uint8, bool) {
+	for t, data := range g.Extensions() {
+		if t == GTPUExtensionPDUSession && len(data) >= 2 {
+			return data[1] & 0x3F, true
+		}
+	}
+	return 0, false
+}
+
+func (d *decoder) gtpu(data []byte) {
+	// GTP' and GTPv2-C use the same port
+	if !d.looksLike(data, 8, len(data) > 0 && data[0]>>5 == 1 && data[0]&gtpuFlagProtocolType != 0) {
+		return
+	}
+	n := 8
+	if data[0]&gtpuFlagsOptional != 0 {
+		if len(data) < 12 {
+			d.cut()
+			return
+		}
+		n = 12
+		for next := data[11]; next != 0; next = data[n-1] {
+			if n >= len(data) {
+				d.cut()
+				return
+			}
+			size := int(data[n]) * 4
+			if size == 0 {
+				d.fail(ErrMalformed)
+				return
+			}
+			n += size
+			if n > len(data) {
+				d.cut()
+				return
+			}
+		}
+	}
+	l, ok := d.header(data, n)
+	if !ok {
+		return
+	}
+	g := GTPU{l}
+	if int(g.Length()) < n-8 {
+		d.fail(ErrMalformed)
+		return
+	}
+	d.limit(&g.layer, int(g.Length())-(n-8))
+	d.add(g)
+	if g.MessageType() == GTPUMessageGPDU && len(g.payload) > 0 {
+		d.raw(g.payload)
+		return
+	}
+	d.payload(g.payload)
+}
+
+// reserved MPLS labels
+const (
+	MPLSLabelIPv4Null     uint32 = 0
+	MPLSLabelRouterAlert  uint32 = 1
+	MPLSLabelIPv6Null     uint32 = 2
+	MPLSLabelImplicitNull uint32 = 3
+	MPLSLabelEntropy      uint32 = 7
+)
+
+// MPLS is an entry of an MPLS label stack, there is one for every label
+type MPLS struct{ layer }
+
+func (MPLS) LayerType() LayerType { return LayerMPLS }
+
+func (m MPLS) Label() uint32       { return binary.BigEndian.Uint32(m.contents[0:4]) >> 12 }
+func (m MPLS) TrafficClass() uint8 { return m.contents[2] >> 1 & 0x7 }
+
+// Reports whether this is the last label of the stack
+func (m MPLS) BottomOfStack() bool { return m.contents[2]&0x01 != 0 }
+func (m MPLS) TTL() uint8          { return m.contents[3] }
+
+// decodes a label stack. What follows the last label is told by
+// its first bytes, as MPLS does not say. Pseudowires cannot be
+// told apart from other payloads reliably and stay a payload.
+func (d *decoder) mpls(data []byte) {
+	for {
+		l, ok := d.header(data, 4)
+		if !ok {
+			return
+		}
+		m := MPLS{l}
+		d.add(m)
+		data = m.payload
+		if m.BottomOfStack() {
+			break
+		}
+	}
+	switch {
+	case len(data) == 0:
+		d.payload(data)
+	case data[0]>>4 == 4 && len(data) >= 20:
+		d.ipv4(data)
+	case data[0]>>4 == 6 && len(data) >= 40:
+		d.ipv6(data)
+	default:
+		d.payload(data)
+	}
+}
+
+// reports whether l is a tunnel that carries next as a packet of its own
+func encapsulates(l, next Layer) bool {
+	switch l.(type) {
+	case GRE, ERSPAN, VXLAN, Geneve, GTPU, MPLS:
+		return true
+	case IPv4, IPv6, IPv6Extension:
+		switch next.(type) {
+		case IPv4, IPv6:
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the index of the first layer of the innermost
+// packet, which is 0 when the packet is not tunneled
+func (p *Packet) innerIndex() int {
+	for i := len(p.Layers) - 2; i >= 0; i-- {
+		if encapsulates(p.Layers[i], p.Layers[i+1]) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Returns the layers of the innermost packet of a tunneled packet,
+// i.e. from the Ethernet header inside of VXLAN on. When the packet
+// is not tunneled these are all its layers.
+func (p *Packet) Inner() []Layer { return p.Layers[p.innerIndex():] }
+
+// Returns the layers in front of those of Inner, which are
+// those of the tunnels. Empty when the packet is not tunneled.
+func (p *Packet) Outer() []Layer { return p.Layers[:p.innerIndex()] }
+
+// returns the link layer type of a packet that starts with l
+func innerLinkLayerType(l Layer) (pcapreader.LinkLayerType, bool) {
+	switch l.(type) {
+	case Ethernet:
+		return pcapreader.LinkTypeEthernet, true
+	case IPv4, IPv6:
+		return pcapreader.LinkTypeRaw, true
+	case PPP:
+		return pcapreader.LinkTypePPP, true
+	}
+	return 0, false
+}
+
+// Returns t with tunneled packets replaced by the innermost packet they
+// carry. Its Interface has the link layer type of that packet, which is
+// LinkTypeEthernet for VXLAN or ERSPAN and LinkTypeRaw for GTP-U or IP
+// in IP. Their Size is what is left of it without the tunnel headers.
+// Packets that are not tunneled, or whose innermost packet cannot be
+// told, are passed through as they are.
+func Decapsulate(t pcapreader.Traffic) pcapreader.Traffic {
+	var p Packet
+	return pcapreader.Map(t, func(info *pcapreader.PacketInfo, packet pcapreader.Packet, iface *pcapreader.Interface) (pcapreader.Packet, bool) {
+		DecodeInto(&p, iface.LinkLayerType, packet, info.Size)
+		i := p.innerIndex()
+		if i == 0 {
+			return packet, true
+		}
+		llt, ok := innerLinkLayerType(p.Layers[i])
+		if !ok {
+			return packet, true
+		}
+		inner := p.Layers[i-1].Payload()
+		// the layers are views into packet that go on up to its end
+		// except for padding, so the capacity tells where inner starts
+		start := cap(packet) - cap(inner)
+		if p.Truncated {
+			info.Size -= uint32(start)
+		} else {
+			info.Size = uint32(len(inner))
+		}
+		iface.LinkLayerType = llt
+		return pcapreader.Packet(inner), true
+	})
+}
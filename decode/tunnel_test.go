@@ -0,0 +1,212 @@
+package decode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/Sojamann/pcapreader"
+)
+
+func (p testPacket) udpTo(port uint16) testPacket {
+	h := p.udp()
+	binary.BigEndian.PutUint16(h[0:2], 49152)
+	binary.BigEndian.PutUint16(h[2:4], port)
+	return h
+}
+
+func (p testPacket) gre(t EtherType, flags uint16, fields ...uint32) testPacket {
+	h := binary.BigEndian.AppendUint16(nil, flags)
+	h = binary.BigEndian.AppendUint16(h, uint16(t))
+	for _, f := range fields {
+		h = binary.BigEndian.AppendUint32(h, f)
+	}
+	return append(h, p...)
+}
+
+func (p testPacket) vxlan(vni uint32) testPacket {
+	h := testPacket{0x08, 0, 0, 0}
+	h = binary.BigEndian.AppendUint32(h, vni<<8)
+	return append(h, p...)
+}
+
+func (p testPacket) mpls(labels ...uint32) testPacket {
+	var h testPacket
+	for i, label := range labels {
+		entry := label<<12 | 64
+		if i == len(labels)-1 {
+			entry |= 0x100
+		}
+		h = binary.BigEndian.AppendUint32(h, entry)
+	}
+	return append(h, p...)
+}
+
+func TestDecodeTunnels(t *testing.T) {
+	ip := testPacket("hello").udp().ipv4(IPProtocolUDP)
+	ip6 := testPacket("hello").udp().ipv6(IPProtocolUDP)
+	frame := ip.ethernet(EtherTypeIPv4)
+
+	geneve := append(testPacket{0x02, 0, 0x65, 0x58, 0, 0, 42, 0, 0x01, 0x02, 0x80, 0x01, 1, 2, 3, 4}, frame...)
+	gtpu := append(testPacket{0x36, 0xFF, 0, byte(8 + len(ip)), 0, 0, 0, 9, 0, 1, 0, 0x85, 1, 0x00, 0x05, 0}, ip...)
+	erspan2 := append(testPacket{0x10, 0x0A, 0x00, 0x07, 0, 0, 0, 3}, frame...)
+	erspan3 := append(testPacket{0x20, 0x0A, 0x00, 0x07, 0, 0, 1, 0, 0, 5, 0x08, 0x00}, ip...)
+	cases := []struct {
+		name string
+		data testPacket
+		want string
+	}{
+		{"gre", ip.gre(EtherTypeIPv4, 0).ipv4(IPProtocolGRE).ethernet(EtherTypeIPv4),
+			"Ethernet IPv4 GRE IPv4 UDP Payload"},
+		{"gre key and sequence", frame.gre(EtherTypeTransparent, GREKey|GRESequence, 7, 1).ipv4(IPProtocolGRE).ethernet(EtherTypeIPv4),
+			"Ethernet IPv4 GRE Ethernet IPv4 UDP Payload"},
+		{"erspan type I", frame.gre(EtherTypeERSPAN, 0).ipv4(IPProtocolGRE).ethernet(EtherTypeIPv4),
+			"Ethernet IPv4 GRE Ethernet IPv4 UDP Payload"},
+		{"erspan type II", erspan2.gre(EtherTypeERSPAN, GRESequence, 1).ipv4(IPProtocolGRE).ethernet(EtherTypeIPv4),
+			"Ethernet IPv4 GRE ERSPAN Ethernet IPv4 UDP Payload"},
+		{"erspan type III", erspan3.gre(EtherTypeERSPAN3, GRESequence, 1).ipv4(IPProtocolGRE).ethernet(EtherTypeIPv4),
+			"Ethernet IPv4 GRE ERSPAN IPv4 UDP Payload"},
+		{"vxlan", frame.vxlan(42).udpTo(UDPPortVXLAN).ipv4(IPProtocolUDP).ethernet(EtherTypeIPv4),
+			"Ethernet IPv4 UDP VXLAN Ethernet IPv4 UDP Payload"},
+		{"no vxlan", testPacket("goodbye").udpTo(UDPPortVXLAN).ipv4(IPProtocolUDP).ethernet(EtherTypeIPv4),
+			"Ethernet IPv4 UDP Payload"},
+		{"geneve", geneve.udpTo(UDPPortGeneve).ipv6(IPProtocolUDP).ethernet(EtherTypeIPv6),
+			"Ethernet IPv6 UDP Geneve Ethernet IPv4 UDP Payload"},
+		{"gtpu", gtpu.udpTo(UDPPortGTPU).ipv4(IPProtocolUDP).ethernet(EtherTypeIPv4),
+			"Ethernet IPv4 UDP GTPU IPv4 UDP Payload"},
+		{"mpls", ip6.mpls(100, 200).ethernet(EtherTypeMPLS),
+			"Ethernet MPLS MPLS IPv6 UDP Payload"},
+		{"6in4", ip6.ipv4(IPProtocolIPv6).ethernet(EtherTypeIPv4),
+			"Ethernet IPv4 IPv6 UDP Payload"},
+		{"ip in ip in vxlan", ip.ipv4(IPProtocolIPv4).ethernet(EtherTypeIPv4).vxlan(1).udpTo(UDPPortVXLAN).ipv4(IPProtocolUDP).ethernet(EtherTypeIPv4),
+			"Ethernet IPv4 UDP VXLAN Ethernet IPv4 IPv4 UDP Payload"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := Decode(pcapreader.LinkTypeEthernet, c.data, uint32(len(c.data)))
+			if got := layerTypes(p); got != c.want {
+				t.Errorf("decoded %s instead of %s", got, c.want)
+			}
+		})
+	}
+
+	data := frame.gre(EtherTypeTransparent, GREChecksum|GREKey|GRESequence, 0, 7, 1).ipv4(IPProtocolGRE).ethernet(EtherTypeIPv4)
+	p := Decode(pcapreader.LinkTypeEthernet, data, uint32(len(data)))
+	g, _ := First[GRE](p)
+	if key, _ := g.Key(); key != 7 {
+		t.Errorf("GRE key %d", key)
+	}
+	if seq, _ := g.Sequence(); seq != 1 {
+		t.Errorf("GRE sequence %d", seq)
+	}
+
+	data = erspan2.gre(EtherTypeERSPAN, GRESequence, 1).ipv4(IPProtocolGRE).ethernet(EtherTypeIPv4)
+	p = Decode(pcapreader.LinkTypeEthernet, data, uint32(len(data)))
+	e, _ := First[ERSPAN](p)
+	if index, _ := e.Index(); e.Version() != 1 || e.VLAN() != 10 || e.SessionID() != 7 || index != 3 {
+		t.Errorf("ERSPAN %d VLAN %d session %d index %d", e.Version(), e.VLAN(), e.SessionID(), index)
+	}
+	data = erspan3.gre(EtherTypeERSPAN3, GRESequence, 1).ipv4(IPProtocolGRE).ethernet(EtherTypeIPv4)
+	p = Decode(pcapreader.LinkTypeEthernet, data, uint32(len(data)))
+	e, _ = First[ERSPAN](p)
+	if ts, _ := e.Timestamp(); ts != 256 {
+		t.Errorf("ERSPAN timestamp %d", ts)
+	}
+	if sgt, _ := e.SGT(); sgt != 5 {
+		t.Errorf("ERSPAN SGT %d", sgt)
+	}
+
+	data = geneve.udpTo(UDPPortGeneve).ipv6(IPProtocolUDP).ethernet(EtherTypeIPv6)
+	p = Decode(pcapreader.LinkTypeEthernet, data, uint32(len(data)))
+	gn, _ := First[Geneve](p)
+	if gn.VNI() != 42 || gn.Protocol() != EtherTypeTransparent {
+		t.Errorf("Geneve VNI %d protocol %s", gn.VNI(), gn.Protocol())
+	}
+	var options []GeneveOption
+	for o := range gn.Options() {
+		options = append(options, o)
+	}
+	if len(options) != 1 || options[0].Class != 0x0102 || !options[0].Critical() || !bytes.Equal(options[0].Data, []byte{1, 2, 3, 4}) {
+		t.Errorf("Geneve options %+v", options)
+	}
+
+	data = gtpu.udpTo(UDPPortGTPU).ipv4(IPProtocolUDP).ethernet(EtherTypeIPv4)
+	p = Decode(pcapreader.LinkTypeEthernet, data, uint32(len(data)))
+	gt, _ := First[GTPU](p)
+	if qfi, ok := gt.QFI(); gt.TEID() != 9 || !ok || qfi != 5 {
+		t.Errorf("GTP-U TEID %d QFI %d", gt.TEID(), qfi)
+	}
+	if seq, _ := gt.Sequence(); seq != 1 {
+		t.Errorf("GTP-U sequence %d", seq)
+	}
+
+	data = ip6.mpls(100, 200).ethernet(EtherTypeMPLS)
+	p = Decode(pcapreader.LinkTypeEthernet, data, uint32(len(data)))
+	m, _ := Last[MPLS](p)
+	if m.Label() != 200 || !m.BottomOfStack() || m.TTL() != 64 {
+		t.Errorf("MPLS label %d bottom %v TTL %d", m.Label(), m.BottomOfStack(), m.TTL())
+	}
+
+	data = ip.ipv4(IPProtocolIPv4).ethernet(EtherTypeIPv4).vxlan(1).udpTo(UDPPortVXLAN).ipv4(IPProtocolUDP).ethernet(EtherTypeIPv4)
+	p = Decode(pcapreader.LinkTypeEthernet, data, uint32(len(data)))
+	if len(p.Outer()) != 6 || len(p.Inner()) != 3 {
+		t.Errorf("%d outer and %d inner layers", len(p.Outer()), len(p.Inner()))
+	}
+	if _, ok := p.Inner()[0].(IPv4); !ok {
+		t.Errorf("inner packet starts with %s", p.Inner()[0].LayerType())
+	}
+	p = Decode(pcapreader.LinkTypeEthernet, frame, uint32(len(frame)))
+	if len(p.Outer()) != 0 || len(p.Inner()) != 4 {
+		t.Errorf("%d outer and %d inner layers without a tunnel", len(p.Outer()), len(p.Inner()))
+	}
+}
+
+func TestDecapsulate(t *testing.T) {
+	ip := testPacket("hello").udp().ipv4(IPProtocolUDP)
+	frame := ip.ethernet(EtherTypeIPv4)
+	packets := []testPacket{
+		frame.vxlan(42).udpTo(UDPPortVXLAN).ipv4(IPProtocolUDP).ethernet(EtherTypeIPv4),
+		// with the padding of a short Ethernet frame
+		append(ip.ipv4(IPProtocolIPv4).ethernet(EtherTypeIPv4), 0, 0, 0, 0),
+		frame,
+	}
+	var b bytes.Buffer
+	w := pcapreader.NewPcapNgWriter(&b)
+	for _, packet := range packets {
+		info := &pcapreader.PacketInfo{Size: uint32(len(packet))}
+		if err := w.WritePacket(pcapreader.Interface{LinkLayerType: pcapreader.LinkTypeEthernet}, info, pcapreader.Packet(packet)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Flush()
+	traffic, err := pcapreader.OpenReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inner := Decapsulate(traffic)
+	want := []struct {
+		llt  pcapreader.LinkLayerType
+		data testPacket
+	}{
+		{pcapreader.LinkTypeEthernet, frame},
+		{pcapreader.LinkTypeRaw, ip},
+		{pcapreader.LinkTypeEthernet, frame},
+	}
+	for i, w := range want {
+		info, packet, err := inner.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if llt := pcapreader.InterfaceOf(inner).LinkLayerType; llt != w.llt {
+			t.Errorf("packet %d is %s instead of %s", i, llt, w.llt)
+		}
+		if !bytes.Equal(packet, w.data) || info.Size != uint32(len(w.data)) {
+			t.Errorf("packet %d of size %d is %x instead of %x", i, info.Size, packet, w.data)
+		}
+	}
+	if _, _, err := inner.Next(); err != io.EOF {
+		t.Errorf("%v instead of EOF", err)
+	}
+}
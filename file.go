@@ -2,25 +2,34 @@ package pcapreader
 
 import (
 	"errors"
-	"os"
+	"io"
 	"path/filepath"
 )
 
 var ErrUnkownExtension error = errors.New("unknown extension")
 
 func OpenFile(name string) (Traffic, error) {
-	f, err := os.Open(name)
-	if err != nil {
-		return nil, err
-	}
+	return OpenFileWithOptions(name, ReaderOptions{})
+}
+
+// Reads a pcap or a pcapng from r, which are told apart by their
+// first bytes. As nothing is read twice, this works for pipes and
+// sockets, like the output of dumpcap -w - or tcpdump -w -.
+// If r is an io.Closer it is closed by Stop.
+func OpenReader(r io.Reader) (Traffic, error) {
+	return OpenReaderWithOptions(r, ReaderOptions{})
+}
 
+// picks the reader based on the file name. src is closed
+// if the extension is not known.
+func openByExtension(name string, src source, opts ReaderOptions) (Traffic, error) {
 	switch filepath.Ext(name) {
 	case ".pcap":
-		return readPcap(f)
+		return readPcap(src, opts)
 	case ".pcapng":
-		return readPcapNg(f)
+		return readPcapNg(src, opts)
 	}
 
-	f.Close()
+	src.Close()
 	return nil, ErrUnkownExtension
 }
@@ -0,0 +1,280 @@
+package pcapreader
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// how often a followed file is checked for new data by default
+const defaultPollInterval = 250 * time.Millisecond
+
+type FollowOptions struct {
+	// How long to wait before looking for new data again
+	// when everything has been read. Defaults to 250ms.
+	PollInterval time.Duration
+
+	// A glob matching all files of a ring buffer, like "/tmp/ring_*.pcapng"
+	// for dumpcap -b or "/tmp/ring.pcap*" for tcpdump -C/-W. Once all the
+	// data of the current file has been read and a file matching the pattern
+	// has been written to after it, reading continues with that file.
+	// Without a pattern only the one file is followed.
+	RotationPattern string
+
+	// How each of the files is read, as with OpenFileWithOptions
+	ReaderOptions ReaderOptions
+}
+
+// blocks on the end of the file until more data has been
+// written to it, so the readers never see the end and a
+// partially written record is just waited for.
+type tailReader struct {
+	file     *os.File
+	interval time.Duration
+	stopped  <-chan struct{}
+	// looks for the file the writer moved on to
+	nextFile func(current *os.File) (string, bool)
+	// the file that comes after this one once we are at its end
+	next string
+
+	ctx  context.Context
+	done <-chan struct{}
+}
+
+func (r *tailReader) Read(b []byte) (int, error) {
+	for {
+		n, err := r.file.Read(b)
+		if n > 0 || (err != nil && err != io.EOF) {
+			return n, err
+		}
+
+		// the writer finished this file before it started the next
+		// one, so after reading once more we really are at the end
+		if next, ok := r.findNext(); ok {
+			r.next = next
+			n, err = r.file.Read(b)
+			if n > 0 {
+				return n, nil
+			}
+			return 0, io.EOF
+		}
+
+		if err := r.wait(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (r *tailReader) findNext() (string, bool) {
+	if r.nextFile == nil {
+		return "", false
+	}
+	return r.nextFile(r.file)
+}
+
+func (r *tailReader) wait() error {
+	timer := time.NewTimer(r.interval)
+	defer timer.Stop()
+
+	select {
+	case <-r.stopped:
+		return ErrTrafficSourceAlreadyStopped
+	case <-r.done:
+		return r.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (r *tailReader) Close() error {
+	return r.file.Close()
+}
+
+type followTraffic struct {
+	// Next holds this while reading so that Stop,
+	// which may be called from a different goroutine,
+	// waits for Next to notice that it has been stopped
+	mu sync.Mutex
+
+	opts    FollowOptions
+	name    string
+	tail    *tailReader
+	current Traffic
+	ctx     context.Context
+	// kept aside so that asking for it does not
+	// have to wait for a Next that is waiting
+	llt atomic.Uint32
+
+	stopped  chan struct{}
+	stopOnce sync.Once
+	dead     bool
+}
+
+// Reads the capture name while it is still being written, i.e. by
+// tcpdump -w. When all packets have been read, Next waits for the
+// next one to be written instead of returning io.EOF. It can be
+// ended by calling Stop from another goroutine or through the
+// context of Packets.
+// As the file might not even have its header yet, this does not wait
+// for it. The LinkLayerType is known once the first packet has been read.
+func FollowFile(name string, opts FollowOptions) (Traffic, error) {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+
+	t := &followTraffic{
+		opts:    opts,
+		stopped: make(chan struct{}),
+	}
+	if err := t.open(name); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// starts following name, the reader is created by
+// the next call to Next as that might have to wait
+func (t *followTraffic) open(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+
+	t.name = name
+	t.current = nil
+	t.tail = &tailReader{
+		file:     f,
+		interval: t.opts.PollInterval,
+		stopped:  t.stopped,
+	}
+	if t.opts.RotationPattern != "" {
+		t.tail.nextFile = t.nextFile
+	}
+	t.setTailContext()
+
+	return nil
+}
+
+func (t *followTraffic) setTailContext() {
+	t.tail.ctx = t.ctx
+	t.tail.done = nil
+	if t.ctx != nil {
+		t.tail.done = t.ctx.Done()
+	}
+}
+
+// looks for the file that has been written to after current
+func (t *followTraffic) nextFile(current *os.File) (string, bool) {
+	stat, err := current.Stat()
+	if err != nil {
+		return "", false
+	}
+
+	matches, err := filepath.Glob(t.opts.RotationPattern)
+	if err != nil {
+		return "", false
+	}
+
+	next := ""
+	var nextTime time.Time
+	for _, match := range matches {
+		candidate, err := os.Stat(match)
+		if err != nil || os.SameFile(stat, candidate) {
+			continue
+		}
+		if !candidate.ModTime().After(stat.ModTime()) {
+			continue
+		}
+		// the oldest one of the newer files is the one
+		// that comes next, others might follow after it
+		if next == "" || candidate.ModTime().Before(nextTime) {
+			next = match
+			nextTime = candidate.ModTime()
+		}
+	}
+
+	return next, next != ""
+}
+
+func (t *followTraffic) Next() (*PacketInfo, Packet, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for {
+		if t.dead {
+			return nil, nil, ErrTrafficSourceAlreadyStopped
+		}
+
+		if t.current == nil {
+			current, err := openByExtension(t.name, newReaderSource(t.tail), t.opts.ReaderOptions)
+			if err != nil {
+				t.stop()
+				return nil, nil, err
+			}
+			setContext(current, t.ctx)
+			t.current = current
+			t.llt.Store(uint32(current.LinkLayerType()))
+		}
+
+		info, packet, err := t.current.Next()
+		if err != io.EOF {
+			if err != nil {
+				t.stop()
+			}
+			return info, packet, err
+		}
+
+		// the tail only ends when the writer moved on
+		if t.tail.next == "" {
+			t.stop()
+			return nil, nil, io.EOF
+		}
+		if err := t.open(t.tail.next); err != nil {
+			t.stop()
+			return nil, nil, err
+		}
+	}
+}
+
+func (t *followTraffic) LinkLayerType() LinkLayerType {
+	return LinkLayerType(t.llt.Load())
+}
+
+func (t *followTraffic) setContext(ctx context.Context) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.ctx = ctx
+	t.setTailContext()
+	if t.current != nil {
+		setContext(t.current, ctx)
+	}
+}
+
+// must be called with mu held
+func (t *followTraffic) stop() {
+	if t.dead {
+		return
+	}
+	if t.current != nil {
+		t.current.Stop()
+	} else {
+		t.tail.Close()
+	}
+	t.dead = true
+}
+
+func (t *followTraffic) Stop() {
+	// wakes up a Next that is waiting for data
+	t.stopOnce.Do(func() {
+		close(t.stopped)
+	})
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stop()
+}
@@ -0,0 +1,155 @@
+package pcapreader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// the packet a Next that is called in the background returns
+type followed struct {
+	packet []byte
+	err    error
+}
+
+func followNext(traffic Traffic) <-chan followed {
+	c := make(chan followed, 1)
+	go func() {
+		_, packet, err := traffic.Next()
+		c <- followed{bytes.Clone(packet), err}
+	}()
+	return c
+}
+
+// the packet has to come within a second
+func expectFollowed(t *testing.T, c <-chan followed, i int) {
+	t.Helper()
+	select {
+	case f := <-c:
+		if f.err != nil {
+			t.Fatalf("packet %d: %v", i, f.err)
+		}
+		if !bytes.Equal(f.packet, genData(i, genSizes[i%len(genSizes)])) {
+			t.Errorf("packet %d differs", i)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("packet %d has not been read", i)
+	}
+}
+
+// nothing may come while the writer has not finished the packet
+func expectWaiting(t *testing.T, c <-chan followed) {
+	t.Helper()
+	select {
+	case f := <-c:
+		t.Fatalf("%d bytes and %v instead of waiting", len(f.packet), f.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func appendFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFollowPartialRecord(t *testing.T) {
+	data := genPcap(genPcapOpts{order: binary.LittleEndian, snaplen: 0xFFFF, packets: 2})
+	// in the middle of the header of the second record
+	cut := len(data) - len(genData(1, genSizes[1])) - 8
+
+	name := filepath.Join(t.TempDir(), "capture.pcap")
+	if err := os.WriteFile(name, data[:cut], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	traffic, err := FollowFile(name, FollowOptions{PollInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectFollowed(t, followNext(traffic), 0)
+
+	next := followNext(traffic)
+	expectWaiting(t, next)
+	appendFile(t, name, data[cut:])
+	expectFollowed(t, next, 1)
+
+	// the end of the file is waited on until stopped
+	next = followNext(traffic)
+	expectWaiting(t, next)
+	traffic.Stop()
+	if f := <-next; f.err != ErrTrafficSourceAlreadyStopped {
+		t.Errorf("%v after stopping", f.err)
+	}
+}
+
+func TestFollowRotation(t *testing.T) {
+	dir := t.TempDir()
+	data := genPcap(genPcapOpts{order: binary.LittleEndian, snaplen: 0xFFFF, packets: 4})
+	// the header and the first two packets
+	half := 24 + 2*16 + genSizes[0] + genSizes[1]
+
+	first := filepath.Join(dir, "ring_1.pcap")
+	if err := os.WriteFile(first, data[:half], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	traffic, err := FollowFile(first, FollowOptions{
+		PollInterval:    time.Millisecond,
+		RotationPattern: filepath.Join(dir, "ring_*.pcap"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer traffic.Stop()
+	expectFollowed(t, followNext(traffic), 0)
+	expectFollowed(t, followNext(traffic), 1)
+
+	next := followNext(traffic)
+	expectWaiting(t, next)
+	// the writer moves on to the next file, which gets its own header.
+	// The modification time is set as it might not change otherwise.
+	second := filepath.Join(dir, "ring_2.pcap")
+	if err := os.WriteFile(second, append(bytes.Clone(data[:24]), data[half:]...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(second, later, later); err != nil {
+		t.Fatal(err)
+	}
+	expectFollowed(t, next, 2)
+	expectFollowed(t, followNext(traffic), 3)
+	expectWaiting(t, followNext(traffic))
+}
+
+func TestFollowReaderOptions(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "capture.pcap")
+	// recovering looks at a whole window of the capture
+	// at once, so there has to be enough after the garbage
+	data, records := recoverPcap(2000)
+	if err := os.WriteFile(name, insert(data, records[1], "garbage!"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var skipped []Skipped
+	traffic, err := FollowFile(name, FollowOptions{ReaderOptions: ReaderOptions{
+		Recover:   true,
+		OnRecover: func(s Skipped) { skipped = append(skipped, s) },
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer traffic.Stop()
+	for i := range 3 {
+		expectFollowed(t, followNext(traffic), i)
+	}
+	if len(skipped) != 1 || skipped[0].Offset != int64(records[1]) || skipped[0].Length != 8 {
+		t.Errorf("%+v skipped", skipped)
+	}
+}
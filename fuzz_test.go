@@ -0,0 +1,121 @@
+package pcapreader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// generated pcaps the corpus starts from
+func fuzzPcapSeeds() [][]byte {
+	pcap := genPcap(genPcapOpts{order: binary.LittleEndian, snaplen: 0xFFFF, packets: 8})
+	return [][]byte{
+		pcap,
+		genPcap(genPcapOpts{order: binary.BigEndian, nano: true, snaplen: 62, packets: 8}),
+		// cut off in the middle of a record
+		pcap[:len(pcap)-30],
+	}
+}
+
+// generated pcapngs the corpus starts from
+func fuzzPcapNgSeeds() [][]byte {
+	var simple genNg
+	simple.shb(binary.LittleEndian, 1, 0)
+	simple.idb(1, 0)
+	simple.packets(0, 1e6, 0, 8)
+
+	// several sections and interfaces, SPBs and a timestamp resolution
+	var sections genNg
+	sections.shb(binary.BigEndian, 1, 0)
+	sections.idb(1, 64, genTsresol(9))
+	sections.packets(0, 1e9, 0, 4)
+	sections.spb(genData(4, 60), 60)
+	sections.shb(binary.LittleEndian, 1, 2)
+	sections.idb(101, 0)
+	sections.idb(1, 0)
+	sections.packets(1, 1e6, 5, 4)
+	return [][]byte{simple.b.Bytes(), sections.b.Bytes()}
+}
+
+// reads all packets of data and returns copies of them and the error
+// reading ended with, which is nil for io.EOF. Reading has to end with
+// io.EOF or an error the package knows about, and never panic.
+func fuzzRead(t *testing.T, read func(source, ReaderOptions) (Traffic, error), src source, opts ReaderOptions, size int) ([][]byte, error) {
+	traffic, err := read(src, opts)
+	if err != nil {
+		fuzzCheckErr(t, err)
+		return nil, nil
+	}
+	defer traffic.Stop()
+
+	var packets [][]byte
+	for {
+		_, packet, err := traffic.Next()
+		if err == io.EOF {
+			return packets, nil
+		}
+		if err != nil {
+			fuzzCheckErr(t, err)
+			return packets, err
+		}
+
+		// every packet takes up some of the capture
+		// so there cannot be more than there are bytes
+		if len(packets) > size {
+			t.Fatalf("more packets than bytes in the capture")
+		}
+		packets = append(packets, bytes.Clone(packet))
+	}
+}
+
+func fuzzCheckErr(t *testing.T, err error) {
+	var fe *FormatError
+	if !errors.As(err, &fe) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+// the readers must come to the same conclusion no matter where the bytes come from
+func fuzzReader(t *testing.T, read func(source, ReaderOptions) (Traffic, error), data []byte) {
+	fromMemory, _ := fuzzRead(t, read, &memorySource{data: data}, ReaderOptions{}, len(data))
+	fromStream, _ := fuzzRead(t, read, newReaderSource(io.NopCloser(bytes.NewReader(data))), ReaderOptions{}, len(data))
+	if len(fromMemory) != len(fromStream) {
+		t.Fatalf("%d packets from memory but %d from a stream", len(fromMemory), len(fromStream))
+	}
+	for i := range fromMemory {
+		if !bytes.Equal(fromMemory[i], fromStream[i]) {
+			t.Fatalf("packet %d differs between memory and stream", i)
+		}
+	}
+
+	// once opened, recovering skips everything that is broken
+	_, err := fuzzRead(t, read, &memorySource{data: data}, ReaderOptions{Recover: true}, len(data))
+	if err != nil {
+		t.Fatalf("recovering ended with %v", err)
+	}
+
+	err = Check(bytes.NewReader(data), CheckOptions{}, func(*FormatError) {})
+	if err != nil {
+		t.Fatalf("checking failed: %v", err)
+	}
+}
+
+func FuzzPcap(f *testing.F) {
+	for _, seed := range fuzzPcapSeeds() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzReader(t, readPcap, data)
+	})
+}
+
+func FuzzPcapNg(f *testing.F) {
+	for _, seed := range fuzzPcapNgSeeds() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzReader(t, readPcapNg, data)
+	})
+}
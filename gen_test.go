@@ -0,0 +1,145 @@
+package pcapreader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// the time all generated captures start at
+var genStart = time.Date(2024, 2, 29, 13, 37, 0, 0, time.UTC)
+
+// the data of the i-th generated packet. Every packet is
+// different so that packets that get mixed up are noticed.
+func genData(i int, size int) []byte {
+	data := make([]byte, size)
+	for j := range data {
+		data[j] = byte(i*31 + j)
+	}
+	return data
+}
+
+// packet sizes of the generated captures cycle through these
+var genSizes = []int{60, 1, 0, 1514, 61, 62, 63, 64}
+
+type genPcapOpts struct {
+	order   binary.ByteOrder
+	nano    bool
+	snaplen uint32
+	packets int
+}
+
+// generates a pcap. Packets larger than the snaplen are
+// cut off like a capture tool would do.
+func genPcap(o genPcapOpts) []byte {
+	var b bytes.Buffer
+
+	magic := uint32(magicMicroseconds)
+	if o.nano {
+		magic = magicNanoseconds
+	}
+	binary.Write(&b, o.order, magic)
+	binary.Write(&b, o.order, []uint16{2, 4})
+	binary.Write(&b, o.order, []uint32{0, 0, o.snaplen, 1})
+
+	for i := 0; i < o.packets; i++ {
+		size := genSizes[i%len(genSizes)]
+		data := genData(i, size)
+		if uint32(size) > o.snaplen {
+			data = data[:o.snaplen]
+		}
+		ts := genStart.Add(time.Duration(i) * 1234567 * time.Nanosecond)
+		frac := uint32(ts.Nanosecond() / 1000)
+		if o.nano {
+			frac = uint32(ts.Nanosecond())
+		}
+		binary.Write(&b, o.order, []uint32{uint32(ts.Unix()), frac, uint32(len(data)), uint32(size)})
+		b.Write(data)
+	}
+	return b.Bytes()
+}
+
+// builds a pcapng block by block
+type genNg struct {
+	b     bytes.Buffer
+	order binary.ByteOrder
+}
+
+type genOption struct {
+	code  uint16
+	value []byte
+}
+
+func (g *genNg) block(blockType uint32, body []byte) {
+	blockLen := uint32(12 + len(body))
+	binary.Write(&g.b, g.order, blockType)
+	binary.Write(&g.b, g.order, blockLen)
+	g.b.Write(body)
+	binary.Write(&g.b, g.order, blockLen)
+}
+
+// the options of a block, padded to 32 bits and with an end of options
+func (g *genNg) options(b *bytes.Buffer, opts []genOption) {
+	if len(opts) == 0 {
+		return
+	}
+	for _, o := range opts {
+		binary.Write(b, g.order, []uint16{o.code, uint16(len(o.value))})
+		b.Write(o.value)
+		b.Write(make([]byte, (4-len(o.value)%4)%4))
+	}
+	binary.Write(b, g.order, []uint16{0, 0})
+}
+
+// starts a new section in the given byte order. The
+// section length is -1 as streaming writers would do.
+func (g *genNg) shb(order binary.ByteOrder, major, minor uint16) {
+	g.order = order
+	var body bytes.Buffer
+	binary.Write(&body, order, ngByteOrderMagic)
+	binary.Write(&body, order, []uint16{major, minor})
+	binary.Write(&body, order, int64(-1))
+	g.options(&body, []genOption{{4, []byte("generated by pcapreader")}})
+	g.block(ngSHB, body.Bytes())
+}
+
+func (g *genNg) idb(llt uint16, snaplen uint32, opts ...genOption) {
+	var body bytes.Buffer
+	binary.Write(&body, g.order, []uint16{llt, 0})
+	binary.Write(&body, g.order, snaplen)
+	g.options(&body, opts)
+	g.block(ngIDB, body.Bytes())
+}
+
+// an EPB whose timestamp is in the units of the interface
+func (g *genNg) epb(ifId uint32, ts uint64, data []byte, size uint32, opts ...genOption) {
+	var body bytes.Buffer
+	binary.Write(&body, g.order, []uint32{ifId, uint32(ts >> 32), uint32(ts), uint32(len(data)), size})
+	body.Write(data)
+	body.Write(make([]byte, (4-len(data)%4)%4))
+	g.options(&body, opts)
+	g.block(ngEPB, body.Bytes())
+}
+
+func (g *genNg) spb(data []byte, size uint32) {
+	var body bytes.Buffer
+	binary.Write(&body, g.order, size)
+	body.Write(data)
+	body.Write(make([]byte, (4-len(data)%4)%4))
+	g.block(ngSPB, body.Bytes())
+}
+
+// the if_tsresol option
+func genTsresol(resol byte) genOption {
+	return genOption{9, []byte{resol}}
+}
+
+// writes packets to the interface with the given id whose
+// timestamps are in units of 1/perSecond seconds
+func (g *genNg) packets(ifId uint32, perSecond uint64, first int, n int) {
+	for i := first; i < first+n; i++ {
+		size := genSizes[i%len(genSizes)]
+		ts := uint64(genStart.Unix())*perSecond + uint64(i)*perSecond/7
+		g.epb(ifId, ts, genData(i, size), uint32(size))
+	}
+}
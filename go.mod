@@ -1,3 +1,3 @@
 module github.com/Sojamann/pcapreader
 
-go 1.18
+go 1.23
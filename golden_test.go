@@ -0,0 +1,310 @@
+package pcapreader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// go test -run Golden -update rewrites the golden files
+// with what the readers return now. Look at the diff!
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// all the captures the golden files are made of. The
+// name of a capture is the name of its golden file.
+var goldenCaptures = map[string]func() []byte{
+	"pcap_le_micro": func() []byte {
+		return genPcap(genPcapOpts{order: binary.LittleEndian, snaplen: 65535, packets: 16})
+	},
+	"pcap_be_micro": func() []byte {
+		return genPcap(genPcapOpts{order: binary.BigEndian, snaplen: 65535, packets: 16})
+	},
+	"pcap_le_nano": func() []byte {
+		return genPcap(genPcapOpts{order: binary.LittleEndian, nano: true, snaplen: 65535, packets: 16})
+	},
+	"pcap_be_nano": func() []byte {
+		return genPcap(genPcapOpts{order: binary.BigEndian, nano: true, snaplen: 65535, packets: 16})
+	},
+	"pcap_snaplen": func() []byte {
+		return genPcap(genPcapOpts{order: binary.LittleEndian, snaplen: 62, packets: 16})
+	},
+	"pcap_cut_in_header": func() []byte {
+		data := genPcap(genPcapOpts{order: binary.LittleEndian, snaplen: 65535, packets: 4})
+		return data[:len(data)-1514-8]
+	},
+	"pcap_cut_in_data": func() []byte {
+		data := genPcap(genPcapOpts{order: binary.BigEndian, snaplen: 65535, packets: 4})
+		return data[:len(data)-10]
+	},
+	"pcap_cut_in_file_header": func() []byte {
+		return genPcap(genPcapOpts{order: binary.LittleEndian, snaplen: 65535})[:20]
+	},
+	"pcap_only_file_header": func() []byte {
+		return genPcap(genPcapOpts{order: binary.LittleEndian, snaplen: 65535})
+	},
+	"pcapng_le": func() []byte {
+		g := &genNg{}
+		g.shb(binary.LittleEndian, 1, 0)
+		g.idb(1, 65535)
+		g.packets(0, 1e6, 0, 16)
+		return g.b.Bytes()
+	},
+	"pcapng_be": func() []byte {
+		g := &genNg{}
+		g.shb(binary.BigEndian, 1, 0)
+		g.idb(1, 65535)
+		g.packets(0, 1e6, 0, 16)
+		return g.b.Bytes()
+	},
+	// sections in both byte orders one after the other
+	// with different resolutions
+	"pcapng_sections": func() []byte {
+		g := &genNg{}
+		g.shb(binary.LittleEndian, 1, 0)
+		g.idb(1, 65535)
+		g.packets(0, 1e6, 0, 4)
+		g.shb(binary.BigEndian, 1, 0)
+		g.idb(1, 65535, genTsresol(9))
+		g.packets(0, 1e9, 4, 4)
+		g.shb(binary.LittleEndian, 1, 2)
+		g.idb(1, 65535, genTsresol(3))
+		g.packets(0, 1e3, 8, 4)
+		return g.b.Bytes()
+	},
+	// sections of a version that cannot be read are left out
+	"pcapng_unsupported_section": func() []byte {
+		g := &genNg{}
+		g.shb(binary.LittleEndian, 1, 0)
+		g.idb(1, 65535)
+		g.packets(0, 1e6, 0, 4)
+		g.shb(binary.LittleEndian, 2, 0)
+		g.idb(1, 65535)
+		g.packets(0, 1e6, 4, 4)
+		g.shb(binary.BigEndian, 1, 0)
+		g.idb(1, 65535)
+		g.packets(0, 1e6, 8, 4)
+		return g.b.Bytes()
+	},
+	// the packets of interfaces with another link type are not read
+	"pcapng_interfaces": func() []byte {
+		g := &genNg{}
+		g.shb(binary.LittleEndian, 1, 0)
+		g.idb(1, 65535, genOption{2, []byte("eth0")})
+		g.idb(105, 65535, genOption{2, []byte("wlan0")})
+		for i := 0; i < 16; i++ {
+			size := genSizes[i%len(genSizes)]
+			ts := uint64(genStart.Unix())*1e6 + uint64(i)*1000
+			g.epb(uint32(i%2), ts, genData(i, size), uint32(size))
+		}
+		return g.b.Bytes()
+	},
+	// only the first one of two interfaces with the same
+	// link type is read, as their names differ
+	"pcapng_same_link_type": func() []byte {
+		g := &genNg{}
+		g.shb(binary.LittleEndian, 1, 0)
+		g.idb(1, 65535, genOption{2, []byte("eth0")})
+		g.idb(1, 62, genOption{2, []byte("eth1")}, genTsresol(9))
+		for i := 0; i < 16; i++ {
+			size := genSizes[i%len(genSizes)]
+			if i%2 == 0 {
+				g.epb(0, uint64(genStart.Unix())*1e6+uint64(i)*1000, genData(i, size), uint32(size))
+				continue
+			}
+			g.epb(1, uint64(genStart.Unix())*1e9+uint64(i)*1e6, genData(i, min(size, 62)), uint32(size))
+		}
+		return g.b.Bytes()
+	},
+	// read with ReaderOptions.AllInterfaces, see goldenOptions
+	"pcapng_all_interfaces": func() []byte {
+		g := &genNg{}
+		g.shb(binary.LittleEndian, 1, 0)
+		g.idb(1, 65535, genOption{2, []byte("eth0")})
+		g.idb(101, 0, genOption{2, []byte("tun0")}, genTsresol(9))
+		g.idb(1, 128, genOption{2, []byte("eth1")})
+		for i := 0; i < 12; i++ {
+			size := genSizes[i%len(genSizes)]
+			switch i % 3 {
+			case 0:
+				g.epb(0, uint64(genStart.Unix())*1e6+uint64(i)*1000, genData(i, size), uint32(size))
+			case 1:
+				g.epb(1, uint64(genStart.Unix())*1e9+uint64(i)*1e6, genData(i, size), uint32(size))
+			case 2:
+				g.epb(2, uint64(genStart.Unix())*1e6+uint64(i)*1000, genData(i, min(size, 128)), uint32(size))
+			}
+		}
+		// a new section starts without interfaces
+		g.shb(binary.BigEndian, 1, 0)
+		g.idb(101, 0)
+		g.epb(0, uint64(genStart.Unix())*1e6, genData(12, 60), 60)
+		return g.b.Bytes()
+	},
+	"pcapng_spb_epb": func() []byte {
+		g := &genNg{}
+		g.shb(binary.BigEndian, 1, 0)
+		g.idb(1, 62)
+		for i := 0; i < 16; i++ {
+			size := genSizes[i%len(genSizes)]
+			data := genData(i, min(size, 62))
+			if i%3 == 0 {
+				g.spb(data, uint32(size))
+				continue
+			}
+			ts := uint64(genStart.Unix())*1e6 + uint64(i)*1000
+			g.epb(0, ts, data, uint32(size), genOption{1, []byte("a comment")})
+		}
+		return g.b.Bytes()
+	},
+	// resolutions of whole seconds, powers of two and finer than nanoseconds
+	"pcapng_tsresol": func() []byte {
+		g := &genNg{}
+		for i, resol := range []byte{0, 1, 6, 9, 12, 19, 0x80, 0x8A, 0x9E, 0xBF} {
+			g.shb(binary.LittleEndian, 1, 0)
+			g.idb(1, 65535, genTsresol(resol))
+			perSecond := uint64(1)
+			if resol&0x80 != 0 {
+				perSecond <<= resol & 0x7F
+			} else {
+				for j := byte(0); j < resol; j++ {
+					perSecond *= 10
+				}
+			}
+			// one second and a bit after the start, the bit being as
+			// small as it can be, and the last unit before the next second.
+			// Resolutions too fine for the start to fit in start at 1970.
+			ts := (uint64(genStart.Unix())+1)%(math.MaxUint64/perSecond)*perSecond + 1
+			g.epb(0, ts, genData(i, 60), 60)
+			g.epb(0, ts+perSecond-2, genData(i, 60), 60)
+		}
+		return g.b.Bytes()
+	},
+	"pcapng_tsoffset": func() []byte {
+		g := &genNg{}
+		g.shb(binary.LittleEndian, 1, 0)
+		offset := make([]byte, 8)
+		binary.LittleEndian.PutUint64(offset, uint64(genStart.Unix()))
+		g.idb(1, 65535, genOption{14, offset}, genTsresol(3))
+		for i := 0; i < 4; i++ {
+			g.epb(0, uint64(i)*1500, genData(i, 60), 60)
+		}
+		return g.b.Bytes()
+	},
+	// blocks the readers do not know about are skipped
+	"pcapng_other_blocks": func() []byte {
+		g := &genNg{}
+		g.shb(binary.LittleEndian, 1, 0)
+		g.block(0x00000004, []byte{0, 0, 0, 0}) // NRB
+		g.idb(1, 65535)
+		g.packets(0, 1e6, 0, 2)
+		g.block(ngISB, make([]byte, 12))
+		g.block(0x00000BAD, []byte{1, 2, 3, 4, 5, 6, 7, 8}) // custom block
+		g.packets(0, 1e6, 2, 2)
+		return g.b.Bytes()
+	},
+	"pcapng_cut_in_block": func() []byte {
+		g := &genNg{}
+		g.shb(binary.LittleEndian, 1, 0)
+		g.idb(1, 65535)
+		g.packets(0, 1e6, 0, 4)
+		return g.b.Bytes()[:g.b.Len()-30]
+	},
+	"pcapng_cut_in_shb": func() []byte {
+		g := &genNg{}
+		g.shb(binary.BigEndian, 1, 0)
+		return g.b.Bytes()[:20]
+	},
+	"pcapng_bad_block_end": func() []byte {
+		g := &genNg{}
+		g.shb(binary.LittleEndian, 1, 0)
+		g.idb(1, 65535)
+		g.packets(0, 1e6, 0, 4)
+		data := g.b.Bytes()
+		data[len(data)-1] = 0xFF
+		return data
+	},
+	"empty": func() []byte {
+		return nil
+	},
+}
+
+// the captures that are not read with the zero ReaderOptions
+var goldenOptions = map[string]ReaderOptions{
+	"pcapng_all_interfaces": {AllInterfaces: true},
+}
+
+// what the golden files hold, everything a reader returns
+func goldenOutput(traffic Traffic, err error) string {
+	var b strings.Builder
+	if err != nil {
+		fmt.Fprintf(&b, "open: %v\n", err)
+		return b.String()
+	}
+	defer traffic.Stop()
+
+	for {
+		info, packet, err := traffic.Next()
+		if err == io.EOF {
+			b.WriteString("EOF\n")
+			return b.String()
+		}
+		if err != nil {
+			fmt.Fprintf(&b, "error: %v\n", err)
+			return b.String()
+		}
+		fmt.Fprintf(&b, "%s %d %d %d %08x\n",
+			info.CaptureTime.UTC().Format("2006-01-02T15:04:05.000000000"),
+			info.Size, len(packet), InterfaceOf(traffic).LinkLayerType, crc32.ChecksumIEEE(packet))
+	}
+}
+
+// the captures of a file in memory and
+// a stream have to be read the same way
+func goldenRead(t *testing.T, data []byte, opts ReaderOptions) string {
+	fromStream := goldenOutput(OpenReaderWithOptions(io.NopCloser(bytes.NewReader(data)), opts))
+
+	var mem Traffic
+	var err error
+	if len(data) >= 4 && binary.BigEndian.Uint32(data) == ngSHB {
+		mem, err = readPcapNg(&memorySource{data: data}, opts)
+	} else {
+		mem, err = readPcap(&memorySource{data: data}, opts)
+	}
+	if fromMemory := goldenOutput(mem, err); fromMemory != fromStream {
+		t.Errorf("reading from memory and from a stream differs\nmemory:\n%s\nstream:\n%s", fromMemory, fromStream)
+	}
+	return fromStream
+}
+
+func goldenCompare(t *testing.T, name string, got string) {
+	path := filepath.Join("testdata", "golden", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v, run with -update to create it", err)
+	}
+	if got != string(want) {
+		t.Errorf("output differs from %s\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	for name, capture := range goldenCaptures {
+		t.Run(name, func(t *testing.T) {
+			goldenCompare(t, name, goldenRead(t, capture(), goldenOptions[name]))
+		})
+	}
+}
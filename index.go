@@ -0,0 +1,604 @@
+package pcapreader
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// every how many packets the capture time is remembered
+// so that seeking by time only has to read a few packets
+const indexCheckpointInterval = 1024
+
+// sidecar files start with this and a version
+// so that we do not read garbage
+const indexMagic uint32 = 0x58495250 // "PRIX"
+const indexVersion uint16 = 1
+
+var (
+	ErrNotIndexable     = errors.New("traffic source cannot be indexed")
+	ErrMalformedIndex   = errors.New("index is malformed")
+	ErrIndexMismatch    = errors.New("index does not belong to the capture")
+	ErrPacketOutOfRange = errors.New("packet number is out of range")
+)
+
+type captureFormat uint16
+
+const (
+	formatPcap captureFormat = iota + 1
+	formatPcapNg
+)
+
+// the capture time of every indexCheckpointInterval'th packet
+type timeCheckpoint struct {
+	packet uint64
+	time   int64 // unix nanoseconds
+}
+
+// the pcapng reader state that is valid from a packet on
+type ngStateChange struct {
+	packet uint64
+	state  ngSectionState
+}
+
+// Index knows where every packet record of a capture starts
+// so that a SeekableTraffic can jump to a packet number or to a
+// point in time without reading everything before it.
+// An Index is created by BuildIndex and can be saved next
+// to the capture with WriteTo and loaded again with ReadIndex.
+type Index struct {
+	format captureFormat
+	// the amount of bytes that have been read to build the
+	// index. This is used to tell if the index is stale.
+	size        int64
+	offsets     []int64
+	checkpoints []timeCheckpoint
+	// only for pcapngs. The reader needs to know what
+	// the SHB and IDBs said before a packet block can be read.
+	ngStates []ngStateChange
+	// whether the pcapng has been read with
+	// ReaderOptions.AllInterfaces, which the reader has
+	// to do as well for the offsets to be those of packets
+	allInterfaces bool
+}
+
+// Returns the amount of packets in the indexed capture
+func (ix *Index) Len() uint64 {
+	return uint64(len(ix.offsets))
+}
+
+// Returns the byte offset of the record of packet n
+func (ix *Index) Offset(n uint64) (int64, error) {
+	if n >= ix.Len() {
+		return 0, ErrPacketOutOfRange
+	}
+	return ix.offsets[n], nil
+}
+
+func (ix *Index) ngStateAt(n uint64) ngSectionState {
+	// the first change that is after n and the one before
+	// that is the one that applies
+	i := sort.Search(len(ix.ngStates), func(i int) bool {
+		return ix.ngStates[i].packet > n
+	})
+	return ix.ngStates[i-1].state
+}
+
+// Reads all the packets of t and records where they are.
+// t must come from OpenFile and must not have been read yet.
+// t is exhausted afterwards and should be stopped by the caller.
+func BuildIndex(t Traffic) (*Index, error) {
+	ix := &Index{}
+
+	var recordOffset func() int64
+	var offset func() int64
+	switch r := t.(type) {
+	case *pcap:
+		ix.format = formatPcap
+		recordOffset = func() int64 { return r.recordOffset }
+		offset = r.src.offset
+	case *pcapng:
+		ix.format = formatPcapNg
+		ix.allInterfaces = r.opts.AllInterfaces
+		recordOffset = func() int64 { return r.blockOffset }
+		offset = r.src.offset
+	default:
+		return nil, ErrNotIndexable
+	}
+
+	for n := uint64(0); ; n++ {
+		info, _, err := t.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		ix.offsets = append(ix.offsets, recordOffset())
+		if n%indexCheckpointInterval == 0 {
+			ix.checkpoints = append(ix.checkpoints, timeCheckpoint{
+				packet: n,
+				time:   info.CaptureTime.UnixNano(),
+			})
+		}
+
+		if ng, ok := t.(*pcapng); ok {
+			last := len(ix.ngStates) - 1
+			if last < 0 || !ix.ngStates[last].state.equal(&ng.ngSectionState) {
+				ix.ngStates = append(ix.ngStates, ngStateChange{
+					packet: n,
+					state:  ng.ngSectionState,
+				})
+			}
+		}
+	}
+	ix.size = offset()
+
+	return ix, nil
+}
+
+/* Persistence */
+
+// how a ngSectionState is laid out in a sidecar file.
+// It is followed by a record for each of its interfaces.
+type ngStateRecord struct {
+	Packet        uint64
+	BigEndian     uint8
+	SectionLen    uint64
+	SectionStart  int64
+	LinkLayerType uint32
+	IfNameHash    uint16
+	IfId          uint32
+	Interfaces    uint32
+}
+
+type ngInterfaceRecord struct {
+	Accepted      uint8
+	LinkLayerType uint32
+	Snaplen       uint32
+	SecondMask    uint64
+	TimeZone      int32
+	TimeOffset    uint64
+}
+
+type indexHeader struct {
+	Magic       uint32
+	Version     uint16
+	Format      uint16
+	Size        int64
+	Packets     uint64
+	Checkpoints uint64
+	NgStates    uint64
+	// indexFlagAllInterfaces
+	Flags uint16
+}
+
+const indexFlagAllInterfaces uint16 = 1
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// Writes the index in a compact binary form. The offsets
+// are stored as deltas so they usually take 2 or 3 bytes each.
+func (ix *Index) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	var flags uint16
+	if ix.allInterfaces {
+		flags |= indexFlagAllInterfaces
+	}
+
+	err := binary.Write(bw, binary.LittleEndian, indexHeader{
+		Magic:       indexMagic,
+		Version:     indexVersion,
+		Format:      uint16(ix.format),
+		Size:        ix.size,
+		Packets:     uint64(len(ix.offsets)),
+		Checkpoints: uint64(len(ix.checkpoints)),
+		NgStates:    uint64(len(ix.ngStates)),
+		Flags:       flags,
+	})
+	if err != nil {
+		return cw.n, err
+	}
+
+	buff := make([]byte, binary.MaxVarintLen64)
+	previous := int64(0)
+	for _, offset := range ix.offsets {
+		n := binary.PutVarint(buff, offset-previous)
+		bw.Write(buff[:n])
+		previous = offset
+	}
+
+	for _, cp := range ix.checkpoints {
+		n := binary.PutUvarint(buff, cp.packet)
+		bw.Write(buff[:n])
+		n = binary.PutVarint(buff, cp.time)
+		bw.Write(buff[:n])
+	}
+
+	for _, change := range ix.ngStates {
+		s := change.state
+		record := ngStateRecord{
+			Packet:        change.packet,
+			SectionLen:    s.sectionLen,
+			SectionStart:  s.sectionStart,
+			LinkLayerType: uint32(s.linkLayerType),
+			IfNameHash:    s.ifNameHash,
+			IfId:          s.ifId,
+			Interfaces:    uint32(len(s.interfaces)),
+		}
+		if s.byteOrder == binary.BigEndian {
+			record.BigEndian = 1
+		}
+		binary.Write(bw, binary.LittleEndian, record)
+
+		for _, iface := range s.interfaces {
+			ifRecord := ngInterfaceRecord{
+				LinkLayerType: uint32(iface.linkLayerType),
+				Snaplen:       iface.snaplen,
+				SecondMask:    iface.secondMask,
+				TimeZone:      iface.timeZone,
+				TimeOffset:    iface.timeOffset,
+			}
+			if iface.accepted {
+				ifRecord.Accepted = 1
+			}
+			binary.Write(bw, binary.LittleEndian, ifRecord)
+		}
+	}
+
+	// the buffered writer keeps the first error
+	// so checking here is enough
+	err = bw.Flush()
+	return cw.n, err
+}
+
+// Reads an index that has been written by Index.WriteTo
+func ReadIndex(r io.Reader) (*Index, error) {
+	br := bufio.NewReader(r)
+
+	var header indexHeader
+	err := binary.Read(br, binary.LittleEndian, &header)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, ErrMalformedIndex
+	}
+	if err != nil {
+		return nil, err
+	}
+	if header.Magic != indexMagic || header.Version != indexVersion {
+		return nil, ErrMalformedIndex
+	}
+
+	ix := &Index{
+		format:        captureFormat(header.Format),
+		size:          header.Size,
+		allInterfaces: header.Flags&indexFlagAllInterfaces != 0,
+	}
+	if ix.format != formatPcap && ix.format != formatPcapNg {
+		return nil, ErrMalformedIndex
+	}
+	// a pcapng index can only be used when the reader state is known
+	if ix.format == formatPcapNg && header.Packets > 0 && header.NgStates == 0 {
+		return nil, ErrMalformedIndex
+	}
+
+	// dont trust the counts for the allocation, a broken
+	// file would otherwise make us allocate arbitrary amounts
+	offset := int64(0)
+	for i := uint64(0); i < header.Packets; i++ {
+		delta, err := binary.ReadVarint(br)
+		if err != nil {
+			return nil, ErrMalformedIndex
+		}
+		offset += delta
+		ix.offsets = append(ix.offsets, offset)
+	}
+
+	for i := uint64(0); i < header.Checkpoints; i++ {
+		packet, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, ErrMalformedIndex
+		}
+		t, err := binary.ReadVarint(br)
+		if err != nil {
+			return nil, ErrMalformedIndex
+		}
+		ix.checkpoints = append(ix.checkpoints, timeCheckpoint{packet: packet, time: t})
+	}
+
+	for i := uint64(0); i < header.NgStates; i++ {
+		var record ngStateRecord
+		if err := binary.Read(br, binary.LittleEndian, &record); err != nil {
+			return nil, ErrMalformedIndex
+		}
+		state := ngSectionState{
+			byteOrder:     binary.LittleEndian,
+			sectionLen:    record.SectionLen,
+			sectionStart:  record.SectionStart,
+			linkLayerType: LinkLayerType(record.LinkLayerType),
+			ifNameHash:    record.IfNameHash,
+			ifId:          record.IfId,
+		}
+		if record.BigEndian == 1 {
+			state.byteOrder = binary.BigEndian
+		}
+
+		// the amount is not trusted for allocating, a broken
+		// one runs out of records to read soon enough
+		for j := uint32(0); j < record.Interfaces; j++ {
+			var ifRecord ngInterfaceRecord
+			if err := binary.Read(br, binary.LittleEndian, &ifRecord); err != nil {
+				return nil, ErrMalformedIndex
+			}
+			// a timestamp cannot be read without a resolution
+			if ifRecord.Accepted == 1 && ifRecord.SecondMask == 0 {
+				return nil, ErrMalformedIndex
+			}
+			state.interfaces = append(state.interfaces, ngInterface{
+				accepted:      ifRecord.Accepted == 1,
+				linkLayerType: LinkLayerType(ifRecord.LinkLayerType),
+				snaplen:       ifRecord.Snaplen,
+				secondMask:    ifRecord.SecondMask,
+				timeZone:      ifRecord.TimeZone,
+				timeOffset:    ifRecord.TimeOffset,
+			})
+		}
+		ix.ngStates = append(ix.ngStates, ngStateChange{packet: record.Packet, state: state})
+	}
+	if len(ix.ngStates) > 0 && ix.ngStates[0].packet != 0 {
+		return nil, ErrMalformedIndex
+	}
+
+	return ix, nil
+}
+
+/* Seeking */
+
+// SeekableTraffic is Traffic whose position can be changed.
+// Reaching the end does not stop it, so it is possible
+// to seek back after Next returned io.EOF.
+type SeekableTraffic interface {
+	Traffic
+
+	// Positions the traffic so that the next call to Next
+	// returns packet n (counting from 0). Seeking to the
+	// amount of packets positions it at the end.
+	SeekPacket(n uint64) error
+
+	// Positions the traffic so that the next call to Next
+	// returns the first packet that was not captured before t.
+	// This assumes the packets to be roughly ordered by time,
+	// which is the case for captures of a single recording.
+	SeekTime(t time.Time) error
+}
+
+// lets the reader stop without closing the underlying
+// file, as we might want to seek back after reaching the end
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error {
+	return nil
+}
+
+type indexedTraffic struct {
+	Traffic
+	ix *Index
+	rs io.ReadSeeker
+	// number of the packet that Next will return
+	packet uint64
+	dead   bool
+}
+
+// Reads the capture in r using an index which has been
+// built for it. If r is an io.Closer it is closed by Stop.
+func OpenIndexed(r io.ReadSeeker, ix *Index) (SeekableTraffic, error) {
+	// an index for a different capture would make
+	// us read garbage, the size tells that cheaply
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if size != ix.size {
+		return nil, ErrIndexMismatch
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var t Traffic
+	switch ix.format {
+	case formatPcap:
+		t, err = readPcap(newReaderSource(nopSeekCloser{r}), ReaderOptions{})
+	case formatPcapNg:
+		t, err = readPcapNg(newReaderSource(nopSeekCloser{r}), ReaderOptions{AllInterfaces: ix.allInterfaces})
+	default:
+		err = ErrMalformedIndex
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &indexedTraffic{Traffic: t, ix: ix, rs: r}, nil
+}
+
+// Opens the capture like OpenFile does but with the index that is
+// stored next to it in name + ".idx". When there is no index or it is
+// outdated, a new one is built and saved there if that is possible.
+func OpenFileIndexed(name string) (SeekableTraffic, error) {
+	stat, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ix, err := readIndexFile(name + ".idx")
+	if err != nil || ix.size != stat.Size() {
+		t, err := OpenFile(name)
+		if err != nil {
+			return nil, err
+		}
+		ix, err = BuildIndex(t)
+		t.Stop()
+		if err != nil {
+			return nil, err
+		}
+
+		// not being able to save the index is not
+		// a reason to fail, it is just slower next time
+		writeIndexFile(name+".idx", ix)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	st, err := OpenIndexed(f, ix)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return st, nil
+}
+
+func readIndexFile(name string) (*Index, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadIndex(f)
+}
+
+func writeIndexFile(name string, ix *Index) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = ix.WriteTo(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name)
+	}
+	return err
+}
+
+func (t *indexedTraffic) Next() (*PacketInfo, Packet, error) {
+	if t.dead {
+		return nil, nil, ErrTrafficSourceAlreadyStopped
+	}
+
+	info, packet, err := t.Traffic.Next()
+	if err == nil {
+		t.packet++
+	}
+	return info, packet, err
+}
+
+func (t *indexedTraffic) SeekPacket(n uint64) error {
+	if t.dead {
+		return ErrTrafficSourceAlreadyStopped
+	}
+	if n > t.ix.Len() {
+		return ErrPacketOutOfRange
+	}
+
+	offset := t.ix.size
+	if n < t.ix.Len() {
+		offset = t.ix.offsets[n]
+	}
+	switch r := t.Traffic.(type) {
+	case *pcap:
+		if err := r.src.seek(offset); err != nil {
+			return err
+		}
+		r.packets = n
+		// recovering must not compare the packets after
+		// the seek to the time of the one read before it
+		r.haveLastSecs = false
+		r.pending = pendingPacket{}
+		r.dead = false
+	case *pcapng:
+		if err := r.src.seek(offset); err != nil {
+			return err
+		}
+		if len(t.ix.ngStates) > 0 {
+			r.ngSectionState = t.ix.ngStateAt(n)
+		}
+		r.readState = ngRSBlockType
+		// the blocks before are not known
+		r.block = -1
+		r.packets = n
+		r.ignoringSection = false
+		r.packetRead = false
+		r.pending = pendingPacket{}
+		r.dead = false
+	}
+	t.packet = n
+
+	return nil
+}
+
+func (t *indexedTraffic) SeekTime(ts time.Time) error {
+	if t.dead {
+		return ErrTrafficSourceAlreadyStopped
+	}
+
+	// the last checkpoint before ts is where we start looking
+	cps := t.ix.checkpoints
+	i := sort.Search(len(cps), func(i int) bool {
+		return cps[i].time >= ts.UnixNano()
+	})
+	start := uint64(0)
+	if i > 0 {
+		start = cps[i-1].packet
+	}
+
+	if err := t.SeekPacket(start); err != nil {
+		return err
+	}
+	for n := start; ; n++ {
+		info, _, err := t.Next()
+		if err == io.EOF {
+			return t.SeekPacket(t.ix.Len())
+		}
+		if err != nil {
+			return err
+		}
+		if !info.CaptureTime.Before(ts) {
+			return t.SeekPacket(n)
+		}
+	}
+}
+
+func (t *indexedTraffic) setContext(ctx context.Context) {
+	setContext(t.Traffic, ctx)
+}
+
+func (t *indexedTraffic) Stop() {
+	if !t.dead {
+		t.Traffic.Stop()
+		if closer, ok := t.rs.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+	t.dead = true
+}
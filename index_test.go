@@ -0,0 +1,303 @@
+package pcapreader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// captures with enough packets to have several checkpoints
+var indexCaptures = map[string]func() []byte{
+	"capture.pcap": func() []byte {
+		return genPcap(genPcapOpts{order: binary.LittleEndian, nano: true, snaplen: 0xFFFF, packets: 2500})
+	},
+	// a second section in a different byte order and with
+	// another interface changes the state of the reader
+	"capture.pcapng": func() []byte {
+		var g genNg
+		g.shb(binary.LittleEndian, 1, 0)
+		g.idb(1, 0)
+		g.packets(0, 1e6, 0, 1500)
+		g.shb(binary.BigEndian, 1, 0)
+		g.idb(1, 128, genTsresol(9))
+		g.packets(0, 1e9, 1500, 1000)
+		return g.b.Bytes()
+	},
+}
+
+type indexedPacket struct {
+	info PacketInfo
+	data []byte
+}
+
+// all the packets of t in the order they are read
+func indexedPackets(t *testing.T, traffic Traffic) []indexedPacket {
+	t.Helper()
+	var packets []indexedPacket
+	for {
+		info, packet, err := traffic.Next()
+		if err == io.EOF {
+			return packets
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, indexedPacket{*info, append([]byte(nil), packet...)})
+	}
+}
+
+// the next packet of traffic has to be packets[n]
+func expectPacket(t *testing.T, traffic Traffic, packets []indexedPacket, n int) {
+	t.Helper()
+	info, packet, err := traffic.Next()
+	if n == len(packets) {
+		if err != io.EOF {
+			t.Errorf("%v instead of EOF", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("packet %d: %v", n, err)
+	}
+	want := packets[n]
+	if !info.CaptureTime.Equal(want.info.CaptureTime) || info.Size != want.info.Size || !bytes.Equal(packet, want.data) {
+		t.Errorf("packet %d differs", n)
+	}
+}
+
+func indexOf(t *testing.T, open func(string) (Traffic, error), name string) *Index {
+	t.Helper()
+	traffic, err := open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer traffic.Stop()
+	ix, err := BuildIndex(traffic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ix
+}
+
+// hides the reader it wraps, like any traffic not read from a file
+type wrappedTraffic struct {
+	Traffic
+}
+
+func TestIndex(t *testing.T) {
+	for name, capture := range indexCaptures {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			if err := os.WriteFile(path, capture(), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			traffic, err := OpenFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			packets := indexedPackets(t, traffic)
+			traffic.Stop()
+
+			ix := indexOf(t, OpenFile, path)
+			if ix.Len() != uint64(len(packets)) || len(ix.checkpoints) != 3 {
+				t.Fatalf("%d packets and %d checkpoints indexed", ix.Len(), len(ix.checkpoints))
+			}
+			if mapped := indexOf(t, OpenFileMapped, path); !reflect.DeepEqual(mapped, ix) {
+				t.Error("the index of a mapped file differs")
+			}
+
+			var b bytes.Buffer
+			if _, err := ix.WriteTo(&b); err != nil {
+				t.Fatal(err)
+			}
+			read, err := ReadIndex(&b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(read, ix) {
+				t.Error("the index differs after reading it back")
+			}
+
+			st, err := OpenFileIndexed(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer st.Stop()
+			if saved, err := readIndexFile(path + ".idx"); err != nil || !reflect.DeepEqual(saved, ix) {
+				t.Errorf("the sidecar has not been saved: %v", err)
+			}
+
+			for _, n := range []int{1499, 0, 1500, len(packets) - 1, 2047, len(packets)} {
+				if err := st.SeekPacket(uint64(n)); err != nil {
+					t.Fatal(err)
+				}
+				expectPacket(t, st, packets, n)
+			}
+			// the end does not stop the traffic
+			if err := st.SeekPacket(1); err != nil {
+				t.Fatal(err)
+			}
+			expectPacket(t, st, packets, 1)
+			if err := st.SeekPacket(uint64(len(packets) + 1)); err != ErrPacketOutOfRange {
+				t.Errorf("%v seeking beyond the end", err)
+			}
+
+			for _, n := range []int{1025, 0, 2400, 1500} {
+				if err := st.SeekTime(packets[n].info.CaptureTime); err != nil {
+					t.Fatal(err)
+				}
+				expectPacket(t, st, packets, n)
+				// in between two packets is the later one
+				if err := st.SeekTime(packets[n].info.CaptureTime.Add(-time.Nanosecond)); err != nil {
+					t.Fatal(err)
+				}
+				expectPacket(t, st, packets, n)
+			}
+			if err := st.SeekTime(packets[len(packets)-1].info.CaptureTime.Add(time.Second)); err != nil {
+				t.Fatal(err)
+			}
+			expectPacket(t, st, packets, len(packets))
+
+			st.Stop()
+			if err := st.SeekPacket(0); err != ErrTrafficSourceAlreadyStopped {
+				t.Errorf("%v seeking a stopped traffic", err)
+			}
+		})
+	}
+}
+
+func TestIndexStale(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "capture.pcap")
+	data := genPcap(genPcapOpts{order: binary.LittleEndian, snaplen: 0xFFFF, packets: 10})
+	// without the last packet
+	if err := os.WriteFile(path, data[:len(data)-16-len(genData(9, genSizes[9%len(genSizes)]))], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	st, err := OpenFileIndexed(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Stop()
+
+	// the capture grew, so the sidecar has to be built again
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	st, err = OpenFileIndexed(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Stop()
+	if err := st.SeekPacket(9); err != nil {
+		t.Fatal(err)
+	}
+	if _, packet, err := st.Next(); err != nil || !bytes.Equal(packet, genData(9, genSizes[9%len(genSizes)])) {
+		t.Errorf("last packet %v", err)
+	}
+	if ix, err := readIndexFile(path + ".idx"); err != nil || ix.Len() != 10 {
+		t.Errorf("the sidecar has not been replaced: %v", err)
+	}
+
+	// an index of another capture is refused
+	other := filepath.Join(dir, "other.pcap")
+	if err := os.WriteFile(other, genPcap(genPcapOpts{order: binary.BigEndian, snaplen: 0xFFFF, packets: 3}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ix := indexOf(t, OpenFile, other)
+	if _, err := OpenIndexed(bytes.NewReader(data), ix); err != ErrIndexMismatch {
+		t.Errorf("%v with the index of another capture", err)
+	}
+}
+
+func TestReadIndexMalformed(t *testing.T) {
+	var g genNg
+	g.shb(binary.LittleEndian, 1, 0)
+	g.idb(1, 0)
+	g.packets(0, 1e6, 0, 3)
+	traffic, err := readPcapNg(&memorySource{data: g.b.Bytes()}, ReaderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix, err := BuildIndex(traffic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	ix.WriteTo(&b)
+	valid := b.Bytes()
+
+	// another version might be laid out differently
+	otherVersion := append([]byte(nil), valid...)
+	binary.LittleEndian.PutUint16(otherVersion[4:], indexVersion+1)
+	// a pcapng index without the state of the reader
+	noStates := append([]byte(nil), valid...)
+	binary.LittleEndian.PutUint64(noStates[6+2+8+8+8:], 0)
+
+	for name, data := range map[string][]byte{
+		"empty":         nil,
+		"garbage":       []byte("not an index at all, just some text"),
+		"other version": otherVersion,
+		"no states":     noStates,
+		"truncated":     valid[:len(valid)-1],
+	} {
+		if _, err := ReadIndex(bytes.NewReader(data)); !errors.Is(err, ErrMalformedIndex) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	if _, err := BuildIndex(wrappedTraffic{traffic}); err != ErrNotIndexable {
+		t.Errorf("%v indexing a wrapped traffic", err)
+	}
+}
+
+// a seek forgets the time of the packet that has been read before,
+// which recovering would compare the packets after the seek to
+func TestIndexSeekRecover(t *testing.T) {
+	var b bytes.Buffer
+	w := NewPcapWriter(&b)
+	// further apart than recovering looks back
+	for i := 0; i < 8; i++ {
+		info := &PacketInfo{CaptureTime: genStart.Add(time.Duration(i) * 2 * time.Hour), Size: 60}
+		if err := w.WritePacket(Interface{LinkLayerType: 1}, info, genData(i, 60)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Flush()
+	data := b.Bytes()
+
+	traffic, err := readPcap(&memorySource{data: data}, ReaderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix, err := BuildIndex(traffic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the captured length of packet 2 is beyond the snaplen
+	offset, err := ix.Offset(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	traffic, err = readPcap(&memorySource{data: corrupt(data, int(offset)+8, 0x7FFFFFFF)}, ReaderOptions{Recover: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &indexedTraffic{Traffic: traffic, ix: ix}
+	defer st.Stop()
+
+	for _, c := range []struct{ seek, want int }{{6, 6}, {2, 3}} {
+		if err := st.SeekPacket(uint64(c.seek)); err != nil {
+			t.Fatal(err)
+		}
+		if _, packet, err := st.Next(); err != nil || !bytes.Equal(packet, genData(c.want, 60)) {
+			t.Errorf("packet %d is not the first after seeking to %d: %v", c.want, c.seek, err)
+		}
+	}
+}
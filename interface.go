@@ -0,0 +1,71 @@
+package pcapreader
+
+// Interface describes what a packet has been captured on.
+// A pcap has just the one, a pcapng has one per IDB.
+type Interface struct {
+	// the capture the packet came from when captures are merged,
+	// counted from 0 in the order they were given. Always 0 otherwise.
+	Source int `json:"source"`
+	// the section the interface belongs to, counted from 0 from
+	// where reading started. Always 0 for pcaps.
+	Section int `json:"section"`
+	// the id of the interface within its section, which is
+	// what the EPBs refer to. Always 0 for pcaps.
+	ID uint32 `json:"id"`
+
+	LinkLayerType LinkLayerType `json:"link_layer_type"`
+	// the largest packet that was captured as a whole,
+	// 0 when there is no limit
+	Snaplen uint32 `json:"snaplen"`
+}
+
+// InterfaceTraffic is Traffic which can tell which interface
+// a packet has been captured on. The readers of this package
+// implement it.
+type InterfaceTraffic interface {
+	Traffic
+
+	// Returns the interface the packet returned
+	// by the last call to Next was captured on
+	Interface() Interface
+}
+
+// Returns the interface of the last packet of t. For Traffic
+// that cannot tell, it is the first one with t's LinkLayerType.
+func InterfaceOf(t Traffic) Interface {
+	if it, ok := t.(InterfaceTraffic); ok {
+		return it.Interface()
+	}
+	return Interface{LinkLayerType: t.LinkLayerType()}
+}
+
+func (p *pcap) Interface() Interface {
+	return Interface{LinkLayerType: p.llt, Snaplen: p.snaplen}
+}
+
+func (p *pcapng) Interface() Interface {
+	return Interface{
+		Section:       max(int(p.sections)-1, 0),
+		ID:            p.packetIfId,
+		LinkLayerType: p.packetLinkLayerType(),
+		Snaplen:       p.packetSnaplen(),
+	}
+}
+
+// the link layer type of the interface of the last packet, which
+// is the one of the traffic for SPBs before any interface
+func (p *pcapng) packetLinkLayerType() LinkLayerType {
+	if int(p.packetIfId) < len(p.interfaces) && p.interfaces[p.packetIfId].accepted {
+		return p.interfaces[p.packetIfId].linkLayerType
+	}
+	return p.linkLayerType
+}
+
+// the snaplen of the interface of the last packet, which
+// for SPBs can be read before any interface is known
+func (p *pcapng) packetSnaplen() uint32 {
+	if int(p.packetIfId) < len(p.interfaces) {
+		return p.interfaces[p.packetIfId].snaplen
+	}
+	return 0
+}
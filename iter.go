@@ -0,0 +1,86 @@
+package pcapreader
+
+import (
+	"context"
+	"io"
+	"iter"
+)
+
+// implemented by traffic whose reads can be interrupted by a context
+type contextual interface {
+	setContext(ctx context.Context)
+}
+
+// hands ctx to t if it can make use of it. A nil ctx removes it again.
+func setContext(t Traffic, ctx context.Context) {
+	if c, ok := t.(contextual); ok {
+		c.setContext(ctx)
+	}
+}
+
+// Iterator ranges over the packets of a Traffic.
+// Like with bufio.Scanner, the reason why the
+// iteration ended is available through Err.
+type Iterator struct {
+	ctx     context.Context
+	traffic Traffic
+	err     error
+}
+
+// Returns an iterator over the packets of t which stops at the end,
+// at the first error or when ctx is done. For the readers of this
+// package ctx is also checked while reading, so that i.e. skipping
+// large parts of a file is interrupted as well. A nil ctx is
+// the same as context.Background().
+//
+//	packets := pcapreader.Packets(ctx, traffic)
+//	for info, packet := range packets.All() {
+//		...
+//	}
+//	if err := packets.Err(); err != nil {
+//		...
+//	}
+func Packets(ctx context.Context, t Traffic) *Iterator {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &Iterator{ctx: ctx, traffic: t}
+}
+
+// Returns the packets. Like with Next, the packet is only valid
+// until the loop continues. Leaving the loop early does not stop
+// the traffic, so ranging over All again continues where it left off.
+func (it *Iterator) All() iter.Seq2[*PacketInfo, Packet] {
+	return func(yield func(*PacketInfo, Packet) bool) {
+		setContext(it.traffic, it.ctx)
+		defer setContext(it.traffic, nil)
+
+		for {
+			// traffic that does not know about contexts
+			// at least stops in between packets
+			if err := it.ctx.Err(); err != nil {
+				it.err = err
+				return
+			}
+
+			info, packet, err := it.traffic.Next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				it.err = err
+				return
+			}
+
+			if !yield(info, packet) {
+				return
+			}
+		}
+	}
+}
+
+// Returns the error that ended the iteration. Reaching the
+// end is not an error, when ctx is done its error is returned.
+func (it *Iterator) Err() error {
+	return it.err
+}
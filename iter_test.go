@@ -0,0 +1,72 @@
+package pcapreader
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+)
+
+func iterTraffic(t *testing.T, packets int) Traffic {
+	t.Helper()
+	traffic, err := OpenReader(bytes.NewReader(genPcap(genPcapOpts{order: binary.LittleEndian, snaplen: 0xFFFF, packets: packets})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(traffic.Stop)
+	return traffic
+}
+
+func TestPacketsCancel(t *testing.T) {
+	for name, traffic := range map[string]Traffic{
+		"reader": iterTraffic(t, 10),
+		// does not know about contexts
+		"other": wrappedTraffic{iterTraffic(t, 10)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			packets := Packets(ctx, traffic)
+			n := 0
+			for range packets.All() {
+				n++
+				if n == 3 {
+					cancel()
+				}
+			}
+			if n != 3 || packets.Err() != context.Canceled {
+				t.Errorf("%d packets and %v after cancelling", n, packets.Err())
+			}
+		})
+	}
+}
+
+func TestPacketsResume(t *testing.T) {
+	// a nil context does not end the iteration
+	packets := Packets(nil, iterTraffic(t, 10))
+	var got [][]byte
+	for _, packet := range packets.All() {
+		got = append(got, bytes.Clone(packet))
+		if len(got) == 4 {
+			break
+		}
+	}
+	if packets.Err() != nil {
+		t.Errorf("%v after leaving the loop", packets.Err())
+	}
+	for _, packet := range packets.All() {
+		got = append(got, bytes.Clone(packet))
+	}
+	if packets.Err() != nil {
+		t.Errorf("%v at the end", packets.Err())
+	}
+
+	if len(got) != 10 {
+		t.Fatalf("%d packets", len(got))
+	}
+	for i, packet := range got {
+		if !bytes.Equal(packet, genData(i, genSizes[i%len(genSizes)])) {
+			t.Errorf("packet %d differs", i)
+		}
+	}
+}
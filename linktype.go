@@ -0,0 +1,324 @@
+package pcapreader
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The LINKTYPE_ values of the link layer types that are used the most.
+// All of them are known to the registry, see LinkLayerTypes.
+const (
+	LinkTypeNull               LinkLayerType = 0
+	LinkTypeEthernet           LinkLayerType = 1
+	LinkTypeTokenRing          LinkLayerType = 6
+	LinkTypePPP                LinkLayerType = 9
+	LinkTypeFDDI               LinkLayerType = 10
+	LinkTypePPPHDLC            LinkLayerType = 50
+	LinkTypePPPEther           LinkLayerType = 51
+	LinkTypeATMRFC1483         LinkLayerType = 100
+	LinkTypeRaw                LinkLayerType = 101
+	LinkTypeCHDLC              LinkLayerType = 104
+	LinkTypeIEEE802_11         LinkLayerType = 105
+	LinkTypeFrameRelay         LinkLayerType = 107
+	LinkTypeLoop               LinkLayerType = 108
+	LinkTypeEnc                LinkLayerType = 109
+	LinkTypeLinuxSLL           LinkLayerType = 113
+	LinkTypePFLog              LinkLayerType = 117
+	LinkTypePrism              LinkLayerType = 119
+	LinkTypeIEEE802_11Radiotap LinkLayerType = 127
+	LinkTypeIEEE802_11AVS      LinkLayerType = 163
+	LinkTypePPPWithDir         LinkLayerType = 204
+	LinkTypeUSBLinux           LinkLayerType = 189
+	LinkTypePPI                LinkLayerType = 192
+	LinkTypeUSBLinuxMmapped    LinkLayerType = 220
+	LinkTypeCANSocketCAN       LinkLayerType = 227
+	LinkTypeIPv4               LinkLayerType = 228
+	LinkTypeIPv6               LinkLayerType = 229
+	LinkTypeNFLog              LinkLayerType = 239
+	LinkTypePFSync             LinkLayerType = 246
+	LinkTypeUSBPcap            LinkLayerType = 249
+	LinkTypeNetlink            LinkLayerType = 253
+	LinkTypeLinuxSLL2          LinkLayerType = 276
+)
+
+var ErrUnknownLinkLayerType = errors.New("unknown link layer type")
+
+// what is known about a link layer type
+type linkTypeInfo struct {
+	// the name libpcap gives the DLT_ value, which is what
+	// tcpdump -L shows, i.e. EN10MB for Ethernet
+	name string
+	// the name of the LINKTYPE_ value when it differs from name
+	alias       string
+	description string
+}
+
+// from https://www.tcpdump.org/linktypes.html and the names and
+// descriptions of libpcap's pcap_datalink_val_to_name
+var linkTypes = map[LinkLayerType]linkTypeInfo{
+	0:   {"NULL", "", "BSD loopback"},
+	1:   {"EN10MB", "ETHERNET", "Ethernet"},
+	3:   {"AX25", "", "AX.25 layer 2"},
+	6:   {"IEEE802", "IEEE802_5", "Token ring"},
+	7:   {"ARCNET", "ARCNET_BSD", "BSD ARCNET"},
+	8:   {"SLIP", "", "SLIP"},
+	9:   {"PPP", "", "PPP"},
+	10:  {"FDDI", "", "FDDI"},
+	50:  {"PPP_SERIAL", "PPP_HDLC", "PPP over serial"},
+	51:  {"PPP_ETHER", "", "PPPoE"},
+	99:  {"SYMANTEC_FIREWALL", "", "Symantec Firewall"},
+	100: {"ATM_RFC1483", "", "RFC 1483 LLC-encapsulated ATM"},
+	101: {"RAW", "", "Raw IP"},
+	102: {"SLIP_BSDOS", "", "BSD/OS SLIP"},
+	103: {"PPP_BSDOS", "", "BSD/OS PPP"},
+	104: {"C_HDLC", "", "Cisco HDLC"},
+	105: {"IEEE802_11", "", "802.11"},
+	106: {"ATM_CLIP", "", "Linux Classical IP over ATM"},
+	107: {"FRELAY", "", "Frame Relay"},
+	108: {"LOOP", "", "OpenBSD loopback"},
+	109: {"ENC", "", "OpenBSD encapsulated IP"},
+	113: {"LINUX_SLL", "", "Linux cooked v1"},
+	114: {"LTALK", "", "Localtalk"},
+	117: {"PFLOG", "", "OpenBSD pflog file"},
+	119: {"PRISM_HEADER", "IEEE802_11_PRISM", "802.11 plus Prism header"},
+	122: {"IP_OVER_FC", "", "RFC 2625 IP-over-Fibre Channel"},
+	123: {"SUNATM", "", "Sun raw ATM"},
+	127: {"IEEE802_11_RADIO", "IEEE802_11_RADIOTAP", "802.11 plus radiotap header"},
+	129: {"ARCNET_LINUX", "", "Linux ARCNET"},
+	138: {"APPLE_IP_OVER_IEEE1394", "", "Apple IP-over-IEEE 1394"},
+	139: {"MTP2_WITH_PHDR", "", "SS7 MTP2 with Pseudo-header"},
+	140: {"MTP2", "", "SS7 MTP2"},
+	141: {"MTP3", "", "SS7 MTP3"},
+	142: {"SCCP", "", "SS7 SCCP"},
+	143: {"DOCSIS", "", "DOCSIS"},
+	144: {"LINUX_IRDA", "", "Linux IrDA"},
+	147: {"USER0", "", "DLT_USER0"},
+	148: {"USER1", "", "DLT_USER1"},
+	149: {"USER2", "", "DLT_USER2"},
+	150: {"USER3", "", "DLT_USER3"},
+	151: {"USER4", "", "DLT_USER4"},
+	152: {"USER5", "", "DLT_USER5"},
+	153: {"USER6", "", "DLT_USER6"},
+	154: {"USER7", "", "DLT_USER7"},
+	155: {"USER8", "", "DLT_USER8"},
+	156: {"USER9", "", "DLT_USER9"},
+	157: {"USER10", "", "DLT_USER10"},
+	158: {"USER11", "", "DLT_USER11"},
+	159: {"USER12", "", "DLT_USER12"},
+	160: {"USER13", "", "DLT_USER13"},
+	161: {"USER14", "", "DLT_USER14"},
+	162: {"USER15", "", "DLT_USER15"},
+	163: {"IEEE802_11_RADIO_AVS", "IEEE802_11_AVS", "802.11 plus AVS radio information header"},
+	165: {"BACNET_MS_TP", "", "BACnet MS/TP"},
+	166: {"PPP_PPPD", "", "PPP for pppd, with direction flag"},
+	169: {"GPRS_LLC", "", "GPRS LLC"},
+	170: {"GPF_T", "", "GPF-T"},
+	171: {"GPF_F", "", "GPF-F"},
+	177: {"LINUX_LAPD", "", "LAPD with Linux pseudo-header"},
+	182: {"MFR", "", "FRF.16 Frame Relay"},
+	187: {"BLUETOOTH_HCI_H4", "", "Bluetooth HCI UART transport layer"},
+	189: {"USB_LINUX", "", "USB with Linux header"},
+	192: {"PPI", "", "Per-Packet Information"},
+	195: {"IEEE802_15_4", "IEEE802_15_4_WITHFCS", "IEEE 802.15.4 with FCS"},
+	196: {"SITA", "", "SITA pseudo-header"},
+	197: {"ERF", "", "Endace ERF header"},
+	201: {"BLUETOOTH_HCI_H4_WITH_PHDR", "", "Bluetooth HCI UART transport layer plus pseudo-header"},
+	202: {"AX25_KISS", "", "AX.25 with KISS header"},
+	203: {"LAPD", "", "Link Access Procedures on the D Channel"},
+	204: {"PPP_WITH_DIR", "", "PPP with direction flag"},
+	205: {"C_HDLC_WITH_DIR", "", "Cisco HDLC with direction flag"},
+	206: {"FRELAY_WITH_DIR", "", "Frame Relay with direction flag"},
+	207: {"LAPB_WITH_DIR", "", "LAPB with direction flag"},
+	209: {"IPMB_LINUX", "", "IPMB with Linux/Pigeon Point pseudo-header"},
+	210: {"FLEXRAY", "", "FlexRay"},
+	215: {"IEEE802_15_4_NONASK_PHY", "", "IEEE 802.15.4 with non-ASK PHY data"},
+	220: {"USB_LINUX_MMAPPED", "", "USB with padded Linux header"},
+	224: {"FC_2", "", "Fibre Channel FC-2"},
+	225: {"FC_2_WITH_FRAME_DELIMS", "", "Fibre Channel FC-2 with frame delimiters"},
+	226: {"IPNET", "", "Solaris ipnet"},
+	227: {"CAN_SOCKETCAN", "", "CAN-bus with SocketCAN headers"},
+	228: {"IPV4", "", "Raw IPv4"},
+	229: {"IPV6", "", "Raw IPv6"},
+	230: {"IEEE802_15_4_NOFCS", "", "IEEE 802.15.4 without FCS"},
+	231: {"DBUS", "", "D-Bus"},
+	235: {"DVB_CI", "", "DVB-CI"},
+	236: {"MUX27010", "", "MUX27010"},
+	237: {"STANAG_5066_D_PDU", "", "STANAG 5066 D_PDUs"},
+	239: {"NFLOG", "", "Linux netfilter log messages"},
+	240: {"NETANALYZER", "", "Ethernet with Hilscher netANALYZER pseudo-header"},
+	241: {"NETANALYZER_TRANSPARENT", "", "Ethernet with netANALYZER pseudo-header, preamble and SFD"},
+	242: {"IPOIB", "", "RFC 4391 IP-over-Infiniband"},
+	243: {"MPEG_2_TS", "", "MPEG-2 transport stream"},
+	244: {"NG40", "", "ng40 protocol tester Iub/Iur"},
+	245: {"NFC_LLCP", "", "NFC LLCP PDUs with pseudo-header"},
+	246: {"PFSYNC", "", "Packet filter state syncing"},
+	247: {"INFINIBAND", "", "InfiniBand"},
+	248: {"SCTP", "", "SCTP"},
+	249: {"USBPCAP", "", "USB with USBPcap header"},
+	250: {"RTAC_SERIAL", "", "Schweitzer Engineering Laboratories RTAC packets"},
+	251: {"BLUETOOTH_LE_LL", "", "Bluetooth Low Energy air interface"},
+	253: {"NETLINK", "", "Linux netlink"},
+	254: {"BLUETOOTH_LINUX_MONITOR", "", "Bluetooth Linux Monitor"},
+	255: {"BLUETOOTH_BREDR_BB", "", "Bluetooth Basic Rate/Enhanced Data Rate baseband packets"},
+	256: {"BLUETOOTH_LE_LL_WITH_PHDR", "", "Bluetooth Low Energy air interface with pseudo-header"},
+	257: {"PROFIBUS_DL", "", "PROFIBUS data link layer"},
+	258: {"PKTAP", "", "Apple DLT_PKTAP"},
+	259: {"EPON", "", "Ethernet with 802.3 Clause 65 EPON preamble"},
+	260: {"IPMI_HPM_2", "", "IPMI trace packets"},
+	261: {"ZWAVE_R1_R2", "", "Z-Wave RF profile R1 and R2 packets"},
+	262: {"ZWAVE_R3", "", "Z-Wave RF profile R3 packets"},
+	263: {"WATTSTOPPER_DLM", "", "WattStopper Digital Lighting Management (DLM) and Legrand Nitoo Open protocol packets"},
+	264: {"ISO_14443", "", "ISO 14443 messages"},
+	265: {"RDS", "", "IEC 62106 Radio Data System groups"},
+	266: {"USB_DARWIN", "", "USB with Darwin header"},
+	268: {"SDLC", "", "IBM SDLC frames"},
+	270: {"LORATAP", "", "LoRa packets with LoRaTap pseudo-header"},
+	271: {"VSOCK", "", "Linux vsock"},
+	272: {"NORDIC_BLE", "", "Nordic Semiconductor Bluetooth LE sniffer frames"},
+	273: {"DOCSIS31_XRA31", "", "Excentis XRA-31 DOCSIS 3.1 RF sniffer frames"},
+	274: {"ETHERNET_MPACKET", "", "802.3br mPackets"},
+	275: {"DISPLAYPORT_AUX", "", "DisplayPort AUX channel monitoring data"},
+	276: {"LINUX_SLL2", "", "Linux cooked v2"},
+	278: {"OPENVIZSLA", "", "OpenVizsla USB"},
+	279: {"EBHSCR", "", "Elektrobit High Speed Capture and Replay (EBHSCR)"},
+	280: {"VPP_DISPATCH", "", "VPP graph dispatch tracer"},
+	281: {"DSA_TAG_BRCM", "", "Broadcom tag"},
+	282: {"DSA_TAG_BRCM_PREPEND", "", "Broadcom tag (prepended)"},
+	283: {"IEEE802_15_4_TAP", "", "IEEE 802.15.4 with pseudo-header"},
+	284: {"DSA_TAG_DSA", "", "Marvell DSA"},
+	285: {"DSA_TAG_EDSA", "", "Marvell EDSA"},
+	286: {"ELEE", "", "ELEE lawful intercept packets"},
+	287: {"Z_WAVE_SERIAL", "", "Z-Wave serial frames between host and chip"},
+	288: {"USB_2_0", "", "USB 2.0/1.1/1.0 as transmitted over the cable"},
+	289: {"ATSC_ALP", "", "ATSC Link-Layer Protocol packets"},
+	290: {"ETW", "", "Event Tracing for Windows messages"},
+	292: {"ZBOSS_NCP", "", "ZBOSS NCP protocol with pseudo-header"},
+	293: {"USB_2_0_LOW_SPEED", "", "Low-Speed USB 2.0/1.1/1.0 as transmitted over the cable"},
+	294: {"USB_2_0_FULL_SPEED", "", "Full-Speed USB 2.0/1.1/1.0 as transmitted over the cable"},
+	295: {"USB_2_0_HIGH_SPEED", "", "High-Speed USB 2.0 as transmitted over the cable"},
+	296: {"AUERSWALD_LOG", "", "Auerswald Logger Protocol"},
+	297: {"ZWAVE_TAP", "", "Z-Wave packets with a TAP meta-data header"},
+	298: {"SILABS_DEBUG_CHANNEL", "", "Silicon Labs debug channel protocol"},
+	299: {"FIRA_UCI", "", "Ultra-wideband controller interface protocol"},
+	300: {"MDB", "", "Multi-Drop Bus"},
+	301: {"DECT_NR", "", "DECT-2020 New Radio (NR) MAC layer"},
+}
+
+// the link layer types by their names and aliases in upper case
+var linkTypeNames = func() map[string]LinkLayerType {
+	names := make(map[string]LinkLayerType, 2*len(linkTypes))
+	for llt, info := range linkTypes {
+		names[info.name] = llt
+		if info.alias != "" {
+			names[info.alias] = llt
+		}
+	}
+	return names
+}()
+
+// Returns the name libpcap gives the link layer type, as
+// tcpdump -L shows it, i.e. EN10MB. Unknown ones are
+// returned as their number.
+func (l LinkLayerType) String() string {
+	if info, ok := linkTypes[l]; ok {
+		return info.name
+	}
+	return strconv.FormatUint(uint64(l), 10)
+}
+
+// Returns what the link layer type is, i.e. "Ethernet",
+// or an empty string for unknown ones
+func (l LinkLayerType) Description() string {
+	return linkTypes[l].description
+}
+
+// Reports whether the link layer type is in the registry
+func (l LinkLayerType) Known() bool {
+	_, ok := linkTypes[l]
+	return ok
+}
+
+// Returns all link layer types of the registry in ascending order
+func LinkLayerTypes() []LinkLayerType {
+	llts := make([]LinkLayerType, 0, len(linkTypes))
+	for llt := range linkTypes {
+		llts = append(llts, llt)
+	}
+	slices.Sort(llts)
+	return llts
+}
+
+// Parses a link layer type from its name as String returns it or
+// the name of its LINKTYPE_ value, i.e. EN10MB or ETHERNET. Case
+// does not matter and a DLT_ or LINKTYPE_ prefix is allowed.
+// Numbers are accepted as well, also for unknown types.
+func ParseLinkLayerType(s string) (LinkLayerType, error) {
+	if n, err := strconv.ParseUint(s, 0, 32); err == nil {
+		return LinkLayerType(n), nil
+	}
+	name := strings.ToUpper(strings.TrimSpace(s))
+	name = strings.TrimPrefix(name, "DLT_")
+	name = strings.TrimPrefix(name, "LINKTYPE_")
+	if llt, ok := linkTypeNames[name]; ok {
+		return llt, nil
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnknownLinkLayerType, s)
+}
+
+// The DLT_ values that differ from their LINKTYPE_ values. Captures
+// hold LINKTYPE_ values, the DLT_ values are what the capture APIs of
+// the operating systems use, and some of them differ between systems.
+// Link layer types not listed here have the same DLT_ value.
+type dltMapping struct {
+	linkType LinkLayerType
+	dlt      uint32
+}
+
+// the mappings for goos, from libpcap's dlt.h
+func dltMappings(goos string) []dltMapping {
+	raw, loop, enc, pfsync := uint32(12), uint32(108), uint32(109), uint32(246)
+	switch goos {
+	case "openbsd":
+		raw, loop, enc, pfsync = 14, 12, 13, 18
+	case "freebsd", "netbsd", "dragonfly":
+		pfsync = 121
+	}
+	return []dltMapping{
+		{LinkTypeATMRFC1483, 11},
+		{LinkTypeRaw, raw},
+		{102, 15}, // SLIP_BSDOS
+		{103, 16}, // PPP_BSDOS
+		{106, 19}, // ATM_CLIP
+		{LinkTypeLoop, loop},
+		{LinkTypeEnc, enc},
+		{LinkTypePFSync, pfsync},
+	}
+}
+
+var dltPlatform = dltMappings(runtime.GOOS)
+
+// Returns the DLT_ value of the link layer type on
+// the system this runs on, as pcap_datalink returns it
+func (l LinkLayerType) DLT() uint32 {
+	for _, m := range dltPlatform {
+		if m.linkType == l {
+			return m.dlt
+		}
+	}
+	return uint32(l)
+}
+
+// Returns the link layer type of a DLT_ value of the
+// system this runs on, as captures have to hold it
+func LinkLayerTypeFromDLT(dlt uint32) LinkLayerType {
+	for _, m := range dltPlatform {
+		if m.dlt == dlt {
+			return m.linkType
+		}
+	}
+	return LinkLayerType(dlt)
+}
@@ -0,0 +1,49 @@
+package pcapreader
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLinkLayerTypeNames(t *testing.T) {
+	if s := LinkTypeEthernet.String(); s != "EN10MB" {
+		t.Errorf("Ethernet is %s", s)
+	}
+	if s := LinkLayerType(4242).String(); s != "4242" {
+		t.Errorf("unknown type is %s", s)
+	}
+	if d := LinkTypeLinuxSLL2.Description(); d != "Linux cooked v2" {
+		t.Errorf("SLL2 is %s", d)
+	}
+
+	for _, name := range []string{"EN10MB", "ethernet", "DLT_EN10MB", "LINKTYPE_ETHERNET", "1", "0x1"} {
+		if llt, err := ParseLinkLayerType(name); err != nil || llt != LinkTypeEthernet {
+			t.Errorf("%s is %d, %v", name, llt, err)
+		}
+	}
+	if _, err := ParseLinkLayerType("ETHERNOT"); !errors.Is(err, ErrUnknownLinkLayerType) {
+		t.Errorf("got %v", err)
+	}
+
+	// every name leads back to its type
+	for _, llt := range LinkLayerTypes() {
+		if parsed, err := ParseLinkLayerType(llt.String()); err != nil || parsed != llt {
+			t.Errorf("%s is %d, %v", llt, parsed, err)
+		}
+	}
+}
+
+func TestDLTMappings(t *testing.T) {
+	for _, goos := range []string{"linux", "openbsd", "freebsd"} {
+		seen := map[uint32]bool{}
+		for _, m := range dltMappings(goos) {
+			if seen[m.dlt] {
+				t.Errorf("%s: DLT %d is used twice", goos, m.dlt)
+			}
+			seen[m.dlt] = true
+		}
+	}
+	if LinkLayerTypeFromDLT(LinkTypeRaw.DLT()) != LinkTypeRaw || LinkTypeEthernet.DLT() != 1 {
+		t.Error("DLTs do not map back")
+	}
+}
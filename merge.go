@@ -0,0 +1,219 @@
+package pcapreader
+
+import (
+	"container/heap"
+	"context"
+	"io"
+)
+
+// a capture that is merged with others and its next packet
+type mergeSource struct {
+	t Traffic
+	// the position among the merged captures
+	index  int
+	info   *PacketInfo
+	packet Packet
+}
+
+// the sources with a packet, the one with the earliest packet first
+type mergeHeap []*mergeSource
+
+func (h mergeHeap) Len() int { return len(h) }
+
+func (h mergeHeap) Less(i, j int) bool {
+	ti, tj := h[i].info.CaptureTime, h[j].info.CaptureTime
+	if ti.Equal(tj) {
+		// the captures given first go first,
+		// so merging is the same every time
+		return h[i].index < h[j].index
+	}
+	return ti.Before(tj)
+}
+
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeHeap) Push(x any) { *h = append(*h, x.(*mergeSource)) }
+
+func (h *mergeHeap) Pop() any {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
+
+type mergedTraffic struct {
+	sources []*mergeSource
+	heap    mergeHeap
+	// whether every source has been asked for its first packet
+	started bool
+	// the source of the last packet, which has to be
+	// asked for its next one before the next packet
+	// can be picked. Its packet is still valid until then.
+	last *mergeSource
+	dead bool
+}
+
+// Returns the packets of all ts as one Traffic ordered by their
+// CaptureTime, like mergecap. Packets with the same time are taken
+// from the Traffic given first. Each Traffic only needs to be in
+// order by itself. Interface tells which Traffic a packet came from
+// through Source, so that writing the packets into a pcapng keeps
+// the interfaces of the captures apart. Stop stops all ts.
+func Merge(ts ...Traffic) Traffic {
+	m := &mergedTraffic{}
+	for i, t := range ts {
+		m.sources = append(m.sources, &mergeSource{t: t, index: i})
+	}
+	return m
+}
+
+// asks s for its next packet and puts it
+// back on the heap if it has one
+func (m *mergedTraffic) advance(s *mergeSource) error {
+	info, packet, err := s.t.Next()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.info = info
+	s.packet = packet
+	heap.Push(&m.heap, s)
+	return nil
+}
+
+func (m *mergedTraffic) Next() (*PacketInfo, Packet, error) {
+	if m.dead {
+		return nil, nil, ErrTrafficSourceAlreadyStopped
+	}
+
+	var err error
+	switch {
+	case !m.started:
+		m.started = true
+		for _, s := range m.sources {
+			if err = m.advance(s); err != nil {
+				break
+			}
+		}
+	case m.last != nil:
+		err = m.advance(m.last)
+		m.last = nil
+	}
+	if err != nil {
+		m.Stop()
+		return nil, nil, err
+	}
+
+	if len(m.heap) == 0 {
+		m.Stop()
+		return nil, nil, io.EOF
+	}
+	m.last = heap.Pop(&m.heap).(*mergeSource)
+	return m.last.info, m.last.packet, nil
+}
+
+// The LinkLayerType of the Traffic the last packet came from
+func (m *mergedTraffic) LinkLayerType() LinkLayerType {
+	if m.last != nil {
+		return m.last.t.LinkLayerType()
+	}
+	if len(m.sources) > 0 {
+		return m.sources[0].t.LinkLayerType()
+	}
+	return 0
+}
+
+func (m *mergedTraffic) Interface() Interface {
+	if m.last == nil {
+		return Interface{LinkLayerType: m.LinkLayerType()}
+	}
+	iface := InterfaceOf(m.last.t)
+	iface.Source = m.last.index
+	return iface
+}
+
+func (m *mergedTraffic) setContext(ctx context.Context) {
+	for _, s := range m.sources {
+		setContext(s.t, ctx)
+	}
+}
+
+func (m *mergedTraffic) Stop() {
+	if !m.dead {
+		for _, s := range m.sources {
+			s.t.Stop()
+		}
+	}
+	m.heap = nil
+	m.last = nil
+	m.dead = true
+}
+
+type appendedTraffic struct {
+	ts []Traffic
+	// the Traffic that is being read
+	current int
+	dead    bool
+}
+
+// Returns the packets of all ts one Traffic after the other, like
+// mergecap -a. Interface tells which Traffic a packet came from
+// through Source. Stop stops all ts.
+func Append(ts ...Traffic) Traffic {
+	return &appendedTraffic{ts: ts}
+}
+
+func (a *appendedTraffic) Next() (*PacketInfo, Packet, error) {
+	if a.dead {
+		return nil, nil, ErrTrafficSourceAlreadyStopped
+	}
+	for a.current < len(a.ts) {
+		info, packet, err := a.ts[a.current].Next()
+		if err == io.EOF {
+			a.current++
+			continue
+		}
+		if err != nil {
+			a.Stop()
+			return nil, nil, err
+		}
+		return info, packet, nil
+	}
+	a.Stop()
+	return nil, nil, io.EOF
+}
+
+// The LinkLayerType of the Traffic that is being read
+func (a *appendedTraffic) LinkLayerType() LinkLayerType {
+	if len(a.ts) == 0 {
+		return 0
+	}
+	return a.ts[min(a.current, len(a.ts)-1)].LinkLayerType()
+}
+
+func (a *appendedTraffic) Interface() Interface {
+	if len(a.ts) == 0 {
+		return Interface{}
+	}
+	current := min(a.current, len(a.ts)-1)
+	iface := InterfaceOf(a.ts[current])
+	iface.Source = current
+	return iface
+}
+
+func (a *appendedTraffic) setContext(ctx context.Context) {
+	for _, t := range a.ts {
+		setContext(t, ctx)
+	}
+}
+
+func (a *appendedTraffic) Stop() {
+	if !a.dead {
+		for _, t := range a.ts {
+			t.Stop()
+		}
+	}
+	a.dead = true
+}
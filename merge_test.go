@@ -0,0 +1,147 @@
+package pcapreader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"slices"
+	"testing"
+	"time"
+)
+
+// a pcap with packets every step starting at offset
+func mergeInput(t *testing.T, offset, step time.Duration, n int) Traffic {
+	return mergeInputOf(t, LinkTypeEthernet, offset, step, n)
+}
+
+// like mergeInput with the link layer type llt
+func mergeInputOf(t *testing.T, llt LinkLayerType, offset, step time.Duration, n int) Traffic {
+	var b bytes.Buffer
+	w := NewPcapWriter(&b)
+	for i := 0; i < n; i++ {
+		info := &PacketInfo{CaptureTime: genStart.Add(offset + time.Duration(i)*step), Size: 60}
+		if err := w.WritePacket(Interface{LinkLayerType: llt}, info, genData(i, 60)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Flush()
+
+	traffic, err := OpenReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return traffic
+}
+
+func TestMerge(t *testing.T) {
+	merged := Merge(
+		mergeInput(t, 0, 3*time.Second, 4),
+		mergeInput(t, time.Second, 2*time.Second, 5),
+		mergeInput(t, 0, 0, 0),
+		mergeInput(t, 3*time.Second, 0, 2),
+	)
+
+	var secs, sources []int
+	for {
+		info, _, err := merged.Next()
+		if err != nil {
+			break
+		}
+		secs = append(secs, int(info.CaptureTime.Sub(genStart)/time.Second))
+		sources = append(sources, InterfaceOf(merged).Source)
+	}
+
+	wantSecs := []int{0, 1, 3, 3, 3, 3, 5, 6, 7, 9, 9}
+	wantSources := []int{0, 1, 0, 1, 3, 3, 1, 0, 1, 0, 1}
+	if len(secs) != len(wantSecs) {
+		t.Fatalf("merged %v from %v", secs, sources)
+	}
+	for i := range secs {
+		if secs[i] != wantSecs[i] || sources[i] != wantSources[i] {
+			t.Fatalf("merged %v from %v instead of %v from %v", secs, sources, wantSecs, wantSources)
+		}
+	}
+}
+
+func TestAppend(t *testing.T) {
+	appended := Append(
+		mergeInput(t, 10*time.Second, time.Second, 2),
+		mergeInput(t, 0, 0, 0),
+		mergeInput(t, 0, time.Second, 3),
+	)
+	infos, _ := readAll(t, appended)
+	if len(infos) != 5 || infos[0].CaptureTime.Sub(genStart) != 10*time.Second || infos[2].CaptureTime != genStart {
+		t.Errorf("appended %v", infos)
+	}
+}
+
+// the captures stay apart when they are written into a pcapng
+func TestMergeInterfaces(t *testing.T) {
+	merged := Merge(mergeInput(t, 0, 2*time.Second, 3), mergeInput(t, time.Second, 2*time.Second, 3))
+
+	var b bytes.Buffer
+	if _, err := CopyPackets(context.Background(), NewPcapNgWriter(&b), merged); err != nil {
+		t.Fatal(err)
+	}
+	traffic, err := OpenReaderWithOptions(&b, ReaderOptions{AllInterfaces: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		_, _, err := traffic.Next()
+		if err != nil {
+			if i != 6 {
+				t.Errorf("read %d packets instead of 6", i)
+			}
+			break
+		}
+		if id := InterfaceOf(traffic).ID; id != uint32(i%2) {
+			t.Errorf("packet %d is from interface %d", i, id)
+		}
+	}
+}
+
+// captures of different link layer types are all read back
+func TestMergeLinkLayerTypes(t *testing.T) {
+	merged := Merge(
+		mergeInputOf(t, LinkTypeEthernet, 0, 2*time.Second, 3),
+		mergeInputOf(t, LinkTypeRaw, time.Second, 2*time.Second, 3),
+	)
+	var b bytes.Buffer
+	if _, err := CopyPackets(context.Background(), NewPcapNgWriter(&b), merged); err != nil {
+		t.Fatal(err)
+	}
+	data := b.Bytes()
+
+	traffic, err := OpenReaderWithOptions(bytes.NewReader(data), ReaderOptions{AllInterfaces: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var llts []LinkLayerType
+	for i := 0; ; i++ {
+		info, packet, err := traffic.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.CaptureTime.Equal(genStart.Add(time.Duration(i)*time.Second)) || !bytes.Equal(packet, genData(i/2, 60)) {
+			t.Errorf("packet %d at %v differs", i, info.CaptureTime)
+		}
+		llts = append(llts, InterfaceOf(traffic).LinkLayerType)
+	}
+	want := []LinkLayerType{LinkTypeEthernet, LinkTypeRaw, LinkTypeEthernet, LinkTypeRaw, LinkTypeEthernet, LinkTypeRaw}
+	if !slices.Equal(llts, want) || traffic.LinkLayerType() != LinkTypeEthernet {
+		t.Errorf("read %v instead of %v", llts, want)
+	}
+
+	// without the option only the first interface is followed
+	traffic, err = OpenReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if infos, _ := readAll(t, traffic); len(infos) != 3 {
+		t.Errorf("read %d packets of the first interface instead of 3", len(infos))
+	}
+}
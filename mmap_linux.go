@@ -0,0 +1,62 @@
+//go:build linux
+
+package pcapreader
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+var ErrFileTooLarge = errors.New("file is too large to be mapped")
+
+// Opens the file like OpenFile but maps it into memory instead
+// of reading it. The packets returned by Next point directly
+// into the mapping, so nothing is copied and reading does not
+// allocate. This is the fastest way to read large captures.
+// The mapping is only released by Stop, not when Next
+// reaches the end, so Stop has to be called and packets
+// must not be used after that.
+func OpenFileMapped(name string) (Traffic, error) {
+	return OpenFileMappedWithOptions(name, ReaderOptions{})
+}
+
+// Like OpenFileMapped but with options
+func OpenFileMappedWithOptions(name string, opts ReaderOptions) (Traffic, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	// the mapping stays valid without the file
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	size := stat.Size()
+	if size != int64(int(size)) {
+		return nil, ErrFileTooLarge
+	}
+	// an empty mapping is not possible
+	if size == 0 {
+		return openByExtension(name, &memorySource{}, opts)
+	}
+
+	// a private writable mapping so that packets can be
+	// modified as with OpenFile, without touching the file
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, err
+	}
+	// only a hint so the error does not matter
+	syscall.Madvise(data, syscall.MADV_SEQUENTIAL)
+
+	return openByExtension(name, &memorySource{
+		data: data,
+		release: func() error {
+			return syscall.Munmap(data)
+		},
+	}, opts)
+}
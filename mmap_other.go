@@ -0,0 +1,14 @@
+//go:build !linux
+
+package pcapreader
+
+// Memory mapping is only implemented for linux,
+// everywhere else this is the same as OpenFile.
+func OpenFileMapped(name string) (Traffic, error) {
+	return OpenFile(name)
+}
+
+// Like OpenFileMapped but with options
+func OpenFileMappedWithOptions(name string, opts ReaderOptions) (Traffic, error) {
+	return OpenFileWithOptions(name, opts)
+}
@@ -0,0 +1,89 @@
+package pcapreader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// a mapped file has to be read like the file itself
+func TestOpenFileMapped(t *testing.T) {
+	var ng genNg
+	ng.shb(binary.BigEndian, 1, 0)
+	ng.idb(1, 0)
+	ng.packets(0, 1e6, 0, 20)
+	ng.spb(genData(20, 60), 60)
+
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"capture.pcap":   genPcap(genPcapOpts{order: binary.LittleEndian, snaplen: 64, packets: 20}),
+		"capture.pcapng": ng.b.Bytes(),
+		"empty.pcap":     nil,
+		"garbage.pcap":   insert(genPcap(genPcapOpts{order: binary.BigEndian, snaplen: 0xFFFF, packets: 5}), 24, "garbage!"),
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		read, readErr := allPackets(OpenFile(path))
+		mapped, mappedErr := allPackets(OpenFileMapped(path))
+		if !reflect.DeepEqual(mapped, read) || !reflect.DeepEqual(mappedErr, readErr) {
+			t.Errorf("%s: reading the mapped file and the file differs", name)
+		}
+
+		opts := ReaderOptions{Recover: true}
+		read, readErr = allPackets(OpenFileWithOptions(path, opts))
+		mapped, mappedErr = allPackets(OpenFileMappedWithOptions(path, opts))
+		if !reflect.DeepEqual(mapped, read) || !reflect.DeepEqual(mappedErr, readErr) {
+			t.Errorf("%s: recovering the mapped file and the file differs", name)
+		}
+	}
+}
+
+// all the packets of traffic with the error that ended reading
+func allPackets(traffic Traffic, err error) ([]indexedPacket, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer traffic.Stop()
+	var packets []indexedPacket
+	for {
+		info, packet, err := traffic.Next()
+		if err != nil {
+			return packets, err
+		}
+		packets = append(packets, indexedPacket{*info, append([]byte(nil), packet...)})
+	}
+}
+
+// the end of the file does not release the mapping
+// the packet that has been read last points into
+func TestOpenFileMappedEnd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	if err := os.WriteFile(path, genPcap(genPcapOpts{order: binary.LittleEndian, snaplen: 0xFFFF, packets: 4}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	traffic, err := OpenFileMapped(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last Packet
+	for {
+		_, packet, err := traffic.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		last = packet
+	}
+	if !bytes.Equal(last, genData(3, genSizes[3])) {
+		t.Error("the last packet differs after the end")
+	}
+	traffic.Stop()
+}
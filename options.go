@@ -0,0 +1,126 @@
+package pcapreader
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+)
+
+// the limits that are used when ReaderOptions do not set them
+const (
+	defaultMaxSnaplen   = 1 << 24
+	defaultMaxBlockSize = 1 << 24
+)
+
+// ReaderOptions change how captures are read.
+// The zero value is what OpenFile and OpenReader use.
+type ReaderOptions struct {
+	// Instead of stopping at the first malformed record or block, the
+	// capture is searched for the next one that looks right and reading
+	// continues there. A record looks right when its lengths are
+	// consistent with each other and with the record after it and
+	// its timestamp is not far off from the last good one.
+	Recover bool
+
+	// Called for every part of the capture that has been skipped
+	// while recovering, in the order they appear in the capture.
+	OnRecover func(Skipped)
+
+	// Reads the packets of all interfaces of a pcapng, whatever their
+	// link layer type, i.e. those of merged captures. InterfaceOf tells
+	// which interface and link layer type a packet belongs to, while
+	// LinkLayerType is the one of the first interface. Without it only
+	// one interface is followed, see the readme.
+	AllInterfaces bool
+
+	// Limits for captures from untrusted sources. Reading fails with
+	// a *FormatError that wraps ErrLimitExceeded when a capture goes
+	// beyond one, even when recovering. Zero means the default.
+
+	// The largest packet. Captures often declare a snaplen much larger
+	// than their packets, so only the packets themselves are checked.
+	// Defaults to 16MiB.
+	MaxSnaplen uint32
+	// The largest pcapng block. Defaults to 16MiB.
+	MaxBlockSize uint32
+	// The most bytes of options a pcapng block may have.
+	// Defaults to MaxBlockSize.
+	MaxOptionsSize uint32
+	// The most interfaces a pcapng section may have.
+	// Defaults to no limit.
+	MaxInterfaces uint32
+	// The most sections a pcapng may have. Defaults to no limit.
+	MaxSections uint32
+}
+
+// fills in the limits that have not been set
+func (o ReaderOptions) withDefaults() ReaderOptions {
+	if o.MaxSnaplen == 0 {
+		o.MaxSnaplen = defaultMaxSnaplen
+	}
+	if o.MaxBlockSize == 0 {
+		o.MaxBlockSize = defaultMaxBlockSize
+	}
+	if o.MaxOptionsSize == 0 {
+		o.MaxOptionsSize = o.MaxBlockSize
+	}
+	if o.MaxInterfaces == 0 {
+		o.MaxInterfaces = math.MaxUint32
+	}
+	if o.MaxSections == 0 {
+		o.MaxSections = math.MaxUint32
+	}
+	return o
+}
+
+// Skipped is a part of a capture that has been
+// skipped because it could not be made sense of.
+type Skipped struct {
+	// where the skipped part starts and how long it is
+	Offset int64
+	Length int64
+	// why it was skipped, this is a *FormatError
+	// that wraps ErrMalformedPcap
+	Reason error
+}
+
+// Like OpenFile but with options
+func OpenFileWithOptions(name string, opts ReaderOptions) (Traffic, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return openByExtension(name, newReaderSource(f), opts)
+}
+
+// Like OpenReader but with options
+func OpenReaderWithOptions(r io.Reader, opts ReaderOptions) (Traffic, error) {
+	rc, ok := r.(io.ReadCloser)
+	if !ok {
+		rc = io.NopCloser(r)
+	}
+	src := newReaderSource(rc)
+
+	magic, err := src.peek(4)
+	switch err {
+	case nil:
+	case io.EOF:
+		if len(magic) == 0 {
+			src.Close()
+			return nil, fileHeaderErr(ErrEmptyPcap, "no file header")
+		}
+		src.Close()
+		return nil, fileHeaderErr(ErrMalformedPcap, "file header cut off")
+	default:
+		src.Close()
+		return nil, err
+	}
+
+	// the block type of the SHB reads the same in both byte orders
+	if binary.BigEndian.Uint32(magic) == ngSHB {
+		return readPcapNg(src, opts)
+	}
+	return readPcap(src, opts)
+}
@@ -1,7 +1,9 @@
 package pcapreader
 
 import (
+	"context"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"time"
 )
@@ -26,18 +28,23 @@ func checkMagic(header []byte) (binary.ByteOrder, uint32, error) {
 	case magicMicrosecondsBigendian:
 		return binary.BigEndian, 1000, nil
 	default:
-		return nil, 0, ErrMalformedPcap
+		return nil, 0, fileHeaderErr(ErrMalformedPcap, fmt.Sprintf("unknown magic 0x%08X", magic))
 	}
 }
 
+// the error for a capture whose file header is broken
+func fileHeaderErr(err error, reason string) *FormatError {
+	return &FormatError{Err: err, Block: -1, Reason: reason}
+}
+
 type pcap struct {
 	// weather the traffic source has been stopped
 	// in this case this means that the pcap file
 	// has been closed already
 	dead bool
-	// the source stream to read from.
+	// the source to read from.
 	// this stems from an opened file
-	reader io.ReadCloser
+	src source
 	// the byte order of the data in the pcap.
 	// The magic in the pcap header tells what
 	// byte order the pcap is in.
@@ -60,7 +67,23 @@ type pcap struct {
 	// (non-static allocation)
 
 	packetHeader []byte
-	packetData   []byte
+	packetInfo   PacketInfo
+
+	// where the record of the last packet started.
+	// This is what an Index is built from.
+	recordOffset int64
+	// how many packets have been read
+	packets uint64
+
+	// a packet that did not fit into a batch
+	pending pendingPacket
+
+	opts ReaderOptions
+	// the timestamp of the last packet, which is
+	// used to tell if a record found while recovering
+	// is real
+	lastSecs     uint32
+	haveLastSecs bool
 }
 
 /* Convenience functions for getting certain packet header data */
@@ -81,6 +104,17 @@ func (p *pcap) packetActualSize() uint32 {
 	return p.byteOrder.Uint32(p.packetHeader[12:16])
 }
 
+// the error for the record that is being read
+func (p *pcap) formatErr(err error, format string, args ...any) *FormatError {
+	return &FormatError{
+		Err:    err,
+		Offset: p.recordOffset,
+		Packet: p.packets,
+		Block:  -1,
+		Reason: fmt.Sprintf(format, args...),
+	}
+}
+
 /* Interface functions */
 
 func (p *pcap) LinkLayerType() LinkLayerType {
@@ -91,92 +125,123 @@ func (p *pcap) Next() (*PacketInfo, Packet, error) {
 	if p.dead {
 		return nil, nil, ErrTrafficSourceAlreadyStopped
 	}
+	if p.pending.ok {
+		return p.pending.take()
+	}
+
+	if p.opts.Recover {
+		if err := p.findRecord(); err != nil {
+			p.end()
+			return nil, nil, err
+		}
+	}
 
-	var err error
+	p.recordOffset = p.src.offset()
 
 	// read header
-	_, err = io.ReadFull(p.reader, p.packetHeader)
+	header, err := p.src.next(len(p.packetHeader))
 	switch {
 	// no more data to read
 	case err == io.EOF:
-		p.Stop()
+		p.end()
 		return nil, nil, io.EOF
 	case err == io.ErrUnexpectedEOF:
-		p.Stop()
-		return nil, nil, ErrMalformedPcap
+		p.end()
+		return nil, nil, p.formatErr(ErrMalformedPcap, "record header cut off")
 	case err != nil:
-		p.Stop()
+		p.end()
 		return nil, nil, err
 	default:
 	}
 
+	// the source might reuse the memory of the
+	// header for the data so keep a copy
+	copy(p.packetHeader, header)
+
 	// read data
 	savedSize := p.packetSavedSize()
 
 	if savedSize > p.snaplen {
-		p.Stop()
-		return nil, nil, ErrMalformedPcap
+		p.end()
+		return nil, nil, p.formatErr(ErrMalformedPcap, "saved size %d exceeds snaplen %d", savedSize, p.snaplen)
+	}
+	if savedSize > p.opts.MaxSnaplen {
+		p.end()
+		return nil, nil, p.formatErr(ErrLimitExceeded, "saved size %d exceeds the limit of %d", savedSize, p.opts.MaxSnaplen)
 	}
 
-	err = binary.Read(p.reader, p.byteOrder, p.packetData[0:savedSize])
+	data, err := p.src.next(int(savedSize))
 	switch {
-	case err == io.EOF && savedSize == 0:
-		p.Stop()
+	case (err == io.ErrUnexpectedEOF || err == io.EOF) && p.opts.Recover:
+		// the last record has been cut off, there is nothing after it
+		reason := p.formatErr(ErrMalformedPcap, "packet data cut off")
+		p.opts.skipped(p.recordOffset, p.src.offset()-p.recordOffset, reason)
+		p.end()
 		return nil, nil, io.EOF
 	case err == io.ErrUnexpectedEOF || err == io.EOF:
-		p.Stop()
-		return nil, nil, ErrMalformedPcap
+		p.end()
+		return nil, nil, p.formatErr(ErrMalformedPcap, "packet data cut off")
 	case err != nil:
-		p.Stop()
+		p.end()
 		return nil, nil, err
 	}
 
-	return &PacketInfo{
+	p.lastSecs = p.timeStampSecs()
+	p.haveLastSecs = true
+	p.packets++
+
+	p.packetInfo = PacketInfo{
 		CaptureTime: time.Unix(int64(p.timeStampSecs()), int64(p.timeStampMSecs()*p.nanoSecsFactor)).UTC(),
 		// size is the size of the packet not how it is saved
 		Size: p.packetActualSize(),
-	}, p.packetData[0:savedSize], nil
+	}
+	return &p.packetInfo, data, nil
 }
 
-func (p *pcap) Stop() {
+func (p *pcap) ReadBatch(infos []PacketInfo, packets []Packet, buf []byte) (int, error) {
+	return fillBatch(p.Next, &p.pending, infos, packets, buf)
+}
+
+func (p *pcap) setContext(ctx context.Context) {
+	p.src.setContext(ctx)
+}
+
+// ends reading after the last packet or an error. The memory
+// the packet returned last points into is kept until Stop.
+func (p *pcap) end() {
 	if !p.dead {
-		p.reader.Close()
+		p.src.end()
 	}
+	p.pending = pendingPacket{}
 	p.dead = true
 }
 
-/*  */
+func (p *pcap) Stop() {
+	p.end()
+	p.src.Close()
+}
 
-func readPcap(reader io.ReadCloser) (Traffic, error) {
-	header := make([]byte, 24)
-	n, err := io.ReadFull(reader, header)
+/*  */
 
-	// accept eof as err as it indicates
-	// invalid pcap header
-	if err != nil && err != io.EOF {
-		reader.Close()
-		return nil, err
-	}
-	if n != len(header) {
-		reader.Close()
-		return nil, ErrMalformedPcap
-	}
+func readPcap(src source, opts ReaderOptions) (Traffic, error) {
+	header, err := src.next(24)
 	switch err {
 	case io.EOF:
-		reader.Close()
-		return nil, ErrEmptyPcap
+		src.Close()
+		return nil, fileHeaderErr(ErrEmptyPcap, "no file header")
 	case io.ErrUnexpectedEOF:
-		reader.Close()
-		return nil, ErrMalformedPcap
+		src.Close()
+		return nil, fileHeaderErr(ErrMalformedPcap, "file header cut off")
 	case nil:
 		// nothing to do here
 	default:
+		src.Close()
 		return nil, err
 	}
 
 	byteOrder, nanoSecsFactor, err := checkMagic(header)
 	if err != nil {
-		reader.Close()
+		src.Close()
 		return nil, err
 	}
 
@@ -186,18 +251,17 @@ func readPcap(reader io.ReadCloser) (Traffic, error) {
 	major := byteOrder.Uint16(header[4:6])
 	minor := byteOrder.Uint16(header[6:8])
 	if major != 2 || minor != 4 {
-		reader.Close()
-		return nil, ErrPcapVersionNotSupported
+		src.Close()
+		return nil, fileHeaderErr(ErrPcapVersionNotSupported, fmt.Sprintf("version %d.%d", major, minor))
 	}
 
-	snaplen := byteOrder.Uint32(header[16:20])
 	return &pcap{
-		reader:         reader,
+		src:            src,
 		nanoSecsFactor: nanoSecsFactor,
 		byteOrder:      byteOrder,
-		snaplen:        snaplen,
+		snaplen:        byteOrder.Uint32(header[16:20]),
 		llt:            LinkLayerType(byteOrder.Uint32(header[20:24])),
 		packetHeader:   make([]byte, 16),
-		packetData:     make([]byte, snaplen),
+		opts:           opts.withDefaults(),
 	}, nil
 }
@@ -1,10 +1,15 @@
 package pcapreader
 
 import (
-	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"math"
+	"math/bits"
+	"slices"
 	"time"
 )
 
@@ -17,6 +22,10 @@ import (
 const ngByteOrderMagic uint32 = 0x1A2B3C4D
 const ngUnsetIfId uint32 = 0xFFFFFFFF // max
 
+// the section length of streamed captures, as
+// they cannot know how long a section will be
+const ngUnknownSectionLen uint64 = 0xFFFFFFFFFFFFFFFF // -1
+
 // Block types that are are worth reading
 const (
 	// Section Header Block
@@ -28,6 +37,19 @@ const (
 	ngSPB uint32 = 0x00000003
 	// Enhanced Packet Block
 	ngEPB uint32 = 0x00000006
+	// Interface Statistics Block
+	ngISB uint32 = 0x00000005
+)
+
+// the smallest total length of the blocks, which is
+// the part of them that always has to be there
+const (
+	ngMinBlockLen uint32 = 12
+	ngMinSHBLen   uint32 = 28
+	ngMinIDBLen   uint32 = 20
+	ngMinSPBLen   uint32 = 16
+	ngMinEPBLen   uint32 = 32
+	ngMinISBLen   uint32 = 24
 )
 
 // records what state we are currently in
@@ -61,8 +83,94 @@ const (
 	ngRSData ngReaderState = ngRSSPB | ngRSEPB
 )
 
+// the short name of the block type, as used in the spec
+func ngBlockTypeName(blockType uint32) string {
+	switch blockType {
+	case ngSHB:
+		return "SHB"
+	case ngIDB:
+		return "IDB"
+	case ngSPB:
+		return "SPB"
+	case ngEPB:
+		return "EPB"
+	case ngISB:
+		return "ISB"
+	default:
+		return fmt.Sprintf("block type 0x%08X", blockType)
+	}
+}
+
 type ngReader func(*pcapng) (ngReaderState, error)
 
+// the error for the block that is being read
+func (p *pcapng) formatErr(err error, format string, args ...any) *FormatError {
+	return &FormatError{
+		Err:       err,
+		Offset:    p.blockOffset,
+		Packet:    p.packets,
+		Block:     p.block,
+		BlockType: p.blockType,
+		Reason:    fmt.Sprintf(format, args...),
+	}
+}
+
+// checks the total length of the block that is being read
+// against the smallest size of its type and the limit
+func (p *pcapng) checkBlockLen(length uint32, minLen uint32) error {
+	switch {
+	case length < minLen:
+		return p.formatErr(ErrMalformedPcap, "block total length %d is less than %d", length, minLen)
+	case length%4 != 0:
+		return p.formatErr(ErrMalformedPcap, "block total length %d is not a multiple of 4", length)
+	case length > p.opts.MaxBlockSize:
+		return p.formatErr(ErrLimitExceeded, "block total length %d exceeds the limit of %d", length, p.opts.MaxBlockSize)
+	}
+	return nil
+}
+
+// checks how many bytes of options a block has against the limit
+func (p *pcapng) checkOptionsLen(length uint32) error {
+	if length > p.opts.MaxOptionsSize {
+		return p.formatErr(ErrLimitExceeded, "%d bytes of options exceed the limit of %d", length, p.opts.MaxOptionsSize)
+	}
+	return nil
+}
+
+// checks the captured length of a packet against the limit
+func (p *pcapng) checkCapturedLen(length uint32) error {
+	if length > p.opts.MaxSnaplen {
+		return p.formatErr(ErrLimitExceeded, "captured length %d exceeds the limit of %d", length, p.opts.MaxSnaplen)
+	}
+	return nil
+}
+
+// reads the block total length at the end of the block,
+// which has to be the same as the one at its start
+func (p *pcapng) readBlockEnd(blockLen uint32) error {
+	buff, err := p.src.next(4)
+	if err != nil {
+		return p.readErr(err)
+	}
+	return p.checkBlockEnd(buff, blockLen)
+}
+
+func (p *pcapng) checkBlockEnd(end []byte, blockLen uint32) error {
+	if trailing := p.byteOrder.Uint32(end); trailing != blockLen {
+		return p.formatErr(ErrMalformedPcap, "block total length %d at the end does not match %d", trailing, blockLen)
+	}
+	return nil
+}
+
+// running out of data in the middle of a block means that
+// the file is broken, everything else is passed along
+func (p *pcapng) readErr(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return p.formatErr(ErrMalformedPcap, "block cut off")
+	}
+	return err
+}
+
 var stateToReader map[ngReaderState]ngReader = map[ngReaderState]ngReader{
 	ngRSIgnoreBlock:   ngIgnoreBlockReader,
 	ngRSIgnoreSection: ngIgnoreSectionReader,
@@ -77,21 +185,42 @@ var stateToReader map[ngReaderState]ngReader = map[ngReaderState]ngReader{
 // reader for the rest of the block
 // this reader starts without requireing any previous informatuin
 func pcapngBlockTypeReader(p *pcapng) (ngReaderState, error) {
-	var err error
-	buff := make([]byte, 4)
+	p.blockOffset = p.src.offset()
+	p.blockType = 0
+	if p.block >= 0 {
+		p.block++
+	}
 
-	err = p.readFull(buff)
+	if p.opts.Recover {
+		if err := p.findBlock(); err != nil {
+			return ngRSDone, err
+		}
+		p.blockOffset = p.src.offset()
+	}
+
+	buff, err := p.src.next(4)
 
-	// as we dont expect any other errors
-	// no others are being addressed here
+	// running out of data between blocks is the normal end
 	switch err {
+	case nil:
 	case io.EOF:
 		return ngRSDone, io.EOF
-	case io.ErrUnexpectedEOF:
-		return ngRSDone, ErrMalformedPcap
+	default:
+		return ngRSDone, p.readErr(err)
+	}
+
+	// the block type of a SHB reads the same in both byte orders,
+	// which is all that can be found before the first section
+	blockType := binary.BigEndian.Uint32(buff)
+	if p.byteOrder != nil {
+		blockType = p.byteOrder.Uint32(buff)
 	}
+	p.blockType = blockType
 
-	blockType := p.byteOrder.Uint32(buff)
+	// only a new section ends a section that is being ignored
+	if p.ignoringSection && blockType != ngSHB {
+		return ngRSIgnoreBlock, nil
+	}
 
 	switch blockType {
 	case ngSHB:
@@ -111,34 +240,49 @@ func pcapngBlockTypeReader(p *pcapng) (ngReaderState, error) {
 // reads the block total length and discards the rest of the block.
 // this reader starts reading after the block type has been read
 func ngIgnoreBlockReader(p *pcapng) (ngReaderState, error) {
-	var err error
-
-	buff := make([]byte, 4)
-	err = p.readFull(buff)
-	if err == io.EOF || err == io.ErrUnexpectedEOF {
-		return ngRSDone, ErrMalformedPcap
+	buff, err := p.src.next(4)
+	if err != nil {
+		return ngRSDone, p.readErr(err)
 	}
 
-	totalLength := p.byteOrder.Uint16(buff)
+	totalLength := p.byteOrder.Uint32(buff)
+	if err := p.checkBlockLen(totalLength, ngMinBlockLen); err != nil {
+		return ngRSDone, err
+	}
 
 	// discard the rest of it which is the block len minus what
 	// we have already read (block type and block total length)
-	err = p.writeNInto(io.Discard, int64(totalLength)-8)
-	if err == io.EOF || err == io.ErrUnexpectedEOF {
-		return ngRSDone, ErrMalformedPcap
+	// and the block total length at the end
+	err = p.src.skip(int64(totalLength) - 12)
+	if err != nil {
+		return ngRSDone, p.readErr(err)
+	}
+	if err := p.readBlockEnd(totalLength); err != nil {
+		return ngRSDone, err
 	}
 
 	return ngRSBlockType, nil
 }
 
 // discards an entire section by looking at sectionLen and sectionOffset
-// it does not matter where this reader starts from as long as all
-// reads from p.reader are done using the methods
-// p.writeNInto and p.readFull
+// it does not matter where this reader starts from.
+// When the length of the section is not known, which is the case
+// for captures that are streamed, the blocks until the next section
+// are ignored one by one instead.
 func ngIgnoreSectionReader(p *pcapng) (ngReaderState, error) {
-	_, err := io.CopyN(io.Discard, p.reader, int64(p.sectionLen-p.sectionOffset))
-	if err == io.EOF || err == io.ErrUnexpectedEOF {
-		return ngRSDone, ErrMalformedPcap
+	if p.sectionLen == ngUnknownSectionLen {
+		p.ignoringSection = true
+		return ngRSBlockType, nil
+	}
+
+	offset := p.sectionOffset()
+	if offset > p.sectionLen || p.sectionLen-offset > math.MaxInt64 {
+		return ngRSDone, p.formatErr(ErrMalformedPcap, "section length %d does not fit the section", p.sectionLen)
+	}
+
+	err := p.src.skip(int64(p.sectionLen - offset))
+	if err != nil {
+		return ngRSDone, p.readErr(err)
 	}
 
 	return ngRSBlockType, nil
@@ -147,24 +291,19 @@ func ngIgnoreSectionReader(p *pcapng) (ngReaderState, error) {
 // reads section header block and stores this in the pcapng struct.
 // this reader starts reading after the block type has been read
 func ngSHBReader(p *pcapng) (ngReaderState, error) {
-	p.ifCounter = 0
+	// a new slice, as an Index may still hold on to the old one
+	p.interfaces = nil
 	p.ifId = ngUnsetIfId
-	p.snaplen = 0
-	p.secondMask = 0
-	p.timeZone = 0
-	p.timeOffset = 0
-	p.sectionOffset = 0
+	p.ignoringSection = false
 
 	// this buff in only for
 	// - block total length
 	// - byte order
 	// - major, minor
 	// - section length
-	buff := make([]byte, 20)
-
-	err := p.readFull(buff)
-	if err == io.EOF || err == io.ErrUnexpectedEOF {
-		return ngRSDone, ErrMalformedPcap
+	buff, err := p.src.next(20)
+	if err != nil {
+		return ngRSDone, p.readErr(err)
 	}
 
 	// determine byte order
@@ -174,36 +313,48 @@ func ngSHBReader(p *pcapng) (ngReaderState, error) {
 	case binary.LittleEndian.Uint32(buff[4:8]) == ngByteOrderMagic:
 		p.byteOrder = binary.LittleEndian
 	default:
-		return ngRSDone, ErrMalformedPcap
+		return ngRSDone, p.formatErr(ErrMalformedPcap, "unknown byte order magic 0x%08X", binary.BigEndian.Uint32(buff[4:8]))
 	}
 
 	// size of SHB
 	blockLen := p.byteOrder.Uint32(buff[0:4])
+	if err := p.checkBlockLen(blockLen, ngMinSHBLen); err != nil {
+		return ngRSDone, err
+	}
+	if err := p.checkOptionsLen(blockLen - ngMinSHBLen); err != nil {
+		return ngRSDone, err
+	}
+	p.sections++
+	if p.sections > p.opts.MaxSections {
+		return ngRSDone, p.formatErr(ErrLimitExceeded, "more than %d sections", p.opts.MaxSections)
+	}
 
 	p.sectionLen = p.byteOrder.Uint64(buff[12:20])
 
 	// discard the rest of the block as we dont
 	// require the information which is everything
 	// except of the header start 8 but and the 4bit
-	// block type that has been written before
-	discardAmount := int64(blockLen) - (int64(len(buff)) + 4)
+	// block type that has been written before and
+	// the block total length at the end
+	discardAmount := int64(blockLen) - (int64(len(buff)) + 8)
 
 	// as per spec, one should treat a minor of 2 as being 0
 	major := p.byteOrder.Uint16(buff[8:10])
 	minor := p.byteOrder.Uint16(buff[10:12])
-	if major != 1 || (minor != 0 && minor != 2) {
+	err = p.src.skip(discardAmount)
+	if err != nil {
+		return ngRSDone, p.readErr(err)
+	}
+	if err := p.readBlockEnd(blockLen); err != nil {
+		return ngRSDone, err
+	}
 
-		// if the section length is not specified
-		// (-1 int64(sectionLen))
-		// we can not discard the entire section
-		if p.sectionLen != 0xFFFFFFFFFFFFFFFF {
-			// discard the entire section + the remaining block
-			discardAmount += int64(p.sectionLen)
-		}
+	// the section length does not include the SHB
+	p.sectionStart = p.src.offset()
 
+	if major != 1 || (minor != 0 && minor != 2) {
+		return ngRSIgnoreSection, nil
 	}
-
-	p.writeNInto(io.Discard, discardAmount)
 	return ngRSBlockType, nil
 }
 
@@ -218,13 +369,12 @@ func ngIDBReader(p *pcapng) (ngReaderState, error) {
 		timeResolution byte = 6
 		timeZone       uint32
 		timeOffset     uint64
+		iface          ngInterface
 	)
 
-	headerStart := make([]byte, 12)
-
-	err := p.readFull(headerStart)
-	if err == io.EOF || err == io.ErrUnexpectedEOF {
-		return ngRSDone, ErrMalformedPcap
+	headerStart, err := p.src.next(12)
+	if err != nil {
+		return ngRSDone, p.readErr(err)
 	}
 
 	// we have to kee everything here, because we still
@@ -232,24 +382,43 @@ func ngIDBReader(p *pcapng) (ngReaderState, error) {
 	blockLength := p.byteOrder.Uint32(headerStart[0:4])
 	linkLayerType = LinkLayerType(p.byteOrder.Uint16(headerStart[4:6]))
 	snaplen = p.byteOrder.Uint32(headerStart[8:12])
+	if err := p.checkBlockLen(blockLength, ngMinIDBLen); err != nil {
+		return ngRSDone, err
+	}
+	if err := p.checkOptionsLen(blockLength - ngMinIDBLen); err != nil {
+		return ngRSDone, err
+	}
+	if uint32(len(p.interfaces)) >= p.opts.MaxInterfaces {
+		return ngRSDone, p.formatErr(ErrLimitExceeded, "more than %d interfaces in the section", p.opts.MaxInterfaces)
+	}
 
 	// read in options before handling them so from now on we
 	// can ignore the interface whenever we want
-	options := make([]byte, int(blockLength)-(len(headerStart)+8))
-	err = p.readFull(options)
-	if err == io.EOF || err == io.ErrUnexpectedEOF {
-		return ngRSDone, ErrMalformedPcap
+	rest, err := p.src.next(int(blockLength) - (len(headerStart) + 4))
+	if err != nil {
+		return ngRSDone, p.readErr(err)
+	}
+	options := rest[:len(rest)-4]
+	if err := p.checkBlockEnd(rest[len(rest)-4:], blockLength); err != nil {
+		return ngRSDone, err
 	}
 
 	// data from other link layer are ignored
-	if p.linkLayerType != 0 && p.linkLayerType != LinkLayerType(linkLayerType) {
+	// unless all interfaces are read
+	if !p.opts.AllInterfaces && p.linkLayerType != 0 && p.linkLayerType != LinkLayerType(linkLayerType) {
 		goto ignoreInterface
 	}
 
 optionLoop:
 	for optionOffset := 0; optionOffset < len(options); {
+		if len(options)-optionOffset < 4 {
+			return ngRSDone, p.formatErr(ErrMalformedPcap, "option header cut off")
+		}
 		optionCode := p.byteOrder.Uint16(options[optionOffset : optionOffset+2])
 		optionValueLen := p.byteOrder.Uint16(options[optionOffset+2 : optionOffset+4])
+		if int(optionValueLen) > len(options)-optionOffset-4 {
+			return ngRSDone, p.formatErr(ErrMalformedPcap, "option %d with length %d does not fit into the block", optionCode, optionValueLen)
+		}
 		optionValue := options[optionOffset+4 : optionOffset+4+int(optionValueLen)]
 		switch optionCode {
 		case 0: // official end of options
@@ -257,15 +426,28 @@ optionLoop:
 		case 2: // if_name
 			hash := md5.Sum(optionValue)
 			nameHash = binary.BigEndian.Uint16(hash[:])
-			if p.ifNameHash != 0 && p.ifNameHash != nameHash {
+			if !p.opts.AllInterfaces && p.ifNameHash != 0 && p.ifNameHash != nameHash {
 				goto ignoreInterface
 			}
 
 		case 9: // if_tsresol
+			if len(optionValue) != 1 {
+				return ngRSDone, p.formatErr(ErrMalformedPcap, "if_tsresol has length %d instead of 1", len(optionValue))
+			}
 			timeResolution = optionValue[0]
+			// the amount of units per second has to fit into 64 bit
+			if exp := timeResolution & 0x7F; (timeResolution>>7 == 1 && exp > 63) || (timeResolution>>7 == 0 && exp > 19) {
+				return ngRSDone, p.formatErr(ErrMalformedPcap, "if_tsresol 0x%02X is too fine", timeResolution)
+			}
 		case 10: // if_tszone
+			if len(optionValue) != 4 {
+				return ngRSDone, p.formatErr(ErrMalformedPcap, "if_tszone has length %d instead of 4", len(optionValue))
+			}
 			timeZone = p.byteOrder.Uint32(optionValue)
 		case 14: // if_tsoffset
+			if len(optionValue) != 8 {
+				return ngRSDone, p.formatErr(ErrMalformedPcap, "if_tsoffset has length %d instead of 8", len(optionValue))
+			}
 			timeOffset = p.byteOrder.Uint64(optionValue)
 		}
 
@@ -278,42 +460,37 @@ optionLoop:
 		optionOffset += 4 + int(optionValueLen) // size of code + size of value
 	}
 
-	// copy all into the pcapng struct
-	p.ifId = p.ifCounter
-	p.ifNameHash = nameHash
-	p.snaplen = snaplen
-	p.linkLayerType = linkLayerType
-	p.timeZone = int32(timeZone)
-	p.timeOffset = timeOffset
-	// ensure the cap of the data buffer as the
-	// largest size is known. But we can grow
-	// the buffer when we switch interfaces
-	p.packetDataRaw.Grow(int(snaplen))
+	// copy all into the pcapng struct. When all interfaces
+	// are read the first one tells the link layer type of
+	// the traffic, otherwise the one that is followed does.
+	if !p.opts.AllInterfaces || p.linkLayerType == 0 {
+		p.linkLayerType = linkLayerType
+	}
+	if !p.opts.AllInterfaces {
+		p.ifId = uint32(len(p.interfaces))
+		p.ifNameHash = nameHash
+	}
+	iface = ngInterface{
+		accepted:      true,
+		linkLayerType: linkLayerType,
+		snaplen:       snaplen,
+		timeZone:      int32(timeZone),
+		timeOffset:    timeOffset,
+	}
 
 	if timeResolution>>7 == 1 { // second resolution
-		p.secondMask = 1 << timeResolution
+		iface.secondMask = 1 << (timeResolution & 0x7F)
 	} else { // microsecond resolution
-		p.secondMask = 1
+		iface.secondMask = 1
 		for i := uint8(0); i < timeResolution; i++ {
-			p.secondMask *= 10
+			iface.secondMask *= 10
 		}
 	}
-	p.tsScaleDown = 1
-	p.tsScaleUp = 1
-	if p.secondMask < 1e9 {
-		p.tsScaleUp = 1e9 / p.secondMask
-	} else {
-		p.tsScaleDown = p.secondMask / 1e9
-	}
 
 ignoreInterface:
-	// discard final block total length
-	err = p.writeNInto(io.Discard, 4)
-	if err == io.EOF || err == io.ErrUnexpectedEOF {
-		return ngRSDone, ErrMalformedPcap
-	}
-
-	p.ifCounter += 1
+	// ignored interfaces are kept as well, as
+	// the EPBs refer to interfaces by their index
+	p.interfaces = append(p.interfaces, iface)
 
 	return ngRSBlockType, nil
 }
@@ -321,41 +498,74 @@ ignoreInterface:
 // reads a simple packet block
 // the reader starts after the block type has been read
 func ngSPBReader(p *pcapng) (ngReaderState, error) {
-	buff := make([]byte, 8)
-	err := p.readFull(buff)
-	if err == io.EOF || err == io.ErrUnexpectedEOF {
-		return ngRSDone, ErrMalformedPcap
+	buff, err := p.src.next(8)
+	if err != nil {
+		return ngRSDone, p.readErr(err)
 	}
 
 	blockLen := p.byteOrder.Uint32(buff[0:4])
 	origPacketLen := p.byteOrder.Uint32(buff[4:8])
-
-	// if no valid interface was found we can ignore the entire section
-	if p.ifId == ngUnsetIfId && p.ifCounter > 0 {
-		err = p.writeNInto(io.Discard, int64(blockLen)-int64(len(buff)+4)) // header start + block type
-		if err == io.EOF || err == io.ErrUnexpectedEOF {
-			return ngRSDone, ErrMalformedPcap
+	if err := p.checkBlockLen(blockLen, ngMinSPBLen); err != nil {
+		return ngRSDone, err
+	}
+
+	// SPBs belong to the first interface, or to the one that is
+	// followed when not all are read. Without any interface the
+	// packets are passed along as they are.
+	var iface ngInterface
+	ifId := uint32(0)
+	if !p.opts.AllInterfaces && p.ifId != ngUnsetIfId {
+		ifId = p.ifId
+	}
+	if int(ifId) < len(p.interfaces) {
+		iface = p.interfaces[ifId]
+	}
+	if len(p.interfaces) > 0 && !p.follows(ifId) {
+		// header start + block type + block total length at the end
+		err = p.src.skip(int64(blockLen) - int64(len(buff)+8))
+		if err != nil {
+			return ngRSDone, p.readErr(err)
 		}
-		return ngRSIgnoreSection, nil
+		if err := p.readBlockEnd(blockLen); err != nil {
+			return ngRSDone, err
+		}
+		return ngRSBlockType, nil
 	}
 
-	p.packetDataRaw.Reset()
-
-	// read data into the buffer
-	err = p.writeNInto(&p.packetDataRaw, int64(blockLen)-16)
-	if err == io.EOF || err == io.ErrUnexpectedEOF {
-		return ngRSDone, ErrMalformedPcap
+	// the data is padded to 32 bits so the captured
+	// length is the smaller one of the two
+	capturedLen := blockLen - ngMinSPBLen
+	if origPacketLen < capturedLen {
+		capturedLen = origPacketLen
+	}
+	// and cut off at the snaplen of the interface, if it has one
+	if iface.snaplen != 0 && iface.snaplen < capturedLen {
+		capturedLen = iface.snaplen
+	}
+	if err := p.checkCapturedLen(capturedLen); err != nil {
+		return ngRSDone, err
 	}
 
-	err = p.writeNInto(io.Discard, 4)
-	if err == io.EOF || err == io.ErrUnexpectedEOF {
-		return ngRSDone, ErrMalformedPcap
+	// the data, its padding and the block total length at the end are
+	// read at once as reading again might overwrite what has been read
+	rest, err := p.src.next(int(blockLen) - (len(buff) + 4))
+	if err != nil {
+		return ngRSDone, p.readErr(err)
+	}
+	if err := p.checkBlockEnd(rest[len(rest)-4:], blockLen); err != nil {
+		return ngRSDone, err
 	}
 
+	// the data is passed along as it is
+	p.packetData = rest[:capturedLen]
+	p.packetIfId = ifId
+
 	// set metadata of packet
 	p.packetInfo = PacketInfo{
 		Size: origPacketLen,
 	}
+	p.packetRead = true
+	p.packets++
 
 	return ngRSBlockType, nil
 }
@@ -363,114 +573,216 @@ func ngSPBReader(p *pcapng) (ngReaderState, error) {
 // reads an extended packet block
 // the reader starts after the block type has been read
 func ngEPBReader(p *pcapng) (ngReaderState, error) {
-	p.packetDataRaw.Reset()
-
-	buff := make([]byte, 24)
-
-	err := p.readFull(buff)
-	if err == io.EOF || err == io.ErrUnexpectedEOF {
-		return ngRSDone, ErrMalformedPcap
+	buff, err := p.src.next(24)
+	if err != nil {
+		return ngRSDone, p.readErr(err)
 	}
 
+	// everything has to be taken from the header before
+	// the data is read as the source may reuse its memory
 	blockLen := p.byteOrder.Uint32(buff[0:4])
 	ifId := p.byteOrder.Uint32(buff[4:8])
+	tsUpper := p.byteOrder.Uint32(buff[8:12])
+	tsLower := p.byteOrder.Uint32(buff[12:16])
+	packetLen := p.byteOrder.Uint32(buff[16:20])
+	origPacketLen := p.byteOrder.Uint32(buff[20:24])
+
+	if err := p.checkBlockLen(blockLen, ngMinEPBLen); err != nil {
+		return ngRSDone, err
+	}
+	if packetLen > blockLen-ngMinEPBLen {
+		return ngRSDone, p.formatErr(ErrMalformedPcap, "EPB captured length %d exceeds block length %d", packetLen, blockLen)
+	}
+	if err := p.checkCapturedLen(packetLen); err != nil {
+		return ngRSDone, err
+	}
+	// the data is padded to 32 bits and followed by the options
+	if err := p.checkOptionsLen(blockLen - ngMinEPBLen - min((packetLen+3)&^3, blockLen-ngMinEPBLen)); err != nil {
+		return ngRSDone, err
+	}
 
-	// discard packet as this is not for the interface
-	// that we are interested in
-	if ifId != p.ifId {
-		err = p.writeNInto(io.Discard, int64(blockLen)-int64(len(buff)+4))
-		if err == io.EOF || err == io.ErrUnexpectedEOF {
-			return ngRSDone, ErrMalformedPcap
+	// discard packet as this is not for an interface
+	// that we are interested in. Without an interface
+	// there is nothing the timestamp could be made of.
+	if !p.follows(ifId) {
+		err = p.src.skip(int64(blockLen) - int64(len(buff)+8))
+		if err != nil {
+			return ngRSDone, p.readErr(err)
 		}
+		if err := p.readBlockEnd(blockLen); err != nil {
+			return ngRSDone, err
+		}
+		return ngRSBlockType, nil
 	}
 
-	packetLen := p.byteOrder.Uint32(buff[16:20])
-	err = p.writeNInto(&p.packetDataRaw, int64(packetLen))
-	if err == io.EOF || err == io.ErrUnexpectedEOF {
-		return ngRSDone, ErrMalformedPcap
+	// the data, the options and the block total length at the end are
+	// read at once as reading again might overwrite what has been read
+	rest, err := p.src.next(int(blockLen) - (len(buff) + 4))
+	if err != nil {
+		return ngRSDone, p.readErr(err)
+	}
+	if err := p.checkBlockEnd(rest[len(rest)-4:], blockLen); err != nil {
+		return ngRSDone, err
 	}
+	p.packetData = rest[:packetLen]
 
-	tsUpper := uint32(p.byteOrder.Uint32(buff[8:12]))
-	tsLower := uint32(p.byteOrder.Uint32(buff[12:16]))
 	ts := uint64(tsUpper)<<32 | uint64(tsLower)
 
-	p.packetInfo.Size = p.byteOrder.Uint32(buff[20:24])
-	p.packetInfo.CaptureTime = time.Unix(int64(ts/p.secondMask+p.timeOffset), int64(ts%p.secondMask*p.tsScaleUp/p.tsScaleDown))
+	p.packetInfo.Size = origPacketLen
+	p.packetInfo.CaptureTime = p.interfaces[ifId].captureTime(ts)
+	p.packetIfId = ifId
+	p.packetRead = true
+	p.packets++
 
-	// discard options and final block total len
-	err = p.writeNInto(io.Discard, int64(blockLen)-int64(len(buff)+int(packetLen)+4))
-	if err == io.EOF || err == io.ErrUnexpectedEOF {
-		return ngRSDone, ErrMalformedPcap
+	return ngRSBlockType, nil
+}
+
+// everything the reader knows from the SHB and the IDBs.
+// This is all that is needed to decode a packet block in the
+// middle of a section, which is why an Index keeps copies of it.
+type ngSectionState struct {
+	// stems from SHB
+
+	byteOrder    binary.ByteOrder
+	sectionLen   uint64 // used to skip over the entire section
+	sectionStart int64  // offset where the section after the SHB starts
+
+	// stems from IDB
+
+	linkLayerType LinkLayerType // created just once
+	ifNameHash    uint16        // created just once
+	// the interface that is followed, unless all are read.
+	// Can be set once per IDB, the last one that fits wins.
+	ifId uint32
+	// one for every IDB of the section, by their id. Elements
+	// are never changed once appended, so copies can share them.
+	interfaces []ngInterface
+}
+
+// reports whether the packets of interface id are read
+func (p *pcapng) follows(id uint32) bool {
+	if int(id) >= len(p.interfaces) || !p.interfaces[id].accepted {
+		return false
 	}
+	return p.opts.AllInterfaces || id == p.ifId
+}
 
-	return ngRSBlockType, nil
+func (s *ngSectionState) equal(o *ngSectionState) bool {
+	return s.byteOrder == o.byteOrder &&
+		s.sectionLen == o.sectionLen &&
+		s.sectionStart == o.sectionStart &&
+		s.linkLayerType == o.linkLayerType &&
+		s.ifNameHash == o.ifNameHash &&
+		s.ifId == o.ifId &&
+		slices.Equal(s.interfaces, o.interfaces)
+}
+
+// what an IDB says about how to read the packets of its interface
+type ngInterface struct {
+	// Whether the IDB has been read. Unless all interfaces
+	// are read, this is only done for those with the link
+	// type and name of the first one.
+	accepted      bool
+	linkLayerType LinkLayerType
+	snaplen       uint32
+	secondMask    uint64
+	timeZone      int32
+	timeOffset    uint64
+}
+
+// turns a timestamp in units of the interface into a time. The
+// part smaller than a second is scaled with 128 bits as the units
+// do not have to be a power of ten, i.e. with a resolution of 2^-30
+func (i *ngInterface) captureTime(ts uint64) time.Time {
+	hi, lo := bits.Mul64(ts%i.secondMask, 1e9)
+	nanos, _ := bits.Div64(hi, lo, i.secondMask)
+	return time.Unix(int64(ts/i.secondMask+i.timeOffset), int64(nanos))
 }
 
 type pcapng struct {
-	dead   bool
-	reader io.ReadCloser
+	dead bool
+	src  source
 
 	readState ngReaderState
 
-	// stems from SHB
+	ngSectionState
 
-	byteOrder     binary.ByteOrder
-	sectionLen    uint64 // used to skip over the entire section
-	sectionOffset uint64 // ^    ^  ^
+	// where the block that is currently being read starts,
+	// how many blocks came before it and its type
+	blockOffset int64
+	block       int64
+	blockType   uint32
+	// how many packets have been read
+	packets uint64
+	// the interface of the last packet
+	packetIfId uint32
 
-	// stems from IDB
+	// set while skipping a section of unknown length
+	ignoringSection bool
 
-	// counter for IDBs per section
-	ifCounter     uint32
-	ifNameHash    uint16        // created just once
-	linkLayerType LinkLayerType // created just once
-	ifId          uint32        // can be set once per SHB
-	snaplen       uint32        // can be set once per SHB
-	secondMask    uint64        // can be set once per SHB
-	timeZone      int32         // can be set once per SHB
-	timeOffset    uint64        // can be set once per SHB
-	tsScaleUp     uint64
-	tsScaleDown   uint64
+	// how many sections have been read
+	sections uint32
+
+	opts ReaderOptions
+	// the total length of the current block, which is
+	// only known when recovering as that checks it first
+	blockLen uint32
+
+	// set by the readers of data blocks when the block
+	// held a packet of the interface we are reading
+	packetRead bool
 
 	// a struct where we store the packet info.
 	// We only allocate space once and place everything
 	// in here. This also plays well with the reader
 	// logic employed here as they cannot return the
 	// extra data.
-	packetInfo    PacketInfo
-	packetDataRaw bytes.Buffer
-}
+	packetInfo PacketInfo
+	packetData Packet
 
-func (p *pcapng) writeNInto(dst io.Writer, amount int64) (err error) {
-	n, err := io.CopyN(dst, p.reader, amount)
-	p.sectionOffset += uint64(n)
-	return
+	// a packet that did not fit into a batch
+	pending pendingPacket
 }
 
-func (p *pcapng) readFull(buff []byte) (err error) {
-	n, err := io.ReadFull(p.reader, buff)
-	p.sectionOffset += uint64(n)
-	return
+// how far into the current section we are
+func (p *pcapng) sectionOffset() uint64 {
+	return uint64(p.src.offset() - p.sectionStart)
 }
 
 func (p *pcapng) Next() (*PacketInfo, Packet, error) {
 	if p.dead {
 		return nil, nil, ErrTrafficSourceAlreadyStopped
 	}
-
-	// as long as there is no data, read all the blocks that come
-	var err error
-	err = p.readTo(ngRSData)
-	if err != nil {
-		return nil, nil, err
+	if p.pending.ok {
+		return p.pending.take()
 	}
 
-	err = p.readTo(^ngRSData)
-	if err != nil {
-		return nil, nil, err
+	// data blocks of other interfaces are read but do not
+	// yield a packet so keep going until one does
+	for !p.packetRead {
+		// the reader that ended reading has reported
+		// why already, so this can only be the end
+		if p.readState == ngRSDone {
+			p.end()
+			return nil, nil, io.EOF
+		}
+
+		// as long as there is no data, read all the blocks that come
+		err := p.readTo(ngRSData)
+		if err != nil {
+			p.end()
+			return nil, nil, err
+		}
+
+		err = p.readTo(^ngRSData)
+		if err != nil {
+			p.end()
+			return nil, nil, err
+		}
 	}
+	p.packetRead = false
 
-	return &p.packetInfo, Packet(p.packetDataRaw.Bytes()), nil
+	return &p.packetInfo, p.packetData, nil
 }
 
 // When reading a pcapng the LinkLayerType might
@@ -481,13 +793,29 @@ func (p *pcapng) LinkLayerType() LinkLayerType {
 	return p.linkLayerType
 }
 
-func (p *pcapng) Stop() {
+func (p *pcapng) ReadBatch(infos []PacketInfo, packets []Packet, buf []byte) (int, error) {
+	return fillBatch(p.Next, &p.pending, infos, packets, buf)
+}
+
+func (p *pcapng) setContext(ctx context.Context) {
+	p.src.setContext(ctx)
+}
+
+// ends reading after the last packet or an error. The memory
+// the packet returned last points into is kept until Stop.
+func (p *pcapng) end() {
 	if !p.dead {
-		p.reader.Close()
+		p.src.end()
 	}
+	p.pending = pendingPacket{}
 	p.dead = true
 }
 
+func (p *pcapng) Stop() {
+	p.end()
+	p.src.Close()
+}
+
 // reads until the current state matches the state mask
 // or we cannot process any further. This only works
 // for aslong the readState is only true at one field
@@ -495,12 +823,15 @@ func (p *pcapng) Stop() {
 func (p *pcapng) readTo(stateMask ngReaderState) (err error) {
 	for p.readState&stateMask == 0 && p.readState != ngRSDone {
 		p.readState, err = stateToReader[p.readState](p)
+		if errors.Is(err, ErrMalformedPcap) && p.opts.Recover {
+			p.readState, err = p.skipBlock(err)
+		}
 	}
 
 	return
 }
 
-func readPcapNg(reader io.ReadCloser) (Traffic, error) {
+func readPcapNg(src source, opts ReaderOptions) (Traffic, error) {
 	var err error
 	// for
 	// read section header block
@@ -508,35 +839,40 @@ func readPcapNg(reader io.ReadCloser) (Traffic, error) {
 	// read enhances packet block
 	// read simple packet block
 	p := &pcapng{
-		reader:    reader,
+		src:       src,
 		readState: ngRSSHB,
+		blockType: ngSHB,
+		opts:      opts.withDefaults(),
 	}
 
 	// read the first block type
-	buff := make([]byte, 4)
-	_, err = io.ReadFull(reader, buff)
+	buff, err := src.next(4)
 	if err != nil {
-		reader.Close()
+		src.Close()
 
 		switch err {
 		case io.EOF:
-			return nil, ErrEmptyPcap
+			return nil, fileHeaderErr(ErrEmptyPcap, "no SHB")
 		case io.ErrUnexpectedEOF:
-			return nil, ErrMalformedPcap
+			return nil, p.formatErr(ErrMalformedPcap, "block cut off")
 		default:
 			return nil, err
 		}
 	}
 
 	// the file must start with a section header block
-	if binary.BigEndian.Uint32(buff) != ngSHB {
-		return nil, ErrMalformedPcap // TODO: actually it is the block type
+	if blockType := binary.BigEndian.Uint32(buff); blockType != ngSHB {
+		src.Close()
+		p.blockType = blockType
+		return nil, p.formatErr(ErrMalformedPcap, "the capture does not start with a SHB")
 	}
 
 	// jump ahead until the next thing is some
-	// data carrying block
+	// data carrying block. A capture without
+	// any packets is fine here, Next reports that.
 	err = p.readTo(ngRSData)
-	if err != nil {
+	if err != nil && err != io.EOF {
+		src.Close()
 		return nil, err
 	}
 
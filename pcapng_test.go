@@ -0,0 +1,92 @@
+package pcapreader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// a capture that is still being written has to be read
+// as far as it goes, without waiting for the writer to finish
+func TestReadPcapNgPipe(t *testing.T) {
+	var g genNg
+	// where the writer pauses and how many packets
+	// the reader has to get before it goes on
+	var cuts, wants []int
+
+	g.shb(binary.LittleEndian, 1, 0)
+	g.idb(1, 0)
+	g.packets(0, 1e6, 0, 2)
+	start := g.b.Len()
+	g.packets(0, 1e6, 2, 1)
+	// in the middle of the third packet
+	cuts, wants = append(cuts, (start+g.b.Len())/2), append(wants, 2)
+
+	start = g.b.Len()
+	g.shb(binary.BigEndian, 1, 0)
+	// in the middle of the header of the second section
+	cuts, wants = append(cuts, start+10), append(wants, 3)
+
+	g.idb(1, 0)
+	g.packets(0, 1e6, 3, 3)
+	cuts, wants = append(cuts, g.b.Len()), append(wants, 6)
+	data := g.b.Bytes()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	read := make(chan int, 6)
+	done := make(chan error, 1)
+	go func() {
+		defer w.Close()
+		start := 0
+		for i, cut := range cuts {
+			if _, err := w.Write(data[start:cut]); err != nil {
+				done <- err
+				return
+			}
+			start = cut
+			for n := 0; n < wants[i]; {
+				select {
+				case n = <-read:
+				case <-time.After(5 * time.Second):
+					done <- fmt.Errorf("%d of %d packets read before writing on", n, wants[i])
+					return
+				}
+			}
+		}
+		done <- nil
+	}()
+
+	traffic, err := OpenReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer traffic.Stop()
+	for i := 0; ; i++ {
+		info, packet, err := traffic.Next()
+		if err == io.EOF {
+			if i != 6 {
+				t.Errorf("EOF after %d packets", i)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		size := genSizes[i%len(genSizes)]
+		if !bytes.Equal(packet, genData(i, size)) || info.Size != uint32(size) {
+			t.Errorf("packet %d differs", i)
+		}
+		read <- i + 1
+	}
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,328 @@
+package pcapreader
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// how much of the capture is looked at at once while
+// searching for the next record that looks right
+const recoverWindow = readerSourceBufferSize
+
+// the largest packet and block that are believed to
+// be real while searching. Captures may have larger
+// ones, they just cannot be recovered at.
+const recoverMaxPacket = 1 << 18
+const recoverMaxBlock = 1 << 24
+
+// how far off in seconds the timestamp of a record found
+// while searching may be from the last one that was good
+const recoverBackward = 60 * 60
+const recoverForward = 24 * 60 * 60
+
+// what a check says about the bytes it has been given
+type recoverVerdict uint8
+
+const (
+	recoverBroken recoverVerdict = iota
+	recoverFine
+	// there are not enough bytes to tell
+	recoverNeedsMore
+)
+
+func (o *ReaderOptions) skipped(offset, length int64, reason error) {
+	if length > 0 && o.OnRecover != nil {
+		o.OnRecover(Skipped{Offset: offset, Length: length, Reason: reason})
+	}
+}
+
+// Searches for the next position at which check says that something
+// good starts and skips everything before it. The current position is
+// known to be broken so the search starts right after it. check is given
+// everything that comes after a position that has been read ahead and
+// whether that is all there is. io.EOF is returned if nothing was found.
+func resync(src source, opts *ReaderOptions, reason error, check func(b []byte, atEnd bool) recoverVerdict) error {
+	start := src.offset()
+	from := 1
+
+	for {
+		window, err := src.peek(recoverWindow)
+		atEnd := err == io.EOF
+		if err != nil && !atEnd {
+			return err
+		}
+
+		found := -1
+		more := -1
+	search:
+		for i := from; i < len(window); i++ {
+			switch check(window[i:], atEnd) {
+			case recoverFine:
+				found = i
+				break search
+			case recoverNeedsMore:
+				// at the start of the window there is no
+				// more to look at, so what is there has to do
+				if i == 0 {
+					found = i
+				} else {
+					more = i
+				}
+				break search
+			}
+		}
+
+		switch {
+		case found >= 0:
+			err = src.skip(int64(found))
+			opts.skipped(start, src.offset()-start, reason)
+			return err
+		case more >= 0:
+			// look again with the candidate at the start of the window
+			if err := src.skip(int64(more)); err != nil {
+				return err
+			}
+			from = 0
+		default:
+			// nothing in there and nothing after it
+			src.skip(int64(len(window)))
+			opts.skipped(start, src.offset()-start, reason)
+			return io.EOF
+		}
+	}
+}
+
+/* pcap */
+
+// reports if header looks like the header of a record. When
+// searching, the timestamp also has to be close to the last one.
+func (p *pcap) plausibleRecord(header []byte, searching bool) bool {
+	secs := p.byteOrder.Uint32(header[0:4])
+	subSecs := p.byteOrder.Uint32(header[4:8])
+	savedSize := p.byteOrder.Uint32(header[8:12])
+	actualSize := p.byteOrder.Uint32(header[12:16])
+
+	if uint64(subSecs)*uint64(p.nanoSecsFactor) >= 1e9 {
+		return false
+	}
+	if savedSize > actualSize {
+		return false
+	}
+	// the same as what Next checks
+	if savedSize > p.snaplen || savedSize > p.opts.MaxSnaplen {
+		return false
+	}
+	if actualSize > recoverMaxPacket && actualSize > p.snaplen {
+		return false
+	}
+
+	if searching && p.haveLastSecs {
+		secs, last := int64(secs), int64(p.lastSecs)
+		if secs < last-recoverBackward || secs > last+recoverForward {
+			return false
+		}
+	}
+
+	return true
+}
+
+// a record found while searching is only believed when
+// the one after it looks right as well or the capture ends
+func (p *pcap) checkRecords(b []byte, atEnd bool) recoverVerdict {
+	const headerLen = 16
+
+	if len(b) < headerLen {
+		if atEnd {
+			return recoverBroken
+		}
+		return recoverNeedsMore
+	}
+	if !p.plausibleRecord(b[:headerLen], true) {
+		return recoverBroken
+	}
+
+	end := headerLen + int(p.byteOrder.Uint32(b[8:12]))
+	switch {
+	case end+headerLen <= len(b):
+		if p.plausibleRecord(b[end:end+headerLen], true) {
+			return recoverFine
+		}
+		return recoverBroken
+	case atEnd && end == len(b):
+		return recoverFine
+	case atEnd:
+		return recoverBroken
+	default:
+		return recoverNeedsMore
+	}
+}
+
+// makes sure that the next record looks right. If it does not
+// the capture is searched for the next one that does.
+func (p *pcap) findRecord() error {
+	header, err := p.src.peek(len(p.packetHeader))
+	if err == io.EOF && len(header) == 0 {
+		return io.EOF
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	if len(header) == len(p.packetHeader) && p.plausibleRecord(header, false) {
+		return nil
+	}
+
+	p.recordOffset = p.src.offset()
+	reason := p.formatErr(ErrMalformedPcap, "record header does not look right")
+	return resync(p.src, &p.opts, reason, p.checkRecords)
+}
+
+/* pcapng */
+
+// whether blocks of this type are looked for while searching.
+// Unknown types are fine in a capture but too likely to be garbage.
+func ngKnownBlockType(blockType uint32) bool {
+	switch blockType {
+	case ngSHB, ngIDB, ngSPB, ngEPB:
+		return true
+	case 0x00000002, // Packet Block (obsolete)
+		0x00000004, // Name Resolution Block
+		ngISB,
+		0x00000009, // systemd Journal Export Block
+		0x0000000A, // Decryption Secrets Block
+		0x00000BAD, // Custom Block
+		0x40000BAD: // Custom Block that must not be copied
+		return true
+	}
+	return false
+}
+
+// checks the framing of the block at the start of b, which is that
+// both of its total lengths are the same. Also returns the length.
+func (p *pcapng) checkBlock(b []byte, atEnd bool, searching bool) (uint32, recoverVerdict) {
+	// the smallest possible block
+	if len(b) < 12 {
+		if atEnd {
+			return 0, recoverBroken
+		}
+		return 0, recoverNeedsMore
+	}
+
+	byteOrder := p.byteOrder
+	switch {
+	case binary.BigEndian.Uint32(b[0:4]) == ngSHB:
+		// a new section can have a different byte order
+		switch {
+		case binary.BigEndian.Uint32(b[8:12]) == ngByteOrderMagic:
+			byteOrder = binary.BigEndian
+		case binary.LittleEndian.Uint32(b[8:12]) == ngByteOrderMagic:
+			byteOrder = binary.LittleEndian
+		default:
+			return 0, recoverBroken
+		}
+	case byteOrder == nil:
+		// before the first section only a SHB can come
+		return 0, recoverBroken
+	case searching && !ngKnownBlockType(byteOrder.Uint32(b[0:4])):
+		return 0, recoverBroken
+	}
+	blockType := byteOrder.Uint32(b[0:4])
+
+	length := byteOrder.Uint32(b[4:8])
+	if length < 12 || length%4 != 0 {
+		return length, recoverBroken
+	}
+	if searching && length > recoverMaxBlock {
+		return length, recoverBroken
+	}
+	if int64(length) > int64(len(b)) {
+		if atEnd {
+			return length, recoverBroken
+		}
+		return length, recoverNeedsMore
+	}
+
+	if byteOrder.Uint32(b[length-4:length]) != length {
+		return length, recoverBroken
+	}
+	// the captured length has to fit into the block
+	if blockType == ngEPB && (length < 32 || byteOrder.Uint32(b[20:24]) > length-32) {
+		return length, recoverBroken
+	}
+
+	return length, recoverFine
+}
+
+func (p *pcapng) checkBlocks(b []byte, atEnd bool) recoverVerdict {
+	_, verdict := p.checkBlock(b, atEnd, true)
+	return verdict
+}
+
+// makes sure that the framing of the next block is fine. If it is
+// not the capture is searched for the next block where it is.
+func (p *pcapng) findBlock() error {
+	b, err := p.src.peek(12)
+	if err == io.EOF && len(b) == 0 {
+		return io.EOF
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	length, verdict := p.checkBlock(b, err == io.EOF, false)
+	if verdict == recoverNeedsMore {
+		b, err = p.src.peek(int(length))
+		if err != nil && err != io.EOF {
+			return err
+		}
+		_, verdict = p.checkBlock(b, err == io.EOF, false)
+		// the block is larger than what can be looked at
+		if verdict == recoverNeedsMore {
+			verdict = recoverFine
+		}
+	}
+
+	if verdict != recoverFine {
+		reason := p.formatErr(ErrMalformedPcap, "block framing is broken")
+		err := resync(p.src, &p.opts, reason, p.checkBlocks)
+		if err != nil {
+			return err
+		}
+		b, _ = p.src.peek(12)
+		length, _ = p.checkBlock(b, false, true)
+	}
+
+	p.blockLen = length
+	return nil
+}
+
+// the framing of the current block was fine but what was in
+// it was not, so the rest of it is skipped
+func (p *pcapng) skipBlock(reason error) (ngReaderState, error) {
+	// when the length is not known, i.e. for the first SHB,
+	// the search starts from where the reader stopped
+	var err error
+	end := p.blockOffset + int64(p.blockLen)
+	if end > p.src.offset() {
+		err = p.src.skip(end - p.src.offset())
+	}
+
+	// if the reader went past the end of the block, what has
+	// been read is lost and the search starts from where we are
+	length := end - p.blockOffset
+	if consumed := p.src.offset() - p.blockOffset; consumed > length {
+		length = consumed
+	}
+	p.opts.skipped(p.blockOffset, length, reason)
+	p.packetRead = false
+
+	// the capture ended in the middle of the block
+	// so there is nothing left to recover
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ngRSDone, io.EOF
+	}
+	if err != nil {
+		return ngRSDone, err
+	}
+	return ngRSBlockType, nil
+}
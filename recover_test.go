@@ -0,0 +1,161 @@
+package pcapreader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+)
+
+// a generated pcap and where each of its records starts.
+// The last offset is the end of the capture.
+func recoverPcap(packets int) ([]byte, []int) {
+	data := genPcap(genPcapOpts{order: binary.LittleEndian, snaplen: 0xFFFF, packets: packets})
+	offsets := []int{24}
+	for i := range packets {
+		offsets = append(offsets, offsets[i]+16+genSizes[i%len(genSizes)])
+	}
+	return data, offsets
+}
+
+// a generated pcapng with one interface and where each of its
+// packet blocks starts. The last offset is the end of the capture.
+func recoverPcapNg(packets int) ([]byte, []int) {
+	var g genNg
+	g.shb(binary.LittleEndian, 1, 0)
+	g.idb(1, 0)
+	var offsets []int
+	for i := range packets {
+		offsets = append(offsets, g.b.Len())
+		g.packets(0, 1e6, i, 1)
+	}
+	return g.b.Bytes(), append(offsets, g.b.Len())
+}
+
+// puts b in between data[:at] and data[at:]
+func insert(data []byte, at int, b string) []byte {
+	return slices.Concat(data[:at], []byte(b), data[at:])
+}
+
+// sets the uint32 at offset in a copy of data
+func corrupt(data []byte, offset int, v uint32) []byte {
+	data = bytes.Clone(data)
+	binary.LittleEndian.PutUint32(data[offset:], v)
+	return data
+}
+
+func TestRecover(t *testing.T) {
+	pcap, records := recoverPcap(8)
+	pcapng, blocks := recoverPcapNg(8)
+
+	for _, c := range []struct {
+		name string
+		data []byte
+		// what has to be skipped and the packets that are left
+		skipped []Skipped
+		packets []int
+	}{
+		{
+			name:    "pcap garbage between records",
+			data:    insert(pcap, records[2], "garbage!"),
+			skipped: []Skipped{{Offset: int64(records[2]), Length: 8}},
+			packets: []int{0, 1, 2, 3, 4, 5, 6, 7},
+		},
+		{
+			name:    "pcap captured length beyond the snaplen",
+			data:    corrupt(pcap, records[3]+8, 0x7FFFFFFF),
+			skipped: []Skipped{{Offset: int64(records[3]), Length: int64(records[4] - records[3])}},
+			packets: []int{0, 1, 2, 4, 5, 6, 7},
+		},
+		{
+			// the next record does not start where this one says it ends
+			name:    "pcap captured length too large",
+			data:    corrupt(pcap, records[4]+8, uint32(genSizes[4]+2)),
+			skipped: []Skipped{{Offset: int64(records[4]), Length: int64(records[5] - records[4])}},
+			packets: []int{0, 1, 2, 3, 5, 6, 7},
+		},
+		{
+			name:    "pcap cut off",
+			data:    pcap[:len(pcap)-5],
+			skipped: []Skipped{{Offset: int64(records[7]), Length: int64(len(pcap) - 5 - records[7])}},
+			packets: []int{0, 1, 2, 3, 4, 5, 6},
+		},
+		{
+			name:    "pcapng garbage between blocks",
+			data:    insert(pcapng, blocks[2], "garbage!"),
+			skipped: []Skipped{{Offset: int64(blocks[2]), Length: 8}},
+			packets: []int{0, 1, 2, 3, 4, 5, 6, 7},
+		},
+		{
+			name:    "pcapng block lengths differ",
+			data:    corrupt(pcapng, blocks[4]-4, 999),
+			skipped: []Skipped{{Offset: int64(blocks[3]), Length: int64(blocks[4] - blocks[3])}},
+			packets: []int{0, 1, 2, 4, 5, 6, 7},
+		},
+		{
+			name: "pcapng two broken blocks",
+			data: corrupt(corrupt(pcapng, blocks[1]+4, 4), blocks[6]-4, 0),
+			skipped: []Skipped{
+				{Offset: int64(blocks[1]), Length: int64(blocks[2] - blocks[1])},
+				{Offset: int64(blocks[5]), Length: int64(blocks[6] - blocks[5])},
+			},
+			packets: []int{0, 2, 3, 4, 6, 7},
+		},
+		{
+			name:    "pcapng cut off",
+			data:    pcapng[:len(pcapng)-5],
+			skipped: []Skipped{{Offset: int64(blocks[7]), Length: int64(len(pcapng) - 5 - blocks[7])}},
+			packets: []int{0, 1, 2, 3, 4, 5, 6},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var skipped []Skipped
+			traffic, err := OpenReaderWithOptions(bytes.NewReader(c.data), ReaderOptions{
+				Recover:   true,
+				OnRecover: func(s Skipped) { skipped = append(skipped, s) },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer traffic.Stop()
+
+			var packets []int
+			for {
+				_, packet, err := traffic.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				// the packets can be told apart by their data
+				i := slices.IndexFunc(c.packets, func(i int) bool {
+					return bytes.Equal(packet, genData(i, genSizes[i%len(genSizes)]))
+				})
+				if i < 0 {
+					t.Fatalf("packet %d is not one of the capture", len(packets))
+				}
+				packets = append(packets, c.packets[i])
+			}
+			if !slices.Equal(packets, c.packets) {
+				t.Errorf("packets %v instead of %v", packets, c.packets)
+			}
+
+			if len(skipped) != len(c.skipped) {
+				t.Fatalf("skipped %v instead of %v", skipped, c.skipped)
+			}
+			for i, s := range skipped {
+				want := c.skipped[i]
+				if s.Offset != want.Offset || s.Length != want.Length {
+					t.Errorf("skipped %d bytes at %d instead of %d at %d", s.Length, s.Offset, want.Length, want.Offset)
+				}
+				var fe *FormatError
+				if !errors.As(s.Reason, &fe) || !errors.Is(s.Reason, ErrMalformedPcap) || fe.Offset != want.Offset {
+					t.Errorf("skipped for %v", s.Reason)
+				}
+			}
+		})
+	}
+}
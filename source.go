@@ -0,0 +1,277 @@
+package pcapreader
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrSourceNotSeekable = errors.New("the traffic source cannot seek")
+
+// source is where the readers get their bytes from.
+// Having this in between lets the readers parse everything
+// in place no matter if the bytes come from a stream
+// or from a memory mapped file.
+type source interface {
+	// Returns the next n bytes. The slice is only valid
+	// until the next call to next. Like io.ReadFull, io.EOF
+	// is returned if nothing was left and io.ErrUnexpectedEOF
+	// if there were less than n bytes.
+	next(n int) ([]byte, error)
+
+	// Discards the next n bytes. The errors are the same as for next.
+	skip(n int64) error
+
+	// Returns up to n of the next bytes without consuming them. Less
+	// are returned together with io.EOF when the source ends and without
+	// an error when the source cannot look that far ahead.
+	peek(n int) ([]byte, error)
+
+	// Continues reading at the absolute offset
+	seek(offset int64) error
+
+	// Returns how many bytes have been consumed
+	offset() int64
+
+	// Once ctx is done, next and skip return its error
+	setContext(ctx context.Context)
+
+	// Called when reading has come to an end. A stream is closed
+	// right away, memory stays valid until Close as the packet
+	// returned last may still point into it.
+	end() error
+
+	// Closing more than once does no harm
+	Close() error
+}
+
+// lets a source stop reading when a context is done.
+// Without a context this costs only a nil check.
+type interruptible struct {
+	ctx  context.Context
+	done <-chan struct{}
+}
+
+func (i *interruptible) setContext(ctx context.Context) {
+	i.ctx = ctx
+	i.done = nil
+	if ctx != nil {
+		i.done = ctx.Done()
+	}
+}
+
+func (i *interruptible) interrupted() error {
+	if i.done == nil {
+		return nil
+	}
+	select {
+	case <-i.done:
+		return i.ctx.Err()
+	default:
+		return nil
+	}
+}
+
+// how much is read from a stream at once. This is large
+// enough for most packets to be handed out without copying
+// them while still keeping the amount of syscalls low.
+const readerSourceBufferSize = 1 << 18
+
+// reads from a stream through a buffer. Things that fit into the
+// buffer are handed out directly from it, larger things are copied
+// into a second buffer that grows to the largest size that was read.
+type readerSource struct {
+	interruptible
+	reader io.ReadCloser
+	buffer *bufio.Reader
+	large  []byte
+	off    int64
+	closed bool
+}
+
+func newReaderSource(reader io.ReadCloser) *readerSource {
+	return &readerSource{
+		reader: reader,
+		buffer: bufio.NewReaderSize(reader, readerSourceBufferSize),
+	}
+}
+
+func (s *readerSource) next(n int) ([]byte, error) {
+	if err := s.interrupted(); err != nil {
+		return nil, err
+	}
+
+	if n > s.buffer.Size() {
+		if cap(s.large) < n {
+			s.large = make([]byte, n)
+		}
+		b := s.large[:n]
+
+		read, err := io.ReadFull(s.buffer, b)
+		s.off += int64(read)
+		return b, err
+	}
+
+	// peeking does not copy, the slice points into the buffer
+	// and stays valid until the buffer is filled again
+	b, err := s.buffer.Peek(n)
+	discarded, _ := s.buffer.Discard(len(b))
+	s.off += int64(discarded)
+	if err == io.EOF && len(b) > 0 {
+		return b, io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+func (s *readerSource) peek(n int) ([]byte, error) {
+	if n > s.buffer.Size() {
+		n = s.buffer.Size()
+	}
+	return s.buffer.Peek(n)
+}
+
+func (s *readerSource) skip(n int64) error {
+	if err := s.interrupted(); err != nil {
+		return err
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	// Discard takes an int so do it in steps
+	// to not overflow on 32 bit platforms
+	for remaining := n; remaining > 0; {
+		step := remaining
+		if step > readerSourceBufferSize {
+			step = readerSourceBufferSize
+		}
+		discarded, err := s.buffer.Discard(int(step))
+		s.off += int64(discarded)
+		remaining -= int64(discarded)
+		if err == io.EOF && remaining < n {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *readerSource) seek(offset int64) error {
+	seeker, ok := s.reader.(io.Seeker)
+	if !ok {
+		return ErrSourceNotSeekable
+	}
+
+	_, err := seeker.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	// throw away what was buffered from the old position
+	s.buffer.Reset(s.reader)
+	s.off = offset
+	return nil
+}
+
+func (s *readerSource) offset() int64 {
+	return s.off
+}
+
+func (s *readerSource) end() error {
+	return s.Close()
+}
+
+func (s *readerSource) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.reader.Close()
+}
+
+// reads from memory which is handed out directly so
+// that nothing is copied. The slices stay valid until
+// the source is closed.
+type memorySource struct {
+	interruptible
+	data []byte
+	off  int
+	// called on Close, i.e. to unmap the data
+	release func() error
+}
+
+func (s *memorySource) next(n int) ([]byte, error) {
+	if err := s.interrupted(); err != nil {
+		return nil, err
+	}
+
+	remaining := len(s.data) - s.off
+	if n > remaining {
+		s.off = len(s.data)
+		if remaining == 0 {
+			return nil, io.EOF
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	// limit the capacity so that appending to
+	// the slice cannot overwrite what comes next
+	b := s.data[s.off : s.off+n : s.off+n]
+	s.off += n
+	return b, nil
+}
+
+func (s *memorySource) skip(n int64) error {
+	if err := s.interrupted(); err != nil {
+		return err
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	remaining := int64(len(s.data) - s.off)
+	if n > remaining {
+		s.off = len(s.data)
+		if remaining == 0 {
+			return io.EOF
+		}
+		return io.ErrUnexpectedEOF
+	}
+	s.off += int(n)
+	return nil
+}
+
+func (s *memorySource) peek(n int) ([]byte, error) {
+	remaining := len(s.data) - s.off
+	if n > remaining {
+		return s.data[s.off:len(s.data):len(s.data)], io.EOF
+	}
+	return s.data[s.off : s.off+n : s.off+n], nil
+}
+
+func (s *memorySource) seek(offset int64) error {
+	if offset < 0 || offset > int64(len(s.data)) {
+		return io.ErrUnexpectedEOF
+	}
+	s.off = int(offset)
+	return nil
+}
+
+func (s *memorySource) offset() int64 {
+	return int64(s.off)
+}
+
+func (s *memorySource) end() error {
+	return nil
+}
+
+func (s *memorySource) Close() error {
+	data := s.data
+	s.data = nil
+	if s.release != nil && data != nil {
+		return s.release()
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package pcapreader
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoSplit        = errors.New("no way to split the capture has been chosen")
+	ErrSplitNameTaken = errors.New("the template gives a name that has been used already")
+)
+
+// SplitOptions tell Split when to start a new file. When more than
+// one of Packets, Interval and Size is set, a new file is started as
+// soon as any of them says so.
+type SplitOptions struct {
+	// A new file is started after this many packets
+	Packets uint64
+	// A new file is started for every interval. The intervals are
+	// aligned to the wall clock, so with an hour a new file starts
+	// at every full hour. Packets without a time stay in the file
+	// of the packet before them.
+	Interval time.Duration
+	// A new file is started once a file holds at least this many
+	// bytes, so files are larger by up to one packet
+	Size int64
+	// When set, the packets of every flow get files of their own.
+	// Returns the name of the flow of a packet, which has to be the
+	// same for both directions and has to fit into a file name.
+	// decode.SplitFlow does this for TCP and UDP connections.
+	FlowOf func(iface Interface, info *PacketInfo, packet Packet) string
+	// When splitting by flow, at most this many files are kept open.
+	// When another one is needed, the one written to the longest ago
+	// is closed. When its flow has packets again, a new file is
+	// started for it. Defaults to 256.
+	MaxOpenFiles int
+
+	// The names of the files. {n} is replaced with the number of the
+	// file counted from 0, {time} with the time of its first packet
+	// and {flow} with the flow, i.e. "tcp_10.0.0.1_80_10.0.0.2_4711".
+	// Defaults to "split_{n}.pcapng", or "split_{n}_{flow}.pcapng"
+	// when splitting by flow, with ".pcap" when writing pcaps. Split
+	// fails with ErrSplitNameTaken instead of writing over a file, so
+	// without {n} or {time} a flow can only get one file.
+	Template string
+	// Write pcaps instead of pcapngs
+	Pcap bool
+	// Creates the files. Defaults to os.Create.
+	Create func(name string) (io.WriteCloser, error)
+}
+
+// the writers of this package, which know how much they wrote
+type splitWriter interface {
+	Writer
+	Written() int64
+}
+
+// a file that is being written
+type splitFile struct {
+	name    string
+	file    io.WriteCloser
+	w       splitWriter
+	packets uint64
+	// the interval of the last packet that had a time, if any had
+	interval int64
+	timed    bool
+	// the flow the file is for and its place among the open files
+	flow  string
+	entry *list.Element
+}
+
+type splitter struct {
+	opts  SplitOptions
+	names []string
+	used  map[string]bool
+	// how many files have been started
+	n int
+
+	// the file when not splitting by flow
+	current *splitFile
+	// the open files when splitting by flow, the one
+	// written to most recently at the front of open
+	flows map[string]*splitFile
+	open  *list.List
+}
+
+// Writes the packets of t into a new file whenever opts say so and
+// returns the names of the files written, in the order they have
+// been started. Reading ends when t ends or ctx is done. All files
+// are closed once Split returns, also when there is an error.
+func Split(ctx context.Context, t Traffic, opts SplitOptions) ([]string, error) {
+	if opts.Packets == 0 && opts.Interval <= 0 && opts.Size <= 0 && opts.FlowOf == nil {
+		return nil, ErrNoSplit
+	}
+	if opts.MaxOpenFiles <= 0 {
+		opts.MaxOpenFiles = 256
+	}
+	if opts.Template == "" {
+		opts.Template = "split_{n}"
+		if opts.FlowOf != nil {
+			opts.Template += "_{flow}"
+		}
+		if opts.Pcap {
+			opts.Template += ".pcap"
+		} else {
+			opts.Template += ".pcapng"
+		}
+	}
+	if opts.Create == nil {
+		opts.Create = func(name string) (io.WriteCloser, error) {
+			return os.Create(name)
+		}
+	}
+
+	s := &splitter{opts: opts, used: make(map[string]bool), flows: make(map[string]*splitFile), open: list.New()}
+	packets := Packets(ctx, t)
+	for info, packet := range packets.All() {
+		iface := InterfaceOf(t)
+		if err := s.write(iface, info, packet); err != nil {
+			s.closeAll()
+			return s.names, err
+		}
+	}
+	err := packets.Err()
+	if closeErr := s.closeAll(); err == nil {
+		err = closeErr
+	}
+	return s.names, err
+}
+
+func (s *splitter) write(iface Interface, info *PacketInfo, packet Packet) error {
+	var f *splitFile
+	if s.opts.FlowOf != nil {
+		flow := s.opts.FlowOf(iface, info, packet)
+		f = s.flows[flow]
+		if f != nil && s.full(f, info) {
+			if err := s.close(f); err != nil {
+				return err
+			}
+			f = nil
+		}
+		if f == nil {
+			var err error
+			if f, err = s.create(flow, info); err != nil {
+				return err
+			}
+		}
+		s.open.MoveToFront(f.entry)
+	} else {
+		f = s.current
+		if f != nil && s.full(f, info) {
+			if err := s.close(f); err != nil {
+				return err
+			}
+			f = nil
+		}
+		if f == nil {
+			var err error
+			if f, err = s.create("", info); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := f.w.WritePacket(iface, info, packet); err != nil {
+		return fmt.Errorf("%s: %w", f.name, err)
+	}
+	f.packets++
+	if !info.CaptureTime.IsZero() && s.opts.Interval > 0 {
+		f.interval = s.intervalOf(info.CaptureTime)
+		f.timed = true
+	}
+	return nil
+}
+
+// the wall clock interval t is in
+func (s *splitter) intervalOf(t time.Time) int64 {
+	n := t.UnixNano()
+	i := n / int64(s.opts.Interval)
+	// round down for times before 1970 as well
+	if n < 0 && n%int64(s.opts.Interval) != 0 {
+		i--
+	}
+	return i
+}
+
+// reports whether the packet has to go to a new file
+func (s *splitter) full(f *splitFile, info *PacketInfo) bool {
+	switch {
+	case s.opts.Packets > 0 && f.packets >= s.opts.Packets:
+		return true
+	case s.opts.Size > 0 && f.w.Written() >= s.opts.Size:
+		return true
+	case s.opts.Interval > 0 && !info.CaptureTime.IsZero() && f.timed:
+		return s.intervalOf(info.CaptureTime) > f.interval
+	}
+	return false
+}
+
+func (s *splitter) create(flow string, info *PacketInfo) (*splitFile, error) {
+	if s.opts.FlowOf != nil && s.open.Len() >= s.opts.MaxOpenFiles {
+		if err := s.close(s.open.Back().Value.(*splitFile)); err != nil {
+			return nil, err
+		}
+	}
+
+	name := s.opts.Template
+	name = strings.ReplaceAll(name, "{n}", fmt.Sprintf("%05d", s.n))
+	name = strings.ReplaceAll(name, "{flow}", flow)
+	if strings.Contains(name, "{time}") {
+		ts := "notime"
+		if !info.CaptureTime.IsZero() {
+			ts = info.CaptureTime.UTC().Format("20060102T150405Z")
+		}
+		name = strings.ReplaceAll(name, "{time}", ts)
+	}
+
+	if s.used[name] {
+		return nil, fmt.Errorf("%s: %w", name, ErrSplitNameTaken)
+	}
+	file, err := s.opts.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	s.n++
+	s.names = append(s.names, name)
+	s.used[name] = true
+
+	f := &splitFile{name: name, file: file, flow: flow}
+	if s.opts.Pcap {
+		f.w = NewPcapWriter(file)
+	} else {
+		f.w = NewPcapNgWriter(file)
+	}
+	if s.opts.FlowOf != nil {
+		f.entry = s.open.PushFront(f)
+		s.flows[flow] = f
+	} else {
+		s.current = f
+	}
+	return f, nil
+}
+
+func (s *splitter) close(f *splitFile) error {
+	if s.opts.FlowOf != nil {
+		s.open.Remove(f.entry)
+		delete(s.flows, f.flow)
+	} else {
+		s.current = nil
+	}
+
+	err := f.w.Flush()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.name, err)
+	}
+	return nil
+}
+
+// closes all files and returns the first error
+func (s *splitter) closeAll() error {
+	var err error
+	if s.current != nil {
+		err = s.close(s.current)
+	}
+	for s.open.Len() > 0 {
+		if closeErr := s.close(s.open.Front().Value.(*splitFile)); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,145 @@
+package pcapreader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// files kept in memory
+type splitFiles map[string]*bytes.Buffer
+
+type splitBuffer struct{ *bytes.Buffer }
+
+func (splitBuffer) Close() error { return nil }
+
+func (f splitFiles) create(name string) (io.WriteCloser, error) {
+	f[name] = &bytes.Buffer{}
+	return splitBuffer{f[name]}, nil
+}
+
+// how many packets the file holds
+func (f splitFiles) count(t *testing.T, name string) int {
+	traffic, err := OpenReader(bytes.NewReader(f[name].Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos, _ := readAll(t, traffic)
+	return len(infos)
+}
+
+func TestSplitPackets(t *testing.T) {
+	files := splitFiles{}
+	names, err := Split(context.Background(), mergeInput(t, 0, time.Second, 10), SplitOptions{Packets: 4, Create: files.create})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"split_00000.pcapng", "split_00001.pcapng", "split_00002.pcapng"}
+	if len(names) != len(want) {
+		t.Fatalf("wrote %v", names)
+	}
+	for i, n := range []int{4, 4, 2} {
+		if names[i] != want[i] || files.count(t, names[i]) != n {
+			t.Errorf("file %s instead of %s with %d packets", names[i], want[i], n)
+		}
+	}
+}
+
+// the first packet is in the middle of a minute, so the first file is shorter
+func TestSplitInterval(t *testing.T) {
+	files := splitFiles{}
+	names, err := Split(context.Background(), mergeInput(t, 30*time.Second, 10*time.Second, 12), SplitOptions{
+		Interval: time.Minute,
+		Template: "{time}.pcap",
+		Pcap:     true,
+		Create:   files.create,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"20240229T133730Z.pcap", "20240229T133800Z.pcap", "20240229T133900Z.pcap"}
+	if len(names) != len(want) {
+		t.Fatalf("wrote %v", names)
+	}
+	for i, n := range []int{3, 6, 3} {
+		if names[i] != want[i] || files.count(t, names[i]) != n {
+			t.Errorf("file %s instead of %s with %d packets", names[i], want[i], n)
+		}
+	}
+}
+
+func TestSplitSize(t *testing.T) {
+	files := splitFiles{}
+	names, err := Split(context.Background(), mergeInput(t, 0, time.Second, 10), SplitOptions{Size: 200, Pcap: true, Create: files.create})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 24 bytes of header and 76 for every packet
+	if len(names) != 4 || files.count(t, names[0]) != 3 || files.count(t, names[3]) != 1 {
+		t.Errorf("wrote %v", names)
+	}
+}
+
+func TestSplitFlow(t *testing.T) {
+	var b bytes.Buffer
+	w := NewPcapNgWriter(&b)
+	// the packets are their flows
+	packets := []string{"first", "first", "second", "", "first"}
+	for _, p := range packets {
+		if err := w.WritePacket(Interface{LinkLayerType: 1}, &PacketInfo{CaptureTime: genStart, Size: uint32(len(p))}, Packet(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Flush()
+	traffic, err := OpenReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := splitFiles{}
+	names, err := Split(context.Background(), traffic, SplitOptions{
+		FlowOf: func(iface Interface, info *PacketInfo, packet Packet) string {
+			if len(packet) == 0 {
+				return "other"
+			}
+			return string(packet)
+		},
+		MaxOpenFiles: 2,
+		Create:       files.create,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first flow is closed for the other packets and gets a second file
+	want := map[string]int{
+		"split_00000_first.pcapng":  2,
+		"split_00001_second.pcapng": 1,
+		"split_00002_other.pcapng":  1,
+		"split_00003_first.pcapng":  1,
+	}
+	if len(names) != len(want) {
+		t.Fatalf("wrote %v", names)
+	}
+	for _, name := range names {
+		if n, ok := want[name]; !ok || files.count(t, name) != n {
+			t.Errorf("file %s with %d packets", name, files.count(t, name))
+		}
+	}
+}
+
+func TestSplitNothing(t *testing.T) {
+	if _, err := Split(context.Background(), mergeInput(t, 0, 0, 1), SplitOptions{}); err != ErrNoSplit {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestSplitNameTaken(t *testing.T) {
+	files := splitFiles{}
+	_, err := Split(context.Background(), mergeInput(t, 0, 0, 3), SplitOptions{Packets: 2, Template: "same.pcapng", Create: files.create})
+	if !errors.Is(err, ErrSplitNameTaken) || files.count(t, "same.pcapng") != 2 {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package pcapreader
+
+import (
+	"context"
+	"time"
+)
+
+// the packet size histogram has a bucket for each of these ranges,
+// the same that tshark -z plen,tree uses. The last one is open.
+var summarySizeBuckets = []uint32{0, 20, 40, 80, 160, 320, 640, 1280, 2560, 5120}
+
+// SizeBucket counts the packets with a size from Min to Max.
+type SizeBucket struct {
+	Min uint32 `json:"min"`
+	// 0 for the last bucket, which has no upper end
+	Max     uint32 `json:"max"`
+	Packets uint64 `json:"packets"`
+}
+
+// Counts is what is counted for a whole capture
+// as well as for each of its interfaces.
+type Counts struct {
+	Packets uint64 `json:"packets"`
+	// the sum of the sizes of the packets on the wire
+	DataBytes uint64 `json:"data_bytes"`
+	// the sum of what has been saved of them, which is
+	// less than DataBytes if packets have been cut off
+	CapturedBytes uint64 `json:"captured_bytes"`
+
+	// the time of the earliest and the latest packet. Packets
+	// without a time, like those of SPBs, are left out.
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+}
+
+// Returns the time from the first to the last packet
+func (c *Counts) Duration() time.Duration {
+	return c.Last.Sub(c.First)
+}
+
+// Returns the bytes on the wire per second, 0 if
+// all packets have been captured at the same time
+func (c *Counts) DataRate() float64 {
+	return c.perSecond(c.DataBytes)
+}
+
+// Returns the packets per second, 0 if all
+// packets have been captured at the same time
+func (c *Counts) PacketRate() float64 {
+	return c.perSecond(c.Packets)
+}
+
+// Returns the average size of the packets on the wire
+func (c *Counts) AverageSize() float64 {
+	if c.Packets == 0 {
+		return 0
+	}
+	return float64(c.DataBytes) / float64(c.Packets)
+}
+
+func (c *Counts) perSecond(n uint64) float64 {
+	d := c.Duration()
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) / d.Seconds()
+}
+
+func (c *Counts) add(info *PacketInfo, packet Packet) {
+	c.Packets++
+	c.DataBytes += uint64(info.Size)
+	c.CapturedBytes += uint64(len(packet))
+
+	if info.CaptureTime.IsZero() {
+		return
+	}
+	if c.First.IsZero() || info.CaptureTime.Before(c.First) {
+		c.First = info.CaptureTime
+	}
+	if c.Last.IsZero() || info.CaptureTime.After(c.Last) {
+		c.Last = info.CaptureTime
+	}
+}
+
+// InterfaceSummary is what has been counted for one interface.
+type InterfaceSummary struct {
+	Interface
+	Counts
+}
+
+// Summary is what capinfos tells about a capture.
+type Summary struct {
+	Counts
+
+	// Whether no packet is older than the one before it.
+	// Packets without a time are left out.
+	Ordered bool `json:"ordered"`
+	// the packets by their size on the wire
+	Sizes []SizeBucket `json:"sizes"`
+	// in the order their first packet has been read
+	Interfaces []InterfaceSummary `json:"interfaces"`
+
+	// the time of the packet before
+	previous time.Time
+}
+
+// Reads all packets of t and sums them up. When reading ends
+// with an error or ctx is done, the summary of everything read
+// until then is returned along with the error.
+func Summarize(ctx context.Context, t Traffic) (*Summary, error) {
+	s := &Summary{Ordered: true}
+	for i, low := range summarySizeBuckets {
+		bucket := SizeBucket{Min: low}
+		if i+1 < len(summarySizeBuckets) {
+			bucket.Max = summarySizeBuckets[i+1] - 1
+		}
+		s.Sizes = append(s.Sizes, bucket)
+	}
+
+	packets := Packets(ctx, t)
+	for info, packet := range packets.All() {
+		s.add(info, packet, InterfaceOf(t))
+	}
+	return s, packets.Err()
+}
+
+func (s *Summary) add(info *PacketInfo, packet Packet, iface Interface) {
+	s.Counts.add(info, packet)
+	s.interfaceCounts(iface).add(info, packet)
+
+	for i := len(s.Sizes) - 1; i >= 0; i-- {
+		if info.Size >= s.Sizes[i].Min {
+			s.Sizes[i].Packets++
+			break
+		}
+	}
+
+	if info.CaptureTime.IsZero() {
+		return
+	}
+	if info.CaptureTime.Before(s.previous) {
+		s.Ordered = false
+	}
+	s.previous = info.CaptureTime
+}
+
+// there are only a few interfaces, so looking
+// through all of them is fast enough
+func (s *Summary) interfaceCounts(iface Interface) *Counts {
+	for i := range s.Interfaces {
+		if s.Interfaces[i].Interface == iface {
+			return &s.Interfaces[i].Counts
+		}
+	}
+	s.Interfaces = append(s.Interfaces, InterfaceSummary{Interface: iface})
+	return &s.Interfaces[len(s.Interfaces)-1].Counts
+}
@@ -0,0 +1,55 @@
+package pcapreader
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	g := &genNg{}
+	g.shb(binary.LittleEndian, 1, 0)
+	g.idb(1, 65535)
+	g.packets(0, 1e6, 0, 8)
+	g.shb(binary.BigEndian, 1, 0)
+	g.idb(1, 9000)
+	// the second section starts before the first ended
+	g.packets(0, 1e6, 2, 4)
+
+	traffic, err := OpenReader(bytes.NewReader(g.b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Summarize(context.Background(), traffic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Packets != 12 || s.DataBytes != 1825+1637 || s.CapturedBytes != s.DataBytes {
+		t.Errorf("counted %d packets with %d bytes of which %d have been captured", s.Packets, s.DataBytes, s.CapturedBytes)
+	}
+	if s.Ordered {
+		t.Error("the packets are not ordered")
+	}
+	if want := genStart.Add(time.Second); !s.Last.Equal(want) {
+		t.Errorf("last packet at %v instead of %v", s.Last, want)
+	}
+
+	if len(s.Interfaces) != 2 {
+		t.Fatalf("%d interfaces instead of 2", len(s.Interfaces))
+	}
+	second := s.Interfaces[1]
+	if second.Section != 1 || second.Snaplen != 9000 || second.Packets != 4 {
+		t.Errorf("second interface is %+v", second)
+	}
+
+	var sized uint64
+	for _, b := range s.Sizes {
+		sized += b.Packets
+	}
+	if sized != s.Packets {
+		t.Errorf("%d packets in the size histogram instead of %d", sized, s.Packets)
+	}
+}
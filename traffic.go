@@ -2,6 +2,8 @@ package pcapreader
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -31,7 +33,8 @@ type Traffic interface {
 	// Returns the LinkLayerType of the traffic.
 	// This for example can be ethernet if
 	// the traffic stems from a ethernet network card.
-	// See: https://www.tcpdump.org/linktypes.html
+	// See: https://www.tcpdump.org/linktypes.html and
+	// LinkLayerTypes for the ones that are known.
 	LinkLayerType() LinkLayerType
 
 	// Closes open files or stops reading
@@ -43,4 +46,46 @@ var (
 	ErrMalformedPcap           = errors.New("PCAP(ng) file is malformed")
 	ErrPcapVersionNotSupported = errors.New("invalid PCAP(NG) version")
 	ErrEmptyPcap               = errors.New("PCAP(NG) file is empty")
+	ErrLimitExceeded           = errors.New("PCAP(NG) file exceeds a limit")
 )
+
+// FormatError tells where and why a capture could not be read.
+// It wraps ErrMalformedPcap, ErrPcapVersionNotSupported, ErrEmptyPcap
+// or ErrLimitExceeded, so errors.Is can still be used to tell them apart.
+type FormatError struct {
+	Err error
+
+	// where the record or block that is broken starts
+	Offset int64
+	// how many packets have been read before it
+	Packet uint64
+	// how many blocks came before it and its type. Block is -1
+	// for pcaps and when it is not known, i.e. after seeking.
+	Block     int64
+	BlockType uint32
+
+	// what exactly is wrong, i.e.
+	// "EPB captured length 70000 exceeds block length 1500"
+	Reason string
+}
+
+func (e *FormatError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v at offset %d (packet %d", e.Err, e.Offset, e.Packet)
+	if e.Block >= 0 {
+		fmt.Fprintf(&b, ", block %d", e.Block)
+	}
+	if e.BlockType != 0 {
+		fmt.Fprintf(&b, ", %s", ngBlockTypeName(e.BlockType))
+	}
+	b.WriteString(")")
+	if e.Reason != "" {
+		b.WriteString(": ")
+		b.WriteString(e.Reason)
+	}
+	return b.String()
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,97 @@
+package pcapreader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFormatError(t *testing.T) {
+	pcap, records := recoverPcap(8)
+	pcapng, blocks := recoverPcapNg(8)
+
+	var g genNg
+	g.shb(binary.LittleEndian, 1, 0)
+	g.idb(1, 0, genOption{9, []byte{6, 6}})
+	badOption := g.b.Bytes()
+
+	for _, c := range []struct {
+		name string
+		data []byte
+		opts ReaderOptions
+		// what the error wraps and where it is
+		err       error
+		offset    int
+		packet    uint64
+		block     int64
+		blockType uint32
+	}{
+		{name: "pcap empty", err: ErrEmptyPcap, block: -1},
+		{name: "pcap version", data: corrupt(pcap, 4, 0x00030003), err: ErrPcapVersionNotSupported, block: -1},
+		{
+			name: "pcap record header cut off", data: pcap[:records[3]+5],
+			err: ErrMalformedPcap, offset: records[3], packet: 3, block: -1,
+		},
+		{
+			name: "pcap saved size beyond the snaplen", data: corrupt(pcap, records[2]+8, 0x10000),
+			err: ErrMalformedPcap, offset: records[2], packet: 2, block: -1,
+		},
+		{
+			name: "pcap packet beyond MaxSnaplen", data: pcap, opts: ReaderOptions{MaxSnaplen: 1000},
+			err: ErrLimitExceeded, offset: records[3], packet: 3, block: -1,
+		},
+		// a capture that does not start with a SHB is no pcapng
+		{name: "unknown magic", data: pcapng[blocks[0]:], err: ErrMalformedPcap, block: -1},
+		{
+			name: "pcapng block lengths differ", data: corrupt(pcapng, blocks[3]-4, 999),
+			err: ErrMalformedPcap, offset: blocks[2], packet: 2, block: 4, blockType: ngEPB,
+		},
+		{
+			name: "pcapng captured length beyond the block", data: corrupt(pcapng, blocks[1]+20, 0x1000),
+			err: ErrMalformedPcap, offset: blocks[1], packet: 1, block: 3, blockType: ngEPB,
+		},
+		{
+			name: "pcapng option", data: badOption,
+			err: ErrMalformedPcap, offset: len(badOption) - 32, block: 1, blockType: ngIDB,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			traffic, err := OpenReaderWithOptions(bytes.NewReader(c.data), c.opts)
+			for err == nil {
+				_, _, err = traffic.Next()
+			}
+			if err == io.EOF {
+				t.Fatal("read without an error")
+			}
+
+			var fe *FormatError
+			if !errors.As(err, &fe) {
+				t.Fatalf("%T instead of a *FormatError", err)
+			}
+			if !errors.Is(err, c.err) {
+				t.Errorf("%v does not wrap %v", err, c.err)
+			}
+			// a limit is not a malformed capture
+			if c.err != ErrMalformedPcap && errors.Is(err, ErrMalformedPcap) {
+				t.Errorf("%v is a malformed capture", err)
+			}
+			if fe.Offset != int64(c.offset) || fe.Packet != c.packet || fe.Block != c.block || fe.BlockType != c.blockType {
+				t.Errorf("error at offset %d, packet %d, block %d of type 0x%08X instead of %d, %d, %d and 0x%08X",
+					fe.Offset, fe.Packet, fe.Block, fe.BlockType, c.offset, c.packet, c.block, c.blockType)
+			}
+		})
+	}
+}
+
+func TestFormatErrorString(t *testing.T) {
+	err := &FormatError{Err: ErrMalformedPcap, Offset: 208, Packet: 2, Block: 4, BlockType: ngEPB, Reason: "block cut off"}
+	if want := "PCAP(ng) file is malformed at offset 208 (packet 2, block 4, EPB): block cut off"; err.Error() != want {
+		t.Errorf("%q instead of %q", err.Error(), want)
+	}
+	err = &FormatError{Err: ErrEmptyPcap, Block: -1}
+	if want := "PCAP(NG) file is empty at offset 0 (packet 0)"; err.Error() != want {
+		t.Errorf("%q instead of %q", err.Error(), want)
+	}
+}
@@ -0,0 +1,248 @@
+package pcapreader
+
+import (
+	"context"
+	"crypto/md5"
+	"io"
+	"time"
+)
+
+// the base of all transformers. It passes everything
+// but Next through to the Traffic it transforms.
+type transformed struct {
+	Traffic
+}
+
+func (t *transformed) Interface() Interface {
+	return InterfaceOf(t.Traffic)
+}
+
+func (t *transformed) setContext(ctx context.Context) {
+	setContext(t.Traffic, ctx)
+}
+
+type shiftedTraffic struct {
+	transformed
+	d    time.Duration
+	info PacketInfo
+}
+
+// Returns t with the capture times of all packets moved by d, like
+// editcap -t. Packets without a time, i.e. from SPBs, keep having none.
+func ShiftTime(t Traffic, d time.Duration) Traffic {
+	return &shiftedTraffic{transformed: transformed{t}, d: d}
+}
+
+func (t *shiftedTraffic) Next() (*PacketInfo, Packet, error) {
+	info, packet, err := t.Traffic.Next()
+	if err != nil {
+		return nil, nil, err
+	}
+	t.info = *info
+	if !t.info.CaptureTime.IsZero() {
+		t.info.CaptureTime = t.info.CaptureTime.Add(t.d)
+	}
+	return &t.info, packet, nil
+}
+
+type slicedTraffic struct {
+	transformed
+	from, to uint64
+	// how many packets have been read from t
+	n uint64
+}
+
+// Returns the packets of t from the one at index from up to, but not
+// including, the one at index to. The first packet has index 0. As
+// with slices, to is the length and not the index of the last packet.
+// Once the packet before to has been read, Next stops t and
+// returns io.EOF without reading t any further.
+func Slice(t Traffic, from, to uint64) Traffic {
+	return &slicedTraffic{transformed: transformed{t}, from: from, to: to}
+}
+
+func (t *slicedTraffic) Next() (*PacketInfo, Packet, error) {
+	for {
+		if t.n >= t.to {
+			t.Traffic.Stop()
+			return nil, nil, io.EOF
+		}
+		info, packet, err := t.Traffic.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		t.n++
+		if t.n > t.from {
+			return info, packet, nil
+		}
+	}
+}
+
+type windowedTraffic struct {
+	transformed
+	start, end time.Time
+}
+
+// Returns the packets of t captured from start up to, but not
+// including, end, like editcap -A and -B. A zero start or end leaves
+// that side open. As captures are not always in order, all of t is
+// read. Packets without a time are left out unless both sides are open.
+func TimeWindow(t Traffic, start, end time.Time) Traffic {
+	return &windowedTraffic{transformed: transformed{t}, start: start, end: end}
+}
+
+func (t *windowedTraffic) Next() (*PacketInfo, Packet, error) {
+	for {
+		info, packet, err := t.Traffic.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if t.start.IsZero() && t.end.IsZero() {
+			return info, packet, nil
+		}
+		if info.CaptureTime.IsZero() {
+			continue
+		}
+		if !t.start.IsZero() && info.CaptureTime.Before(t.start) {
+			continue
+		}
+		if !t.end.IsZero() && !info.CaptureTime.Before(t.end) {
+			continue
+		}
+		return info, packet, nil
+	}
+}
+
+type snappedTraffic struct {
+	transformed
+	snaplen uint32
+}
+
+// Returns t with all packets cut off after snaplen bytes, like
+// editcap -s. The Size of a packet stays what it was on the wire.
+func Snap(t Traffic, snaplen uint32) Traffic {
+	return &snappedTraffic{transformed: transformed{t}, snaplen: snaplen}
+}
+
+func (t *snappedTraffic) Next() (*PacketInfo, Packet, error) {
+	info, packet, err := t.Traffic.Next()
+	if err != nil {
+		return nil, nil, err
+	}
+	if uint32(len(packet)) > t.snaplen {
+		packet = packet[:t.snaplen]
+	}
+	return info, packet, nil
+}
+
+// the interface has the smaller snaplen of the two
+func (t *snappedTraffic) Interface() Interface {
+	iface := InterfaceOf(t.Traffic)
+	if iface.Snaplen == 0 || iface.Snaplen > t.snaplen {
+		iface.Snaplen = t.snaplen
+	}
+	return iface
+}
+
+type dedupedTraffic struct {
+	transformed
+	// the hashes of the last packets, oldest first once full
+	window [][md5.Size]byte
+	next   int
+	// how often a hash is in the window
+	seen map[[md5.Size]byte]int
+}
+
+// Returns t without the packets whose data is the same as that of one
+// of the window packets before them, like editcap -D. Packets are told
+// apart by the MD5 of what has been captured of them. With a window
+// of 0 or less nothing is left out and t is returned as it is.
+func Dedup(t Traffic, window int) Traffic {
+	if window <= 0 {
+		return t
+	}
+	return &dedupedTraffic{
+		transformed: transformed{t},
+		window:      make([][md5.Size]byte, 0, window),
+		seen:        make(map[[md5.Size]byte]int, window),
+	}
+}
+
+func (t *dedupedTraffic) Next() (*PacketInfo, Packet, error) {
+	for {
+		info, packet, err := t.Traffic.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		hash := md5.Sum(packet)
+		duplicate := t.seen[hash] > 0
+
+		// duplicates are remembered as well, so that a packet sent
+		// over and over again is dropped every time and not only
+		// as long as the first one is within the window
+		if len(t.window) < cap(t.window) {
+			t.window = append(t.window, hash)
+		} else {
+			oldest := t.window[t.next]
+			if t.seen[oldest]--; t.seen[oldest] == 0 {
+				delete(t.seen, oldest)
+			}
+			t.window[t.next] = hash
+			t.next = (t.next + 1) % len(t.window)
+		}
+		t.seen[hash]++
+
+		if !duplicate {
+			return info, packet, nil
+		}
+	}
+}
+
+type mappedTraffic struct {
+	transformed
+	f     func(info *PacketInfo, packet Packet, iface *Interface) (Packet, bool)
+	info  PacketInfo
+	iface Interface
+	// whether there has been a packet
+	started bool
+}
+
+// Returns t with every packet replaced by what f returns for it.
+// f gets copies of the info and the interface of the packet, which it
+// can change, i.e. to give the packet another LinkLayerType. Packets
+// for which f returns false are left out. It is meant for transformers
+// of other packages, like decode.Decapsulate.
+func Map(t Traffic, f func(info *PacketInfo, packet Packet, iface *Interface) (Packet, bool)) Traffic {
+	return &mappedTraffic{transformed: transformed{t}, f: f}
+}
+
+func (t *mappedTraffic) Next() (*PacketInfo, Packet, error) {
+	for {
+		info, packet, err := t.Traffic.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		t.info = *info
+		t.iface = InterfaceOf(t.Traffic)
+		t.started = true
+		if packet, ok := t.f(&t.info, packet, &t.iface); ok {
+			return &t.info, packet, nil
+		}
+	}
+}
+
+// The LinkLayerType f gave the last packet
+func (t *mappedTraffic) LinkLayerType() LinkLayerType {
+	if !t.started {
+		return t.Traffic.LinkLayerType()
+	}
+	return t.iface.LinkLayerType
+}
+
+func (t *mappedTraffic) Interface() Interface {
+	if !t.started {
+		return InterfaceOf(t.Traffic)
+	}
+	return t.iface
+}
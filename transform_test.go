@@ -0,0 +1,174 @@
+package pcapreader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+// the generated pcap with 16 packets
+func transformInput(t *testing.T) Traffic {
+	data := genPcap(genPcapOpts{order: binary.LittleEndian, nano: true, snaplen: 65535, packets: 16})
+	traffic, err := OpenReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return traffic
+}
+
+// reads all of t and returns the packet infos and copies of the packets
+func readAll(t *testing.T, traffic Traffic) ([]PacketInfo, []Packet) {
+	var infos []PacketInfo
+	var packets []Packet
+	for {
+		info, packet, err := traffic.Next()
+		if err == io.EOF {
+			return infos, packets
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		infos = append(infos, *info)
+		packets = append(packets, bytes.Clone(packet))
+	}
+}
+
+func TestShiftTime(t *testing.T) {
+	want, _ := readAll(t, transformInput(t))
+	got, _ := readAll(t, ShiftTime(transformInput(t), -time.Hour))
+	if len(got) != len(want) {
+		t.Fatalf("%d packets instead of %d", len(got), len(want))
+	}
+	for i := range got {
+		if d := want[i].CaptureTime.Sub(got[i].CaptureTime); d != time.Hour {
+			t.Errorf("packet %d moved by %v", i, d)
+		}
+	}
+}
+
+// remembers if it has been stopped
+type stoppedTraffic struct {
+	Traffic
+	stopped bool
+}
+
+func (t *stoppedTraffic) Stop() {
+	t.stopped = true
+	t.Traffic.Stop()
+}
+
+func TestSlice(t *testing.T) {
+	all, _ := readAll(t, transformInput(t))
+	input := &stoppedTraffic{Traffic: transformInput(t)}
+	got, _ := readAll(t, Slice(input, 3, 7))
+	if len(got) != 4 || got[0] != all[3] || got[3] != all[6] {
+		t.Errorf("got %v", got)
+	}
+	if !input.stopped {
+		t.Error("the traffic has not been stopped after the slice")
+	}
+	if got, _ := readAll(t, Slice(transformInput(t), 14, 100)); len(got) != 2 {
+		t.Errorf("%d packets instead of 2 at the end", len(got))
+	}
+}
+
+func TestTimeWindow(t *testing.T) {
+	all, _ := readAll(t, transformInput(t))
+	got, _ := readAll(t, TimeWindow(transformInput(t), all[2].CaptureTime, all[5].CaptureTime))
+	if len(got) != 3 || got[0] != all[2] || got[2] != all[4] {
+		t.Errorf("got %v", got)
+	}
+	if got, _ := readAll(t, TimeWindow(transformInput(t), all[10].CaptureTime, time.Time{})); len(got) != 6 {
+		t.Errorf("%d packets instead of 6 with an open end", len(got))
+	}
+}
+
+func TestSnap(t *testing.T) {
+	traffic := Snap(transformInput(t), 61)
+	infos, packets := readAll(t, traffic)
+	for i, packet := range packets {
+		if want := min(genSizes[i%len(genSizes)], 61); len(packet) != want {
+			t.Errorf("packet %d is %d bytes instead of %d", i, len(packet), want)
+		}
+		if int(infos[i].Size) != genSizes[i%len(genSizes)] {
+			t.Errorf("packet %d has a size of %d", i, infos[i].Size)
+		}
+	}
+	if iface := InterfaceOf(traffic); iface.Snaplen != 61 {
+		t.Errorf("snaplen of the interface is %d", iface.Snaplen)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	var b bytes.Buffer
+	w := NewPcapWriter(&b)
+	// every packet comes three times, one of them late
+	for i, n := range []int{0, 0, 1, 0, 1, 2, 1, 3, 2, 4, 5, 6, 7, 8, 2} {
+		info := &PacketInfo{CaptureTime: genStart.Add(time.Duration(i) * time.Second), Size: 60}
+		if err := w.WritePacket(Interface{LinkLayerType: 1}, info, genData(n, 60)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Flush()
+
+	data := b.Bytes()
+	traffic, err := OpenReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos, _ := readAll(t, Dedup(traffic, 4))
+	var kept []int
+	for _, info := range infos {
+		kept = append(kept, int(info.CaptureTime.Sub(genStart)/time.Second))
+	}
+	// the last one is further back than 4 packets
+	want := []int{0, 2, 5, 7, 9, 10, 11, 12, 13, 14}
+	if len(kept) != len(want) {
+		t.Fatalf("kept %v instead of %v", kept, want)
+	}
+	for i := range kept {
+		if kept[i] != want[i] {
+			t.Fatalf("kept %v instead of %v", kept, want)
+		}
+	}
+
+	// without a window nothing is left out
+	for _, window := range []int{0, -1} {
+		traffic, err := OpenReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if infos, _ := readAll(t, Dedup(traffic, window)); len(infos) != 15 {
+			t.Errorf("%d packets with a window of %d", len(infos), window)
+		}
+	}
+}
+
+func TestMap(t *testing.T) {
+	all, packets := readAll(t, transformInput(t))
+	mapped := Map(transformInput(t), func(info *PacketInfo, packet Packet, iface *Interface) (Packet, bool) {
+		// the packets without their first 4 bytes as raw IP,
+		// those that are too short are left out
+		if len(packet) < 4 {
+			return nil, false
+		}
+		info.Size -= 4
+		iface.LinkLayerType = LinkTypeRaw
+		return packet[4:], true
+	})
+	if llt := mapped.LinkLayerType(); llt != LinkTypeEthernet {
+		t.Errorf("link layer type %s before the first packet", llt)
+	}
+	infos, got := readAll(t, mapped)
+	if len(got) != 12 {
+		t.Fatalf("%d packets instead of 12", len(got))
+	}
+	if infos[1].Size != all[3].Size-4 || !bytes.Equal(got[1], packets[3][4:]) {
+		t.Errorf("packet %v %x", infos[1], got[1])
+	}
+	if llt := InterfaceOf(mapped).LinkLayerType; llt != LinkTypeRaw {
+		t.Errorf("link layer type %s", llt)
+	}
+}
@@ -0,0 +1,291 @@
+package pcapreader
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+// the snaplen of written pcaps whose
+// interface does not have one, as tcpdump uses
+const writerDefaultSnaplen = 262144
+
+var (
+	ErrLinkLayerTypeMismatch = errors.New("pcap can only hold packets of one link layer type")
+	ErrPacketExceedsSnaplen  = errors.New("packet is larger than the snaplen of the capture")
+	// pcaps can hold capture times from 1970 to 2106
+	// and pcapngs with nanoseconds from 1970 to 2554
+	ErrTimeOutOfRange = errors.New("capture time cannot be written to the capture")
+	// the link layer type of a pcapng interface has 16 bits
+	ErrLinkLayerTypeOutOfRange = errors.New("link layer type cannot be written to the capture")
+)
+
+// Writer writes packets into a capture.
+type Writer interface {
+	// Writes a packet that has been captured on iface. Nothing
+	// is kept of packet, so it may be reused once this returns.
+	WritePacket(iface Interface, info *PacketInfo, packet Packet) error
+
+	// Writes what is still buffered. The writer
+	// the capture is written to is not closed.
+	Flush() error
+}
+
+// Writes all packets of t to w until t ends or ctx is done.
+// Returns how many have been written. Reaching the end
+// of t is not an error. w is flushed in any case.
+func CopyPackets(ctx context.Context, w Writer, t Traffic) (uint64, error) {
+	var n uint64
+	packets := Packets(ctx, t)
+	for info, packet := range packets.All() {
+		if err := w.WritePacket(InterfaceOf(t), info, packet); err != nil {
+			w.Flush()
+			return n, err
+		}
+		n++
+	}
+	if err := packets.Err(); err != nil {
+		w.Flush()
+		return n, err
+	}
+	return n, w.Flush()
+}
+
+// the seconds and nanoseconds of a capture time. Packets
+// without a time, i.e. from SPBs, are written at 0. Times
+// before 1970 or with more seconds than maxSecs would wrap.
+func writerTime(t time.Time, maxSecs uint64) (uint64, uint32, error) {
+	if t.IsZero() {
+		return 0, 0, nil
+	}
+	if t.Unix() < 0 || uint64(t.Unix()) > maxSecs {
+		return 0, 0, ErrTimeOutOfRange
+	}
+	return uint64(t.Unix()), uint32(t.Nanosecond()), nil
+}
+
+// PcapWriter writes pcaps with nanosecond timestamps.
+// The file header is written along with the first
+// packet, using its link layer type and snaplen.
+type PcapWriter struct {
+	w  *bufio.Writer
+	cw *countingWriter
+	// set once the file header has been written
+	started bool
+	llt     LinkLayerType
+	snaplen uint32
+	header  []byte
+}
+
+// Returns a writer that writes a pcap to w
+func NewPcapWriter(w io.Writer) *PcapWriter {
+	cw := &countingWriter{w: w}
+	return &PcapWriter{w: bufio.NewWriter(cw), cw: cw, header: make([]byte, 24)}
+}
+
+// Returns how many bytes of the capture have been
+// written, including those that are still buffered
+func (p *PcapWriter) Written() int64 {
+	return p.cw.n + int64(p.w.Buffered())
+}
+
+func (p *PcapWriter) start(iface Interface) error {
+	p.started = true
+	p.llt = iface.LinkLayerType
+	p.snaplen = iface.Snaplen
+	if p.snaplen == 0 {
+		p.snaplen = writerDefaultSnaplen
+	}
+
+	le := binary.LittleEndian
+	le.PutUint32(p.header[0:4], magicNanoseconds)
+	le.PutUint16(p.header[4:6], 2)
+	le.PutUint16(p.header[6:8], 4)
+	// time zone and accuracy are always 0
+	clear(p.header[8:16])
+	le.PutUint32(p.header[16:20], p.snaplen)
+	le.PutUint32(p.header[20:24], uint32(p.llt))
+	_, err := p.w.Write(p.header)
+	return err
+}
+
+func (p *PcapWriter) WritePacket(iface Interface, info *PacketInfo, packet Packet) error {
+	if !p.started {
+		if err := p.start(iface); err != nil {
+			return err
+		}
+	}
+	if iface.LinkLayerType != p.llt {
+		return ErrLinkLayerTypeMismatch
+	}
+	if uint32(len(packet)) > p.snaplen {
+		return ErrPacketExceedsSnaplen
+	}
+	secs, nanos, err := writerTime(info.CaptureTime, math.MaxUint32)
+	if err != nil {
+		return err
+	}
+
+	le := binary.LittleEndian
+	le.PutUint32(p.header[0:4], uint32(secs))
+	le.PutUint32(p.header[4:8], nanos)
+	le.PutUint32(p.header[8:12], uint32(len(packet)))
+	le.PutUint32(p.header[12:16], info.Size)
+	if _, err := p.w.Write(p.header[:16]); err != nil {
+		return err
+	}
+	_, err = p.w.Write(packet)
+	return err
+}
+
+// Writes the file header if no packet has been written yet,
+// so that even a capture without packets can be read.
+func (p *PcapWriter) Flush() error {
+	if !p.started {
+		if err := p.start(Interface{}); err != nil {
+			return err
+		}
+	}
+	return p.w.Flush()
+}
+
+// PcapNgWriter writes pcapngs of a single section in little
+// endian order. Every interface gets an IDB before its first
+// packet, packets are written as EPBs with nanosecond timestamps.
+type PcapNgWriter struct {
+	w  *bufio.Writer
+	cw *countingWriter
+	// set once the SHB has been written
+	started bool
+	// the ids of the interfaces that have an IDB already
+	ids   map[Interface]uint32
+	block []byte
+}
+
+// Returns a writer that writes a pcapng to w
+func NewPcapNgWriter(w io.Writer) *PcapNgWriter {
+	cw := &countingWriter{w: w}
+	return &PcapNgWriter{w: bufio.NewWriter(cw), cw: cw, ids: make(map[Interface]uint32), block: make([]byte, 32)}
+}
+
+// Returns how many bytes of the capture have been
+// written, including those that are still buffered
+func (p *PcapNgWriter) Written() int64 {
+	return p.cw.n + int64(p.w.Buffered())
+}
+
+// writes the start of a block up to its body
+func (p *PcapNgWriter) blockStart(blockType uint32, blockLen uint32) error {
+	le := binary.LittleEndian
+	le.PutUint32(p.block[0:4], blockType)
+	le.PutUint32(p.block[4:8], blockLen)
+	_, err := p.w.Write(p.block[:8])
+	return err
+}
+
+// writes the padding of a body of length n and the block total length
+func (p *PcapNgWriter) blockEnd(n int, blockLen uint32) error {
+	pad := (4 - n%4) % 4
+	clear(p.block[:pad])
+	binary.LittleEndian.PutUint32(p.block[pad:pad+4], blockLen)
+	_, err := p.w.Write(p.block[:pad+4])
+	return err
+}
+
+func (p *PcapNgWriter) start() error {
+	p.started = true
+	if err := p.blockStart(ngSHB, ngMinSHBLen); err != nil {
+		return err
+	}
+	le := binary.LittleEndian
+	le.PutUint32(p.block[0:4], ngByteOrderMagic)
+	le.PutUint16(p.block[4:6], 1)
+	le.PutUint16(p.block[6:8], 0)
+	// the length of the section is not known while writing
+	le.PutUint64(p.block[8:16], ngUnknownSectionLen)
+	if _, err := p.w.Write(p.block[:16]); err != nil {
+		return err
+	}
+	return p.blockEnd(0, ngMinSHBLen)
+}
+
+// writes an IDB for iface and returns its id
+func (p *PcapNgWriter) addInterface(iface Interface) (uint32, error) {
+	if iface.LinkLayerType > math.MaxUint16 {
+		return 0, ErrLinkLayerTypeOutOfRange
+	}
+	id := uint32(len(p.ids))
+	p.ids[iface] = id
+
+	// the only option is if_tsresol, as the timestamps
+	// are in nanoseconds, and the end of options
+	const blockLen = ngMinIDBLen + 8 + 4
+	if err := p.blockStart(ngIDB, blockLen); err != nil {
+		return 0, err
+	}
+	le := binary.LittleEndian
+	le.PutUint16(p.block[0:2], uint16(iface.LinkLayerType))
+	le.PutUint16(p.block[2:4], 0)
+	le.PutUint32(p.block[4:8], iface.Snaplen)
+	le.PutUint16(p.block[8:10], 9) // if_tsresol
+	le.PutUint16(p.block[10:12], 1)
+	p.block[12] = 9
+	clear(p.block[13:20])
+	if _, err := p.w.Write(p.block[:20]); err != nil {
+		return 0, err
+	}
+	return id, p.blockEnd(0, blockLen)
+}
+
+func (p *PcapNgWriter) WritePacket(iface Interface, info *PacketInfo, packet Packet) error {
+	if !p.started {
+		if err := p.start(); err != nil {
+			return err
+		}
+	}
+	// the timestamp is in nanoseconds, which have to fit into 64 bits
+	secs, nanos, err := writerTime(info.CaptureTime, math.MaxUint64/uint64(time.Second)-1)
+	if err != nil {
+		return err
+	}
+	id, ok := p.ids[iface]
+	if !ok {
+		if id, err = p.addInterface(iface); err != nil {
+			return err
+		}
+	}
+
+	blockLen := ngMinEPBLen + uint32(len(packet)+3)&^3
+	if err := p.blockStart(ngEPB, blockLen); err != nil {
+		return err
+	}
+	ts := secs*1e9 + uint64(nanos)
+	le := binary.LittleEndian
+	le.PutUint32(p.block[0:4], id)
+	le.PutUint32(p.block[4:8], uint32(ts>>32))
+	le.PutUint32(p.block[8:12], uint32(ts))
+	le.PutUint32(p.block[12:16], uint32(len(packet)))
+	le.PutUint32(p.block[16:20], info.Size)
+	if _, err := p.w.Write(p.block[:20]); err != nil {
+		return err
+	}
+	if _, err := p.w.Write(packet); err != nil {
+		return err
+	}
+	return p.blockEnd(len(packet), blockLen)
+}
+
+// Writes the SHB if no packet has been written yet,
+// so that even a capture without packets can be read.
+func (p *PcapNgWriter) Flush() error {
+	if !p.started {
+		if err := p.start(); err != nil {
+			return err
+		}
+	}
+	return p.w.Flush()
+}
@@ -0,0 +1,105 @@
+package pcapreader
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// what is written has to be read back the same way
+func TestWriterRoundTrip(t *testing.T) {
+	writers := map[string]func(*bytes.Buffer) Writer{
+		"pcap":   func(b *bytes.Buffer) Writer { return NewPcapWriter(b) },
+		"pcapng": func(b *bytes.Buffer) Writer { return NewPcapNgWriter(b) },
+	}
+	captures := map[string][]byte{
+		"no_packets": genPcap(genPcapOpts{order: binary.LittleEndian, snaplen: 65535}),
+	}
+	for _, name := range []string{"pcap_le_micro", "pcap_be_nano", "pcap_snaplen", "pcapng_be", "pcapng_sections", "pcapng_tsresol", "pcapng_interfaces"} {
+		captures[name] = goldenCaptures[name]()
+	}
+
+	for name, data := range captures {
+		for format, newWriter := range writers {
+			t.Run(name+"/"+format, func(t *testing.T) {
+				want := goldenOutput(OpenReader(bytes.NewReader(data)))
+
+				traffic, err := OpenReader(bytes.NewReader(data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				var b bytes.Buffer
+				if _, err := CopyPackets(context.Background(), newWriter(&b), traffic); err != nil {
+					t.Fatal(err)
+				}
+
+				if got := goldenOutput(OpenReader(bytes.NewReader(b.Bytes()))); got != want {
+					t.Errorf("read back\n%s\ninstead of\n%s", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestPcapWriterLinkLayerTypes(t *testing.T) {
+	w := NewPcapWriter(&bytes.Buffer{})
+	info := &PacketInfo{Size: 1}
+	if err := w.WritePacket(Interface{LinkLayerType: 1}, info, Packet{0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePacket(Interface{LinkLayerType: 105}, info, Packet{0}); err != ErrLinkLayerTypeMismatch {
+		t.Errorf("writing another link layer type returned %v", err)
+	}
+}
+
+// a link layer type that does not fit would be written as another one
+func TestPcapNgWriterLinkLayerTypeOutOfRange(t *testing.T) {
+	w := NewPcapNgWriter(&bytes.Buffer{})
+	info := &PacketInfo{Size: 1}
+	if err := w.WritePacket(Interface{LinkLayerType: 0x10001}, info, Packet{0}); err != ErrLinkLayerTypeOutOfRange {
+		t.Errorf("writing link layer type 0x10001 returned %v", err)
+	}
+	if err := w.WritePacket(Interface{LinkLayerType: 0xFFFF}, info, Packet{0}); err != nil {
+		t.Error(err)
+	}
+}
+
+// times that do not fit would wrap around to others
+func TestWriterTimeOutOfRange(t *testing.T) {
+	for _, c := range []struct {
+		format string
+		w      Writer
+		time   time.Time
+		err    error
+	}{
+		{"pcap", NewPcapWriter(&bytes.Buffer{}), time.Date(1969, 12, 31, 23, 59, 59, 0, time.UTC), ErrTimeOutOfRange},
+		{"pcap", NewPcapWriter(&bytes.Buffer{}), time.Date(2106, 3, 1, 0, 0, 0, 0, time.UTC), ErrTimeOutOfRange},
+		{"pcap", NewPcapWriter(&bytes.Buffer{}), time.Date(2106, 1, 1, 0, 0, 0, 0, time.UTC), nil},
+		{"pcapng", NewPcapNgWriter(&bytes.Buffer{}), time.Unix(-1, 999999999), ErrTimeOutOfRange},
+		{"pcapng", NewPcapNgWriter(&bytes.Buffer{}), time.Date(2600, 1, 1, 0, 0, 0, 0, time.UTC), ErrTimeOutOfRange},
+		{"pcapng", NewPcapNgWriter(&bytes.Buffer{}), time.Date(2500, 1, 1, 0, 0, 0, 0, time.UTC), nil},
+		{"pcapng", NewPcapNgWriter(&bytes.Buffer{}), time.Unix(0, 0), nil},
+	} {
+		info := &PacketInfo{CaptureTime: c.time, Size: 1}
+		if err := c.w.WritePacket(Interface{LinkLayerType: LinkTypeEthernet}, info, Packet{0}); err != c.err {
+			t.Errorf("%s: %v writing a packet captured at %v", c.format, err, c.time)
+		}
+	}
+
+	// what can be written is read back as it was
+	var b bytes.Buffer
+	w := NewPcapNgWriter(&b)
+	want := time.Date(2500, 1, 1, 0, 0, 0, 1, time.UTC)
+	w.WritePacket(Interface{LinkLayerType: LinkTypeEthernet}, &PacketInfo{CaptureTime: want, Size: 1}, Packet{0})
+	w.Flush()
+	traffic, err := OpenReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer traffic.Stop()
+	if info, _, err := traffic.Next(); err != nil || !info.CaptureTime.Equal(want) {
+		t.Errorf("read back %v, %v", info, err)
+	}
+}